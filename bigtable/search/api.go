@@ -0,0 +1,374 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+)
+
+const (
+	// importBatchSize is the number of documents importDocuments indexes
+	// with each ApplyBulk call.
+	importBatchSize = 50
+	// maxDocumentSize is the largest document, in bytes, that can be
+	// imported.
+	maxDocumentSize = 1 << 20
+	// maxImportSize is the largest request body, in bytes, that can be
+	// imported at once.
+	maxImportSize = 64 << 20
+)
+
+// apiDocument is a document in the JSON API, and a line of a bulk import.
+type apiDocument struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// importError is the error for one line of a bulk import.
+type importError struct {
+	Line  int    `json:"line"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// importResult reports the outcome of a bulk import.
+type importResult struct {
+	Imported int           `json:"imported"`
+	Errors   []importError `json:"errors"`
+	// Error is set if the import stopped early, such as when the request
+	// body was too large. The documents before it were still imported.
+	Error string `json:"error,omitempty"`
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Encode: %v", err)
+	}
+}
+
+// writeJSONError writes an error as a JSON response.
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// handleAPISearch responds to GET /api/search?q= with the ranked results.
+func handleAPISearch(w http.ResponseWriter, r *http.Request, table *bigtable.Table) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "GET requests only")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	query := r.FormValue("q")
+	q, err := parseQuery(query)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	results, err := search(ctx, table, q)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error searching: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Query   string         `json:"query"`
+		Results []searchResult `json:"results"`
+	}{query, results})
+}
+
+// handleAPIDoc gets, adds or replaces, and deletes the document named in
+// the path /api/docs/{name}. PUT requests have a JSON body with the
+// document's content.
+func handleAPIDoc(w http.ResponseWriter, r *http.Request, table *bigtable.Table) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/docs/")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "Empty document name!")
+		return
+	}
+	if reservedName(name) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Document name %q is reserved.", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		row, err := table.ReadRow(ctx, name, contentFilter)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Error reading content: "+err.Error())
+			return
+		}
+		content, ok := documentContent(row)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "Document not found.")
+			return
+		}
+		writeJSON(w, http.StatusOK, apiDocument{Name: name, Content: content})
+
+	case http.MethodPut:
+		var doc apiDocument
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxDocumentSize)).Decode(&doc); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+			return
+		}
+		if doc.Content == "" {
+			writeJSONError(w, http.StatusBadRequest, "Empty document content!")
+			return
+		}
+		if err := addDocument(ctx, table, name, doc.Content); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Error writing to Bigtable: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, apiDocument{Name: name, Content: doc.Content})
+
+	case http.MethodDelete:
+		existed, err := deleteDocument(ctx, table, name)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Error deleting from Bigtable: "+err.Error())
+			return
+		}
+		if !existed {
+			writeJSONError(w, http.StatusNotFound, "Document not found.")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "GET, PUT and DELETE requests only")
+	}
+}
+
+// handleImport indexes the newline-delimited JSON documents in the request
+// body, and reports the documents that failed.
+func handleImport(w http.ResponseWriter, r *http.Request, table *bigtable.Table) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST requests only")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	res, err := importDocuments(ctx, table, body)
+	if err != nil {
+		code := http.StatusInternalServerError
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		res.Error = "Error importing documents: " + err.Error()
+		writeJSON(w, code, res)
+		return
+	}
+	code := http.StatusOK
+	if len(res.Errors) > 0 && res.Imported == 0 {
+		code = http.StatusBadRequest
+	}
+	writeJSON(w, code, res)
+}
+
+// importDocuments reads one JSON document per line from r, and indexes them
+// in batches of importBatchSize with ApplyBulk. Documents that cannot be
+// parsed or written, or are longer than maxDocumentSize, are reported in the
+// result's errors, and the rest are still imported. It only returns an error
+// if r cannot be read, or the corpus statistics cannot be updated, and then
+// also returns the result of the documents imported before the error.
+func importDocuments(ctx context.Context, table *bigtable.Table, r io.Reader) (*importResult, error) {
+	type line struct {
+		num int
+		doc apiDocument
+	}
+	res := &importResult{Errors: []importError{}}
+	var batch []line
+	inBatch := make(map[string]bool)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		fail := func(l line, err error) {
+			res.Errors = append(res.Errors, importError{Line: l.num, Name: l.doc.Name, Error: err.Error()})
+		}
+		defer func() {
+			batch = nil
+			inBatch = make(map[string]bool)
+		}()
+
+		// Read the current content of the documents, to remove stale
+		// postings.
+		names := make(bigtable.RowList, len(batch))
+		for i, l := range batch {
+			names[i] = l.doc.Name
+		}
+		old := make(map[string]string)
+		err := table.ReadRows(ctx, names, func(row bigtable.Row) bool {
+			if content, ok := documentContent(row); ok {
+				old[row.Key()] = content
+			}
+			return true
+		}, contentFilter)
+		if err != nil {
+			for _, l := range batch {
+				fail(l, fmt.Errorf("reading document: %w", err))
+			}
+			return nil
+		}
+
+		m := make(rowMutations)
+		rows := make([][]string, len(batch))
+		stats := make([]corpusStats, len(batch))
+		for i, l := range batch {
+			content, exists := old[l.doc.Name]
+			rows[i], stats[i] = indexDocument(m, l.doc.Name, l.doc.Content, content, exists)
+		}
+		rowErrs, err := m.apply(ctx, table)
+		if err != nil {
+			for _, l := range batch {
+				fail(l, fmt.Errorf("writing document: %w", err))
+			}
+			return nil
+		}
+
+		// A document failed if any of its rows did. Its statistics are not
+		// counted, although some of its rows may have been written.
+		var total corpusStats
+		for i, l := range batch {
+			var rowErr error
+			for _, key := range rows[i] {
+				if err := rowErrs[key]; err != nil {
+					rowErr = fmt.Errorf("writing row %q: %w", key, err)
+					break
+				}
+			}
+			if rowErr != nil {
+				fail(l, rowErr)
+				continue
+			}
+			res.Imported++
+			total.docs += stats[i].docs
+			total.length += stats[i].length
+		}
+		if err := updateStats(ctx, table, total); err != nil {
+			return fmt.Errorf("updating statistics: %w", err)
+		}
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	for num := 1; ; num++ {
+		text, tooLong, err := readLine(br, maxDocumentSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Import the documents read so far before reporting the error.
+			if ferr := flush(); ferr != nil {
+				return res, ferr
+			}
+			return res, fmt.Errorf("reading documents: %w", err)
+		}
+		if tooLong {
+			res.Errors = append(res.Errors, importError{Line: num, Error: fmt.Sprintf("document larger than %d bytes", maxDocumentSize)})
+			continue
+		}
+		text = bytes.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+		var doc apiDocument
+		err = json.Unmarshal(text, &doc)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("invalid JSON: %w", err)
+		case doc.Name == "":
+			err = errors.New("empty document name")
+		case reservedName(doc.Name):
+			err = fmt.Errorf("document name %q is reserved", doc.Name)
+		case doc.Content == "":
+			err = errors.New("empty document content")
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, importError{Line: num, Name: doc.Name, Error: err.Error()})
+			continue
+		}
+		// Index a later version of a document in the next batch, so that
+		// its stale postings are computed from the earlier version.
+		if inBatch[doc.Name] {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+		batch = append(batch, line{num, doc})
+		inBatch[doc.Name] = true
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return res, err
+	}
+	sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+	return res, nil
+}
+
+// readLine reads a line from br, without its line ending, and returns io.EOF
+// after the last line. If the line is longer than max bytes, the rest of it
+// is skipped, and tooLong is set instead of returning it.
+func readLine(br *bufio.Reader, max int) (line []byte, tooLong bool, err error) {
+	read := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		read = read || len(chunk) > 0
+		// Allow for a \r\n line ending.
+		if !tooLong && len(line)+len(chunk) > max+2 {
+			tooLong, line = true, nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || !read) {
+			return nil, false, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > max {
+			tooLong, line = true, nil
+		}
+		return line, tooLong, nil
+	}
+}
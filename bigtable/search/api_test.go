@@ -0,0 +1,262 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/google/go-cmp/cmp"
+)
+
+// serveAPI sends a request to the JSON API and decodes the response into v.
+func serveAPI(t *testing.T, table *bigtable.Table, method, target, body string, v interface{}) int {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	switch {
+	case strings.HasPrefix(target, "/api/search"):
+		handleAPISearch(w, r, table)
+	case strings.HasPrefix(target, "/api/docs/"):
+		handleAPIDoc(w, r, table)
+	case target == "/api/import":
+		handleImport(w, r, table)
+	default:
+		t.Fatalf("unknown target %q", target)
+	}
+	if v != nil && w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, target, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+type searchResponse struct {
+	Results []searchResult `json:"results"`
+}
+
+func (s searchResponse) names() []string {
+	var names []string
+	for _, r := range s.Results {
+		names = append(names, r.Title)
+	}
+	return names
+}
+
+func TestAPI(t *testing.T) {
+	client, _ := newTestTable(t)
+	table := client.Open("docindex")
+
+	if code := serveAPI(t, table, "PUT", "/api/docs/clouds", `{"content": "Clouds are made of water."}`, nil); code != http.StatusOK {
+		t.Fatalf("PUT: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := serveAPI(t, table, "PUT", "/api/docs/rain", `{"content": "Rain is water falling from clouds."}`, nil); code != http.StatusOK {
+		t.Fatalf("PUT: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := serveAPI(t, table, "PUT", "/api/docs/empty", `{}`, nil); code != http.StatusBadRequest {
+		t.Errorf("PUT without content: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := serveAPI(t, table, "PUT", "/api/docs/%23stats", `{"content": "Not statistics."}`, nil); code != http.StatusBadRequest {
+		t.Errorf("PUT of #stats: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	var doc apiDocument
+	if code := serveAPI(t, table, "GET", "/api/docs/rain", "", &doc); code != http.StatusOK || doc.Content != "Rain is water falling from clouds." {
+		t.Errorf("GET: got status %d and %+v", code, doc)
+	}
+
+	var res searchResponse
+	if code := serveAPI(t, table, "GET", "/api/search?q=water", "", &res); code != http.StatusOK {
+		t.Fatalf("search: got status %d, want %d", code, http.StatusOK)
+	}
+	if diff := cmp.Diff([]string{"clouds", "rain"}, res.names()); diff != "" {
+		t.Errorf("search mismatch (-want +got):\n%s", diff)
+	}
+	if code := serveAPI(t, table, "GET", "/api/search?q=", "", nil); code != http.StatusBadRequest {
+		t.Errorf("empty search: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code := serveAPI(t, table, "DELETE", "/api/docs/clouds", "", nil); code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if code := serveAPI(t, table, "DELETE", "/api/docs/clouds", "", nil); code != http.StatusNotFound {
+		t.Errorf("second DELETE: got status %d, want %d", code, http.StatusNotFound)
+	}
+	res = searchResponse{}
+	serveAPI(t, table, "GET", "/api/search?q=water", "", &res)
+	if diff := cmp.Diff([]string{"rain"}, res.names()); diff != "" {
+		t.Errorf("search after DELETE mismatch (-want +got):\n%s", diff)
+	}
+	stats, err := readStats(context.Background(), table)
+	if err != nil {
+		t.Fatalf("readStats: %v", err)
+	}
+	if want := (corpusStats{docs: 1, length: 6}); stats != want {
+		t.Errorf("readStats got %+v, want %+v", stats, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	client, _ := newTestTable(t)
+	table := client.Open("docindex")
+
+	// More documents than fit in one batch, a replaced document, and some
+	// invalid lines.
+	var body strings.Builder
+	for i := 0; i < importBatchSize+10; i++ {
+		fmt.Fprintf(&body, `{"name": "doc%d", "content": "document number %d about gophers"}`+"\n", i, i)
+	}
+	body.WriteString(`{"name": "doc3", "content": "replaced with bigtable"}` + "\n")
+	body.WriteString("not json\n\n")
+	body.WriteString(`{"name": "nocontent"}` + "\n")
+	body.WriteString(`{"name": "#stats", "content": "not statistics"}` + "\n")
+	// An oversized line is reported, and the import continues after it.
+	body.WriteString(`{"name": "huge", "content": "` + strings.Repeat("x", maxDocumentSize) + `"}` + "\n")
+	body.WriteString(`{"name": "after", "content": "imported after the huge one"}` + "\n")
+
+	var res importResult
+	if code := serveAPI(t, table, "POST", "/api/import", body.String(), &res); code != http.StatusOK {
+		t.Fatalf("import: got status %d, want %d", code, http.StatusOK)
+	}
+	if want := importBatchSize + 12; res.Imported != want {
+		t.Errorf("import: got %d imported, want %d", res.Imported, want)
+	}
+	var lines []int
+	for _, e := range res.Errors {
+		lines = append(lines, e.Line)
+	}
+	if diff := cmp.Diff([]int{importBatchSize + 12, importBatchSize + 14, importBatchSize + 15, importBatchSize + 16}, lines); diff != "" {
+		t.Errorf("import error lines mismatch (-want +got):\n%s\n%+v", diff, res.Errors)
+	}
+
+	var sr searchResponse
+	serveAPI(t, table, "GET", "/api/search?q=gophers", "", &sr)
+	if got, want := len(sr.Results), importBatchSize+9; got != want {
+		t.Errorf("search(gophers): got %d results, want %d", got, want)
+	}
+	sr = searchResponse{}
+	serveAPI(t, table, "GET", "/api/search?q=bigtable", "", &sr)
+	if diff := cmp.Diff([]string{"doc3"}, sr.names()); diff != "" {
+		t.Errorf("search(bigtable) mismatch (-want +got):\n%s", diff)
+	}
+	stats, err := readStats(context.Background(), table)
+	if err != nil {
+		t.Fatalf("readStats: %v", err)
+	}
+	if want := int64(importBatchSize + 11); stats.docs != want {
+		t.Errorf("readStats got %d docs, want %d", stats.docs, want)
+	}
+
+	if code := serveAPI(t, table, "POST", "/api/import", "bad\n", &res); code != http.StatusBadRequest {
+		t.Errorf("import of only bad lines: got status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestImportReadError(t *testing.T) {
+	client, _ := newTestTable(t)
+	table := client.Open("docindex")
+
+	// The documents read before the error are imported and reported.
+	errRead := errors.New("connection reset")
+	r := io.MultiReader(
+		strings.NewReader(`{"name": "a", "content": "gophers"}`+"\n"+`{"name": "b", "content": "more gophers"}`+"\n"),
+		iotest.ErrReader(errRead),
+	)
+	res, err := importDocuments(context.Background(), table, r)
+	if !errors.Is(err, errRead) {
+		t.Errorf("importDocuments got error %v, want %v", err, errRead)
+	}
+	if res == nil || res.Imported != 2 {
+		t.Fatalf("importDocuments got result %+v, want 2 imported", res)
+	}
+	q, _ := parseQuery("gophers")
+	results, err := search(context.Background(), table, q)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("search after failed import: got %d results, want 2", len(results))
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader("short\r\n"+strings.Repeat("x", 40)+"\nexact\n\nlast"), 16)
+	type result struct {
+		line    string
+		tooLong bool
+	}
+	var got []result
+	for {
+		line, tooLong, err := readLine(br, 5)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("readLine: %v", err)
+		}
+		got = append(got, result{string(line), tooLong})
+	}
+	want := []result{{"short", false}, {"", true}, {"exact", false}, {"", false}, {"last", false}}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(result{})); diff != "" {
+		t.Errorf("readLine mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCopyTable(t *testing.T) {
+	ctx := context.Background()
+	client, adminClient := newTestTable(t)
+	createTestTable(t, adminClient, "copy")
+	src := client.Open("docindex")
+	// Enough documents to need more than one batch.
+	for i := 0; i < copyBatchSize+10; i++ {
+		if err := addDocument(ctx, src, fmt.Sprintf("doc%d", i), fmt.Sprintf("gopher number %d", i)); err != nil {
+			t.Fatalf("addDocument: %v", err)
+		}
+	}
+	dst := client.Open("copy")
+	if err := addDocument(ctx, dst, "own", "a gopher of its own"); err != nil {
+		t.Fatalf("addDocument: %v", err)
+	}
+
+	if err := copyTable("docindex", "copy", client, adminClient); err != nil {
+		t.Fatalf("copyTable: %v", err)
+	}
+	q, _ := parseQuery("gopher")
+	results, err := search(ctx, dst, q)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if got, want := len(results), copyBatchSize+11; got != want {
+		t.Errorf("search after copy: got %d results, want %d", got, want)
+	}
+	stats, err := readStats(ctx, dst)
+	if err != nil {
+		t.Fatalf("readStats: %v", err)
+	}
+	if want := int64(copyBatchSize + 11); stats.docs != want {
+		t.Errorf("readStats after copy: got %d docs, want %d", stats.docs, want)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/bigtable"
+)
+
+// The index has a row for each word, with a column in the index column
+// family for each document containing the word. The cell value is the list
+// of positions of the word in the document, such as "0,12,31", so the term
+// frequency is the number of positions. Documents indexed before positions
+// were stored have an empty value, and count as one occurrence.
+//
+// The statsRow holds the number of documents and their total length in
+// words, which are needed for ranking. It cannot clash with a word's row,
+// since words only contain letters, and documents cannot be given its name.
+const (
+	statsRow     = "#stats"
+	docsColumn   = "docs"
+	lengthColumn = "length"
+)
+
+// reservedName reports whether name cannot be used for a document, because
+// its row holds the corpus statistics.
+func reservedName(name string) bool {
+	return name == statsRow
+}
+
+// A token is a word in a text, with its byte offsets in the text.
+type token struct {
+	word       string
+	start, end int
+}
+
+// tokens splits a string into lower case words of letters.
+// This is very simple, it's not a good tokenization function.
+func tokens(s string) []token {
+	var toks []token
+	start := -1
+	for i, r := range s {
+		if unicode.IsLetter(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			toks = append(toks, token{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{strings.ToLower(s[start:]), start, len(s)})
+	}
+	return toks
+}
+
+// wordPositions maps each word to the positions it occurs at.
+func wordPositions(toks []token) map[string][]int {
+	positions := make(map[string][]int)
+	for i, t := range toks {
+		positions[t.word] = append(positions[t.word], i)
+	}
+	return positions
+}
+
+func encodePositions(positions []int) []byte {
+	s := make([]string, len(positions))
+	for i, p := range positions {
+		s[i] = strconv.Itoa(p)
+	}
+	return []byte(strings.Join(s, ","))
+}
+
+// decodePositions parses an index cell value. It returns nil for cells
+// written without positions, or with invalid ones.
+func decodePositions(b []byte) []int {
+	if len(b) == 0 {
+		return nil
+	}
+	fields := strings.Split(string(b), ",")
+	positions := make([]int, len(fields))
+	for i, f := range fields {
+		p, err := strconv.Atoi(f)
+		if err != nil {
+			return nil
+		}
+		positions[i] = p
+	}
+	return positions
+}
+
+// corpusStats are the statistics kept in the statsRow.
+type corpusStats struct {
+	docs, length int64
+}
+
+// parseStats reads the statistics from the statsRow.
+func parseStats(row bigtable.Row) corpusStats {
+	var s corpusStats
+	for _, item := range row[indexColumnFamily] {
+		if len(item.Value) != 8 {
+			continue
+		}
+		v := int64(binary.BigEndian.Uint64(item.Value))
+		switch item.Column {
+		case indexColumnFamily + ":" + docsColumn:
+			s.docs = v
+		case indexColumnFamily + ":" + lengthColumn:
+			s.length = v
+		}
+	}
+	return s
+}
+
+// readStats reads the corpus statistics from the table.
+func readStats(ctx context.Context, table *bigtable.Table) (corpusStats, error) {
+	row, err := table.ReadRow(ctx, statsRow, bigtable.RowFilter(bigtable.ChainFilters(
+		bigtable.FamilyFilter(indexColumnFamily),
+		bigtable.LatestNFilter(1),
+	)))
+	if err != nil {
+		return corpusStats{}, err
+	}
+	return parseStats(row), nil
+}
+
+// updateStats adds delta to the corpus statistics in the table.
+func updateStats(ctx context.Context, table *bigtable.Table, delta corpusStats) error {
+	if delta == (corpusStats{}) {
+		return nil
+	}
+	rmw := bigtable.NewReadModifyWrite()
+	rmw.Increment(indexColumnFamily, docsColumn, delta.docs)
+	rmw.Increment(indexColumnFamily, lengthColumn, delta.length)
+	_, err := table.ApplyReadModifyWrite(ctx, statsRow, rmw)
+	return err
+}
+
+// rowMutations collects mutations by row key, so that all the changes to a
+// row, possibly from several documents, are applied together.
+type rowMutations map[string]*bigtable.Mutation
+
+// row returns the mutation for a row.
+func (m rowMutations) row(key string) *bigtable.Mutation {
+	if m[key] == nil {
+		m[key] = bigtable.NewMutation()
+	}
+	return m[key]
+}
+
+// bulk returns the row keys, in order, and their mutations for ApplyBulk.
+func (m rowMutations) bulk() ([]string, []*bigtable.Mutation) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	muts := make([]*bigtable.Mutation, len(keys))
+	for i, key := range keys {
+		muts[i] = m[key]
+	}
+	return keys, muts
+}
+
+// apply applies the mutations with ApplyBulk. It returns the error of each
+// failed row, by row key.
+func (m rowMutations) apply(ctx context.Context, table *bigtable.Table) (map[string]error, error) {
+	keys, muts := m.bulk()
+	errs, err := table.ApplyBulk(ctx, keys, muts)
+	if err != nil {
+		return nil, err
+	}
+	rowErrs := make(map[string]error)
+	for i, err := range errs {
+		if err != nil {
+			rowErrs[keys[i]] = err
+		}
+	}
+	return rowErrs, nil
+}
+
+// indexDocument adds the mutations that store content as document name to
+// m. If the document already exists with oldContent, postings for the words
+// that are no longer in the document are deleted. It returns the rows it
+// changes, and the change to the corpus statistics.
+func indexDocument(m rowMutations, name, content, oldContent string, exists bool) ([]string, corpusStats) {
+	ts := bigtable.Now()
+	rows := []string{name}
+	m.row(name).Set(contentColumnFamily, "", ts, []byte(content))
+	toks := tokens(content)
+	positions := wordPositions(toks)
+	for word, p := range positions {
+		m.row(word).Set(indexColumnFamily, name, ts, encodePositions(p))
+		rows = append(rows, word)
+	}
+
+	if !exists {
+		return rows, corpusStats{docs: 1, length: int64(len(toks))}
+	}
+	oldToks := tokens(oldContent)
+	for word := range wordPositions(oldToks) {
+		if _, ok := positions[word]; !ok {
+			m.row(word).DeleteCellsInColumn(indexColumnFamily, name)
+			rows = append(rows, word)
+		}
+	}
+	return rows, corpusStats{docs: 0, length: int64(len(toks) - len(oldToks))}
+}
+
+// unindexDocument adds the mutations that delete document name, which has
+// the given content, to m. It returns the rows it changes, and the change to
+// the corpus statistics.
+func unindexDocument(m rowMutations, name, content string) ([]string, corpusStats) {
+	rows := []string{name}
+	m.row(name).DeleteCellsInFamily(contentColumnFamily)
+	toks := tokens(content)
+	for word := range wordPositions(toks) {
+		m.row(word).DeleteCellsInColumn(indexColumnFamily, name)
+		rows = append(rows, word)
+	}
+	return rows, corpusStats{docs: -1, length: -int64(len(toks))}
+}
+
+// documentContent returns the content of a document row, and whether the
+// document exists.
+func documentContent(row bigtable.Row) (string, bool) {
+	c := row[contentColumnFamily]
+	if len(c) == 0 {
+		return "", false
+	}
+	return string(c[0].Value), true
+}
+
+// contentFilter reads the latest content of a document row.
+var contentFilter = bigtable.RowFilter(bigtable.ChainFilters(
+	bigtable.FamilyFilter(contentColumnFamily),
+	bigtable.LatestNFilter(1),
+))
+
+// addDocument stores and indexes a document, replacing any previous version
+// of it.
+func addDocument(ctx context.Context, table *bigtable.Table, name, content string) error {
+	row, err := table.ReadRow(ctx, name, contentFilter)
+	if err != nil {
+		return fmt.Errorf("reading document: %w", err)
+	}
+	oldContent, exists := documentContent(row)
+	m := make(rowMutations)
+	_, stats := indexDocument(m, name, content, oldContent, exists)
+	return applyDocumentChange(ctx, table, m, stats)
+}
+
+// deleteDocument removes a document and its postings. It reports whether
+// the document existed.
+func deleteDocument(ctx context.Context, table *bigtable.Table, name string) (bool, error) {
+	row, err := table.ReadRow(ctx, name, contentFilter)
+	if err != nil {
+		return false, fmt.Errorf("reading document: %w", err)
+	}
+	content, exists := documentContent(row)
+	if !exists {
+		return false, nil
+	}
+	m := make(rowMutations)
+	_, stats := unindexDocument(m, name, content)
+	return true, applyDocumentChange(ctx, table, m, stats)
+}
+
+// applyDocumentChange applies the mutations for a change to one document,
+// then updates the corpus statistics.
+func applyDocumentChange(ctx context.Context, table *bigtable.Table, m rowMutations, stats corpusStats) error {
+	rowErrs, err := m.apply(ctx, table)
+	if err != nil {
+		return fmt.Errorf("writing document: %w", err)
+	}
+	for key, err := range rowErrs {
+		return fmt.Errorf("writing row %q: %w", key, err)
+	}
+	if err := updateStats(ctx, table, stats); err != nil {
+		return fmt.Errorf("updating statistics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/bigtable"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// snippetLength is the approximate length of a snippet in bytes.
+const snippetLength = 160
+
+// A phrase is a sequence of words that must appear consecutively in a
+// document. A phrase with one word is a plain term.
+type phrase []string
+
+// A searchQuery is a parsed query. A document matches if it contains a
+// phrase from every group, and none of the excluded phrases.
+//
+// Words are separated by spaces, and must all be in a document. Quoted
+// words form a phrase. "a OR b" matches documents with either a or b, and
+// "NOT a" or "-a" excludes documents containing a.
+type searchQuery struct {
+	groups  [][]phrase
+	exclude []phrase
+}
+
+// errEmptyQuery is returned by parseQuery for queries without any words to
+// search for.
+var errEmptyQuery = errors.New("Empty query.")
+
+// lexQuery splits a query on spaces outside of quotes.
+func lexQuery(s string) []string {
+	var (
+		items   []string
+		cur     strings.Builder
+		inQuote bool
+	)
+	flush := func() {
+		if cur.Len() > 0 {
+			items = append(items, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			cur.WriteRune(r)
+			if inQuote = !inQuote; !inQuote {
+				flush()
+			}
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return items
+}
+
+// parseQuery parses a search query.
+func parseQuery(s string) (searchQuery, error) {
+	var (
+		q       searchQuery
+		or, not bool
+	)
+	for _, item := range lexQuery(s) {
+		switch {
+		case item == "OR":
+			or = true
+			continue
+		case item == "NOT":
+			not = true
+			continue
+		case strings.HasPrefix(item, "-"):
+			not = true
+			item = item[1:]
+		}
+		var p phrase
+		for _, t := range tokens(item) {
+			p = append(p, t.word)
+		}
+		switch {
+		case len(p) == 0:
+		case not:
+			q.exclude = append(q.exclude, p)
+		case or && len(q.groups) > 0:
+			q.groups[len(q.groups)-1] = append(q.groups[len(q.groups)-1], p)
+		default:
+			q.groups = append(q.groups, []phrase{p})
+		}
+		or, not = false, false
+	}
+	if len(q.groups) == 0 {
+		return q, errEmptyQuery
+	}
+	return q, nil
+}
+
+// terms returns the unique words a matching document may contain, which
+// are ranked and highlighted.
+func (q searchQuery) terms() []string {
+	return uniqueWords(flatten(q.groups))
+}
+
+// words returns every unique word in the query, including excluded ones.
+func (q searchQuery) words() []string {
+	return uniqueWords(append(flatten(q.groups), q.exclude...))
+}
+
+func flatten(groups [][]phrase) []phrase {
+	var phrases []phrase
+	for _, g := range groups {
+		phrases = append(phrases, g...)
+	}
+	return phrases
+}
+
+func uniqueWords(phrases []phrase) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, p := range phrases {
+		for _, w := range p {
+			if !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+	}
+	return words
+}
+
+// A postingList maps document names to the positions of a word in them.
+type postingList map[string][]int
+
+// invertedIndex maps words to their postings.
+type invertedIndex map[string]postingList
+
+// contains reports whether doc contains the phrase.
+func (idx invertedIndex) contains(doc string, p phrase) bool {
+	first, ok := idx[p[0]][doc]
+	if !ok {
+		return false
+	}
+	if len(p) == 1 {
+		return true
+	}
+	for _, start := range first {
+		found := true
+		for i, w := range p[1:] {
+			if !containsInt(idx[w][doc], start+i+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the names of the documents matching q, in name order.
+func (idx invertedIndex) match(q searchQuery) []string {
+	candidates := make(map[string]bool)
+	for _, p := range q.groups[0] {
+		for doc := range idx[p[0]] {
+			candidates[doc] = true
+		}
+	}
+	var docs []string
+	for doc := range candidates {
+		if idx.matches(doc, q) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Strings(docs)
+	return docs
+}
+
+func (idx invertedIndex) matches(doc string, q searchQuery) bool {
+	for _, p := range q.exclude {
+		if idx.contains(doc, p) {
+			return false
+		}
+	}
+	for _, g := range q.groups {
+		found := false
+		for _, p := range g {
+			if idx.contains(doc, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// bm25 scores a document of docLen words against the query terms, using
+// the Okapi BM25 ranking function.
+func (idx invertedIndex) bm25(doc string, docLen int, terms []string, stats corpusStats) float64 {
+	n := float64(stats.docs)
+	avgLen := float64(docLen)
+	if stats.docs > 0 && stats.length > 0 {
+		avgLen = float64(stats.length) / n
+	}
+	var score float64
+	for _, term := range terms {
+		positions, ok := idx[term][doc]
+		if !ok {
+			continue
+		}
+		tf := float64(len(positions))
+		if tf == 0 {
+			tf = 1
+		}
+		// The statistics may lag behind the index, so never let a term
+		// look more common than it is.
+		df := float64(len(idx[term]))
+		total := math.Max(n, df)
+		idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(docLen)/avgLen))
+	}
+	return score
+}
+
+// A snippetPart is a piece of a snippet, which is highlighted if it
+// matched a query term.
+type snippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// snippet returns the part of content with the most query terms in it, with
+// the terms highlighted.
+func snippet(content string, terms []string) []snippetPart {
+	isTerm := make(map[string]bool)
+	for _, t := range terms {
+		isTerm[t] = true
+	}
+	toks := tokens(content)
+	if len(toks) == 0 {
+		if len(content) > snippetLength {
+			return []snippetPart{{Text: truncate(content, snippetLength) + "..."}}
+		}
+		return []snippetPart{{Text: content}}
+	}
+
+	var matched []int
+	for i, t := range toks {
+		if isTerm[t.word] {
+			matched = append(matched, i)
+		}
+	}
+	// Find the window with the most matches, then start a few words before
+	// its first match.
+	best, bestCount := 0, 0
+	for j, m := range matched {
+		count := 0
+		for _, k := range matched[j:] {
+			if toks[k].end-toks[m].start > snippetLength {
+				break
+			}
+			count++
+		}
+		if count > bestCount {
+			best, bestCount = m, count
+		}
+	}
+	first := best - 3
+	if first < 0 {
+		first = 0
+	}
+	last := first
+	for last+1 < len(toks) && toks[last+1].end-toks[first].start <= snippetLength {
+		last++
+	}
+
+	var parts []snippetPart
+	add := func(text string, match bool) {
+		if text == "" {
+			return
+		}
+		if n := len(parts); n > 0 && !match && !parts[n-1].Match {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, snippetPart{Text: text, Match: match})
+	}
+	if first > 0 {
+		add("...", false)
+	}
+	for i := first; i <= last; i++ {
+		if i > first {
+			add(content[toks[i-1].end:toks[i].start], false)
+		}
+		add(content[toks[i].start:toks[i].end], isTerm[toks[i].word])
+	}
+	if last < len(toks)-1 {
+		add("...", false)
+	}
+	return parts
+}
+
+// truncate shortens s to at most n bytes, without splitting a rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := 0
+	for i := range s {
+		if i > n {
+			break
+		}
+		cut = i
+	}
+	return s[:cut]
+}
+
+// readRows reads rows with a single ReadRows call, and returns them in the
+// same order. Rows that do not exist are returned empty.
+func readRows(ctx context.Context, table *bigtable.Table, rows []string, opts ...bigtable.ReadOption) ([]bigtable.Row, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	byKey := make(map[string]bigtable.Row, len(rows))
+	err := table.ReadRows(ctx, bigtable.RowList(rows), func(row bigtable.Row) bool {
+		byKey[row.Key()] = row
+		return true
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]bigtable.Row, len(rows))
+	for i, row := range rows {
+		results[i] = byKey[row]
+	}
+	return results, nil
+}
+
+// A searchResult is a document matching a query.
+type searchResult struct {
+	Title   string        `json:"name"`
+	Score   float64       `json:"score"`
+	Snippet []snippetPart `json:"snippet"`
+}
+
+// search returns the documents matching q, best first.
+func search(ctx context.Context, table *bigtable.Table, q searchQuery) ([]searchResult, error) {
+	// For each query word, get the list of documents containing it.
+	words := q.words()
+	rows, err := readRows(ctx, table, words, bigtable.RowFilter(bigtable.ChainFilters(
+		bigtable.FamilyFilter(indexColumnFamily),
+		bigtable.LatestNFilter(1),
+	)))
+	if err != nil {
+		return nil, err
+	}
+	idx := make(invertedIndex)
+	for i, row := range rows {
+		postings := make(postingList)
+		for _, item := range row[indexColumnFamily] {
+			postings[item.Column[len(indexColumnFamily+":"):]] = decodePositions(item.Value)
+		}
+		idx[words[i]] = postings
+	}
+
+	// Fetch the content of the matching documents, to rank them and build
+	// snippets.
+	matches := idx.match(q)
+	content, err := readRows(ctx, table, matches, contentFilter)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := readStats(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := q.terms()
+	results := make([]searchResult, 0, len(matches))
+	for i, doc := range matches {
+		text, _ := documentContent(content[i])
+		results = append(results, searchResult{
+			Title:   doc,
+			Score:   idx.bm25(doc, len(tokens(text)), terms, stats),
+			Snippet: snippet(text, terms),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results, nil
+}
@@ -19,10 +19,22 @@
 //   - Add a document.  This adds the content of a user-supplied document to the
 //     Bigtable, and adds references to the document to an index in the Bigtable.
 //     The document is indexed under each unique word in the document.
-//   - Search the index.  This returns documents containing each word in a user
-//     query, with snippets and links to view the whole document.
+//     The index stores the positions of each word, and re-adding a document
+//     removes it from the index of words it no longer contains.
+//   - Search the index.  This returns documents matching a user query, ranked
+//     with BM25, with highlighted snippets and links to view the whole
+//     document.  Queries can contain "quoted phrases", OR and NOT.
 //   - Copy table.  This copies the documents and index from another table and
 //     adds them to the current one.
+//
+// The same operations are available as a JSON API:
+//
+//	GET    /api/search?q=QUERY   search the index
+//	GET    /api/docs/NAME        get a document
+//	PUT    /api/docs/NAME        add or replace a document: {"content": "..."}
+//	DELETE /api/docs/NAME        delete a document
+//	POST   /api/import           add newline-delimited JSON documents:
+//	                             {"name": "...", "content": "..."}
 package main
 
 import (
@@ -35,10 +47,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
-	"unicode"
 
 	"cloud.google.com/go/bigtable"
 )
@@ -57,11 +66,15 @@ Added {{.Title}}
 Results for <b>{{.Query}}</b>:<br><br>
 {{range .Results}}
 <a href="/content?name={{.Title}}">{{.Title}}</a><br>
-<i>{{.Snippet}}</i><br><br>
+<i>{{range .Snippet}}{{if .Match}}<b>{{.Text}}</b>{{else}}{{.Text}}{{end}}{{end}}</i><br><br>
 {{end}}
 </body></html>`))
 )
 
+// copyBatchSize is the number of rows copyTable writes with each ApplyBulk
+// call.
+const copyBatchSize = 500
+
 const (
 	indexColumnFamily   = "i"
 	contentColumnFamily = "c"
@@ -133,6 +146,11 @@ func main() {
 	http.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) { handleReset(w, r, *tableName, adminClient) })
 	http.HandleFunc("/copy", func(w http.ResponseWriter, r *http.Request) { handleCopy(w, r, *tableName, client, adminClient) })
 	http.HandleFunc("/", handleMain)
+
+	// Set up JSON API handlers.
+	http.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) { handleAPISearch(w, r, table) })
+	http.HandleFunc("/api/docs/", func(w http.ResponseWriter, r *http.Request) { handleAPIDoc(w, r, table) })
+	http.HandleFunc("/api/import", func(w http.ResponseWriter, r *http.Request) { handleImport(w, r, table) })
 	if err := http.ListenAndServe(":"+strconv.Itoa(*port), nil); err != nil {
 		log.Fatal(err)
 	}
@@ -143,22 +161,6 @@ func handleMain(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, mainPage)
 }
 
-// tokenize splits a string into tokens.
-// This is very simple, it's not a good tokenization function.
-func tokenize(s string) []string {
-	wordMap := make(map[string]bool)
-	f := strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) })
-	for _, word := range f {
-		word = strings.ToLower(word)
-		wordMap[word] = true
-	}
-	words := make([]string, 0, len(wordMap))
-	for word := range wordMap {
-		words = append(words, word)
-	}
-	return words
-}
-
 // handleContent fetches the content of a document from the Bigtable and returns it.
 func handleContent(w http.ResponseWriter, r *http.Request, table *bigtable.Table) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -192,82 +194,22 @@ func handleSearch(w http.ResponseWriter, r *http.Request, table *bigtable.Table)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	query := r.FormValue("q")
-	// Split the query into words.
-	words := tokenize(query)
-	if len(words) == 0 {
-		http.Error(w, "Empty query.", http.StatusBadRequest)
-		return
-	}
-
-	// readRows reads from many rows concurrently.
-	readRows := func(rows []string) ([]bigtable.Row, error) {
-		results := make([]bigtable.Row, len(rows))
-		errors := make([]error, len(rows))
-		var wg sync.WaitGroup
-		for i, row := range rows {
-			wg.Add(1)
-			go func(i int, row string) {
-				defer wg.Done()
-				results[i], errors[i] = table.ReadRow(ctx, row, bigtable.RowFilter(bigtable.LatestNFilter(1)))
-			}(i, row)
-		}
-		wg.Wait()
-		for _, err := range errors {
-			if err != nil {
-				return nil, err
-			}
-		}
-		return results, nil
-	}
-
-	// For each query word, get the list of documents containing it.
-	results, err := readRows(words)
+	q, err := parseQuery(query)
 	if err != nil {
-		http.Error(w, "Error reading index: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Count how many of the query words each result contained.
-	hits := make(map[string]int)
-	for _, r := range results {
-		for _, r := range r[indexColumnFamily] {
-			hits[r.Column]++
-		}
-	}
-
-	// Build a slice of all the documents that matched every query word.
-	var matches []string
-	for doc, count := range hits {
-		if count == len(words) {
-			matches = append(matches, doc[len(indexColumnFamily+":"):])
-		}
-	}
-
-	// Fetch the content of those documents from the Bigtable.
-	content, err := readRows(matches)
+	results, err := search(ctx, table, q)
 	if err != nil {
-		http.Error(w, "Error reading results: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error searching: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	type result struct{ Title, Snippet string }
 	data := struct {
 		Query   string
-		Results []result
-	}{query, nil}
-
-	// Output links and snippets.
-	for i, doc := range matches {
-		var text string
-		c := content[i][contentColumnFamily]
-		if len(c) > 0 {
-			text = string(c[0].Value)
-		}
-		if len(text) > 100 {
-			text = text[:100] + "..."
-		}
-		data.Results = append(data.Results, result{doc, text})
-	}
+		Results []searchResult
+	}{query, results}
 	var buf bytes.Buffer
 	if err := searchTemplate.ExecuteTemplate(&buf, "", data); err != nil {
 		http.Error(w, "Error executing HTML template: "+err.Error(), http.StatusInternalServerError)
@@ -291,6 +233,10 @@ func handleAddDoc(w http.ResponseWriter, r *http.Request, table *bigtable.Table)
 		http.Error(w, "Empty document name!", http.StatusBadRequest)
 		return
 	}
+	if reservedName(name) {
+		http.Error(w, fmt.Sprintf("Document name %q is reserved.", name), http.StatusBadRequest)
+		return
+	}
 
 	content := r.FormValue("content")
 	if len(content) == 0 {
@@ -298,52 +244,8 @@ func handleAddDoc(w http.ResponseWriter, r *http.Request, table *bigtable.Table)
 		return
 	}
 
-	var (
-		writeErr error          // Set if any write fails.
-		mu       sync.Mutex     // Protects writeErr
-		wg       sync.WaitGroup // Used to wait for all writes to finish.
-	)
-
-	// writeOneColumn writes one column in one row, updates err if there is an error,
-	// and signals wg that one operation has finished.
-	writeOneColumn := func(row, family, column, value string, ts bigtable.Timestamp) {
-		mut := bigtable.NewMutation()
-		mut.Set(family, column, ts, []byte(value))
-		err := table.Apply(ctx, row, mut)
-		if err != nil {
-			mu.Lock()
-			writeErr = err
-			mu.Unlock()
-		}
-	}
-
-	// Start a write to store the document content.
-	wg.Add(1)
-	go func() {
-		writeOneColumn(name, contentColumnFamily, "", content, bigtable.Now())
-		wg.Done()
-	}()
-
-	// Start writes to store the document name in the index for each word in the document.
-	words := tokenize(content)
-	for _, word := range words {
-		var (
-			row    = word
-			family = indexColumnFamily
-			column = name
-			value  = ""
-			ts     = bigtable.Now()
-		)
-		wg.Add(1)
-		go func() {
-			// TODO: should use a semaphore to limit the number of concurrent writes.
-			writeOneColumn(row, family, column, value, ts)
-			wg.Done()
-		}()
-	}
-	wg.Wait()
-	if writeErr != nil {
-		http.Error(w, "Error writing to Bigtable: "+writeErr.Error(), http.StatusInternalServerError)
+	if err := addDocument(ctx, table, name, content); err != nil {
+		http.Error(w, "Error writing to Bigtable: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	var buf bytes.Buffer
@@ -397,47 +299,59 @@ func copyTable(src, dst string, client *bigtable.Client, adminClient *bigtable.A
 	dstTable := client.Open(dst)
 
 	var (
-		writeErr error          // Set if any write fails.
-		mu       sync.Mutex     // Protects writeErr
-		wg       sync.WaitGroup // Used to wait for all writes to finish.
+		batch    = make(rowMutations) // Rows waiting to be written.
+		writeErr error                // Set if any write fails.
+		srcStats corpusStats
 	)
-	copyRowToTable := func(row bigtable.Row) bool {
-		mu.Lock()
-		failed := writeErr != nil
-		mu.Unlock()
-		if failed {
-			return false
+	// flush writes the batch to dstTable with a single ApplyBulk call.
+	flush := func() {
+		rowErrs, err := batch.apply(ctx, dstTable)
+		for key, rowErr := range rowErrs {
+			err = fmt.Errorf("row %q: %w", key, rowErr)
 		}
-		mut := bigtable.NewMutation()
+		if err != nil {
+			writeErr = err
+		}
+		batch = make(rowMutations)
+	}
+	copyRowToTable := func(row bigtable.Row) bool {
 		for family, items := range row {
+			if row.Key() == statsRow && family == indexColumnFamily {
+				// Add the source's statistics to ours, rather than
+				// overwriting them.
+				srcStats = parseStats(row)
+				continue
+			}
 			for _, item := range items {
 				// Get the column name, excluding the column family name and ':' character.
 				columnWithoutFamily := item.Column[len(family)+1:]
-				mut.Set(family, columnWithoutFamily, bigtable.Now(), item.Value)
+				batch.row(row.Key()).Set(family, columnWithoutFamily, bigtable.Now(), item.Value)
 			}
 		}
-		wg.Add(1)
-		go func() {
-			// TODO: should use a semaphore to limit the number of concurrent writes.
-			if err := dstTable.Apply(ctx, row.Key(), mut); err != nil {
-				mu.Lock()
-				writeErr = err
-				mu.Unlock()
-			}
-			wg.Done()
-		}()
-		return true
+		if len(batch) >= copyBatchSize {
+			flush()
+		}
+		return writeErr == nil
 	}
 
-	// Create a filter that only accepts the column families we're interested in.
-	filter := bigtable.FamilyFilter(indexColumnFamily + "|" + contentColumnFamily)
+	// Create a filter that only accepts the latest cells in the column
+	// families we're interested in.
+	filter := bigtable.ChainFilters(
+		bigtable.FamilyFilter(indexColumnFamily+"|"+contentColumnFamily),
+		bigtable.LatestNFilter(1),
+	)
 	// Read every row from srcTable, and call copyRowToTable to copy it to our table.
 	err := srcTable.ReadRows(ctx, bigtable.InfiniteRange(""), copyRowToTable, bigtable.RowFilter(filter))
-	wg.Wait()
 	if err != nil {
 		return err
 	}
-	return writeErr
+	if writeErr == nil && len(batch) > 0 {
+		flush()
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	return updateStats(ctx, dstTable, srcStats)
 }
 
 // handleCopy copies data from one table to another.
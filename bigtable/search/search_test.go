@@ -0,0 +1,217 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigtable"
+	"cloud.google.com/go/bigtable/bttest"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newTestTable creates a table with the index and content column families
+// in an in-memory Bigtable emulator.
+func newTestTable(t *testing.T) (*bigtable.Client, *bigtable.AdminClient) {
+	t.Helper()
+	ctx := context.Background()
+	srv, err := bttest.NewServer("localhost:0")
+	if err != nil {
+		t.Fatalf("bttest.NewServer: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	conn, err := grpc.Dial(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	adminClient, err := bigtable.NewAdminClient(ctx, "project", "instance", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("NewAdminClient: %v", err)
+	}
+	client, err := bigtable.NewClient(ctx, "project", "instance", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	createTestTable(t, adminClient, "docindex")
+	return client, adminClient
+}
+
+func createTestTable(t *testing.T, adminClient *bigtable.AdminClient, name string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := adminClient.CreateTable(ctx, name); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	for _, family := range []string{indexColumnFamily, contentColumnFamily} {
+		if err := adminClient.CreateColumnFamily(ctx, name, family); err != nil {
+			t.Fatalf("CreateColumnFamily(%s): %v", family, err)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		in   string
+		want searchQuery
+	}{
+		{
+			in:   "Big table",
+			want: searchQuery{groups: [][]phrase{{{"big"}}, {{"table"}}}},
+		},
+		{
+			in:   `"column family" rows`,
+			want: searchQuery{groups: [][]phrase{{{"column", "family"}}, {{"rows"}}}},
+		},
+		{
+			in:   "gopher OR go NOT java -rust",
+			want: searchQuery{groups: [][]phrase{{{"gopher"}, {"go"}}}, exclude: []phrase{{"java"}, {"rust"}}},
+		},
+		{
+			in:   `-"big data" cloud`,
+			want: searchQuery{groups: [][]phrase{{{"cloud"}}}, exclude: []phrase{{"big", "data"}}},
+		},
+	}
+	for _, tc := range tests {
+		got, err := parseQuery(tc.in)
+		if err != nil {
+			t.Errorf("parseQuery(%q): %v", tc.in, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(searchQuery{})); diff != "" {
+			t.Errorf("parseQuery(%q) mismatch (-want +got):\n%s", tc.in, diff)
+		}
+	}
+	for _, in := range []string{"", "   ", "123", "NOT cloud", "OR"} {
+		if _, err := parseQuery(in); err != errEmptyQuery {
+			t.Errorf("parseQuery(%q) got error %v, want errEmptyQuery", in, err)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	content := strings.Repeat("filler text ", 30) + "the Bigtable emulator runs in memory. " + strings.Repeat("more words ", 30)
+	parts := snippet(content, []string{"bigtable", "memory"})
+	var text strings.Builder
+	var matches []string
+	for _, p := range parts {
+		text.WriteString(p.Text)
+		if p.Match {
+			matches = append(matches, p.Text)
+		}
+	}
+	if diff := cmp.Diff([]string{"Bigtable", "memory"}, matches); diff != "" {
+		t.Errorf("snippet highlights mismatch (-want +got):\n%s", diff)
+	}
+	got := text.String()
+	if !strings.HasPrefix(got, "...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("snippet = %q, want ellipses at both ends", got)
+	}
+	if len(got) > snippetLength+len("......") {
+		t.Errorf("snippet has length %d, want at most %d", len(got), snippetLength)
+	}
+
+	short := snippet("Hello, gopher!", []string{"gopher"})
+	want := []snippetPart{{Text: "Hello, "}, {Text: "gopher", Match: true}}
+	if diff := cmp.Diff(want, short); diff != "" {
+		t.Errorf("snippet mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestTable(t)
+	table := client.Open("docindex")
+
+	docs := map[string]string{
+		"emulator": "The Bigtable emulator stores rows in memory. Rows rows rows.",
+		"families": "Each column family in Bigtable has a garbage collection policy for rows.",
+		"gophers":  "Gophers write Go. Go gophers like column stores.",
+	}
+	for name, content := range docs {
+		if err := addDocument(ctx, table, name, content); err != nil {
+			t.Fatalf("addDocument(%q): %v", name, err)
+		}
+	}
+
+	// Results are ranked, so the emulator document, which mentions rows
+	// most, comes first.
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"rows", []string{"emulator", "families"}},
+		{"bigtable rows", []string{"emulator", "families"}},
+		{`"column family"`, []string{"families"}},
+		{`"family column"`, nil},
+		{"emulator OR gophers", []string{"gophers", "emulator"}},
+		{"bigtable -emulator", []string{"families"}},
+		{"column NOT garbage", []string{"gophers"}},
+	}
+	for _, tc := range tests {
+		q, err := parseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parseQuery(%q): %v", tc.query, err)
+		}
+		results, err := search(ctx, table, q)
+		if err != nil {
+			t.Fatalf("search(%q): %v", tc.query, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Title)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("search(%q) mismatch (-want +got):\n%s", tc.query, diff)
+		}
+	}
+
+	stats, err := readStats(ctx, table)
+	if err != nil {
+		t.Fatalf("readStats: %v", err)
+	}
+	if stats.docs != 3 {
+		t.Errorf("readStats got %d docs, want 3", stats.docs)
+	}
+
+	// Re-adding a document removes the postings of words it no longer has.
+	if err := addDocument(ctx, table, "emulator", "The emulator is for tests."); err != nil {
+		t.Fatalf("addDocument: %v", err)
+	}
+	row, err := table.ReadRow(ctx, "memory")
+	if err != nil {
+		t.Fatalf("ReadRow: %v", err)
+	}
+	if len(row[indexColumnFamily]) != 0 {
+		t.Errorf("index row for removed word: got %v, want no postings", row[indexColumnFamily])
+	}
+	q, _ := parseQuery("rows")
+	results, err := search(ctx, table, q)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(results) != 1 || results[0].Title != "families" {
+		t.Errorf("search(rows) after re-adding got %+v, want only families", results)
+	}
+	if stats2, _ := readStats(ctx, table); stats2.docs != 3 || stats2.length >= stats.length {
+		t.Errorf("readStats after re-adding got %+v, want 3 docs and fewer words than %+v", stats2, stats)
+	}
+}
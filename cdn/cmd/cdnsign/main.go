@@ -0,0 +1,136 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command cdnsign signs and verifies Cloud CDN signed URLs and cookies with
+// the keys in a key file. See signing.ParseKeyring for the file format.
+//
+// Usage:
+//
+//	cdnsign -keys FILE sign [-ttl DURATION] URL
+//	cdnsign -keys FILE sign -prefix [-ttl DURATION] URL_PREFIX
+//	cdnsign -keys FILE sign -cookie [-ttl DURATION] URL_PREFIX
+//	cdnsign -keys FILE verify URL
+//	cdnsign -keys FILE verify -cookie VALUE URL
+//
+// Signing uses the first key in the file. Verifying accepts any key.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/cdn/signing"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "cdnsign: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage: cdnsign -keys FILE sign|verify [flags] URL")
+
+// run runs the command with the given arguments, writing its output to w.
+func run(args []string, w io.Writer, now time.Time) error {
+	fs := flag.NewFlagSet("cdnsign", flag.ContinueOnError)
+	keyPath := fs.String("keys", os.Getenv("KEY_PATH"), "Path to the key file. Defaults to $KEY_PATH.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" || fs.NArg() < 1 {
+		return errUsage
+	}
+	keys, err := signing.ReadKeyringFile(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	switch cmd, args := fs.Arg(0), fs.Args()[1:]; cmd {
+	case "sign":
+		return sign(keys, args, w, now)
+	case "verify":
+		return verify(keys, args, w)
+	default:
+		return fmt.Errorf("unknown command %q: %w", cmd, errUsage)
+	}
+}
+
+func sign(keys *signing.Keyring, args []string, w io.Writer, now time.Time) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", time.Hour, "How long the signature is valid for.")
+	prefix := fs.Bool("prefix", false, "Sign a URL prefix, and print the query parameters to add to URLs with the prefix.")
+	cookie := fs.Bool("cookie", false, "Sign a URL prefix, and print the value of a Cloud-CDN-Cookie.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	url, expiration := fs.Arg(0), now.Add(*ttl)
+
+	var (
+		signed string
+		err    error
+	)
+	switch {
+	case *prefix && *cookie:
+		return errors.New("-prefix and -cookie cannot both be set")
+	case *prefix:
+		signed, err = keys.SignURLPrefix(url, expiration)
+	case *cookie:
+		signed, err = keys.SignCookie(url, expiration)
+	default:
+		signed, err = keys.SignURL(url, expiration)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, signed)
+	return nil
+}
+
+func verify(keys *signing.Keyring, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	cookie := fs.String("cookie", "", "The value of a Cloud-CDN-Cookie to verify for the URL.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	v := signing.NewVerifier(keys)
+	var (
+		c   *signing.Claims
+		err error
+	)
+	if *cookie != "" {
+		c, err = v.VerifyCookie(*cookie, fs.Arg(0))
+	} else {
+		c, err = v.VerifyURL(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "valid: key %s, expires %s", c.KeyName, c.Expires.UTC().Format(time.RFC3339))
+	if c.URLPrefix != "" {
+		fmt.Fprintf(w, ", prefix %s", c.URLPrefix)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/cdn/signing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyPath, []byte("my-key nZtRohdNF9m3cKM24IcK4w==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cmd := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(append([]string{"-keys", keyPath}, args...), &out, now)
+		return strings.TrimSpace(out.String()), err
+	}
+
+	url, err := cmd("sign", "-ttl", "10m", "https://example.com/video.mp4")
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if !strings.HasPrefix(url, "https://example.com/video.mp4?Expires=") {
+		t.Errorf("sign got %q", url)
+	}
+	out, err := cmd("verify", url)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !strings.HasPrefix(out, "valid: key my-key") {
+		t.Errorf("verify got %q", out)
+	}
+	if _, err := cmd("verify", strings.Replace(url, "video", "audio", 1)); !errors.Is(err, signing.ErrBadSignature) {
+		t.Errorf("verify of a modified URL got %v, want ErrBadSignature", err)
+	}
+
+	cookie, err := cmd("sign", "-cookie", "https://example.com/media/")
+	if err != nil {
+		t.Fatalf("sign -cookie: %v", err)
+	}
+	out, err = cmd("verify", "-cookie", cookie, "https://example.com/media/1.ts")
+	if err != nil {
+		t.Fatalf("verify -cookie: %v", err)
+	}
+	if !strings.HasSuffix(out, "prefix https://example.com/media/") {
+		t.Errorf("verify -cookie got %q", out)
+	}
+
+	if _, err := cmd("rotate"); err == nil {
+		t.Errorf("unknown command got nil error")
+	}
+}
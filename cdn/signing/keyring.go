@@ -0,0 +1,240 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signing signs and verifies Cloud CDN signed URLs, signed URL
+// prefixes and signed cookies, using a keyring of named keys so that keys
+// can be rotated.
+package signing
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Algorithm is the hash function used to compute HMAC signatures.
+type Algorithm string
+
+// Supported algorithms.
+const (
+	SHA1   Algorithm = "sha1"
+	SHA256 Algorithm = "sha256"
+)
+
+func (a Algorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case SHA1, "":
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q", a)
+}
+
+// A Key is a named signing key.
+type Key struct {
+	// Name must match a key added to the backend service or bucket.
+	Name string
+	// Secret is the raw key, not base64url-encoded.
+	Secret []byte
+	// Algorithm defaults to SHA1.
+	Algorithm Algorithm
+}
+
+func (k Key) validate() error {
+	if k.Name == "" {
+		return errors.New("key has no name")
+	}
+	if len(k.Secret) == 0 {
+		return fmt.Errorf("key %q has no secret", k.Name)
+	}
+	if _, err := k.Algorithm.hash(); err != nil {
+		return fmt.Errorf("key %q: %w", k.Name, err)
+	}
+	return nil
+}
+
+// sign returns the base64url-encoded HMAC of input.
+func (k Key) sign(input string) string {
+	return base64.URLEncoding.EncodeToString(k.mac(input))
+}
+
+func (k Key) mac(input string) []byte {
+	h, err := k.Algorithm.hash()
+	if err != nil {
+		// Keys are validated when they are added to a keyring.
+		panic(err)
+	}
+	mac := hmac.New(h, k.Secret)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+// A Keyring holds the keys that signatures are made and checked with. The
+// first key is the primary key, which new signatures are made with; any key
+// is accepted when verifying. To rotate keys, add the new key, make it the
+// primary key once Cloud CDN has it, and remove the old key once everything
+// signed with it has expired.
+//
+// A Keyring is safe for concurrent use.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []Key
+}
+
+// NewKeyring creates a keyring with the given keys. The first key is the
+// primary key.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	k := &Keyring{}
+	for _, key := range keys {
+		if err := k.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Add adds a key to the keyring. The first key added is the primary key.
+func (k *Keyring) Add(key Key) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, old := range k.keys {
+		if old.Name == key.Name {
+			return fmt.Errorf("duplicate key %q", key.Name)
+		}
+	}
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+// SetPrimary makes the named key the primary key.
+func (k *Keyring) SetPrimary(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i, key := range k.keys {
+		if key.Name == name {
+			copy(k.keys[1:i+1], k.keys[:i])
+			k.keys[0] = key
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownKey, name)
+}
+
+// Remove removes the named key. It reports whether the key was found.
+func (k *Keyring) Remove(name string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i, key := range k.keys {
+		if key.Name == name {
+			k.keys = append(k.keys[:i], k.keys[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Primary returns the primary key. It returns false if the keyring is
+// empty.
+func (k *Keyring) Primary() (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return Key{}, false
+	}
+	return k.keys[0], true
+}
+
+// Lookup returns the named key.
+func (k *Keyring) Lookup(name string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// ParseKeyring parses a key file. Each line has a key name, the
+// base64url-encoded key, and optionally the algorithm, separated by spaces.
+// Empty lines and lines starting with # are ignored. The first key is the
+// primary key. For example:
+//
+//	# name    key                       algorithm
+//	new-key   Vq8k2nmnVWwoZB0oGrhEgg==  sha256
+//	my-key    nZtRohdNF9m3cKM24IcK4w==
+func ParseKeyring(r io.Reader) (*Keyring, error) {
+	k := &Keyring{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want name, key and optional algorithm, got %d fields", n, len(fields))
+		}
+		secret, err := decodeBase64(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: failed to base64url decode: %w", n, err)
+		}
+		key := Key{Name: fields[0], Secret: secret}
+		if len(fields) == 3 {
+			key.Algorithm = Algorithm(strings.ToLower(fields[2]))
+		}
+		if err := k.Add(key); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	return k, nil
+}
+
+// ReadKeyringFile reads a key file in the format of ParseKeyring.
+func ReadKeyringFile(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	defer f.Close()
+	return ParseKeyring(f)
+}
+
+// decodeBase64 decodes base64url with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	if strings.HasSuffix(s, "=") {
+		return base64.URLEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims verified by the Middleware for the
+// request with the given context.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
+
+// Middleware only passes requests with a valid signed URL, signed URL
+// prefix or Cloud-CDN-Cookie on to next, and responds to other requests
+// with 403 Forbidden. The verified claims are available to next from
+// ClaimsFromContext.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		url := RequestURL(r)
+		c, err := v.VerifyURL(url)
+		if errors.Is(err, ErrNoSignature) {
+			if cookie, cerr := r.Cookie(CookieName); cerr == nil {
+				c, err = v.VerifyCookie(cookie.Value, url)
+			}
+		}
+		if err != nil {
+			log.Printf("signing: rejected %s: %v", url, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, c)))
+	})
+}
+
+// RequestURL returns the URL the client requested, as it was signed. The
+// scheme is taken from the X-Forwarded-Proto header if it is set.
+func RequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = strings.TrimSpace(strings.Split(p, ",")[0])
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CookieName is the name of Cloud CDN signed cookies.
+const CookieName = "Cloud-CDN-Cookie"
+
+// SignURL signs url, which should not have the "Expires", "KeyName", or
+// "Signature" query parameters, and returns the signed URL.
+func SignURL(url string, key Key, expiration time.Time) (string, error) {
+	if err := key.validate(); err != nil {
+		return "", err
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	url += fmt.Sprintf("%sExpires=%d&KeyName=%s", sep, expiration.Unix(), key.Name)
+	return url + "&Signature=" + key.sign(url), nil
+}
+
+// SignURLPrefix signs a URL prefix, which must not include query
+// parameters. It returns the query parameters to append to any URL with
+// the prefix.
+func SignURLPrefix(urlPrefix string, key Key, expiration time.Time) (string, error) {
+	if strings.Contains(urlPrefix, "?") {
+		return "", fmt.Errorf("urlPrefix must not include query params: %s", urlPrefix)
+	}
+	return signPrefix(urlPrefix, key, expiration, "&")
+}
+
+// SignCookie signs a URL prefix and returns the value of a Cloud-CDN-Cookie
+// granting access to URLs with the prefix.
+func SignCookie(urlPrefix string, key Key, expiration time.Time) (string, error) {
+	return signPrefix(urlPrefix, key, expiration, ":")
+}
+
+func signPrefix(urlPrefix string, key Key, expiration time.Time, sep string) (string, error) {
+	if err := key.validate(); err != nil {
+		return "", err
+	}
+	input := strings.Join([]string{
+		"URLPrefix=" + base64.URLEncoding.EncodeToString([]byte(urlPrefix)),
+		fmt.Sprintf("Expires=%d", expiration.Unix()),
+		"KeyName=" + key.Name,
+	}, sep)
+	return input + sep + "Signature=" + key.sign(input), nil
+}
+
+// SignURL signs url with the primary key. See SignURL.
+func (k *Keyring) SignURL(url string, expiration time.Time) (string, error) {
+	key, ok := k.Primary()
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	return SignURL(url, key, expiration)
+}
+
+// SignURLPrefix signs urlPrefix with the primary key. See SignURLPrefix.
+func (k *Keyring) SignURLPrefix(urlPrefix string, expiration time.Time) (string, error) {
+	key, ok := k.Primary()
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	return SignURLPrefix(urlPrefix, key, expiration)
+}
+
+// SignCookie signs urlPrefix with the primary key. See SignCookie.
+func (k *Keyring) SignCookie(urlPrefix string, expiration time.Time) (string, error) {
+	key, ok := k.Primary()
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	return SignCookie(urlPrefix, key, expiration)
+}
@@ -0,0 +1,267 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey = Key{
+		Name: "my-key",
+		Secret: []byte{0x9d, 0x9b, 0x51, 0xa2, 0x17, 0x4d, 0x17, 0xd9,
+			0xb7, 0x70, 0xa3, 0x36, 0xe0, 0x87, 0x0a, 0xe3}, // base64url: nZtRohdNF9m3cKM24IcK4w==
+	}
+	testKey256 = Key{
+		Name:      "new-key",
+		Secret:    []byte("0123456789abcdef0123456789abcdef"),
+		Algorithm: SHA256,
+	}
+	// The test vectors expire at this time.
+	testExpiry = time.Unix(1558131350, 0)
+)
+
+func newTestVerifier(t *testing.T, now time.Time, keys ...Key) *Verifier {
+	t.Helper()
+	k, err := NewKeyring(keys...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	v := NewVerifier(k)
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestSignMatchesSamples(t *testing.T) {
+	// The same values as the signedurls and signedcookies samples.
+	url, err := SignURL("http://35.186.234.33/index.html", testKey, testExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://35.186.234.33/index.html?Expires=1558131350&KeyName=my-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE="; url != want {
+		t.Errorf("SignURL got %s, want %s", url, want)
+	}
+	prefix, err := SignURLPrefix("https://media.example.com/segments/", testKey, testExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "URLPrefix=aHR0cHM6Ly9tZWRpYS5leGFtcGxlLmNvbS9zZWdtZW50cy8=&Expires=1558131350&KeyName=my-key&Signature=HWE5tBTZgnYVoZzVLG7BtRnOsgk="; prefix != want {
+		t.Errorf("SignURLPrefix got %s, want %s", prefix, want)
+	}
+	cookie, err := SignCookie("https://media.example.com/segments/", testKey, testExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "URLPrefix=aHR0cHM6Ly9tZWRpYS5leGFtcGxlLmNvbS9zZWdtZW50cy8=:Expires=1558131350:KeyName=my-key:Signature=_qwhz38bxCKdiDqENLIx4ujrw-U="; cookie != want {
+		t.Errorf("SignCookie got %s, want %s", cookie, want)
+	}
+}
+
+func TestVerifyURL(t *testing.T) {
+	before := testExpiry.Add(-time.Minute)
+	v := newTestVerifier(t, before, testKey256, testKey)
+
+	signed256, err := SignURL("https://example.com/a?b=c", testKey256, testExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := SignURLPrefix("https://media.example.com/segments/", testKey, testExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{"sha1", "http://35.186.234.33/index.html?Expires=1558131350&KeyName=my-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=", nil},
+		{"sha1 with query", "https://www.example.com/some/path?some=query&another=param&Expires=1549751461&KeyName=my-key&Signature=sTqqGX5hUJmlRJ84koAIhWW_c3M=", ErrExpired},
+		{"sha256", signed256, nil},
+		{"prefix", "https://media.example.com/segments/1.ts?" + prefix, nil},
+		{"prefix with query", "https://media.example.com/segments/1.ts?quality=hd&" + prefix, nil},
+		{"prefix mismatch", "https://media.example.com/other/1.ts?" + prefix, ErrPrefixMismatch},
+		{"tampered path", "http://35.186.234.33/other.html?Expires=1558131350&KeyName=my-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=", ErrBadSignature},
+		{"tampered expiry", "http://35.186.234.33/index.html?Expires=1558131351&KeyName=my-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=", ErrBadSignature},
+		{"unknown key", "http://35.186.234.33/index.html?Expires=1558131350&KeyName=old-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=", ErrUnknownKey},
+		{"unsigned", "https://example.com/a?b=c", ErrNoSignature},
+		{"no query", "https://example.com/a", ErrNoSignature},
+		{"signature not last", "http://35.186.234.33/index.html?Expires=1558131350&KeyName=my-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=&x=y", ErrMalformed},
+		{"missing key name", "http://35.186.234.33/index.html?Expires=1558131350&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=", ErrMalformed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := v.VerifyURL(tc.url)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("VerifyURL(%s) got error %v, want %v", tc.url, err, tc.wantErr)
+			}
+			if err == nil && !c.Expires.Equal(testExpiry) {
+				t.Errorf("VerifyURL got expiry %v, want %v", c.Expires, testExpiry)
+			}
+		})
+	}
+
+	// The same URL is rejected once it has expired.
+	v.now = func() time.Time { return testExpiry.Add(time.Second) }
+	if _, err := v.VerifyURL(signed256); !errors.Is(err, ErrExpired) {
+		t.Errorf("VerifyURL after expiry got %v, want ErrExpired", err)
+	}
+}
+
+func TestVerifyCookie(t *testing.T) {
+	v := newTestVerifier(t, testExpiry.Add(-time.Minute), testKey)
+	cookie := "URLPrefix=aHR0cHM6Ly9tZWRpYS5leGFtcGxlLmNvbS9zZWdtZW50cy8=:Expires=1558131350:KeyName=my-key:Signature=_qwhz38bxCKdiDqENLIx4ujrw-U="
+
+	c, err := v.VerifyCookie(cookie, "https://media.example.com/segments/1.ts")
+	if err != nil {
+		t.Fatalf("VerifyCookie: %v", err)
+	}
+	if c.URLPrefix != "https://media.example.com/segments/" || c.KeyName != "my-key" {
+		t.Errorf("VerifyCookie got %+v", c)
+	}
+	if _, err := v.VerifyCookie(cookie, "https://media.example.com/private/1.ts"); !errors.Is(err, ErrPrefixMismatch) {
+		t.Errorf("VerifyCookie for another path got %v, want ErrPrefixMismatch", err)
+	}
+	tampered := strings.Replace(cookie, "Expires=1558131350", "Expires=1858131350", 1)
+	if _, err := v.VerifyCookie(tampered, "https://media.example.com/segments/1.ts"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyCookie with tampered expiry got %v, want ErrBadSignature", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	k, err := NewKeyring(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(k)
+	exp := time.Now().Add(time.Hour)
+	oldURL, err := k.SignURL("https://example.com/video.mp4", exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a new key and start signing with it. URLs signed with either key
+	// are valid.
+	if err := k.Add(testKey256); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetPrimary(testKey256.Name); err != nil {
+		t.Fatal(err)
+	}
+	newURL, err := k.SignURL("https://example.com/video.mp4", exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(newURL, "KeyName=new-key") {
+		t.Errorf("SignURL after rotation got %s, want it signed with new-key", newURL)
+	}
+	for _, url := range []string{oldURL, newURL} {
+		if _, err := v.VerifyURL(url); err != nil {
+			t.Errorf("VerifyURL(%s): %v", url, err)
+		}
+	}
+
+	// Once the old key is removed, only the new URL is valid.
+	if !k.Remove(testKey.Name) {
+		t.Errorf("Remove(%q) got false, want true", testKey.Name)
+	}
+	if _, err := v.VerifyURL(oldURL); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("VerifyURL with removed key got %v, want ErrUnknownKey", err)
+	}
+	if _, err := v.VerifyURL(newURL); err != nil {
+		t.Errorf("VerifyURL(%s): %v", newURL, err)
+	}
+
+	if err := k.Add(Key{Name: "bad", Secret: []byte("x"), Algorithm: "md5"}); err == nil {
+		t.Errorf("Add with unknown algorithm got nil error")
+	}
+	if err := k.Add(testKey256); err == nil {
+		t.Errorf("Add duplicate key got nil error")
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	k, err := ParseKeyring(strings.NewReader(`
+# name    key                       algorithm
+new-key   MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY  SHA256
+my-key    nZtRohdNF9m3cKM24IcK4w==
+`))
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	primary, _ := k.Primary()
+	if primary.Name != "new-key" || primary.Algorithm != SHA256 || !bytes.Equal(primary.Secret, testKey256.Secret) {
+		t.Errorf("Primary got %+v, want %+v", primary, testKey256)
+	}
+	key, ok := k.Lookup("my-key")
+	if !ok || !bytes.Equal(key.Secret, testKey.Secret) {
+		t.Errorf("Lookup(my-key) got %+v, want %+v", key, testKey)
+	}
+
+	for _, bad := range []string{"", "# only comments\n", "my-key\n", "my-key !!!\n", "my-key nZtRohdNF9m3cKM24IcK4w== sha3\n"} {
+		if _, err := ParseKeyring(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseKeyring(%q) got nil error", bad)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	v := newTestVerifier(t, testExpiry.Add(-time.Minute), testKey)
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := ClaimsFromContext(r.Context())
+		w.Write([]byte(c.KeyName))
+	}))
+	cookie := "URLPrefix=aHR0cHM6Ly9tZWRpYS5leGFtcGxlLmNvbS9zZWdtZW50cy8=:Expires=1558131350:KeyName=my-key:Signature=_qwhz38bxCKdiDqENLIx4ujrw-U="
+
+	tests := []struct {
+		name   string
+		target string
+		cookie string
+		proto  string
+		want   int
+	}{
+		{"signed URL", "http://35.186.234.33/index.html?Expires=1558131350&KeyName=my-key&Signature=fm6JZSmKNsB5sys8VGr-JE4LiiE=", "", "", http.StatusOK},
+		{"tampered URL", "http://35.186.234.33/index.html?Expires=1558131350&KeyName=my-key&Signature=AAAAZSmKNsB5sys8VGr-JE4LiiE=", "", "", http.StatusForbidden},
+		{"cookie", "http://media.example.com/segments/1.ts", cookie, "https", http.StatusOK},
+		{"cookie without forwarded proto", "http://media.example.com/segments/1.ts", cookie, "", http.StatusForbidden},
+		{"cookie for other path", "http://media.example.com/other/1.ts", cookie, "https", http.StatusForbidden},
+		{"unsigned", "http://media.example.com/segments/1.ts", "", "https", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CookieName, Value: tc.cookie})
+			}
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && w.Body.String() != "my-key" {
+				t.Errorf("got body %q, want the key name from the claims", w.Body.String())
+			}
+		})
+	}
+}
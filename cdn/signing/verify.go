@@ -0,0 +1,181 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by the Verifier. They are wrapped with details.
+var (
+	ErrNoSignature    = errors.New("no signature")
+	ErrMalformed      = errors.New("malformed signed value")
+	ErrUnknownKey     = errors.New("unknown key")
+	ErrBadSignature   = errors.New("signature does not match")
+	ErrExpired        = errors.New("signature expired")
+	ErrPrefixMismatch = errors.New("URL does not match the signed prefix")
+)
+
+// Claims are the verified contents of a signed URL or cookie.
+type Claims struct {
+	// URLPrefix is the signed prefix, or empty for a signed URL.
+	URLPrefix string
+	Expires   time.Time
+	KeyName   string
+}
+
+// A Verifier checks signed URLs and cookies against the keys in a Keyring.
+type Verifier struct {
+	keys *Keyring
+	now  func() time.Time
+}
+
+// NewVerifier creates a Verifier that accepts signatures made with any key
+// in keys.
+func NewVerifier(keys *Keyring) *Verifier {
+	return &Verifier{keys: keys, now: time.Now}
+}
+
+// signed is a parsed signed value.
+type signed struct {
+	input     string // The signed part of the value.
+	signature string
+	params    map[string]string
+}
+
+// parseSigned parses a value ending with sep+"Signature=...", where the
+// signed part ends with the given parameters, separated by sep.
+func parseSigned(value, sep string, names ...string) (*signed, error) {
+	i := strings.LastIndex(value, sep+"Signature=")
+	if i < 0 {
+		return nil, ErrNoSignature
+	}
+	s := &signed{
+		input:     value[:i],
+		signature: value[i+len(sep+"Signature="):],
+		params:    make(map[string]string),
+	}
+	if s.signature == "" || strings.ContainsAny(s.signature, sep+"&#") {
+		return nil, fmt.Errorf("%w: Signature must be the last parameter", ErrMalformed)
+	}
+	fields := strings.Split(s.input, sep)
+	if len(fields) < len(names) {
+		return nil, fmt.Errorf("%w: want %s", ErrMalformed, strings.Join(names, ", "))
+	}
+	fields = fields[len(fields)-len(names):]
+	for i, name := range names {
+		v := strings.TrimPrefix(fields[i], name+"=")
+		if v == fields[i] {
+			return nil, fmt.Errorf("%w: want %s, got %q", ErrMalformed, name, fields[i])
+		}
+		s.params[name] = v
+	}
+	return s, nil
+}
+
+// check verifies the signature and expiry of a parsed value.
+func (v *Verifier) check(s *signed) (*Claims, error) {
+	key, ok := v.keys.Lookup(s.params["KeyName"])
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, s.params["KeyName"])
+	}
+	sig, err := decodeBase64(s.signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Signature: %v", ErrMalformed, err)
+	}
+	if !hmac.Equal(sig, key.mac(s.input)) {
+		return nil, ErrBadSignature
+	}
+	exp, err := strconv.ParseInt(s.params["Expires"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Expires: %v", ErrMalformed, err)
+	}
+	c := &Claims{Expires: time.Unix(exp, 0), KeyName: key.Name}
+	if v.now().After(c.Expires) {
+		return nil, fmt.Errorf("%w at %v", ErrExpired, c.Expires.UTC())
+	}
+	if p, ok := s.params["URLPrefix"]; ok {
+		prefix, err := decodeBase64(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: URLPrefix: %v", ErrMalformed, err)
+		}
+		c.URLPrefix = string(prefix)
+	}
+	return c, nil
+}
+
+// VerifyURL checks a URL signed with SignURL, or a URL with the query
+// parameters returned by SignURLPrefix.
+func (v *Verifier) VerifyURL(url string) (*Claims, error) {
+	q := strings.Index(url, "?")
+	if q < 0 {
+		return nil, ErrNoSignature
+	}
+	// A signed prefix is a URLPrefix parameter followed by the signed
+	// parameters.
+	query := "&" + url[q+1:]
+	if p := strings.LastIndex(query, "&URLPrefix="); p >= 0 {
+		s, err := parseSigned(query[p+1:], "&", "URLPrefix", "Expires", "KeyName")
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.Split(s.input, "&")) != 3 {
+			return nil, fmt.Errorf("%w: unexpected parameters after URLPrefix", ErrMalformed)
+		}
+		c, err := v.check(s)
+		if err != nil {
+			return nil, err
+		}
+		// The URL without the signed parameters must have the prefix.
+		unsigned := url[:q+p]
+		if !strings.HasPrefix(unsigned, c.URLPrefix) {
+			return nil, fmt.Errorf("%w: %q", ErrPrefixMismatch, c.URLPrefix)
+		}
+		return c, nil
+	}
+
+	// A signed URL is signed up to the Signature parameter.
+	s, err := parseSigned(url[q+1:], "&", "Expires", "KeyName")
+	if err != nil {
+		return nil, err
+	}
+	s.input = url[:q+1] + s.input
+	return v.check(s)
+}
+
+// VerifyCookie checks the value of a Cloud-CDN-Cookie signed with
+// SignCookie, and that url has the signed prefix.
+func (v *Verifier) VerifyCookie(value, url string) (*Claims, error) {
+	s, err := parseSigned(value, ":", "URLPrefix", "Expires", "KeyName")
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.Split(s.input, ":")) != 3 {
+		return nil, fmt.Errorf("%w: unexpected fields in cookie", ErrMalformed)
+	}
+	c, err := v.check(s)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(url, c.URLPrefix) {
+		return nil, fmt.Errorf("%w: %q", ErrPrefixMismatch, c.URLPrefix)
+	}
+	return c, nil
+}
@@ -25,10 +25,13 @@ import (
 
 // Task is the model used to store tasks in the datastore.
 type Task struct {
-	Desc    string    `datastore:"description"`
-	Created time.Time `datastore:"created"`
-	Done    bool      `datastore:"done"`
-	id      int64     // The integer ID used in the datastore.
+	Desc     string    `datastore:"description"`
+	Created  time.Time `datastore:"created"`
+	Done     bool      `datastore:"done"`
+	Tags     []string  `datastore:"tags"`
+	Priority int       `datastore:"priority"`
+	Due      time.Time `datastore:"due,omitempty"`
+	id       int64     // The integer ID used in the datastore.
 }
 
 // AddTask adds a task with the given description to the datastore,
@@ -0,0 +1,172 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+const cliUsage = `Usage: tasks <command> [flags] [args]
+
+  new [--tag TAG]... [--priority N] [--due DATE] <description>
+                     Adds a task. DATE is 2006-01-02 or an RFC 3339 time
+  done <task-id>     Marks a task as done
+  delete <task-id>   Deletes a task
+  list [--tag TAG]... [--overdue] [--sort created|priority|due]
+       [--output table|json|csv]
+                     Lists tasks
+  export [--output json|csv]
+                     Writes all tasks to stdout
+  import [--input json|csv] [FILE]
+                     Adds or replaces tasks from FILE or stdin, as written
+                     by export
+
+With no command, tasks reads commands from stdin.
+`
+
+// run runs a single command and returns.
+func run(ctx context.Context, client *datastore.Client, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(cliUsage)
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var tags stringList
+	var (
+		priority = fs.Int("priority", 0, "")
+		due      = fs.String("due", "", "")
+		overdue  = fs.Bool("overdue", false, "")
+		order    = fs.String("sort", "created", "")
+		output   = fs.String("output", "table", "")
+		input    = fs.String("input", "json", "")
+	)
+	fs.Var(&tags, "tag", "")
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%s: %w\n\n%s", args[0], err, cliUsage)
+	}
+	rest := fs.Args()
+
+	switch args[0] {
+	case "new":
+		if len(rest) == 0 {
+			return fmt.Errorf("missing description in %q command", args[0])
+		}
+		t := &Task{
+			Desc:     strings.Join(rest, " "),
+			Created:  time.Now(),
+			Tags:     tags,
+			Priority: *priority,
+		}
+		if *due != "" {
+			d, err := parseDue(*due)
+			if err != nil {
+				return fmt.Errorf("invalid due date %q: %w", *due, err)
+			}
+			t.Due = d
+		}
+		if err := PutTasks(ctx, client, []*Task{t}); err != nil {
+			return fmt.Errorf("failed to create task: %w", err)
+		}
+		fmt.Fprintf(stdout, "Created new task with ID %d\n", t.id)
+
+	case "done", "delete":
+		if len(rest) != 1 {
+			return fmt.Errorf("missing numerical task ID in %q command", args[0])
+		}
+		id, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid task ID %q", rest[0])
+		}
+		key := datastore.IDKey("Task", id, nil)
+		if args[0] == "delete" {
+			if err := client.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete task: %w", err)
+			}
+			fmt.Fprintf(stdout, "Task %d deleted\n", id)
+			break
+		}
+		_, err = client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var task Task
+			if err := tx.Get(key, &task); err != nil {
+				return err
+			}
+			task.Done = true
+			_, err := tx.Put(key, &task)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to mark task done: %w", err)
+		}
+		fmt.Fprintf(stdout, "Task %d marked done\n", id)
+
+	case "list", "export":
+		f := TaskFilter{Tags: tags, Overdue: *overdue, Now: time.Now(), Sort: *order}
+		format := *output
+		if args[0] == "export" {
+			f = TaskFilter{}
+			if format == "table" {
+				format = "json"
+			}
+		}
+		tasks, err := QueryTasks(ctx, client, f)
+		if err != nil {
+			return fmt.Errorf("failed to fetch task list: %w", err)
+		}
+		return WriteTasks(stdout, tasks, format)
+
+	case "import":
+		r := stdin
+		if len(rest) > 0 {
+			f, err := os.Open(rest[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		tasks, err := ReadTasks(r, *input)
+		if err != nil {
+			return fmt.Errorf("failed to read tasks: %w", err)
+		}
+		if err := PutTasks(ctx, client, tasks); err != nil {
+			return fmt.Errorf("failed to import tasks: %w", err)
+		}
+		fmt.Fprintf(stdout, "Imported %d tasks\n", len(tasks))
+
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], cliUsage)
+	}
+	return nil
+}
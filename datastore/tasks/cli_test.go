@@ -0,0 +1,253 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func testTasks() []*Task {
+	created := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	return []*Task{
+		{id: 1, Desc: "write report", Created: created, Priority: 1, Tags: []string{"work"}},
+		{id: 2, Desc: "buy milk, eggs", Created: created.Add(time.Hour), Priority: 3, Tags: []string{"home", "shop"},
+			Due: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{id: 3, Desc: "call \"Bob\"", Created: created.Add(2 * time.Hour), Done: true},
+	}
+}
+
+func TestReadWriteTasks(t *testing.T) {
+	for _, format := range []string{"json", "csv"} {
+		var buf bytes.Buffer
+		if err := WriteTasks(&buf, testTasks(), format); err != nil {
+			t.Fatalf("WriteTasks(%s): %v", format, err)
+		}
+		got, err := ReadTasks(&buf, format)
+		if err != nil {
+			t.Fatalf("ReadTasks(%s): %v", format, err)
+		}
+		want := testTasks()
+		if len(got) != len(want) {
+			t.Fatalf("ReadTasks(%s) got %d tasks, want %d", format, len(got), len(want))
+		}
+		for i := range want {
+			if !tasksEqual(got[i], want[i]) {
+				t.Errorf("ReadTasks(%s) task %d: got %+v, want %+v", format, i, got[i], want[i])
+			}
+		}
+	}
+
+	if _, err := ReadTasks(strings.NewReader("a,b\n1,2\n"), "csv"); err == nil {
+		t.Errorf("ReadTasks(csv without header): got nil error")
+	}
+	if err := WriteTasks(&bytes.Buffer{}, nil, "xml"); err == nil {
+		t.Errorf("WriteTasks(xml): got nil error")
+	}
+}
+
+func tasksEqual(a, b *Task) bool {
+	return a.id == b.id && a.Desc == b.Desc && a.Created.Equal(b.Created) && a.Done == b.Done &&
+		a.Priority == b.Priority && a.Due.Equal(b.Due) && len(a.Tags) == len(b.Tags) &&
+		(len(a.Tags) == 0 || reflect.DeepEqual(a.Tags, b.Tags))
+}
+
+func TestWriteTasksJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTasks(&buf, testTasks()[2:], "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{{
+		"id":          3.0,
+		"description": `call "Bob"`,
+		"created":     "2023-01-01T11:00:00Z",
+		"done":        true,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteTasks(json) = %v, want %v", got, want)
+	}
+}
+
+func TestTaskOrder(t *testing.T) {
+	tests := []struct {
+		sort string
+		want []int64
+	}{
+		{"created", []int64{1, 2, 3}},
+		{"priority", []int64{2, 1, 3}},
+		{"due", []int64{2, 1, 3}},
+	}
+	for _, test := range tests {
+		less, err := taskOrder(test.sort)
+		if err != nil {
+			t.Fatalf("taskOrder(%q): %v", test.sort, err)
+		}
+		tasks := testTasks()
+		tasks[0], tasks[2] = tasks[2], tasks[0]
+		var got []int64
+		for len(tasks) > 0 {
+			min := 0
+			for i := range tasks {
+				if less(tasks[i], tasks[min]) {
+					min = i
+				}
+			}
+			got = append(got, tasks[min].id)
+			tasks = append(tasks[:min], tasks[min+1:]...)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("taskOrder(%q) = %v, want %v", test.sort, got, test.want)
+		}
+	}
+	if _, err := taskOrder("size"); err == nil {
+		t.Errorf("taskOrder(size): got nil error")
+	}
+}
+
+func TestParseDue(t *testing.T) {
+	got, err := parseDue("2023-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local); !got.Equal(want) {
+		t.Errorf("parseDue(date) = %v, want %v", got, want)
+	}
+	if _, err := parseDue("tomorrow"); err == nil {
+		t.Errorf("parseDue(tomorrow): got nil error")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"new"},
+		{"new", "--priority", "high", "task"},
+		{"new", "--due", "someday", "task"},
+		{"done"},
+		{"delete", "abc"},
+		{"list", "--sort", "size"},
+	} {
+		if err := run(ctx, nil, args, nil, &bytes.Buffer{}); err == nil {
+			t.Errorf("run(%q): got nil error", args)
+		}
+	}
+}
+
+// TestEmulator runs the commands against the Datastore emulator, started
+// with:
+//
+//	gcloud beta emulators datastore start --consistency=1.0
+//	$(gcloud beta emulators datastore env-init)
+func TestEmulator(t *testing.T) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("DATASTORE_EMULATOR_HOST not set")
+	}
+	projectID := os.Getenv("DATASTORE_PROJECT_ID")
+	if projectID == "" {
+		projectID = "test-project"
+	}
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		t.Fatalf("datastore.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	// A unique tag keeps the tasks of this test apart from others.
+	tag := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	cmd := func(stdin string, args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(ctx, client, args, strings.NewReader(stdin), &out); err != nil {
+			t.Fatalf("run(%q): %v", args, err)
+		}
+		return out.String()
+	}
+	var id int64
+	if _, err := fmt.Sscanf(cmd("", "new", "--tag", tag, "--priority", "1", "--due", "2000-01-01", "old task"), "Created new task with ID %d", &id); err != nil {
+		t.Fatal(err)
+	}
+	cmd("", "new", "--tag", tag, "--priority", "5", "urgent task")
+	cmd("", "new", "--tag", tag, "--tag", "later", "--due", "2999-01-01", "future task")
+
+	list := func(args ...string) []taskRecord {
+		t.Helper()
+		var records []taskRecord
+		out := cmd("", append([]string{"list", "--tag", tag, "--output", "json"}, args...)...)
+		if err := json.Unmarshal([]byte(out), &records); err != nil {
+			t.Fatalf("list %q: %v\n%s", args, err, out)
+		}
+		return records
+	}
+	descs := func(records []taskRecord) string {
+		var d []string
+		for _, r := range records {
+			d = append(d, r.Description)
+		}
+		return strings.Join(d, ", ")
+	}
+
+	if got, want := descs(list()), "old task, urgent task, future task"; got != want {
+		t.Errorf("list: got %s, want %s", got, want)
+	}
+	if got, want := descs(list("--sort", "priority")), "urgent task, old task, future task"; got != want {
+		t.Errorf("list --sort priority: got %s, want %s", got, want)
+	}
+	if got, want := descs(list("--overdue")), "old task"; got != want {
+		t.Errorf("list --overdue: got %s, want %s", got, want)
+	}
+	if got, want := descs(list("--tag", "later")), "future task"; got != want {
+		t.Errorf("list --tag later: got %s, want %s", got, want)
+	}
+
+	cmd("", "done", fmt.Sprint(id))
+	if got := list("--overdue"); len(got) != 0 {
+		t.Errorf("list --overdue after done: got %s, want none", descs(got))
+	}
+
+	// Export, delete everything, and import it again.
+	records := list()
+	exported := cmd("", "list", "--tag", tag, "--output", "csv")
+	for _, r := range records {
+		cmd("", "delete", fmt.Sprint(r.ID))
+	}
+	if got := list(); len(got) != 0 {
+		t.Fatalf("list after delete: got %s, want none", descs(got))
+	}
+	if got, want := cmd(exported, "import", "--input", "csv"), "Imported 3 tasks\n"; got != want {
+		t.Errorf("import: got %q, want %q", got, want)
+	}
+	if got := list(); !reflect.DeepEqual(got, records) {
+		t.Errorf("list after import: got %+v, want %+v", got, records)
+	}
+	for _, r := range records {
+		cmd("", "delete", fmt.Sprint(r.ID))
+	}
+}
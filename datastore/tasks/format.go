@@ -0,0 +1,183 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// taskRecord is the JSON representation of a task.
+type taskRecord struct {
+	ID          int64      `json:"id,omitempty"`
+	Description string     `json:"description"`
+	Created     time.Time  `json:"created"`
+	Done        bool       `json:"done"`
+	Tags        []string   `json:"tags,omitempty"`
+	Priority    int        `json:"priority,omitempty"`
+	Due         *time.Time `json:"due,omitempty"`
+}
+
+func toRecord(t *Task) taskRecord {
+	r := taskRecord{
+		ID:          t.id,
+		Description: t.Desc,
+		Created:     t.Created,
+		Done:        t.Done,
+		Tags:        t.Tags,
+		Priority:    t.Priority,
+	}
+	if !t.Due.IsZero() {
+		due := t.Due
+		r.Due = &due
+	}
+	return r
+}
+
+func fromRecord(r taskRecord) *Task {
+	t := &Task{
+		Desc:     r.Description,
+		Created:  r.Created,
+		Done:     r.Done,
+		Tags:     r.Tags,
+		Priority: r.Priority,
+		id:       r.ID,
+	}
+	if r.Due != nil {
+		t.Due = *r.Due
+	}
+	if t.Created.IsZero() {
+		t.Created = time.Now()
+	}
+	return t
+}
+
+var csvHeader = []string{"id", "description", "created", "done", "tags", "priority", "due"}
+
+// WriteTasks writes the tasks in the given format: "table", "json" or "csv".
+// In CSV, tags are separated by spaces.
+func WriteTasks(w io.Writer, tasks []*Task, format string) error {
+	switch format {
+	case "", "table":
+		PrintTasks(w, tasks)
+		return nil
+	case "json":
+		records := make([]taskRecord, len(tasks))
+		for i, t := range tasks {
+			records[i] = toRecord(t)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, t := range tasks {
+			due := ""
+			if !t.Due.IsZero() {
+				due = t.Due.Format(time.RFC3339)
+			}
+			cw.Write([]string{
+				strconv.FormatInt(t.id, 10),
+				t.Desc,
+				t.Created.Format(time.RFC3339Nano),
+				strconv.FormatBool(t.Done),
+				strings.Join(t.Tags, " "),
+				strconv.Itoa(t.Priority),
+				due,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown output format %q: want table, json or csv", format)
+}
+
+// ReadTasks reads tasks written by WriteTasks in the "json" or "csv" format.
+func ReadTasks(r io.Reader, format string) ([]*Task, error) {
+	switch format {
+	case "", "json":
+		var records []taskRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, err
+		}
+		tasks := make([]*Task, len(records))
+		for i, rec := range records {
+			tasks[i] = fromRecord(rec)
+		}
+		return tasks, nil
+	case "csv":
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+			return nil, fmt.Errorf("missing CSV header %q", strings.Join(csvHeader, ","))
+		}
+		var tasks []*Task
+		for n, row := range rows[1:] {
+			rec, err := parseCSVRecord(row)
+			if err != nil {
+				return nil, fmt.Errorf("CSV line %d: %w", n+2, err)
+			}
+			tasks = append(tasks, fromRecord(rec))
+		}
+		return tasks, nil
+	}
+	return nil, fmt.Errorf("unknown input format %q: want json or csv", format)
+}
+
+func parseCSVRecord(row []string) (taskRecord, error) {
+	rec := taskRecord{Description: row[1], Tags: strings.Fields(row[4])}
+	var err error
+	if row[0] != "" && row[0] != "0" {
+		if rec.ID, err = strconv.ParseInt(row[0], 10, 64); err != nil {
+			return rec, fmt.Errorf("id: %w", err)
+		}
+	}
+	if row[2] != "" {
+		if rec.Created, err = time.Parse(time.RFC3339Nano, row[2]); err != nil {
+			return rec, fmt.Errorf("created: %w", err)
+		}
+	}
+	if rec.Done, err = strconv.ParseBool(row[3]); err != nil {
+		return rec, fmt.Errorf("done: %w", err)
+	}
+	if rec.Priority, err = strconv.Atoi(row[5]); err != nil {
+		return rec, fmt.Errorf("priority: %w", err)
+	}
+	if row[6] != "" {
+		due, err := parseDue(row[6])
+		if err != nil {
+			return rec, fmt.Errorf("due: %w", err)
+		}
+		rec.Due = &due
+	}
+	return rec, nil
+}
+
+// parseDue parses a due date, either as a date such as 2023-12-31, which is
+// due at the end of that day in local time, or as an RFC 3339 time.
+func parseDue(s string) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return d.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// putBatchSize is the maximum number of entities in a PutMulti call.
+const putBatchSize = 500
+
+// TaskFilter selects and orders the tasks returned by QueryTasks.
+type TaskFilter struct {
+	// Tags selects the tasks that have all of the tags.
+	Tags []string
+	// Overdue selects the tasks that are not done and were due before Now.
+	Overdue bool
+	Now     time.Time
+	// Sort is "created" (the default), "priority" (highest first) or "due"
+	// (soonest first, tasks without a due date last).
+	Sort string
+}
+
+// QueryTasks returns the tasks selected by f. Tags are filtered by the
+// query, and everything else in memory, so that no composite indexes are
+// needed.
+func QueryTasks(ctx context.Context, client *datastore.Client, f TaskFilter) ([]*Task, error) {
+	less, err := taskOrder(f.Sort)
+	if err != nil {
+		return nil, err
+	}
+	query := datastore.NewQuery("Task")
+	for _, tag := range f.Tags {
+		query = query.FilterField("tags", "=", tag)
+	}
+	var tasks []*Task
+	keys, err := client.GetAll(ctx, query, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	selected := tasks[:0]
+	for i, t := range tasks {
+		t.id = keys[i].ID
+		if f.Overdue && (t.Done || t.Due.IsZero() || !t.Due.Before(f.Now)) {
+			continue
+		}
+		selected = append(selected, t)
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return less(selected[i], selected[j]) })
+	return selected, nil
+}
+
+// taskOrder returns the ordering of tasks with the given name.
+func taskOrder(name string) (func(a, b *Task) bool, error) {
+	byCreated := func(a, b *Task) bool {
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.id < b.id
+	}
+	switch name {
+	case "", "created":
+		return byCreated, nil
+	case "priority":
+		return func(a, b *Task) bool {
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			return byCreated(a, b)
+		}, nil
+	case "due":
+		return func(a, b *Task) bool {
+			if !a.Due.Equal(b.Due) {
+				if a.Due.IsZero() || b.Due.IsZero() {
+					return b.Due.IsZero()
+				}
+				return a.Due.Before(b.Due)
+			}
+			return byCreated(a, b)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown sort order %q: want created, priority or due", name)
+}
+
+// PutTasks stores the tasks. Tasks with an ID replace the task with that
+// ID; the others are added, and their IDs are set.
+func PutTasks(ctx context.Context, client *datastore.Client, tasks []*Task) error {
+	for start := 0; start < len(tasks); start += putBatchSize {
+		batch := tasks[start:]
+		if len(batch) > putBatchSize {
+			batch = batch[:putBatchSize]
+		}
+		keys := make([]*datastore.Key, len(batch))
+		for i, t := range batch {
+			if t.id != 0 {
+				keys[i] = datastore.IDKey("Task", t.id, nil)
+			} else {
+				keys[i] = datastore.IncompleteKey("Task", nil)
+			}
+		}
+		keys, err := client.PutMulti(ctx, keys, batch)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			batch[i].id = k.ID
+		}
+	}
+	return nil
+}
@@ -14,10 +14,14 @@
 
 // A simple command-line task list manager to demonstrate using the
 // cloud.google.com/go/datastore package.
+//
+// With a command, such as "tasks list --tag home", it runs the command and
+// exits. Without one, it reads commands from stdin.
 package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -38,6 +42,15 @@ func main() {
 	}
 	defer client.Close()
 
+	if len(os.Args) > 1 {
+		if err := run(context.Background(), client, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			client.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Print welcome message.
 	fmt.Println("Cloud Datastore Task List")
 	fmt.Println()
@@ -112,13 +125,17 @@ func main() {
 func PrintTasks(w io.Writer, tasks []*Task) {
 	// Use a tab writer to help make results pretty.
 	tw := tabwriter.NewWriter(w, 8, 8, 1, ' ', 0) // Min cell size of 8.
-	fmt.Fprintf(tw, "ID\tDescription\tStatus\n")
+	fmt.Fprintf(tw, "ID\tDescription\tStatus\tPriority\tDue\tTags\n")
 	for _, t := range tasks {
-		if t.Done {
-			fmt.Fprintf(tw, "%d\t%s\tdone\n", t.id, t.Desc)
-		} else {
-			fmt.Fprintf(tw, "%d\t%s\tcreated %v\n", t.id, t.Desc, t.Created)
+		status := "done"
+		if !t.Done {
+			status = fmt.Sprintf("created %v", t.Created)
+		}
+		due := ""
+		if !t.Due.IsZero() {
+			due = t.Due.Format("2006-01-02 15:04")
 		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", t.id, t.Desc, status, t.Priority, due, strings.Join(t.Tags, " "))
 	}
 	tw.Flush()
 }
@@ -0,0 +1,255 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// counterDoc is the counter document of a ReshardableCounter. It holds the
+// number of shards and the total rolled up by the aggregator.
+type counterDoc struct {
+	NumShards    int
+	Total        int64
+	TotalUpdated time.Time
+}
+
+// shardCacheTTL is how long a ReshardableCounter uses the number of shards
+// before reading it again.
+const shardCacheTTL = time.Minute
+
+// ReshardableCounter is a sharded counter whose number of shards is stored
+// in the counter document, so that it can be changed while the counter is
+// in use. Shards are stored like those of a Counter.
+type ReshardableCounter struct {
+	client *firestore.Client
+	doc    *firestore.DocumentRef
+
+	mu        sync.Mutex
+	numShards int
+	fetched   time.Time
+}
+
+func newReshardableCounter(client *firestore.Client, doc *firestore.DocumentRef) *ReshardableCounter {
+	return &ReshardableCounter{client: client, doc: doc}
+}
+
+func (c *ReshardableCounter) shard(n int) *firestore.DocumentRef {
+	return c.doc.Collection("shards").Doc(strconv.Itoa(n))
+}
+
+// initCounter creates the counter document and numShards shards.
+func (c *ReshardableCounter) initCounter(ctx context.Context, numShards int) error {
+	if numShards < 1 {
+		return fmt.Errorf("numShards is %d, want at least 1", numShards)
+	}
+	batch := c.client.Batch()
+	batch.Set(c.doc, counterDoc{NumShards: numShards, TotalUpdated: time.Now()})
+	for n := 0; n < numShards; n++ {
+		batch.Set(c.shard(n), Shard{0})
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	c.setNumShards(numShards)
+	return nil
+}
+
+func (c *ReshardableCounter) setNumShards(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.numShards, c.fetched = n, time.Now()
+}
+
+// getNumShards returns the number of shards, reading it from the counter
+// document if the cached value is stale.
+func (c *ReshardableCounter) getNumShards(ctx context.Context, refresh bool) (int, error) {
+	c.mu.Lock()
+	n, fetched := c.numShards, c.fetched
+	c.mu.Unlock()
+	if !refresh && n > 0 && time.Since(fetched) < shardCacheTTL {
+		return n, nil
+	}
+	snap, err := c.doc.Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("Get: %w", err)
+	}
+	var d counterDoc
+	if err := snap.DataTo(&d); err != nil {
+		return 0, fmt.Errorf("DataTo: %w", err)
+	}
+	if d.NumShards < 1 {
+		return 0, fmt.Errorf("counter %s has %d shards", c.doc.Path, d.NumShards)
+	}
+	c.setNumShards(d.NumShards)
+	return d.NumShards, nil
+}
+
+// incrementCounter adds n to a randomly picked shard. If the counter was
+// resharded and the shard no longer exists, it picks another one.
+func (c *ReshardableCounter) incrementCounter(ctx context.Context, n int64) error {
+	refresh := false
+	for {
+		numShards, err := c.getNumShards(ctx, refresh)
+		if err != nil {
+			return err
+		}
+		_, err = c.shard(rand.Intn(numShards)).Update(ctx, []firestore.Update{
+			{Path: "Count", Value: firestore.Increment(n)},
+		})
+		if status.Code(err) == codes.NotFound && !refresh {
+			refresh = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Update: %w", err)
+		}
+		return nil
+	}
+}
+
+// reshard changes the number of shards, preserving the total. When
+// shrinking, the counts of the removed shards are added to the remaining
+// ones.
+func (c *ReshardableCounter) reshard(ctx context.Context, numShards int) error {
+	if numShards < 1 {
+		return fmt.Errorf("numShards is %d, want at least 1", numShards)
+	}
+	err := c.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(c.doc)
+		if err != nil {
+			return err
+		}
+		var d counterDoc
+		if err := snap.DataTo(&d); err != nil {
+			return err
+		}
+		old := d.NumShards
+
+		// Read every shard that changes before writing.
+		counts := make(map[int]int64)
+		if numShards < old {
+			for n := 0; n < old; n++ {
+				s, err := tx.Get(c.shard(n))
+				if err != nil {
+					return err
+				}
+				var shard Shard
+				if err := s.DataTo(&shard); err != nil {
+					return err
+				}
+				counts[n] = int64(shard.Count)
+			}
+		}
+
+		if err := tx.Update(c.doc, []firestore.Update{{Path: "NumShards", Value: numShards}}); err != nil {
+			return err
+		}
+		for n := numShards; n < old; n++ {
+			counts[n%numShards] += counts[n]
+			if err := tx.Delete(c.shard(n)); err != nil {
+				return err
+			}
+		}
+		for n := 0; n < numShards && numShards < old; n++ {
+			if err := tx.Set(c.shard(n), Shard{int(counts[n])}); err != nil {
+				return err
+			}
+		}
+		for n := old; n < numShards; n++ {
+			if err := tx.Set(c.shard(n), Shard{0}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("RunTransaction: %w", err)
+	}
+	c.setNumShards(numShards)
+	return nil
+}
+
+// getCount returns the total count across all shards.
+func (c *ReshardableCounter) getCount(ctx context.Context) (int64, error) {
+	docs, err := c.doc.Collection("shards").Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("GetAll: %w", err)
+	}
+	var total int64
+	for _, doc := range docs {
+		var shard Shard
+		if err := doc.DataTo(&shard); err != nil {
+			return 0, fmt.Errorf("DataTo: %w", err)
+		}
+		total += int64(shard.Count)
+	}
+	return total, nil
+}
+
+// aggregate rolls the shards up into the total in the counter document.
+func (c *ReshardableCounter) aggregate(ctx context.Context) (int64, error) {
+	total, err := c.getCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.doc.Update(ctx, []firestore.Update{
+		{Path: "Total", Value: total},
+		{Path: "TotalUpdated", Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Update: %w", err)
+	}
+	return total, nil
+}
+
+// runAggregator aggregates the counter every interval until ctx is done.
+func (c *ReshardableCounter) runAggregator(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.aggregate(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// getCountApprox returns the total from the last aggregation, and when it
+// was made, reading only the counter document.
+func (c *ReshardableCounter) getCountApprox(ctx context.Context) (int64, time.Time, error) {
+	snap, err := c.doc.Get(ctx)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("Get: %w", err)
+	}
+	var d counterDoc
+	if err := snap.DataTo(&d); err != nil {
+		return 0, time.Time{}, fmt.Errorf("DataTo: %w", err)
+	}
+	return d.Total, d.TotalUpdated, nil
+}
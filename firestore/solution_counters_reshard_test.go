@@ -0,0 +1,189 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// emulatorCounter returns a new ReshardableCounter in the Firestore
+// emulator, skipping the test if FIRESTORE_EMULATOR_HOST is not set.
+func emulatorCounter(t *testing.T, numShards int) (*ReshardableCounter, context.Context) {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("Skipping Firestore emulator test. Set FIRESTORE_EMULATOR_HOST.")
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, "golang-samples-emulator")
+	if err != nil {
+		t.Fatalf("firestore.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	id := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+	c := newReshardableCounter(client, client.Collection("counter_samples").Doc(id))
+	if err := c.initCounter(ctx, numShards); err != nil {
+		t.Fatalf("initCounter: %v", err)
+	}
+	return c, ctx
+}
+
+func wantCount(ctx context.Context, t *testing.T, c *ReshardableCounter, want int64) {
+	t.Helper()
+	got, err := c.getCount(ctx)
+	if err != nil {
+		t.Fatalf("getCount: %v", err)
+	}
+	if got != want {
+		t.Errorf("getCount = %d, want %d", got, want)
+	}
+}
+
+func wantShards(ctx context.Context, t *testing.T, c *ReshardableCounter, want int) {
+	t.Helper()
+	refs, err := c.doc.Collection("shards").DocumentRefs(ctx).GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(refs) != want {
+		t.Errorf("got %d shards, want %d", len(refs), want)
+	}
+}
+
+func TestReshardableCounterReshard(t *testing.T) {
+	c, ctx := emulatorCounter(t, 3)
+	wantShards(ctx, t, c, 3)
+	for i := 0; i < 10; i++ {
+		if err := c.incrementCounter(ctx, 1); err != nil {
+			t.Fatalf("incrementCounter: %v", err)
+		}
+	}
+
+	for _, n := range []int{8, 2, 1, 5} {
+		if err := c.reshard(ctx, n); err != nil {
+			t.Fatalf("reshard(%d): %v", n, err)
+		}
+		wantShards(ctx, t, c, n)
+		wantCount(ctx, t, c, 10)
+	}
+
+	if err := c.reshard(ctx, 0); err == nil {
+		t.Errorf("reshard(0) succeeded, want an error")
+	}
+}
+
+func TestReshardableCounterStaleShards(t *testing.T) {
+	c, ctx := emulatorCounter(t, 4)
+	other := newReshardableCounter(c.client, c.doc)
+	if err := other.reshard(ctx, 1); err != nil {
+		t.Fatalf("reshard: %v", err)
+	}
+
+	// c still thinks there are 4 shards, and must notice the removed ones.
+	for i := 0; i < 20; i++ {
+		if err := c.incrementCounter(ctx, 2); err != nil {
+			t.Fatalf("incrementCounter: %v", err)
+		}
+	}
+	wantCount(ctx, t, c, 40)
+}
+
+func TestReshardableCounterConcurrent(t *testing.T) {
+	c, ctx := emulatorCounter(t, 2)
+
+	const workers, increments = 4, 25
+	var wg sync.WaitGroup
+	errc := make(chan error, workers+1)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				if err := c.incrementCounter(ctx, 1); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, n := range []int{6, 3, 10, 1} {
+			if err := c.reshard(ctx, n); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+	wantCount(ctx, t, c, workers*increments)
+}
+
+func TestReshardableCounterApprox(t *testing.T) {
+	c, ctx := emulatorCounter(t, 3)
+	for i := 0; i < 5; i++ {
+		if err := c.incrementCounter(ctx, 1); err != nil {
+			t.Fatalf("incrementCounter: %v", err)
+		}
+	}
+
+	// Until the aggregator runs, the approximate count is stale.
+	got, _, err := c.getCountApprox(ctx)
+	if err != nil {
+		t.Fatalf("getCountApprox: %v", err)
+	}
+	if got != 0 {
+		t.Errorf("getCountApprox before aggregating = %d, want 0", got)
+	}
+
+	aggCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- c.runAggregator(aggCtx, 50*time.Millisecond) }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got, updated, err := c.getCountApprox(ctx)
+		if err != nil {
+			t.Fatalf("getCountApprox: %v", err)
+		}
+		if got == 5 {
+			if updated.IsZero() {
+				t.Errorf("getCountApprox returned no update time")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("getCountApprox = %d after 10s, want 5", got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("runAggregator: %v", err)
+	}
+}
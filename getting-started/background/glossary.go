@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package background
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Glossary is a list of terms, such as product names, that are never
+// translated.
+type Glossary []string
+
+// ParseGlossary parses a comma-separated list of terms, as set in the
+// GLOSSARY environment variable of the function.
+func ParseGlossary(s string) Glossary {
+	var g Glossary
+	for _, term := range strings.Split(s, ",") {
+		if term = strings.TrimSpace(term); term != "" {
+			g = append(g, term)
+		}
+	}
+	return g
+}
+
+// protect escapes text as HTML and wraps each glossary term in a
+// translate="no" span, which the Translation API leaves as is. Terms only
+// match whole words, so "Go" is not wrapped inside "Google".
+func (g Glossary) protect(text string) string {
+	text = html.EscapeString(text)
+	if len(g) == 0 {
+		return text
+	}
+	var terms []string
+	for _, term := range g {
+		if term != "" {
+			terms = append(terms, html.EscapeString(term))
+		}
+	}
+	// Prefer the longest match when terms overlap.
+	sort.Slice(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		if term := termAt(text, i, terms); term != "" {
+			b.WriteString(`<span translate="no">` + term + `</span>`)
+			i += len(term)
+			continue
+		}
+		// Copy escaped characters whole, so that terms never match inside
+		// them.
+		size := 1
+		if text[i] == '&' {
+			size = strings.IndexByte(text[i:], ';') + 1
+		} else {
+			_, size = utf8.DecodeRuneInString(text[i:])
+		}
+		b.WriteString(text[i : i+size])
+		i += size
+	}
+	return b.String()
+}
+
+// termAt returns the first of terms that appears in text at i as a whole
+// word, or "" if none does. Like \b in a regexp, but for any letters and
+// digits, a term that starts or ends with one must not be next to another.
+func termAt(text string, i int, terms []string) string {
+	for _, term := range terms {
+		if !strings.HasPrefix(text[i:], term) {
+			continue
+		}
+		first, _ := utf8.DecodeRuneInString(term)
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		if i > 0 && isWordRune(first) && isWordRune(before) {
+			continue
+		}
+		end := i + len(term)
+		last, _ := utf8.DecodeLastRuneInString(term)
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if end < len(text) && isWordRune(last) && isWordRune(after) {
+			continue
+		}
+		return term
+	}
+	return ""
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+var noTranslateSpan = regexp.MustCompile(`<span translate="no">(.*?)</span>`)
+
+// unprotect reverses protect on translated HTML.
+func unprotect(translated string) string {
+	return html.UnescapeString(noTranslateSpan.ReplaceAllString(translated, "$1"))
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package background
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGlossary(t *testing.T) {
+	got := ParseGlossary(" Cloud Run, ,Firestore,")
+	want := Glossary{"Cloud Run", "Firestore"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseGlossary got %q, want %q", got, want)
+	}
+	if got := ParseGlossary(""); got != nil {
+		t.Errorf("ParseGlossary(\"\") got %q, want nil", got)
+	}
+}
+
+func TestGlossaryProtect(t *testing.T) {
+	g := Glossary{"Cloud", "Cloud Run", "A&B", "Go", "Gö", "amp"}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Deploy to Cloud Run", `Deploy to <span translate="no">Cloud Run</span>`},
+		{"Cloud storage", `<span translate="no">Cloud</span> storage`},
+		{"A&B <b>", `<span translate="no">A&amp;B</span> &lt;b&gt;`},
+		{"nothing here", "nothing here"},
+		{"Go, not Google or GoLand", `<span translate="no">Go</span>, not Google or GoLand`},
+		{"Cloud Runner", `<span translate="no">Cloud</span> Runner`},
+		{"Göttingen Gö", `Göttingen <span translate="no">Gö</span>`},
+		{"amp & amp", `<span translate="no">amp</span> &amp; <span translate="no">amp</span>`},
+		{"A&B&C", `<span translate="no">A&amp;B</span>&amp;C`},
+	}
+	for _, tc := range tests {
+		got := g.protect(tc.in)
+		if got != tc.want {
+			t.Errorf("protect(%q) got %q, want %q", tc.in, got, tc.want)
+		}
+		if back := unprotect(got); back != tc.in {
+			t.Errorf("unprotect(protect(%q)) got %q", tc.in, back)
+		}
+	}
+}
+
+func TestRequestLanguages(t *testing.T) {
+	tests := []struct {
+		r    Request
+		want []string
+	}{
+		{Request{Language: "fr"}, []string{"fr"}},
+		{Request{Languages: []string{"de", "ja", "de"}}, []string{"de", "ja"}},
+		{Request{Language: "fr", Languages: []string{"fr", "es"}}, []string{"fr", "es"}},
+		{Request{}, nil},
+	}
+	for _, tc := range tests {
+		if got := tc.r.languages(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v.languages() got %q, want %q", tc.r, got, tc.want)
+		}
+	}
+}
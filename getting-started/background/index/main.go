@@ -31,13 +31,20 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/translate"
 	"github.com/GoogleCloudPlatform/golang-samples/getting-started/background"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // topicName is the Pub/Sub topic to publish requests to. The Cloud Function to
 // process translation requests should be subscribed to this topic.
 const topicName = "translate"
 
+// maxLanguages is the most target languages a single request can have.
+const maxLanguages = 10
+
 // An app holds the clients and parsed templates that can be reused between
 // requests.
 type app struct {
@@ -45,6 +52,11 @@ type app struct {
 	pubsubTopic     *pubsub.Topic
 	firestoreClient *firestore.Client
 	tmpl            *template.Template
+
+	// languages are the languages supported by the Translation API, and
+	// supported is the set of their tags.
+	languages []translate.Language
+	supported map[string]bool
 }
 
 func main() {
@@ -60,6 +72,7 @@ func main() {
 
 	http.HandleFunc("/", a.index)
 	http.HandleFunc("/request-translation", a.requestTranslation)
+	http.HandleFunc("/status", a.status)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -88,6 +101,20 @@ func newApp(projectID, templateDir string) (*app, error) {
 		return nil, fmt.Errorf("firestore.NewClient: %w", err)
 	}
 
+	translateClient, err := translate.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("translate.NewClient: %w", err)
+	}
+	defer translateClient.Close()
+	languages, err := translateClient.SupportedLanguages(ctx, language.English)
+	if err != nil {
+		return nil, fmt.Errorf("SupportedLanguages: %w", err)
+	}
+	supported := make(map[string]bool)
+	for _, l := range languages {
+		supported[l.Tag.String()] = true
+	}
+
 	// Template referenced relative to the module/app root.
 	tmpl, err := template.ParseFiles(filepath.Join(templateDir, "index.html"))
 	if err != nil {
@@ -100,6 +127,9 @@ func newApp(projectID, templateDir string) (*app, error) {
 
 		firestoreClient: firestoreClient,
 		tmpl:            tmpl,
+
+		languages: languages,
+		supported: supported,
 	}, nil
 }
 
@@ -127,7 +157,11 @@ func (a *app) index(w http.ResponseWriter, r *http.Request) {
 		translations = append(translations, t)
 	}
 
-	if err := a.tmpl.Execute(w, translations); err != nil {
+	data := struct {
+		Translations []background.Translation
+		Languages    []translate.Language
+	}{translations, a.languages}
+	if err := a.tmpl.Execute(w, data); err != nil {
 		log.Printf("tmpl.Execute: %v", err)
 		http.Error(w, "Error writing response", http.StatusInternalServerError)
 		return
@@ -138,7 +172,9 @@ func (a *app) index(w http.ResponseWriter, r *http.Request) {
 
 // [START getting_started_background_app_request]
 
-// requestTranslation parses the request, validates it, and sends it to Pub/Sub.
+// requestTranslation parses the request, validates it, records its status
+// as pending, and sends it to Pub/Sub. It responds with the request ID, which
+// can be passed to /status.
 func (a *app) requestTranslation(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		log.Printf("ParseForm: %v", err)
@@ -151,28 +187,33 @@ func (a *app) requestTranslation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Empty value", http.StatusBadRequest)
 		return
 	}
-	acceptableLanguages := map[string]bool{
-		"de": true,
-		"en": true,
-		"es": true,
-		"fr": true,
-		"ja": true,
-		"sw": true,
-	}
-	lang := r.PostFormValue("lang")
-	if !acceptableLanguages[lang] {
-		log.Printf("Unsupported language: %v", lang)
-		http.Error(w, fmt.Sprintf("Unsupported language: %v", lang), http.StatusBadRequest)
+	langs, err := a.parseLanguages(r.PostForm["lang"])
+	if err != nil {
+		log.Printf("parseLanguages: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Translation requested: %q -> %s", v, lang)
+	log.Printf("Translation requested: %q -> %v", v, langs)
 
-	t := background.Translation{
-		Original: v,
-		Language: lang,
+	ref := a.firestoreClient.Collection("requests").NewDoc()
+	st := background.Status{
+		State:     background.StatePending,
+		Original:  v,
+		Languages: langs,
 	}
-	msg, err := json.Marshal(t)
+	if _, err := ref.Create(r.Context(), st); err != nil {
+		log.Printf("Create: %v", err)
+		http.Error(w, "Error requesting translation", http.StatusInternalServerError)
+		return
+	}
+
+	req := background.Request{
+		ID:        ref.ID,
+		Original:  v,
+		Languages: langs,
+	}
+	msg, err := json.Marshal(req)
 	if err != nil {
 		log.Printf("json.Marshal: %v", err)
 		http.Error(w, "Error requesting translation", http.StatusInternalServerError)
@@ -182,9 +223,78 @@ func (a *app) requestTranslation(w http.ResponseWriter, r *http.Request) {
 	res := a.pubsubTopic.Publish(r.Context(), &pubsub.Message{Data: msg})
 	if _, err := res.Get(r.Context()); err != nil {
 		log.Printf("Publish.Get: %v", err)
+		// The request never reaches the translator, so it would otherwise
+		// stay pending forever.
+		_, err := ref.Update(r.Context(), []firestore.Update{
+			{Path: "state", Value: background.StateFailed},
+			{Path: "error", Value: "Error requesting translation"},
+			{Path: "updated", Value: firestore.ServerTimestamp},
+		})
+		if err != nil {
+			log.Printf("Update: %v", err)
+		}
 		http.Error(w, "Error requesting translation", http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": ref.ID})
+}
+
+// parseLanguages checks that the requested languages are supported, and
+// removes duplicates.
+func (a *app) parseLanguages(langs []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, lang := range langs {
+		if !a.supported[lang] {
+			return nil, fmt.Errorf("Unsupported language: %v", lang)
+		}
+		if !seen[lang] {
+			seen[lang] = true
+			out = append(out, lang)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("No language selected")
+	}
+	if len(out) > maxLanguages {
+		return nil, fmt.Errorf("Too many languages: %d, the maximum is %d", len(out), maxLanguages)
+	}
+	return out, nil
 }
 
 // [END getting_started_background_app_request]
+
+// [START getting_started_background_app_status]
+
+// status responds with the Status of the request with the given id.
+func (a *app) status(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+	doc, err := a.firestoreClient.Collection("requests").Doc(id).Get(r.Context())
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Get: %v", err)
+		http.Error(w, "Error getting status", http.StatusInternalServerError)
+		return
+	}
+	st := background.Status{}
+	if err := doc.DataTo(&st); err != nil {
+		log.Printf("DataTo: %v", err)
+		http.Error(w, "Error reading status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Printf("Encode: %v", err)
+	}
+}
+
+// [END getting_started_background_app_status]
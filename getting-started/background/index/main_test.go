@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -41,3 +42,19 @@ func TestIndex(t *testing.T) {
 		t.Errorf("wrong status code, got %v, want %v", resp.StatusCode, http.StatusOK)
 	}
 }
+
+func TestParseLanguages(t *testing.T) {
+	a := &app{supported: map[string]bool{"de": true, "fr": true, "ja": true}}
+	got, err := a.parseLanguages([]string{"fr", "de", "fr"})
+	if err != nil {
+		t.Fatalf("parseLanguages: %v", err)
+	}
+	if want := []string{"fr", "de"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLanguages got %q, want %q", got, want)
+	}
+	for _, langs := range [][]string{nil, {"fr", "xx"}} {
+		if _, err := a.parseLanguages(langs); err == nil {
+			t.Errorf("parseLanguages(%q) got nil error, want error", langs)
+		}
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/translate"
@@ -43,10 +44,53 @@ type Translation struct {
 	Language         string `json:"language"`
 }
 
+// A Request asks for text to be translated to one or more languages. It is
+// the message published to Pub/Sub. The single Language field is still
+// accepted, so a Translation can also be published as a Request.
+type Request struct {
+	// ID is the ID of the request's Status document in the "requests"
+	// collection. Requests without an ID are not tracked.
+	ID        string   `json:"id,omitempty"`
+	Original  string   `json:"original"`
+	Languages []string `json:"languages,omitempty"`
+	Language  string   `json:"language,omitempty"`
+}
+
+// languages returns the request's target languages, without duplicates.
+func (r Request) languages() []string {
+	var langs []string
+	seen := map[string]bool{}
+	for _, l := range append([]string{r.Language}, r.Languages...) {
+		if l != "" && !seen[l] {
+			seen[l] = true
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+// Request states.
+const (
+	StatePending = "pending"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+// A Status tracks the progress of a Request.
+type Status struct {
+	State     string    `json:"state" firestore:"state"`
+	Original  string    `json:"original" firestore:"original"`
+	Languages []string  `json:"languages" firestore:"languages"`
+	Error     string    `json:"error,omitempty" firestore:"error,omitempty"`
+	Created   time.Time `json:"created" firestore:"created,serverTimestamp"`
+	Updated   time.Time `json:"updated" firestore:"updated,serverTimestamp"`
+}
+
 // Clients reused between function invocations.
 var (
 	translateClient *translate.Client
 	firestoreClient *firestore.Client
+	glossary        Glossary
 )
 
 // PubSubMessage is the payload of a Pub/Sub event.
@@ -76,6 +120,7 @@ func initializeClients() error {
 			return fmt.Errorf("translate.NewClient: %w", err)
 		}
 	}
+	glossary = ParseGlossary(os.Getenv("GLOSSARY"))
 	if firestoreClient == nil {
 		// Pre-declare err to avoid shadowing firestoreClient.
 		var err error
@@ -96,13 +141,21 @@ func initializeClients() error {
 // * the translated text,
 // * the automatically detected source language, and
 // * an error.
+// Terms in the glossary are left untranslated.
 func translateString(ctx context.Context, text string, lang string) (translated string, originalLang string, err error) {
 	l, err := language.Parse(lang)
 	if err != nil {
 		return "", "", fmt.Errorf("language.Parse: %w", err)
 	}
 
-	outs, err := translateClient.Translate(ctx, []string{text}, l, nil)
+	var opts *translate.Options
+	if len(glossary) > 0 {
+		// Send HTML so glossary terms can be marked with translate="no".
+		text = glossary.protect(text)
+		opts = &translate.Options{Format: translate.HTML}
+	}
+
+	outs, err := translateClient.Translate(ctx, []string{text}, l, opts)
 	if err != nil {
 		return "", "", fmt.Errorf("Translate: %w", err)
 	}
@@ -111,22 +164,68 @@ func translateString(ctx context.Context, text string, lang string) (translated
 		return "", "", fmt.Errorf("Translate got %d translations, need at least 1", len(outs))
 	}
 
-	return outs[0].Text, outs[0].Source.String(), nil
+	translated = outs[0].Text
+	if opts != nil {
+		translated = unprotect(translated)
+	}
+	return translated, outs[0].Source.String(), nil
 }
 
 // [END getting_started_background_translate_string]
 
 // [START getting_started_background_translate]
 
-// Translate translates the given message and stores the result in Firestore.
+// Translate translates the given message to each requested language, stores
+// the results in Firestore, and marks the request done or failed.
 func Translate(ctx context.Context, m PubSubMessage) error {
-	initializeClients()
+	if err := initializeClients(); err != nil {
+		return err
+	}
 
-	t := Translation{}
-	if err := json.Unmarshal(m.Data, &t); err != nil {
+	req := Request{}
+	if err := json.Unmarshal(m.Data, &req); err != nil {
 		return fmt.Errorf("json.Unmarshal: %w", err)
 	}
 
+	var errs []string
+	langs := req.languages()
+	if len(langs) == 0 {
+		errs = append(errs, "no target languages")
+	}
+	for _, lang := range langs {
+		if err := translateTo(ctx, req.Original, lang); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", lang, err))
+		}
+	}
+
+	if req.ID != "" {
+		state, msg := StateDone, ""
+		if len(errs) > 0 {
+			state, msg = StateFailed, strings.Join(errs, "; ")
+		}
+		_, err := firestoreClient.Collection("requests").Doc(req.ID).Update(ctx, []firestore.Update{
+			{Path: "state", Value: state},
+			{Path: "error", Value: msg},
+			{Path: "updated", Value: firestore.ServerTimestamp},
+		})
+		if err != nil {
+			return fmt.Errorf("Update: %w", err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Translate: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// translateTo translates text to lang and stores the result in the
+// "translations" collection, unless it is already there.
+func translateTo(ctx context.Context, text, lang string) error {
+	t := Translation{
+		Original: text,
+		Language: lang,
+	}
+
 	// Use a unique document name to prevent duplicate translations.
 	key := fmt.Sprintf("%s/%s", t.Language, t.Original)
 	sum := sha512.Sum512([]byte(key))
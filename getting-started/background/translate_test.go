@@ -142,3 +142,53 @@ func getAll(ctx context.Context, client *firestore.Client, projectID string) ([]
 	}
 	return translations, nil
 }
+
+func TestTranslateRequest(t *testing.T) {
+	projectID := os.Getenv("GOLANG_SAMPLES_FIRESTORE_PROJECT")
+	if projectID == "" {
+		t.Skip("Skipping Firestore test. Set GOLANG_SAMPLES_FIRESTORE_PROJECT.")
+	}
+	os.Setenv("GOOGLE_CLOUD_PROJECT", projectID)
+
+	ctx := context.Background()
+
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		t.Fatalf("firestore.NewClient: %v", err)
+	}
+	if err := deleteAll(ctx, client, projectID); err != nil {
+		t.Fatalf("deleteAll: %v", err)
+	}
+
+	ref := client.Collection("requests").NewDoc()
+	defer ref.Delete(ctx)
+	if _, err := ref.Create(ctx, Status{State: StatePending, Original: "Me", Languages: []string{"fr", "es"}}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	msg, err := json.Marshal(Request{ID: ref.ID, Original: "Me", Languages: []string{"fr", "es"}})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if err := Translate(ctx, PubSubMessage{Data: msg}); err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+
+	translations, err := getAll(ctx, client, projectID)
+	if err != nil {
+		t.Fatalf("getAll: %v", err)
+	}
+	if len(translations) != 2 {
+		t.Errorf("Translate got %d translations, want 2", len(translations))
+	}
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	st := Status{}
+	if err := doc.DataTo(&st); err != nil {
+		t.Fatalf("DataTo: %v", err)
+	}
+	if st.State != StateDone {
+		t.Errorf("Status got state %q (error %q), want %q", st.State, st.Error, StateDone)
+	}
+}
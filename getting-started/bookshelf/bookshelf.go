@@ -39,6 +39,10 @@ type BookDatabase interface {
 	// ListBooks returns a list of books, ordered by title.
 	ListBooks(context.Context) ([]*Book, error)
 
+	// ListBooksPage returns one page of books, filtered and sorted as
+	// described by opts.
+	ListBooksPage(ctx context.Context, opts ListOptions) (*BookPage, error)
+
 	// GetBook retrieves a book by its ID.
 	GetBook(ctx context.Context, id string) (*Book, error)
 
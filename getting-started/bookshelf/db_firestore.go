@@ -119,3 +119,56 @@ func (db *firestoreDB) ListBooks(ctx context.Context) ([]*Book, error) {
 
 	return books, nil
 }
+
+// sortFields maps sort options to Firestore fields.
+var sortFields = map[string]string{
+	SortByTitle:         "Title",
+	SortByAuthor:        "Author",
+	SortByPublishedDate: "PublishedDate",
+}
+
+// ListBooksPage returns one page of books, filtered and sorted as described
+// by opts. Pages are read with query cursors, ordering by the sort field and
+// then the document ID so that books with equal values keep a stable order.
+func (db *firestoreDB) ListBooksPage(ctx context.Context, opts ListOptions) (*BookPage, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, fmt.Errorf("firestoredb: %w", err)
+	}
+	c, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("firestoredb: %w", err)
+	}
+
+	field := sortFields[opts.SortBy]
+	q := db.client.Collection(db.collection).Query
+	if prefix := opts.prefix(); prefix != "" {
+		// \uf8ff sorts after almost all other characters, so this range
+		// matches every string that starts with prefix.
+		q = q.Where(field, ">=", prefix).Where(field, "<", prefix+"\uf8ff")
+	}
+	dir := firestore.Asc
+	if c != nil && c.Before {
+		dir = firestore.Desc
+	}
+	q = q.OrderBy(field, dir).OrderBy(firestore.DocumentID, dir)
+	if c != nil {
+		q = q.StartAfter(c.Value, c.ID)
+	}
+
+	books := make([]*Book, 0, opts.PageSize+1)
+	iter := q.Limit(opts.PageSize + 1).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("firestoredb: could not list books: %w", err)
+		}
+		b := &Book{}
+		doc.DataTo(b)
+		books = append(books, b)
+	}
+	return newBookPage(books, c, opts), nil
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -118,3 +119,63 @@ func (db *memoryDB) ListBooks(_ context.Context) ([]*Book, error) {
 	})
 	return books, nil
 }
+
+// ListBooksPage returns one page of books, filtered and sorted as described
+// by opts.
+func (db *memoryDB) ListBooksPage(_ context.Context, opts ListOptions) (*BookPage, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, fmt.Errorf("memorydb: %w", err)
+	}
+	c, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("memorydb: %w", err)
+	}
+
+	db.mu.Lock()
+	var books []*Book
+	for _, b := range db.books {
+		if strings.HasPrefix(sortValue(b, opts.SortBy), opts.prefix()) {
+			books = append(books, b)
+		}
+	}
+	db.mu.Unlock()
+
+	less := func(a, b *Book) bool {
+		va, vb := sortValue(a, opts.SortBy), sortValue(b, opts.SortBy)
+		if va != vb {
+			return va < vb
+		}
+		return a.ID < b.ID
+	}
+	backward := c != nil && c.Before
+	sort.Slice(books, func(i, j int) bool {
+		if backward {
+			return less(books[j], books[i])
+		}
+		return less(books[i], books[j])
+	})
+
+	if c != nil {
+		at := &Book{ID: c.ID}
+		switch opts.SortBy {
+		case SortByAuthor:
+			at.Author = c.Value
+		case SortByPublishedDate:
+			at.PublishedDate = c.Value
+		default:
+			at.Title = c.Value
+		}
+		// Skip books up to and including the cursor position.
+		i := sort.Search(len(books), func(i int) bool {
+			if backward {
+				return less(books[i], at)
+			}
+			return less(at, books[i])
+		})
+		books = books[i:]
+	}
+	if len(books) > opts.PageSize+1 {
+		books = books[:opts.PageSize+1]
+	}
+	return newBookPage(books, c, opts), nil
+}
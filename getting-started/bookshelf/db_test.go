@@ -18,6 +18,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -61,6 +63,123 @@ func testDB(t *testing.T, db BookDatabase) {
 	if _, err := db.GetBook(ctx, id); err == nil {
 		t.Error("want non-nil err")
 	}
+
+	testListBooksPage(t, db)
+}
+
+// testListBooksPage pages through books added with a unique title and
+// author prefix, so that other books in a shared database are ignored.
+func testListBooksPage(t *testing.T, db BookDatabase) {
+	t.Helper()
+
+	ctx := context.Background()
+	prefix := fmt.Sprintf("p-%d-", time.Now().UnixNano())
+	// Titles and dates sort in opposite orders.
+	var books []*Book
+	for i := 0; i < 5; i++ {
+		b := &Book{
+			Title:         fmt.Sprintf("%stitle-%d", prefix, i),
+			Author:        fmt.Sprintf("%sauthor-%d", prefix, i%2),
+			PublishedDate: fmt.Sprintf("200%d-01-01", 9-i),
+		}
+		if _, err := db.AddBook(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+		books = append(books, b)
+	}
+	defer func() {
+		for _, b := range books {
+			db.DeleteBook(ctx, b.ID)
+		}
+	}()
+
+	titles := func(p *BookPage) []string {
+		var got []string
+		for _, b := range p.Books {
+			got = append(got, strings.TrimPrefix(b.Title, prefix))
+		}
+		return got
+	}
+
+	opts := ListOptions{PageSize: 2, TitlePrefix: prefix}
+	var pages [][]string
+	var last *BookPage
+	for {
+		p, err := db.ListBooksPage(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListBooksPage: %v", err)
+		}
+		pages = append(pages, titles(p))
+		last = p
+		if p.NextCursor == "" {
+			break
+		}
+		opts.Cursor = p.NextCursor
+	}
+	want := [][]string{{"title-0", "title-1"}, {"title-2", "title-3"}, {"title-4"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("ListBooksPage forward: got %v, want %v", pages, want)
+	}
+
+	// Page back from the last page.
+	opts.Cursor = last.PrevCursor
+	prev, err := db.ListBooksPage(ctx, opts)
+	if err != nil {
+		t.Fatalf("ListBooksPage: %v", err)
+	}
+	if got, want := titles(prev), []string{"title-2", "title-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksPage backward: got %v, want %v", got, want)
+	}
+	if prev.NextCursor == "" || prev.PrevCursor == "" {
+		t.Errorf("ListBooksPage backward: want both cursors on a middle page")
+	}
+	opts.Cursor = prev.PrevCursor
+	first, err := db.ListBooksPage(ctx, opts)
+	if err != nil {
+		t.Fatalf("ListBooksPage: %v", err)
+	}
+	if got, want := titles(first), []string{"title-0", "title-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksPage first page: got %v, want %v", got, want)
+	}
+	if first.PrevCursor != "" {
+		t.Errorf("ListBooksPage first page: got PrevCursor, want none")
+	}
+
+	// Authors have duplicates, which are ordered by ID.
+	p, err := db.ListBooksPage(ctx, ListOptions{PageSize: 10, AuthorPrefix: prefix + "author-1"})
+	if err != nil {
+		t.Fatalf("ListBooksPage: %v", err)
+	}
+	if got := titles(p); len(got) != 2 {
+		t.Errorf("ListBooksPage author prefix: got %v, want title-1 and title-3", got)
+	}
+
+	if _, err := db.ListBooksPage(ctx, ListOptions{TitlePrefix: prefix, SortBy: SortByPublishedDate}); err == nil {
+		t.Errorf("ListBooksPage: want error sorting by date while filtering on title")
+	}
+
+	// Without a filter, only the relative order of our books can be checked.
+	var dates []string
+	opts = ListOptions{PageSize: maxPageSize, SortBy: SortByPublishedDate}
+	for {
+		p, err := db.ListBooksPage(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListBooksPage: %v", err)
+		}
+		for _, b := range p.Books {
+			if strings.HasPrefix(b.Title, prefix) {
+				dates = append(dates, b.PublishedDate)
+			}
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		opts.Cursor = p.NextCursor
+	}
+	wantDates := []string{"2005-01-01", "2006-01-01", "2007-01-01", "2008-01-01", "2009-01-01"}
+	if !reflect.DeepEqual(dates, wantDates) {
+		t.Errorf("ListBooksPage by date: got %v, want %v", dates, wantDates)
+	}
 }
 
 func TestMemoryDB(t *testing.T) {
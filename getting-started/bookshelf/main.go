@@ -108,15 +108,54 @@ func (b *Bookshelf) registerHandlers() {
 	http.Handle("/", handlers.CombinedLoggingHandler(b.logWriter, r))
 }
 
-// listHandler displays a list with summaries of books in the database.
+// listHandler displays a page of summaries of books in the database.
+// The query parameters sort, title, author and cursor select the page.
 func (b *Bookshelf) listHandler(w http.ResponseWriter, r *http.Request) *appError {
 	ctx := r.Context()
-	books, err := b.DB.ListBooks(ctx)
+	q := r.URL.Query()
+	opts := ListOptions{
+		SortBy:       q.Get("sort"),
+		TitlePrefix:  q.Get("title"),
+		AuthorPrefix: q.Get("author"),
+		Cursor:       q.Get("cursor"),
+	}
+	// Bad query parameters are the client's error, so they are not
+	// reported.
+	if err := opts.normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if _, err := decodeCursor(opts.Cursor); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	page, err := b.DB.ListBooksPage(ctx, opts)
 	if err != nil {
 		return b.appErrorf(r, err, "could not list books: %v", err)
 	}
 
-	return listTmpl.Execute(b, w, r, books)
+	// pageURL links to the page at cursor with the same filters.
+	pageURL := func(cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		q.Set("cursor", cursor)
+		return "/books?" + q.Encode()
+	}
+	data := struct {
+		Books            []*Book
+		Sort             string
+		Title, Author    string
+		NextURL, PrevURL string
+	}{
+		Books:   page.Books,
+		Sort:    opts.SortBy,
+		Title:   opts.TitlePrefix,
+		Author:  opts.AuthorPrefix,
+		NextURL: pageURL(page.NextCursor),
+		PrevURL: pageURL(page.PrevCursor),
+	}
+	return listTmpl.Execute(b, w, r, data)
 }
 
 // bookFromRequest retrieves a book from the database given a book ID in the
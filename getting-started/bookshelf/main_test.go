@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"log"
 	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
@@ -106,6 +107,24 @@ func TestNoBooks(t *testing.T) {
 	}
 }
 
+func TestListBadRequest(t *testing.T) {
+	b.DB = testDBs["memory"]
+	for _, path := range []string{
+		"/books?sort=price",
+		"/books?sort=published&title=Go",
+		"/books?cursor=not-a-cursor",
+	} {
+		resp, err := wt.Get(path)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Get(%q): got status %d, want %d", path, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
 func TestBookDetail(t *testing.T) {
 	for name, db := range testDBs {
 		t.Run(name, func(t *testing.T) {
@@ -0,0 +1,178 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Fields that books can be sorted by.
+const (
+	SortByTitle         = "title"
+	SortByAuthor        = "author"
+	SortByPublishedDate = "published"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ListOptions configures ListBooksPage.
+type ListOptions struct {
+	// PageSize is the maximum number of books in the page. Defaults to 20,
+	// and is capped at 100.
+	PageSize int
+
+	// SortBy is SortByTitle, SortByAuthor or SortByPublishedDate.
+	// Defaults to SortByTitle, or to the field being filtered on.
+	SortBy string
+
+	// TitlePrefix and AuthorPrefix only include books whose title or author
+	// starts with the prefix. At most one may be set, and SortBy must match
+	// the filtered field, so that the query can be served by an index.
+	TitlePrefix  string
+	AuthorPrefix string
+
+	// Cursor is the NextCursor or PrevCursor of a previous page listed with
+	// the same options. Empty means the first page.
+	Cursor string
+}
+
+// BookPage is one page of books.
+type BookPage struct {
+	Books []*Book
+
+	// NextCursor and PrevCursor list the following and preceding pages.
+	// They are empty on the last and first page.
+	NextCursor string
+	PrevCursor string
+}
+
+// normalize checks the options and fills in defaults.
+func (o *ListOptions) normalize() error {
+	if o.PageSize <= 0 {
+		o.PageSize = defaultPageSize
+	}
+	if o.PageSize > maxPageSize {
+		o.PageSize = maxPageSize
+	}
+	filter := ""
+	switch {
+	case o.TitlePrefix != "" && o.AuthorPrefix != "":
+		return fmt.Errorf("cannot filter on both title and author")
+	case o.TitlePrefix != "":
+		filter = SortByTitle
+	case o.AuthorPrefix != "":
+		filter = SortByAuthor
+	}
+	if o.SortBy == "" {
+		o.SortBy = filter
+	}
+	if o.SortBy == "" {
+		o.SortBy = SortByTitle
+	}
+	switch o.SortBy {
+	case SortByTitle, SortByAuthor, SortByPublishedDate:
+	default:
+		return fmt.Errorf("unknown sort field %q", o.SortBy)
+	}
+	if filter != "" && filter != o.SortBy {
+		return fmt.Errorf("cannot sort by %s when filtering on %s", o.SortBy, filter)
+	}
+	return nil
+}
+
+// prefix returns the prefix filter, which applies to the SortBy field.
+func (o *ListOptions) prefix() string {
+	if o.TitlePrefix != "" {
+		return o.TitlePrefix
+	}
+	return o.AuthorPrefix
+}
+
+// sortValue returns the value of the field b is sorted by.
+func sortValue(b *Book, sortBy string) string {
+	switch sortBy {
+	case SortByAuthor:
+		return b.Author
+	case SortByPublishedDate:
+		return b.PublishedDate
+	}
+	return b.Title
+}
+
+// pageCursor marks a position between two books, in the order given by the
+// sort field then the book ID.
+type pageCursor struct {
+	// Before reports whether the cursor lists the page before the position,
+	// rather than after it.
+	Before bool   `json:"b,omitempty"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	c := &pageCursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return c, nil
+}
+
+// newBookPage builds a page from books fetched in the cursor's direction.
+// Fetching one more book than the page size tells whether there are more
+// books beyond the page.
+func newBookPage(books []*Book, c *pageCursor, opts ListOptions) *BookPage {
+	more := len(books) > opts.PageSize
+	if more {
+		books = books[:opts.PageSize]
+	}
+	backward := c != nil && c.Before
+	if backward {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
+		}
+	}
+
+	p := &BookPage{Books: books}
+	if len(books) == 0 {
+		return p
+	}
+	first, last := books[0], books[len(books)-1]
+	// Going forward, there is a previous page if we started from a cursor;
+	// going backward, there is a next page.
+	if (backward && more) || (!backward && c != nil) {
+		p.PrevCursor = encodeCursor(pageCursor{Before: true, Value: sortValue(first, opts.SortBy), ID: first.ID})
+	}
+	if (!backward && more) || backward {
+		p.NextCursor = encodeCursor(pageCursor{Value: sortValue(last, opts.SortBy), ID: last.ID})
+	}
+	return p
+}
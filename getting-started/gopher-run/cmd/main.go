@@ -16,10 +16,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -29,7 +30,7 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/golang-samples/getting-started/gopher-run/generator"
 	"github.com/GoogleCloudPlatform/golang-samples/getting-started/gopher-run/leaderboard"
-	"golang.org/x/oauth2/google"
+	"github.com/GoogleCloudPlatform/golang-samples/getting-started/gopher-run/predictor"
 )
 
 type playData struct {
@@ -41,8 +42,18 @@ type app struct {
 	projectID string
 	bucket    *storage.BucketHandle
 	fsClient  *firestore.Client
+	board     *leaderboard.Leaderboard
+	predictor predictor.Predictor
+	// local is the in-process predictor retrained on new play data. It is
+	// nil when predictions are served by a remote model.
+	local *predictor.Local
+	// train requests a run of submitTrainingJob by the training worker.
+	train chan struct{}
 }
 
+// modelObject holds the weights of the local model.
+const modelObject = "model/weights.json"
+
 func main() {
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
 	if projectID == "" {
@@ -55,6 +66,7 @@ func main() {
 	http.HandleFunc("/leaderboard/post", a.addScore)
 	http.HandleFunc("/leaderboard/get", a.topScores)
 	http.HandleFunc("/predict", a.predictionRequest)
+	http.HandleFunc("/v2/predict", a.predictionRequestV2)
 	http.HandleFunc("/pldata", a.addPlayData)
 	http.HandleFunc("/bggenerator", a.sendGeneratedBackground)
 	http.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("static/gorun/"))))
@@ -68,27 +80,90 @@ func main() {
 	}
 }
 
+// predictionRequest predicts the next action for each instance of
+// {"instances": [[feature, ...], ...]}, returning the class of each action as
+// {"predictions": [class, ...]}, like the AI Platform model does.
 func (a *app) predictionRequest(w http.ResponseWriter, r *http.Request) {
-	client, err := google.DefaultClient(r.Context(), "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		log.Printf("DefaultClient: %v", err)
+	preds, ok := a.predict(w, r)
+	if !ok {
+		return
+	}
+	classes := make([]int, len(preds))
+	for i, p := range preds {
+		classes[i] = p.Class
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"predictions": classes})
+}
+
+// predictionRequestV2 is like predictionRequest, but returns the class,
+// action name and probabilities of each prediction as
+// {"predictions": [{"class": 2, "action": "jump", ...}, ...]}.
+func (a *app) predictionRequestV2(w http.ResponseWriter, r *http.Request) {
+	preds, ok := a.predict(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"predictions": preds})
+}
+
+// predict returns the predictions for the instances in the request. If it
+// fails, it writes the error response and returns false.
+func (a *app) predict(w http.ResponseWriter, r *http.Request) ([]predictor.Prediction, bool) {
+	var req struct {
+		Instances [][]float64 `json:"instances"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decoder.Decode: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	r.Body.Close()
+	preds, err := a.predictor.Predict(r.Context(), req.Instances)
+	if errors.Is(err, predictor.ErrNoModel) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
 	}
-	resp, err := client.Post("https://ml.googleapis.com/v1/projects/"+a.projectID+"/models/playerdata_linear_classification:predict", "application/json", r.Body)
 	if err != nil {
-		log.Printf("client.Post: %v", err)
+		log.Printf("predictor.Predict: %v", err)
 		http.Error(w, "Prediction server error", http.StatusInternalServerError)
-		return
+		return nil, false
+	}
+	return preds, true
+}
+
+// readObject returns the contents of the named object in the bucket.
+func (a *app) readObject(ctx context.Context, name string) ([]byte, error) {
+	rc, err := a.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
 	}
-	io.Copy(w, resp.Body)
-	resp.Body.Close()
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
 
-// Concatenate data from the top runs and start a Cloud ML training job on it
+// writeObject replaces the contents of the named object in the bucket.
+func (a *app) writeObject(ctx context.Context, name string, data []byte) error {
+	wc := a.bucket.Object(name).NewWriter(ctx)
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return fmt.Errorf("Write: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+	return nil
+}
+
+// submitTrainingJob concatenates the play data of the top runs into
+// pldata.csv. If it changed, the local model is retrained on it and its
+// weights are saved to the bucket. Remote models are trained from pldata.csv
+// by training.sh. Play data that does not parse is skipped, and pldata.csv is
+// only saved once the local model is trained, so a failed run is retried.
 func (a *app) submitTrainingJob(ctx context.Context) error {
-	bkt := a.bucket
-	topPlayers, err := leaderboard.TopScores(ctx, a.fsClient)
+	topPlayers, err := a.board.Top(ctx, leaderboard.Query{})
 	if err != nil {
-		log.Printf("leaderboard.TopScores: %v", err)
+		log.Printf("board.Top: %v", err)
 		return err
 	}
 	// Add dummy data at the beginning for consistency in which actions are assigned numbers 0,1,2,3 for prediction output
@@ -96,33 +171,81 @@ func (a *app) submitTrainingJob(ctx context.Context) error {
 roll,0,0,0,0,0,0,0,0,0,0,0
 jump,0,0,0,0,0,0,0,0,0,0,0
 unroll,0,0,0,0,0,0,0,0,0,0,0`)
+	examples, err := predictor.ParseCSV(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("predictor.ParseCSV: %w", err)
+	}
+	numFeatures := len(examples[0].Features)
 	for _, player := range topPlayers {
-		pld, err := bkt.Object("pldata/" + player.Name + "_pldata.csv").NewReader(ctx)
+		name := "pldata/" + player.Name + "_pldata.csv"
+		b, err := a.readObject(ctx, name)
 		if err != nil {
-			log.Printf("NewReader: %v", err)
+			log.Printf("readObject: %v", err)
 			continue
 		}
-		defer pld.Close()
-		old, err := bkt.Object("pldata.csv").NewReader(ctx)
+		// Players upload their own play data, so one bad file must not
+		// stop the model from being trained on everyone else's.
+		ex, err := predictor.ParseCSV(bytes.NewReader(b))
 		if err != nil {
-			log.Printf("NewReader: %v", err)
+			log.Printf("Skipping %s: predictor.ParseCSV: %v", name, err)
 			continue
 		}
-		defer old.Close()
-		b, err := ioutil.ReadAll(pld)
-		if err != nil {
-			log.Printf("ioutil.ReadAll: %v", err)
+		if len(ex) > 0 && len(ex[0].Features) != numFeatures {
+			log.Printf("Skipping %s: got %d features, want %d", name, len(ex[0].Features), numFeatures)
 			continue
 		}
-		data = append(append(data, []byte("\n")...))
+		examples = append(examples, ex...)
+		data = append(data, '\n')
 		data = append(data, b...)
 	}
-	newObj := bkt.Object("pldata.csv").NewWriter(ctx)
-	defer newObj.Close()
-	newObj.Write(data)
+
+	old, err := a.readObject(ctx, "pldata.csv")
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("readObject: %w", err)
+	}
+	if bytes.Equal(old, data) {
+		return nil
+	}
+	if a.local != nil {
+		model, err := predictor.Train(examples, predictor.TrainOptions{})
+		if err != nil {
+			return fmt.Errorf("predictor.Train: %w", err)
+		}
+		var weights bytes.Buffer
+		if err := model.Save(&weights); err != nil {
+			return fmt.Errorf("model.Save: %w", err)
+		}
+		if err := a.writeObject(ctx, modelObject, weights.Bytes()); err != nil {
+			return fmt.Errorf("writeObject: %w", err)
+		}
+		a.local.SetModel(model)
+		log.Printf("Trained model on %d examples, accuracy %.2f", len(examples), model.Accuracy(examples))
+	}
+	if err := a.writeObject(ctx, "pldata.csv", data); err != nil {
+		return fmt.Errorf("writeObject: %w", err)
+	}
 	return nil
 }
 
+// requestTraining asks the training worker to run submitTrainingJob, without
+// waiting for it. Requests made while a job is pending are merged into it.
+func (a *app) requestTraining() {
+	select {
+	case a.train <- struct{}{}:
+	default:
+	}
+}
+
+// trainingWorker runs submitTrainingJob for each training request, one at a
+// time, so scores are saved without waiting for the model to be retrained.
+func (a *app) trainingWorker(ctx context.Context) {
+	for range a.train {
+		if err := a.submitTrainingJob(ctx); err != nil {
+			log.Printf("submitTrainingJob: %v", err)
+		}
+	}
+}
+
 func (a *app) addScore(w http.ResponseWriter, r *http.Request) {
 	var d leaderboard.ScoreData
 	decoder := json.NewDecoder(r.Body)
@@ -132,23 +255,52 @@ func (a *app) addScore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	r.Body.Close()
-	top, err := leaderboard.AddScore(r.Context(), a.fsClient, d)
+	top, err := a.board.Submit(r.Context(), d)
+	if errors.Is(err, leaderboard.ErrImplausible) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		log.Printf("leaderboar.AddScore: %v\n", err)
+		log.Printf("board.Submit: %v\n", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
 	}
-	a.submitTrainingJob(r.Context())
+	a.requestTraining()
 	fmt.Fprint(w, top)
 }
 
-// topScores retrieves top 10 scores from the database, return as \n-separated jsons.
+// topScores retrieves the top 10 entries of a leaderboard, returned as \n-separated jsons.
+// The team, window and metric query parameters select the leaderboard.
+// With group=team, team totals are returned instead of players.
 func (a *app) topScores(w http.ResponseWriter, r *http.Request) {
-	scores, err := leaderboard.TopScores(r.Context(), a.fsClient)
+	params := r.URL.Query()
+	q, err := leaderboard.ParseQuery(params.Get("team"), params.Get("window"), params.Get("metric"))
 	if err != nil {
-		log.Printf("leaderboard.TopScores: %v", err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var scores []interface{}
+	if params.Get("group") == "team" {
+		totals, err := a.board.TeamTotals(r.Context(), q)
+		if err != nil {
+			log.Printf("board.TeamTotals: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		for _, t := range totals {
+			scores = append(scores, t)
+		}
+	} else {
+		entries, err := a.board.Top(r.Context(), q)
+		if err != nil {
+			log.Printf("board.Top: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		for _, e := range entries {
+			scores = append(scores, e)
+		}
+	}
 	for _, obj := range scores {
 		j, err := json.Marshal(obj)
 		if err != nil {
@@ -173,7 +325,8 @@ func (a *app) addPlayData(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "Recieved data\n")
 }
 
-// sendGeneratedBackground returns cloud/hill placements.
+// sendGeneratedBackground returns cloud/hill placements, or the full level
+// if the request has a seed.
 func (a *app) sendGeneratedBackground(w http.ResponseWriter, r *http.Request) {
 	var d generator.RequestData
 	decoder := json.NewDecoder(r.Body)
@@ -183,10 +336,20 @@ func (a *app) sendGeneratedBackground(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	r.Body.Close()
-	objs := generator.GenerateBackground(d.Xmin, d.Xmax, d.Speed)
-	s := ""
-	for _, obj := range objs {
-		s += obj.String() + "\n"
+	var objs []generator.GameObject
+	if d.Seed != nil {
+		objs = generator.NewGenerator(*d.Seed).Generate(d.Xmin, d.Xmax, d.Speed)
+	} else {
+		objs = generator.GenerateBackground(d.Xmin, d.Xmax, d.Speed)
+	}
+	s, err := generator.Format(objs, d.Format)
+	if err != nil {
+		log.Printf("generator.Format: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if d.Format == "json" {
+		w.Header().Set("Content-Type", "application/json")
 	}
 	fmt.Fprint(w, s)
 }
@@ -206,5 +369,37 @@ func newApp(projectID string) (*app, error) {
 		return nil, fmt.Errorf("env variable GOPHER_RUN_BUCKET must be set")
 	}
 	bucket := csClient.Bucket(bName)
-	return &app{projectID: projectID, fsClient: fsClient, bucket: bucket}, nil
+	board := leaderboard.New(leaderboard.NewFirestoreStore(fsClient))
+	a := &app{projectID: projectID, fsClient: fsClient, bucket: bucket, board: board, train: make(chan struct{}, 1)}
+	go a.trainingWorker(ctx)
+	go func() {
+		n, err := leaderboard.Backfill(ctx, fsClient)
+		if err != nil {
+			log.Printf("leaderboard.Backfill: %v", err)
+			return
+		}
+		if n > 0 {
+			log.Printf("Backfilled %d scores to the leaderboard", n)
+		}
+	}()
+
+	// Set GOPHER_RUN_PREDICTOR=remote to use the AI Platform model trained by training.sh.
+	if os.Getenv("GOPHER_RUN_PREDICTOR") == "remote" {
+		a.predictor = predictor.NewRemote(projectID, "playerdata_linear_classification")
+		return a, nil
+	}
+	a.local = &predictor.Local{}
+	a.predictor = a.local
+	weights, err := a.readObject(ctx, modelObject)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("readObject: %w", err)
+	}
+	if err == nil {
+		model, err := predictor.Load(bytes.NewReader(weights))
+		if err != nil {
+			return nil, fmt.Errorf("predictor.Load: %w", err)
+		}
+		a.local.SetModel(model)
+	}
+	return a, nil
 }
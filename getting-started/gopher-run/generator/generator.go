@@ -25,6 +25,13 @@ type RequestData struct {
 	Xmin  float64
 	Xmax  float64
 	Speed float64
+
+	// Seed, if set, generates the full level (background, obstacles and
+	// coins) with a Generator, so that it can be reproduced.
+	Seed *int64
+	// Format is "json" for a JSON array, or empty for one
+	// space-separated object per line.
+	Format string
 }
 
 // Vector3 is 3-value vector.
@@ -54,7 +61,8 @@ func (o GameObject) String() string {
 	return fmt.Sprintf("%v %v %v %v %v %v %v", o.name, o.transform.position.X, o.transform.position.Y, o.transform.position.Z, o.transform.localScale.X, o.transform.localScale.Y, o.transform.localScale.Z)
 }
 
-// GenerateBackground determines positions for background objects.
+// GenerateBackground determines random positions for background objects.
+// Use a Generator for reproducible levels.
 func GenerateBackground(start, end, speed float64) []GameObject {
 	objects := []GameObject{}
 	for n := start; n < end; n += 30 {
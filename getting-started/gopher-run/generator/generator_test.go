@@ -29,3 +29,94 @@ func TestGenerateBackground(t *testing.T) {
 		}
 	}
 }
+
+func TestGeneratorGolden(t *testing.T) {
+	got, err := Format(NewGenerator(42).Generate(60, 90, 25), "")
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	want := `cloud 67.70009826520683 19.282378214414617 17.759139612973243 0.4323885236568461 0.4323885236568461 0.4323885236568461
+cloud 68.09402782012643 13.403952557087198 12.049100300562644 0.4872297646721582 0.4872297646721582 0.4872297646721582
+cloud 60.450444373946624 23.9632690099871 13.8079191956418 0.3569478146062325 0.3569478146062325 0.3569478146062325
+nimbus 61.09225050059985 30.173417186002276 12.066650982390442 0.6251859336568151 0.6251859336568151 0.6251859336568151
+hill 60.1699675363648 5 10.134848370961173 1.9553295355324076 1.9553295355324076 1.9553295355324076
+rock 75.7799936394916 0.5 0 1 1 1
+coin 69.9285860860848 2 0 1 1 1
+coin 71.9285860860848 2 0 1 1 1
+coin 73.9285860860848 2 0 1 1 1
+coin 75.9285860860848 6 0 1 1 1
+coin 77.9285860860848 2 0 1 1 1
+`
+	if got != want {
+		t.Errorf("Generate(60, 90, 25) with seed 42:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGeneratorDeterministic(t *testing.T) {
+	whole, _ := Format(NewGenerator(7).Generate(0, 300, 20), "")
+	first, _ := Format(NewGenerator(7).Generate(0, 150, 20), "")
+	second, _ := Format(NewGenerator(7).Generate(150, 300, 20), "")
+	if whole != first+second {
+		t.Errorf("Generate(0, 300) differs from Generate(0, 150) + Generate(150, 300)")
+	}
+	other, _ := Format(NewGenerator(8).Generate(0, 300, 20), "")
+	if whole == other {
+		t.Errorf("Generate: seeds 7 and 8 produced the same level")
+	}
+}
+
+func TestGeneratorRules(t *testing.T) {
+	objects := NewGenerator(1).Generate(0, 3000, 40)
+	var obstacles []float64
+	for _, o := range objects {
+		if n := o.Name(); n == "rock" || n == "log" {
+			x := o.Position().X
+			if x < SafeStart {
+				t.Errorf("obstacle at %v, before SafeStart", x)
+			}
+			if len(obstacles) > 0 && x-obstacles[len(obstacles)-1] < MinObstacleGap {
+				t.Errorf("obstacles at %v and %v are closer than %v", obstacles[len(obstacles)-1], x, MinObstacleGap)
+			}
+			obstacles = append(obstacles, x)
+		}
+	}
+	for _, o := range objects {
+		if o.Name() != "coin" || o.Position().Y != CoinHeight {
+			continue
+		}
+		for _, x := range obstacles {
+			if d := o.Position().X - x; d > -CoinClearance && d < CoinClearance {
+				t.Errorf("ground coin at %v overlaps obstacle at %v", o.Position().X, x)
+			}
+		}
+	}
+}
+
+func TestGeneratorDifficulty(t *testing.T) {
+	count := func(speed float64) int {
+		n := 0
+		for _, o := range NewGenerator(3).Generate(0, 6000, speed) {
+			if o.Name() == "rock" || o.Name() == "log" {
+				n++
+			}
+		}
+		return n
+	}
+	if easy, hard := count(10), count(40); easy >= hard {
+		t.Errorf("obstacles: got %d at speed 10 and %d at speed 40, want more when faster", easy, hard)
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	got, err := Format(NewGenerator(42).Generate(60, 60.3, 25), "json")
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	want := `[{"name":"hill","position":{"x":60.1699675363648,"y":5,"z":10.134848370961173},"scale":{"x":1.9553295355324076,"y":1.9553295355324076,"z":1.9553295355324076}}]`
+	if got != want {
+		t.Errorf("Format json:\ngot  %s\nwant %s", got, want)
+	}
+	if got, _ := Format(nil, "json"); got != "[]" {
+		t.Errorf("Format(nil, json): got %s, want []", got)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generator
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Level layout constants, in world units.
+const (
+	// SegmentWidth is the width of the independently generated level segments.
+	SegmentWidth = 30.0
+	// SafeStart is the distance at the start of a level with no obstacles.
+	SafeStart = 60.0
+	// MinObstacleGap is the smallest gap between two obstacles.
+	MinObstacleGap = 5.0
+	// CoinHeight is the height of coins on the ground.
+	CoinHeight = 2.0
+	// JumpHeight is the height of coins placed over obstacles.
+	JumpHeight = 6.0
+	// CoinClearance is how close to an obstacle a coin is lifted to JumpHeight.
+	CoinClearance = 1.5
+	coinSpacing   = 2.0
+)
+
+// DifficultyCurve maps the player's speed to a difficulty between 0 and 1.
+type DifficultyCurve func(speed float64) float64
+
+// LinearDifficulty returns a curve rising linearly from 0 at minSpeed to 1
+// at maxSpeed.
+func LinearDifficulty(minSpeed, maxSpeed float64) DifficultyCurve {
+	return func(speed float64) float64 {
+		return math.Max(0, math.Min(1, (speed-minSpeed)/(maxSpeed-minSpeed)))
+	}
+}
+
+// Generator deterministically generates levels from a seed. The objects in
+// each segment depend only on the seed, the segment's position and the
+// speed, so any range of the level can be regenerated on its own and
+// replays can be verified.
+type Generator struct {
+	seed int64
+
+	// Difficulty sets how many obstacles are placed. Defaults to
+	// LinearDifficulty(10, 40).
+	Difficulty DifficultyCurve
+}
+
+// NewGenerator creates a Generator for the level with the given seed.
+func NewGenerator(seed int64) *Generator {
+	return &Generator{seed: seed, Difficulty: LinearDifficulty(10, 40)}
+}
+
+// Seed returns the generator's seed.
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// segmentRand returns the random source for the segment starting at x.
+func (g *Generator) segmentRand(segment int64) *rand.Rand {
+	// Mix the segment into the seed so neighbouring segments are unrelated.
+	h := uint64(g.seed) ^ (uint64(segment) * 0x9E3779B97F4A7C15)
+	h ^= h >> 31
+	return rand.New(rand.NewSource(int64(h)))
+}
+
+// Generate returns the background, obstacles and coins with X positions in
+// [start, end), ordered by segment.
+func (g *Generator) Generate(start, end, speed float64) []GameObject {
+	difficulty := g.Difficulty(speed)
+	var objects []GameObject
+	for seg := int64(math.Floor(start / SegmentWidth)); float64(seg)*SegmentWidth < end; seg++ {
+		for _, o := range g.segment(seg, difficulty) {
+			if x := o.transform.position.X; x >= start && x < end {
+				objects = append(objects, o)
+			}
+		}
+	}
+	return objects
+}
+
+// segment generates the objects of one segment. Random values are always
+// drawn in the same order, so the output depends only on the seed,
+// segment and difficulty.
+func (g *Generator) segment(seg int64, difficulty float64) []GameObject {
+	r := g.segmentRand(seg)
+	n := float64(seg) * SegmentWidth
+	rr := func(i, j float64) float64 { return r.Float64()*(j-i) + i }
+	uniform := func(name string, x, y, z, scale float64) GameObject {
+		return GameObject{name, Transform{Vector3{x, y, z}, Vector3{scale, scale, scale}}}
+	}
+
+	var objects []GameObject
+	for m := 0; m < 3; m++ {
+		scale, x, y, z := rr(0.2, 0.6), rr(n, n+10), rr(10, 25), 15+rr(-5, 5)
+		objects = append(objects, uniform("cloud", x, y, z, scale))
+	}
+	scale, x, y, z := rr(0.5, 1.5), rr(n, n+10), rr(30, 40), 15+rr(-5, 5)
+	objects = append(objects, uniform("nimbus", x, y, z, scale))
+	scale, x, z = rr(1.5, 2.5), rr(n, n+10), 15+rr(-5, 5)
+	objects = append(objects, uniform("hill", x, 5, z, scale))
+
+	// Obstacles go in two slots, [n+5, n+10) and [n+15, n+20), so they are
+	// always at least MinObstacleGap apart, even across segments.
+	chance := 0.15 + 0.55*difficulty
+	var obstacles []float64
+	for _, slot := range []float64{n + 5, n + 15} {
+		roll, x, kind := r.Float64(), rr(slot, slot+5), r.Intn(2)
+		if n+SegmentWidth <= SafeStart || roll >= chance {
+			continue
+		}
+		name, height := "rock", 1.0
+		if kind == 1 {
+			name, height = "log", 0.6
+		}
+		obstacles = append(obstacles, x)
+		objects = append(objects, uniform(name, x, height/2, 0, height))
+	}
+
+	// A row of coins, lifted over any obstacle in its way.
+	roll, rowStart, count := r.Float64(), rr(n, n+20), 3+r.Intn(3)
+	if roll < 0.6 {
+		for i := 0; i < count; i++ {
+			x := rowStart + float64(i)*coinSpacing
+			y := CoinHeight
+			for _, o := range obstacles {
+				if math.Abs(x-o) < CoinClearance {
+					y = JumpHeight
+				}
+			}
+			objects = append(objects, uniform("coin", x, y, 0, 1))
+		}
+	}
+	return objects
+}
+
+// MarshalJSON encodes the object as
+// {"name":...,"position":{"x":...},"scale":{"x":...}}.
+func (o GameObject) MarshalJSON() ([]byte, error) {
+	type vec struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+		Z float64 `json:"z"`
+	}
+	p, s := o.transform.position, o.transform.localScale
+	return json.Marshal(struct {
+		Name     string `json:"name"`
+		Position vec    `json:"position"`
+		Scale    vec    `json:"scale"`
+	}{o.name, vec{p.X, p.Y, p.Z}, vec{s.X, s.Y, s.Z}})
+}
+
+// Name returns the kind of object, such as "cloud", "rock" or "coin".
+func (o GameObject) Name() string {
+	return o.name
+}
+
+// Position returns where the object is placed.
+func (o GameObject) Position() Vector3 {
+	return o.transform.position
+}
+
+// Format writes objects in the space-separated GameObject.String format,
+// one per line, or as a JSON array if format is "json".
+func Format(objects []GameObject, format string) (string, error) {
+	if format == "json" {
+		if objects == nil {
+			objects = []GameObject{}
+		}
+		b, err := json.Marshal(objects)
+		return string(b), err
+	}
+	var sb strings.Builder
+	for _, o := range objects {
+		sb.WriteString(o.String())
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
@@ -17,68 +17,374 @@ package leaderboard
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"time"
 
 	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
 )
 
 // ScoreData is a player's score.
 type ScoreData struct {
-	Name     string  `json:"name"`
-	Team     string  `json:"team"`
-	Coins    int     `json:"coins"`
-	Distance float32 `json:"distance"`
-	Combo    float32 `json:"combo"`
+	Name     string    `json:"name" firestore:"name"`
+	Team     string    `json:"team" firestore:"team"`
+	Coins    int       `json:"coins" firestore:"coins"`
+	Distance float32   `json:"distance" firestore:"distance"`
+	Combo    float32   `json:"combo" firestore:"combo"`
+	Time     time.Time `json:"time,omitempty" firestore:"time,omitempty"`
 }
 
-// TopScores returns the top 10 scores in the leaderboard.
-func TopScores(ctx context.Context, client *firestore.Client) ([]ScoreData, error) {
-	iter := client.Collection("leaderboard").Query.OrderBy("coins", firestore.Desc).Limit(10).Documents(ctx)
-	var top []ScoreData
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+// Window is the period of time a leaderboard covers.
+type Window string
+
+// Leaderboard windows. Daily and weekly boards reset at midnight UTC, and
+// weekly boards on Mondays.
+const (
+	AllTime Window = "all"
+	Daily   Window = "daily"
+	Weekly  Window = "weekly"
+)
+
+// Metric is the score field players are ranked by.
+type Metric string
+
+// Leaderboard metrics.
+const (
+	Coins    Metric = "coins"
+	Distance Metric = "distance"
+	Combo    Metric = "combo"
+)
+
+// ErrImplausible is returned by Submit for scores that cannot be achieved
+// in the game, or that have no player name.
+var ErrImplausible = errors.New("implausible score")
+
+// Limits bound what a single run can plausibly score.
+type Limits struct {
+	// MaxCoinsPerDistance is the most coins that can be collected per unit
+	// of distance run.
+	MaxCoinsPerDistance float64
+	// MaxComboPerCoin is the highest combo per coin collected.
+	MaxComboPerCoin float64
+	// MaxDistance is the longest possible run.
+	MaxDistance float64
+}
+
+// DefaultLimits are the limits of the standard level layout.
+var DefaultLimits = Limits{
+	MaxCoinsPerDistance: 0.5,
+	MaxComboPerCoin:     1,
+	MaxDistance:         1e6,
+}
+
+// Check returns an error wrapping ErrImplausible if d has no player name or
+// exceeds the limits.
+func (l Limits) Check(d ScoreData) error {
+	dist, combo := float64(d.Distance), float64(d.Combo)
+	switch {
+	case d.Name == "":
+		return fmt.Errorf("%w: missing player name", ErrImplausible)
+	case d.Coins < 0 || dist < 0 || combo < 0:
+		return fmt.Errorf("%w: negative value", ErrImplausible)
+	case math.IsNaN(dist) || math.IsInf(dist, 0) || math.IsNaN(combo) || math.IsInf(combo, 0):
+		return fmt.Errorf("%w: not a number", ErrImplausible)
+	case dist > l.MaxDistance:
+		return fmt.Errorf("%w: distance %v exceeds %v", ErrImplausible, dist, l.MaxDistance)
+	case float64(d.Coins) > dist*l.MaxCoinsPerDistance:
+		return fmt.Errorf("%w: %d coins in distance %v", ErrImplausible, d.Coins, dist)
+	case combo > float64(d.Coins)*l.MaxComboPerCoin:
+		return fmt.Errorf("%w: combo %v with %d coins", ErrImplausible, combo, d.Coins)
+	}
+	return nil
+}
+
+// Board is the leaderboard of one metric over one window.
+type Board struct {
+	Window Window
+	Metric Metric
+	// Start is when the window began, or the zero time for AllTime.
+	Start time.Time
+}
+
+// ID identifies the board, such as "coins-all" or "distance-daily-20230614".
+func (b Board) ID() string {
+	if b.Window == AllTime {
+		return fmt.Sprintf("%s-%s", b.Metric, b.Window)
+	}
+	return fmt.Sprintf("%s-%s-%s", b.Metric, b.Window, b.Start.Format("20060102"))
+}
+
+// Store keeps each player's best score on each board, and the total of the
+// best scores of each team's players.
+type Store interface {
+	// Record saves d as the player's best score on each of boards where it
+	// beats their previous best, and updates the totals of their team, in
+	// one transaction. It reports which boards d was a new best on.
+	Record(ctx context.Context, d ScoreData, boards []Board) ([]bool, error)
+	// Top returns up to limit best scores on b, highest first, with ties
+	// broken by the earlier score, then by name. A non-empty team limits
+	// them to the team's players.
+	Top(ctx context.Context, b Board, team string, limit int) ([]ScoreData, error)
+	// TeamTotals returns up to limit team totals on b, highest first, with
+	// ties broken by team name. Their ranks are not set.
+	TeamTotals(ctx context.Context, b Board, limit int) ([]TeamTotal, error)
+}
+
+// Query selects a leaderboard.
+type Query struct {
+	// Team limits the board to one team. Empty means every team.
+	Team string
+	// Window defaults to AllTime.
+	Window Window
+	// Metric defaults to Coins.
+	Metric Metric
+	// Limit is the number of entries to return. Defaults to 10.
+	Limit int
+}
+
+// Entry is a player's place on a leaderboard, with their best score in the
+// window by the query's metric. A player is on a team's board if the team
+// was theirs when they made that score.
+type Entry struct {
+	Rank int `json:"rank"`
+	ScoreData
+}
+
+// TeamTotal is a team's place on a leaderboard. Total is the sum of the
+// best score of each player in the team.
+type TeamTotal struct {
+	Rank    int     `json:"rank"`
+	Team    string  `json:"team"`
+	Total   float64 `json:"total"`
+	Players int     `json:"players"`
+}
+
+// Leaderboard ranks scores kept in a Store.
+type Leaderboard struct {
+	store  Store
+	limits Limits
+	now    func() time.Time
+}
+
+// New creates a Leaderboard that checks scores against DefaultLimits.
+func New(store Store) *Leaderboard {
+	return &Leaderboard{store: store, limits: DefaultLimits, now: time.Now}
+}
+
+// ParseQuery parses the team, window and metric query parameters.
+func ParseQuery(team, window, metric string) (Query, error) {
+	q := Query{Team: team, Window: Window(window), Metric: Metric(metric)}
+	return q, q.normalize()
+}
+
+func (q *Query) normalize() error {
+	switch q.Window {
+	case "":
+		q.Window = AllTime
+	case AllTime, Daily, Weekly:
+	default:
+		return fmt.Errorf("unknown window %q", q.Window)
+	}
+	switch q.Metric {
+	case "":
+		q.Metric = Coins
+	case Coins, Distance, Combo:
+	default:
+		return fmt.Errorf("unknown metric %q", q.Metric)
+	}
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	return nil
+}
+
+// start returns when the window containing now began, or the zero time for AllTime.
+func (w Window) start(now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch w {
+	case Daily:
+		return day
+	case Weekly:
+		// Weeks start on Monday.
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return time.Time{}
+}
+
+// value returns the metric's value in d.
+func (m Metric) value(d ScoreData) float64 {
+	switch m {
+	case Distance:
+		return float64(d.Distance)
+	case Combo:
+		return float64(d.Combo)
+	}
+	return float64(d.Coins)
+}
+
+// board returns the query's board at time now.
+func (q Query) board(now time.Time) Board {
+	return Board{Window: q.Window, Metric: q.Metric, Start: q.Window.start(now)}
+}
+
+// boards returns every board a score made at t is on. The first is the
+// all-time board by coins.
+func boards(t time.Time) []Board {
+	var bs []Board
+	for _, w := range []Window{AllTime, Daily, Weekly} {
+		for _, m := range []Metric{Coins, Distance, Combo} {
+			bs = append(bs, Query{Window: w, Metric: m}.board(t))
+		}
+	}
+	return bs
+}
+
+// teamChange is a change to a team's total on a board.
+type teamChange struct {
+	total   float64
+	players int
+}
+
+// teamChanges returns the changes to the team totals on a board by metric m
+// when d replaces old as a player's best score. old is nil if the player had
+// no score on the board.
+func teamChanges(m Metric, old *ScoreData, d ScoreData) map[string]teamChange {
+	changes := make(map[string]teamChange)
+	if old != nil && old.Team != "" {
+		c := changes[old.Team]
+		c.total -= m.value(*old)
+		c.players--
+		changes[old.Team] = c
+	}
+	if d.Team != "" {
+		c := changes[d.Team]
+		c.total += m.value(d)
+		c.players++
+		changes[d.Team] = c
+	}
+	return changes
+}
+
+// sortScores sorts scores by metric m, highest first. Ties are broken by the
+// earlier score, then by name.
+func sortScores(m Metric, scores []ScoreData) {
+	sort.Slice(scores, func(i, j int) bool {
+		a, b := scores[i], scores[j]
+		if va, vb := m.value(a), m.value(b); va != vb {
+			return va > vb
 		}
-		if err != nil {
-			return nil, fmt.Errorf("iter.Next: %w", err)
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
 		}
-		var d ScoreData
-		if err = doc.DataTo(&d); err != nil {
-			return nil, fmt.Errorf("doc.DataTo: %w", err)
+		return a.Name < b.Name
+	})
+}
+
+// sortTotals sorts totals highest first. Ties are broken by team name.
+func sortTotals(totals []TeamTotal) {
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Total != totals[j].Total {
+			return totals[i].Total > totals[j].Total
 		}
-		top = append(top, d)
+		return totals[i].Team < totals[j].Team
+	})
+}
+
+// Submit checks and saves a score. It returns "pb" if the score is the
+// player's all-time best by coins, and "" otherwise.
+func (l *Leaderboard) Submit(ctx context.Context, d ScoreData) (string, error) {
+	if err := l.limits.Check(d); err != nil {
+		return "", err
 	}
-	return top, nil
+	d.Time = l.now()
+	improved, err := l.store.Record(ctx, d, boards(d.Time))
+	if err != nil {
+		return "", err
+	}
+	if improved[0] {
+		return "pb", nil
+	}
+	return "", nil
 }
 
-// AddScore adds a score to the leaderboard database and returns information about whether it updated an existing score.
-func AddScore(ctx context.Context, client *firestore.Client, d ScoreData) (string, error) {
-	var oldD ScoreData
-	iter := client.Collection("leaderboard").Query.Limit(1).Where("name", "==", d.Name).Documents(ctx)
-	doc, err := iter.Next()
-	if err != iterator.Done && err != nil {
-		return "", fmt.Errorf("iter.Next: %w", err)
-	}
-	if err != iterator.Done {
-		if err = doc.DataTo(&oldD); err != nil {
-			return "", fmt.Errorf("doc.DataTo: %w", err)
+// Import records a score kept by an earlier version, which had only an
+// all-time board by coins, on the all-time boards. Scores are not checked
+// against the limits, and d.Time should be when the score was saved. Import
+// only records scores that beat the player's best, so importing a score
+// again changes nothing. It reports whether d was recorded on any board.
+func (l *Leaderboard) Import(ctx context.Context, d ScoreData) (bool, error) {
+	if d.Name == "" {
+		return false, fmt.Errorf("%w: missing player name", ErrImplausible)
+	}
+	var all []Board
+	for _, b := range boards(d.Time) {
+		if b.Window == AllTime {
+			all = append(all, b)
 		}
 	}
-	s := ""
-	if oldD.Coins < d.Coins {
-		s = "pb"
-		_, err := client.Collection("leaderboard").Doc(d.Name).Set(ctx, map[string]interface{}{
-			"name":     d.Name,
-			"team":     d.Team,
-			"coins":    d.Coins,
-			"distance": d.Distance,
-			"combo":    d.Combo,
-		})
-		if err != nil {
-			return "", fmt.Errorf("Doc(%v).Set: %w", d.Name, err)
+	improved, err := l.store.Record(ctx, d, all)
+	if err != nil {
+		return false, err
+	}
+	for _, ok := range improved {
+		if ok {
+			return true, nil
 		}
 	}
-	return s, nil
+	return false, nil
+}
+
+// Top returns the players with the best scores, highest first. Ties are
+// broken by the earlier score, then by name.
+func (l *Leaderboard) Top(ctx context.Context, q Query) ([]Entry, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	scores, err := l.store.Top(ctx, q.board(l.now()), q.Team, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(scores))
+	for i, s := range scores {
+		entries[i] = Entry{Rank: i + 1, ScoreData: s}
+	}
+	return entries, nil
+}
+
+// TeamTotals ranks teams by the sum of their players' best scores, highest
+// first. Players without a team are not counted, and the query's team is
+// ignored.
+func (l *Leaderboard) TeamTotals(ctx context.Context, q Query) ([]TeamTotal, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	totals, err := l.store.TeamTotals(ctx, q.board(l.now()), q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range totals {
+		totals[i].Rank = i + 1
+	}
+	return totals, nil
+}
+
+// TopScores returns the top 10 all-time scores by coins in the leaderboard.
+func TopScores(ctx context.Context, client *firestore.Client) ([]ScoreData, error) {
+	entries, err := New(NewFirestoreStore(client)).Top(ctx, Query{})
+	if err != nil {
+		return nil, err
+	}
+	top := make([]ScoreData, len(entries))
+	for i, e := range entries {
+		top[i] = e.ScoreData
+	}
+	return top, nil
+}
+
+// AddScore adds a score to the leaderboard database and returns "pb" if it is the player's best.
+func AddScore(ctx context.Context, client *firestore.Client, d ScoreData) (string, error) {
+	return New(NewFirestoreStore(client)).Submit(ctx, d)
 }
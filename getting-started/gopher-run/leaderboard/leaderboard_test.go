@@ -0,0 +1,283 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestBoard returns a leaderboard whose clock is set by the returned func.
+func newTestBoard() (*Leaderboard, func(time.Time)) {
+	l := New(NewMemoryStore())
+	now := time.Date(2023, 6, 14, 12, 0, 0, 0, time.UTC) // A Wednesday.
+	l.now = func() time.Time { return now }
+	return l, func(t time.Time) { now = t }
+}
+
+func submit(t *testing.T, l *Leaderboard, d ScoreData) string {
+	t.Helper()
+	pb, err := l.Submit(context.Background(), d)
+	if err != nil {
+		t.Fatalf("Submit(%+v): %v", d, err)
+	}
+	return pb
+}
+
+func names(entries []Entry) []string {
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name)
+	}
+	return got
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubmitPersonalBest(t *testing.T) {
+	l, _ := newTestBoard()
+	if got := submit(t, l, ScoreData{Name: "gopher", Coins: 10, Distance: 100}); got != "pb" {
+		t.Errorf("first Submit: got %q, want pb", got)
+	}
+	if got := submit(t, l, ScoreData{Name: "gopher", Coins: 5, Distance: 100}); got != "" {
+		t.Errorf("worse Submit: got %q, want empty", got)
+	}
+	if got := submit(t, l, ScoreData{Name: "gopher", Coins: 20, Distance: 100}); got != "pb" {
+		t.Errorf("better Submit: got %q, want pb", got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	l, _ := newTestBoard()
+	ctx := context.Background()
+	old := ScoreData{Name: "veteran", Team: "red", Coins: 500, Distance: 100, Combo: 2, Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for i := 0; i < 2; i++ {
+		ok, err := l.Import(ctx, old)
+		if err != nil {
+			t.Fatalf("Import: %v", err)
+		}
+		if want := i == 0; ok != want {
+			t.Errorf("Import #%d: got %v, want %v", i+1, ok, want)
+		}
+	}
+	if _, err := l.Import(ctx, ScoreData{Coins: 1}); !errors.Is(err, ErrImplausible) {
+		t.Errorf("Import without a name: got %v, want ErrImplausible", err)
+	}
+	submit(t, l, ScoreData{Name: "newcomer", Team: "red", Coins: 10, Distance: 100})
+
+	got, err := l.Top(ctx, Query{})
+	if err != nil {
+		t.Fatalf("Top: %v", err)
+	}
+	if want := []string{"veteran", "newcomer"}; !equal(names(got), want) {
+		t.Errorf("Top: got %v, want %v", names(got), want)
+	}
+	got, err = l.Top(ctx, Query{Window: Daily})
+	if err != nil {
+		t.Fatalf("Top: %v", err)
+	}
+	if want := []string{"newcomer"}; !equal(names(got), want) {
+		t.Errorf("Top daily: got %v, want %v", names(got), want)
+	}
+	totals, err := l.TeamTotals(ctx, Query{})
+	if err != nil {
+		t.Fatalf("TeamTotals: %v", err)
+	}
+	if len(totals) != 1 || totals[0].Total != 510 || totals[0].Players != 2 {
+		t.Errorf("TeamTotals: got %+v, want red with 510 from 2 players", totals)
+	}
+}
+
+func TestTopByMetricAndWindow(t *testing.T) {
+	l, setNow := newTestBoard()
+	ctx := context.Background()
+
+	// Last week.
+	setNow(time.Date(2023, 6, 8, 12, 0, 0, 0, time.UTC))
+	submit(t, l, ScoreData{Name: "old", Coins: 50, Distance: 500, Combo: 1})
+	// Monday this week.
+	setNow(time.Date(2023, 6, 12, 12, 0, 0, 0, time.UTC))
+	submit(t, l, ScoreData{Name: "monday", Coins: 30, Distance: 900, Combo: 2})
+	// Today.
+	setNow(time.Date(2023, 6, 14, 12, 0, 0, 0, time.UTC))
+	submit(t, l, ScoreData{Name: "today", Coins: 20, Distance: 100, Combo: 20})
+	submit(t, l, ScoreData{Name: "today", Coins: 10, Distance: 950, Combo: 3})
+
+	tests := []struct {
+		q    Query
+		want []string
+	}{
+		{Query{}, []string{"old", "monday", "today"}},
+		{Query{Window: Weekly}, []string{"monday", "today"}},
+		{Query{Window: Daily}, []string{"today"}},
+		{Query{Metric: Distance}, []string{"today", "monday", "old"}},
+		{Query{Metric: Combo}, []string{"today", "monday", "old"}},
+		{Query{Limit: 2}, []string{"old", "monday"}},
+	}
+	for _, tc := range tests {
+		got, err := l.Top(ctx, tc.q)
+		if err != nil {
+			t.Fatalf("Top(%+v): %v", tc.q, err)
+		}
+		if !equal(names(got), tc.want) {
+			t.Errorf("Top(%+v): got %v, want %v", tc.q, names(got), tc.want)
+		}
+		for i, e := range got {
+			if e.Rank != i+1 {
+				t.Errorf("Top(%+v): entry %d has rank %d", tc.q, i, e.Rank)
+			}
+		}
+	}
+
+	// The best distance is from a different run than the best coins.
+	got, _ := l.Top(ctx, Query{Window: Daily, Metric: Distance})
+	if got[0].Distance != 950 {
+		t.Errorf("Top by distance: got distance %v, want 950", got[0].Distance)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	l, _ := newTestBoard()
+	ctx := context.Background()
+	submit(t, l, ScoreData{Name: "a1", Team: "red", Coins: 10, Distance: 100})
+	submit(t, l, ScoreData{Name: "a2", Team: "red", Coins: 15, Distance: 100})
+	submit(t, l, ScoreData{Name: "a2", Team: "red", Coins: 5, Distance: 100})
+	submit(t, l, ScoreData{Name: "b1", Team: "blue", Coins: 20, Distance: 100})
+	submit(t, l, ScoreData{Name: "solo", Coins: 40, Distance: 100})
+
+	got, err := l.Top(ctx, Query{Team: "red"})
+	if err != nil {
+		t.Fatalf("Top: %v", err)
+	}
+	if want := []string{"a2", "a1"}; !equal(names(got), want) {
+		t.Errorf("Top(red): got %v, want %v", names(got), want)
+	}
+
+	totals, err := l.TeamTotals(ctx, Query{})
+	if err != nil {
+		t.Fatalf("TeamTotals: %v", err)
+	}
+	want := []TeamTotal{
+		{Rank: 1, Team: "red", Total: 25, Players: 2},
+		{Rank: 2, Team: "blue", Total: 20, Players: 1},
+	}
+	if len(totals) != len(want) {
+		t.Fatalf("TeamTotals: got %+v, want %+v", totals, want)
+	}
+	for i := range want {
+		if totals[i] != want[i] {
+			t.Errorf("TeamTotals[%d]: got %+v, want %+v", i, totals[i], want[i])
+		}
+	}
+}
+
+func TestTeamChange(t *testing.T) {
+	l, _ := newTestBoard()
+	ctx := context.Background()
+	submit(t, l, ScoreData{Name: "a1", Team: "red", Coins: 10, Distance: 100})
+	submit(t, l, ScoreData{Name: "a2", Team: "red", Coins: 15, Distance: 100})
+	// a1 moves to blue with a better score, and their red score no longer
+	// counts. A worse score does not move them back.
+	submit(t, l, ScoreData{Name: "a1", Team: "blue", Coins: 30, Distance: 100})
+	submit(t, l, ScoreData{Name: "a1", Team: "red", Coins: 5, Distance: 100})
+
+	totals, err := l.TeamTotals(ctx, Query{})
+	if err != nil {
+		t.Fatalf("TeamTotals: %v", err)
+	}
+	want := []TeamTotal{
+		{Rank: 1, Team: "blue", Total: 30, Players: 1},
+		{Rank: 2, Team: "red", Total: 15, Players: 1},
+	}
+	if len(totals) != len(want) {
+		t.Fatalf("TeamTotals: got %+v, want %+v", totals, want)
+	}
+	for i := range want {
+		if totals[i] != want[i] {
+			t.Errorf("TeamTotals[%d]: got %+v, want %+v", i, totals[i], want[i])
+		}
+	}
+	got, err := l.Top(ctx, Query{Team: "red"})
+	if err != nil {
+		t.Fatalf("Top: %v", err)
+	}
+	if want := []string{"a2"}; !equal(names(got), want) {
+		t.Errorf("Top(red): got %v, want %v", names(got), want)
+	}
+}
+
+func TestBoardID(t *testing.T) {
+	now := time.Date(2023, 6, 14, 12, 0, 0, 0, time.UTC)
+	for q, want := range map[Query]string{
+		{Window: AllTime, Metric: Coins}:  "coins-all",
+		{Window: Daily, Metric: Distance}: "distance-daily-20230614",
+		{Window: Weekly, Metric: Combo}:   "combo-weekly-20230612",
+	} {
+		if got := q.board(now).ID(); got != want {
+			t.Errorf("board(%+v).ID() = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestSubmitImplausible(t *testing.T) {
+	l, _ := newTestBoard()
+	tests := []ScoreData{
+		{Name: "neg", Coins: -1, Distance: 10},
+		{Name: "fast", Coins: 100, Distance: 10},
+		{Name: "combo", Coins: 5, Distance: 100, Combo: 50},
+		{Name: "far", Coins: 1, Distance: 1e7},
+		{Name: "nan", Coins: 1, Distance: float32(math.NaN())},
+	}
+	for _, d := range tests {
+		if _, err := l.Submit(context.Background(), d); !errors.Is(err, ErrImplausible) {
+			t.Errorf("Submit(%+v): got %v, want ErrImplausible", d, err)
+		}
+	}
+	if _, err := l.Submit(context.Background(), ScoreData{Coins: 1, Distance: 10}); !errors.Is(err, ErrImplausible) {
+		t.Errorf("Submit without a name: got %v, want ErrImplausible", err)
+	}
+	if top, _ := l.Top(context.Background(), Query{}); len(top) != 0 {
+		t.Errorf("Top: got %v, want implausible scores not saved", names(top))
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery("red", "weekly", "distance")
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	if q.Team != "red" || q.Window != Weekly || q.Metric != Distance || q.Limit != 10 {
+		t.Errorf("ParseQuery: got %+v", q)
+	}
+	if _, err := ParseQuery("", "monthly", ""); err == nil {
+		t.Errorf("ParseQuery: want error for unknown window")
+	}
+	if _, err := ParseQuery("", "", "speed"); err == nil {
+		t.Errorf("ParseQuery: want error for unknown metric")
+	}
+}
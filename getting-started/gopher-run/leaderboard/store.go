@@ -0,0 +1,331 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderboard
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// bestDoc is a player's best score on a board.
+type bestDoc struct {
+	ScoreData
+	Board string  `firestore:"board"`
+	Value float64 `firestore:"value"`
+}
+
+// teamDoc is a team's total on a board.
+type teamDoc struct {
+	Board   string  `firestore:"board"`
+	Team    string  `firestore:"team"`
+	Total   float64 `firestore:"total"`
+	Players int     `firestore:"players"`
+}
+
+// firestoreStore keeps one document per player and board in the
+// "leaderboard_best" collection, and one per team and board in the
+// "leaderboard_teams" collection, so boards are read with ordered, limited
+// queries. Top and TeamTotals need the composite indexes in
+// firestore.indexes.json, deployed with
+//
+//	firebase deploy --only firestore:indexes
+//
+// Scores in the "leaderboard" collection, written by earlier versions, are
+// copied to the all-time boards by Backfill.
+type firestoreStore struct {
+	client *firestore.Client
+	best   *firestore.CollectionRef
+	teams  *firestore.CollectionRef
+}
+
+// NewFirestoreStore creates a Store backed by Cloud Firestore.
+func NewFirestoreStore(client *firestore.Client) Store {
+	return &firestoreStore{
+		client: client,
+		best:   client.Collection("leaderboard_best"),
+		teams:  client.Collection("leaderboard_teams"),
+	}
+}
+
+// docID returns the ID of the document for name on board b. Names are
+// escaped, as document IDs cannot contain slashes.
+func docID(b Board, name string) string {
+	return b.ID() + "_" + url.PathEscape(name)
+}
+
+func (s *firestoreStore) Record(ctx context.Context, d ScoreData, boards []Board) ([]bool, error) {
+	var improved []bool
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		improved = make([]bool, len(boards))
+		refs := make([]*firestore.DocumentRef, len(boards))
+		for i, b := range boards {
+			refs[i] = s.best.Doc(docID(b, d.Name))
+		}
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return fmt.Errorf("tx.GetAll: %w", err)
+		}
+		// A transaction's reads must all come before its writes, so the
+		// changes to team documents are collected first.
+		changes := make(map[string]*teamDoc)
+		for i, b := range boards {
+			var old *ScoreData
+			if snaps[i].Exists() {
+				var doc bestDoc
+				if err := snaps[i].DataTo(&doc); err != nil {
+					return fmt.Errorf("DataTo: %w", err)
+				}
+				if b.Metric.value(d) <= doc.Value {
+					continue
+				}
+				old = &doc.ScoreData
+			}
+			improved[i] = true
+			for team, c := range teamChanges(b.Metric, old, d) {
+				id := docID(b, team)
+				t, ok := changes[id]
+				if !ok {
+					t = &teamDoc{Board: b.ID(), Team: team}
+					changes[id] = t
+				}
+				t.Total += c.total
+				t.Players += c.players
+			}
+		}
+		var teamRefs []*firestore.DocumentRef
+		for id := range changes {
+			teamRefs = append(teamRefs, s.teams.Doc(id))
+		}
+		var teamSnaps []*firestore.DocumentSnapshot
+		if len(teamRefs) > 0 {
+			if teamSnaps, err = tx.GetAll(teamRefs); err != nil {
+				return fmt.Errorf("tx.GetAll: %w", err)
+			}
+		}
+
+		for i, b := range boards {
+			if !improved[i] {
+				continue
+			}
+			doc := bestDoc{ScoreData: d, Board: b.ID(), Value: b.Metric.value(d)}
+			if err := tx.Set(refs[i], doc); err != nil {
+				return fmt.Errorf("tx.Set: %w", err)
+			}
+		}
+		for i, ref := range teamRefs {
+			doc := *changes[ref.ID]
+			if teamSnaps[i].Exists() {
+				var old teamDoc
+				if err := teamSnaps[i].DataTo(&old); err != nil {
+					return fmt.Errorf("DataTo: %w", err)
+				}
+				doc.Total += old.Total
+				doc.Players += old.Players
+			}
+			if doc.Players <= 0 {
+				if err := tx.Delete(ref); err != nil {
+					return fmt.Errorf("tx.Delete: %w", err)
+				}
+				continue
+			}
+			if err := tx.Set(ref, doc); err != nil {
+				return fmt.Errorf("tx.Set: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("RunTransaction: %w", err)
+	}
+	return improved, nil
+}
+
+func (s *firestoreStore) Top(ctx context.Context, b Board, team string, limit int) ([]ScoreData, error) {
+	q := s.best.Where("board", "==", b.ID())
+	if team != "" {
+		q = q.Where("team", "==", team)
+	}
+	iter := q.OrderBy("value", firestore.Desc).
+		OrderBy("time", firestore.Asc).
+		OrderBy("name", firestore.Asc).
+		Limit(limit).
+		Documents(ctx)
+	defer iter.Stop()
+	var scores []ScoreData
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return scores, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("iter.Next: %w", err)
+		}
+		var d bestDoc
+		if err := doc.DataTo(&d); err != nil {
+			return nil, fmt.Errorf("doc.DataTo: %w", err)
+		}
+		scores = append(scores, d.ScoreData)
+	}
+}
+
+func (s *firestoreStore) TeamTotals(ctx context.Context, b Board, limit int) ([]TeamTotal, error) {
+	iter := s.teams.Where("board", "==", b.ID()).
+		OrderBy("total", firestore.Desc).
+		OrderBy("team", firestore.Asc).
+		Limit(limit).
+		Documents(ctx)
+	defer iter.Stop()
+	var totals []TeamTotal
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return totals, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("iter.Next: %w", err)
+		}
+		var t teamDoc
+		if err := doc.DataTo(&t); err != nil {
+			return nil, fmt.Errorf("doc.DataTo: %w", err)
+		}
+		totals = append(totals, TeamTotal{Team: t.Team, Total: t.Total, Players: t.Players})
+	}
+}
+
+// memoryStore keeps boards in memory.
+type memoryStore struct {
+	mu    sync.Mutex
+	best  map[string]map[string]ScoreData  // By board ID and player.
+	teams map[string]map[string]*TeamTotal // By board ID and team.
+}
+
+// NewMemoryStore creates a Store that keeps boards in memory, for tests and
+// local development.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		best:  make(map[string]map[string]ScoreData),
+		teams: make(map[string]map[string]*TeamTotal),
+	}
+}
+
+func (s *memoryStore) Record(_ context.Context, d ScoreData, boards []Board) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	improved := make([]bool, len(boards))
+	for i, b := range boards {
+		best, ok := s.best[b.ID()]
+		if !ok {
+			best = make(map[string]ScoreData)
+			s.best[b.ID()] = best
+		}
+		var old *ScoreData
+		if o, ok := best[d.Name]; ok {
+			if b.Metric.value(d) <= b.Metric.value(o) {
+				continue
+			}
+			old = &o
+		}
+		improved[i] = true
+		best[d.Name] = d
+		teams, ok := s.teams[b.ID()]
+		if !ok {
+			teams = make(map[string]*TeamTotal)
+			s.teams[b.ID()] = teams
+		}
+		for team, c := range teamChanges(b.Metric, old, d) {
+			t, ok := teams[team]
+			if !ok {
+				t = &TeamTotal{Team: team}
+				teams[team] = t
+			}
+			t.Total += c.total
+			t.Players += c.players
+			if t.Players <= 0 {
+				delete(teams, team)
+			}
+		}
+	}
+	return improved, nil
+}
+
+func (s *memoryStore) Top(_ context.Context, b Board, team string, limit int) ([]ScoreData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var scores []ScoreData
+	for _, d := range s.best[b.ID()] {
+		if team == "" || d.Team == team {
+			scores = append(scores, d)
+		}
+	}
+	sortScores(b.Metric, scores)
+	if len(scores) > limit {
+		scores = scores[:limit]
+	}
+	return scores, nil
+}
+
+func (s *memoryStore) TeamTotals(_ context.Context, b Board, limit int) ([]TeamTotal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var totals []TeamTotal
+	for _, t := range s.teams[b.ID()] {
+		totals = append(totals, *t)
+	}
+	sortTotals(totals)
+	if len(totals) > limit {
+		totals = totals[:limit]
+	}
+	return totals, nil
+}
+
+// Backfill imports the scores in the "leaderboard" collection, written by
+// earlier versions, to the all-time boards, using the time each score was
+// saved. It can run more than once, and alongside new scores, and returns
+// the number of scores it recorded.
+func Backfill(ctx context.Context, client *firestore.Client) (int, error) {
+	l := New(NewFirestoreStore(client))
+	iter := client.Collection("leaderboard").Documents(ctx)
+	defer iter.Stop()
+	n := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("iter.Next: %w", err)
+		}
+		var d ScoreData
+		if err := doc.DataTo(&d); err != nil {
+			return n, fmt.Errorf("doc.DataTo: %w", err)
+		}
+		if d.Name == "" {
+			continue
+		}
+		d.Time = doc.UpdateTime
+		ok, err := l.Import(ctx, d)
+		if err != nil {
+			return n, fmt.Errorf("Import(%q): %w", d.Name, err)
+		}
+		if ok {
+			n++
+		}
+	}
+}
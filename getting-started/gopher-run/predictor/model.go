@@ -0,0 +1,211 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package predictor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Model is a multinomial logistic regression over the Actions. Features
+// are standardized with the mean and scale of the training data.
+type Model struct {
+	// Weights has one row per class, with one weight per feature followed
+	// by the bias.
+	Weights [][]float64 `json:"weights"`
+	Mean    []float64   `json:"mean"`
+	Scale   []float64   `json:"scale"`
+}
+
+// TrainOptions configures Train.
+type TrainOptions struct {
+	// Epochs is the number of passes of gradient descent. Defaults to 200.
+	Epochs int
+	// LearningRate defaults to 0.5.
+	LearningRate float64
+	// L2 is the regularization strength. Defaults to 0.001.
+	L2 float64
+}
+
+// Train fits a model to the examples with full-batch gradient descent.
+// Training is deterministic: the same examples always give the same model.
+func Train(examples []Example, opts TrainOptions) (*Model, error) {
+	if len(examples) == 0 {
+		return nil, errors.New("no examples")
+	}
+	if opts.Epochs <= 0 {
+		opts.Epochs = 200
+	}
+	if opts.LearningRate <= 0 {
+		opts.LearningRate = 0.5
+	}
+	if opts.L2 <= 0 {
+		opts.L2 = 0.001
+	}
+
+	nf := len(examples[0].Features)
+	m := &Model{Mean: make([]float64, nf), Scale: make([]float64, nf)}
+	for _, ex := range examples {
+		if len(ex.Features) != nf {
+			return nil, fmt.Errorf("got %d features, want %d", len(ex.Features), nf)
+		}
+		for j, f := range ex.Features {
+			m.Mean[j] += f
+		}
+	}
+	n := float64(len(examples))
+	for j := range m.Mean {
+		m.Mean[j] /= n
+	}
+	for _, ex := range examples {
+		for j, f := range ex.Features {
+			m.Scale[j] += (f - m.Mean[j]) * (f - m.Mean[j])
+		}
+	}
+	for j := range m.Scale {
+		m.Scale[j] = math.Sqrt(m.Scale[j] / n)
+		if m.Scale[j] == 0 {
+			m.Scale[j] = 1
+		}
+	}
+
+	xs := make([][]float64, len(examples))
+	for i, ex := range examples {
+		xs[i] = m.standardize(ex.Features)
+	}
+	m.Weights = make([][]float64, len(Actions))
+	for c := range m.Weights {
+		m.Weights[c] = make([]float64, nf+1)
+	}
+	grad := make([][]float64, len(Actions))
+	for c := range grad {
+		grad[c] = make([]float64, nf+1)
+	}
+	for epoch := 0; epoch < opts.Epochs; epoch++ {
+		for c := range grad {
+			for j := range grad[c] {
+				grad[c][j] = 0
+			}
+		}
+		for i, x := range xs {
+			p := m.probabilities(x)
+			for c := range p {
+				d := p[c]
+				if c == examples[i].Class {
+					d--
+				}
+				for j, f := range x {
+					grad[c][j] += d * f
+				}
+				grad[c][nf] += d
+			}
+		}
+		for c := range m.Weights {
+			for j := range m.Weights[c] {
+				g := grad[c][j] / n
+				if j < nf {
+					g += opts.L2 * m.Weights[c][j]
+				}
+				m.Weights[c][j] -= opts.LearningRate * g
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *Model) standardize(features []float64) []float64 {
+	x := make([]float64, len(features))
+	for j, f := range features {
+		x[j] = (f - m.Mean[j]) / m.Scale[j]
+	}
+	return x
+}
+
+// probabilities returns the softmax of each class's score for standardized x.
+func (m *Model) probabilities(x []float64) []float64 {
+	scores := make([]float64, len(m.Weights))
+	max := math.Inf(-1)
+	for c, w := range m.Weights {
+		s := w[len(x)]
+		for j, f := range x {
+			s += w[j] * f
+		}
+		scores[c] = s
+		max = math.Max(max, s)
+	}
+	var sum float64
+	for c := range scores {
+		scores[c] = math.Exp(scores[c] - max)
+		sum += scores[c]
+	}
+	for c := range scores {
+		scores[c] /= sum
+	}
+	return scores
+}
+
+// Predict returns the most probable action for the features.
+func (m *Model) Predict(features []float64) (Prediction, error) {
+	if len(features) != len(m.Mean) {
+		return Prediction{}, fmt.Errorf("got %d features, want %d", len(features), len(m.Mean))
+	}
+	p := m.probabilities(m.standardize(features))
+	best := 0
+	for c := range p {
+		if p[c] > p[best] {
+			best = c
+		}
+	}
+	return Prediction{Class: best, Action: Actions[best], Probabilities: p}, nil
+}
+
+// Accuracy returns the fraction of examples the model predicts correctly.
+func (m *Model) Accuracy(examples []Example) float64 {
+	if len(examples) == 0 {
+		return 0
+	}
+	correct := 0
+	for _, ex := range examples {
+		if p, err := m.Predict(ex.Features); err == nil && p.Class == ex.Class {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(examples))
+}
+
+// Save writes the model as JSON.
+func (m *Model) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(m)
+}
+
+// Load reads a model written by Save.
+func Load(r io.Reader) (*Model, error) {
+	m := &Model{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("json.Decode: %w", err)
+	}
+	if len(m.Weights) != len(Actions) || len(m.Mean) != len(m.Scale) {
+		return nil, errors.New("malformed model")
+	}
+	for _, w := range m.Weights {
+		if len(w) != len(m.Mean)+1 {
+			return nil, errors.New("malformed model")
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package predictor predicts a Gopher Run player's next action from recorded play data.
+package predictor
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Actions are the actions a player can take, in class order.
+var Actions = []string{"idle", "roll", "jump", "unroll"}
+
+// actionClass returns the class of the named action.
+func actionClass(action string) (int, bool) {
+	for i, a := range Actions {
+		if a == action {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Example is a recorded action and the game state it was taken in.
+type Example struct {
+	Class    int
+	Features []float64
+}
+
+// ParseCSV reads play data, one example per line in the form
+// "action,feature1,feature2,...". Every line must have the same number of
+// features.
+func ParseCSV(r io.Reader) ([]Example, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var examples []Example
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return examples, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv.Read: %w", err)
+		}
+		class, ok := actionClass(rec[0])
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown action %q", line, rec[0])
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: no features", line)
+		}
+		if len(examples) > 0 && len(rec)-1 != len(examples[0].Features) {
+			return nil, fmt.Errorf("line %d: got %d features, want %d", line, len(rec)-1, len(examples[0].Features))
+		}
+		ex := Example{Class: class, Features: make([]float64, len(rec)-1)}
+		for i, f := range rec[1:] {
+			if ex.Features[i], err = strconv.ParseFloat(f, 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		examples = append(examples, ex)
+	}
+}
+
+// Prediction is the predicted action for one instance.
+type Prediction struct {
+	Class  int    `json:"class"`
+	Action string `json:"action"`
+	// Probabilities of each class, if the backend provides them.
+	Probabilities []float64 `json:"probabilities,omitempty"`
+}
+
+// Predictor predicts actions for instances of game state features.
+type Predictor interface {
+	Predict(ctx context.Context, instances [][]float64) ([]Prediction, error)
+}
+
+// ErrNoModel is returned by a Local predictor that has not been trained.
+var ErrNoModel = errors.New("no model trained yet")
+
+// Local predicts with an in-process Model, which can be replaced while
+// serving.
+type Local struct {
+	mu    sync.RWMutex
+	model *Model
+}
+
+// Ensure Local conforms to the Predictor interface.
+var _ Predictor = &Local{}
+
+// SetModel replaces the model used for predictions.
+func (l *Local) SetModel(m *Model) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.model = m
+}
+
+// Model returns the model used for predictions, or nil.
+func (l *Local) Model() *Model {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.model
+}
+
+// Predict implements Predictor.
+func (l *Local) Predict(_ context.Context, instances [][]float64) ([]Prediction, error) {
+	m := l.Model()
+	if m == nil {
+		return nil, ErrNoModel
+	}
+	preds := make([]Prediction, len(instances))
+	for i, x := range instances {
+		p, err := m.Predict(x)
+		if err != nil {
+			return nil, fmt.Errorf("instance %d: %w", i, err)
+		}
+		preds[i] = p
+	}
+	return preds, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package predictor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// playData returns CSV where the action is determined by which of the
+// first two features is large.
+func playData() string {
+	var sb strings.Builder
+	for i := 0; i < 20; i++ {
+		v := float64(i%5) / 10
+		fmt.Fprintf(&sb, "idle,%v,%v,0\n", v, v)
+		fmt.Fprintf(&sb, "roll,%v,%v,0\n", 5+v, v)
+		fmt.Fprintf(&sb, "jump,%v,%v,0\n", v, 5+v)
+		fmt.Fprintf(&sb, "unroll,%v,%v,0\n", 5+v, 5+v)
+	}
+	return sb.String()
+}
+
+func TestParseCSV(t *testing.T) {
+	examples, err := ParseCSV(strings.NewReader("jump,1,2\n\nunroll,3,4\n"))
+	if err != nil {
+		t.Fatalf("ParseCSV: %v", err)
+	}
+	if len(examples) != 2 || examples[0].Class != 2 || examples[1].Features[1] != 4 {
+		t.Errorf("ParseCSV: got %+v", examples)
+	}
+
+	for _, bad := range []string{"fly,1,2\n", "jump,1,x\n", "jump,1,2\nroll,1\n", "jump\n"} {
+		if _, err := ParseCSV(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseCSV(%q): want error", bad)
+		}
+	}
+}
+
+func TestTrainPredict(t *testing.T) {
+	examples, err := ParseCSV(strings.NewReader(playData()))
+	if err != nil {
+		t.Fatalf("ParseCSV: %v", err)
+	}
+	m, err := Train(examples, TrainOptions{})
+	if err != nil {
+		t.Fatalf("Train: %v", err)
+	}
+	if acc := m.Accuracy(examples); acc < 0.95 {
+		t.Errorf("Accuracy: got %v, want >= 0.95", acc)
+	}
+
+	p, err := m.Predict([]float64{6, 0, 0})
+	if err != nil {
+		t.Fatalf("Predict: %v", err)
+	}
+	if p.Action != "roll" {
+		t.Errorf("Predict: got %q, want roll", p.Action)
+	}
+	var sum float64
+	for _, pr := range p.Probabilities {
+		sum += pr
+	}
+	if sum < 0.999 || sum > 1.001 {
+		t.Errorf("Predict: probabilities sum to %v, want 1", sum)
+	}
+	if _, err := m.Predict([]float64{1}); err == nil {
+		t.Errorf("Predict with wrong feature count: want error")
+	}
+
+	again, _ := Train(examples, TrainOptions{})
+	if fmt.Sprint(again.Weights) != fmt.Sprint(m.Weights) {
+		t.Errorf("Train: not deterministic")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	examples, _ := ParseCSV(strings.NewReader(playData()))
+	m, err := Train(examples, TrainOptions{Epochs: 10})
+	if err != nil {
+		t.Fatalf("Train: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := m.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if fmt.Sprint(loaded) != fmt.Sprint(m) {
+		t.Errorf("Load: got %v, want %v", loaded, m)
+	}
+	if _, err := Load(strings.NewReader(`{"weights":[[1]]}`)); err == nil {
+		t.Errorf("Load malformed model: want error")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	l := &Local{}
+	if _, err := l.Predict(context.Background(), [][]float64{{0, 0, 0}}); !errors.Is(err, ErrNoModel) {
+		t.Errorf("Predict before training: got %v, want ErrNoModel", err)
+	}
+	examples, _ := ParseCSV(strings.NewReader(playData()))
+	m, _ := Train(examples, TrainOptions{})
+	l.SetModel(m)
+	preds, err := l.Predict(context.Background(), [][]float64{{0, 6, 0}, {6, 6, 0}})
+	if err != nil {
+		t.Fatalf("Predict: %v", err)
+	}
+	if preds[0].Action != "jump" || preds[1].Action != "unroll" {
+		t.Errorf("Predict: got %+v, want jump and unroll", preds)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"predictions": [2, 0]}`)
+	}))
+	defer srv.Close()
+
+	r := &Remote{URL: srv.URL, Client: srv.Client()}
+	preds, err := r.Predict(context.Background(), [][]float64{{1}, {2}})
+	if err != nil {
+		t.Fatalf("Predict: %v", err)
+	}
+	if preds[0].Action != "jump" || preds[1].Action != "idle" {
+		t.Errorf("Predict: got %+v, want jump and idle", preds)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package predictor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Remote predicts with a model deployed to AI Platform Prediction, such as
+// one trained by cmd/training.sh.
+type Remote struct {
+	// URL is the model's predict endpoint.
+	URL string
+	// Client defaults to a client with Application Default Credentials.
+	Client *http.Client
+}
+
+// Ensure Remote conforms to the Predictor interface.
+var _ Predictor = &Remote{}
+
+// NewRemote creates a Remote predictor for the named AI Platform model.
+func NewRemote(projectID, model string) *Remote {
+	return &Remote{URL: "https://ml.googleapis.com/v1/projects/" + projectID + "/models/" + model + ":predict"}
+}
+
+// Predict implements Predictor. The model returns the class of each instance.
+func (r *Remote) Predict(ctx context.Context, instances [][]float64) ([]Prediction, error) {
+	client := r.Client
+	if client == nil {
+		var err error
+		if client, err = google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform"); err != nil {
+			return nil, fmt.Errorf("google.DefaultClient: %w", err)
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"instances": instances})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("prediction server: %s", resp.Status)
+	}
+	var out struct {
+		Predictions []float64 `json:"predictions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("json.Decode: %w", err)
+	}
+	preds := make([]Prediction, len(out.Predictions))
+	for i, c := range out.Predictions {
+		class := int(c)
+		if class < 0 || class >= len(Actions) {
+			return nil, fmt.Errorf("prediction %d: unknown class %v", i, c)
+		}
+		preds[i] = Prediction{Class: class, Action: Actions[class]}
+	}
+	return preds, nil
+}
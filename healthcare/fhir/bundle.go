@@ -0,0 +1,195 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Bundle is a FHIR Bundle resource: the result of a search or of a history
+// request, or a transaction or batch to execute.
+type Bundle struct {
+	Base
+	Type  string        `json:"type"`
+	Total *int          `json:"total,omitempty"`
+	Link  []BundleLink  `json:"link,omitempty"`
+	Entry []BundleEntry `json:"entry,omitempty"`
+}
+
+// ResourceType returns "Bundle".
+func (*Bundle) ResourceType() string { return "Bundle" }
+
+// MarshalJSON adds the resourceType to the JSON representation.
+func (b Bundle) MarshalJSON() ([]byte, error) {
+	type plain Bundle
+	return json.Marshal(struct {
+		ResourceType string `json:"resourceType"`
+		plain
+	}{"Bundle", plain(b)})
+}
+
+// BundleLink is a link from a Bundle, such as to the next page of results.
+type BundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+// BundleEntry is a resource in a Bundle, or a request for a resource.
+type BundleEntry struct {
+	FullURL  string          `json:"fullUrl,omitempty"`
+	Resource json.RawMessage `json:"resource,omitempty"`
+	Request  *BundleRequest  `json:"request,omitempty"`
+	Response *BundleResponse `json:"response,omitempty"`
+}
+
+// BundleRequest is the request of an entry of a transaction or batch.
+type BundleRequest struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	IfMatch     string `json:"ifMatch,omitempty"`
+	IfNoneExist string `json:"ifNoneExist,omitempty"`
+}
+
+// BundleResponse is the result of an entry of a transaction or batch.
+type BundleResponse struct {
+	Status   string `json:"status"`
+	Location string `json:"location,omitempty"`
+	ETag     string `json:"etag,omitempty"`
+	// Outcome is an OperationOutcome resource for failed batch entries.
+	Outcome json.RawMessage `json:"outcome,omitempty"`
+}
+
+// ResourceType returns the type of the resource of the entry, or "" if it
+// has none.
+func (e BundleEntry) ResourceType() string {
+	var r struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if len(e.Resource) == 0 || json.Unmarshal(e.Resource, &r) != nil {
+		return ""
+	}
+	return r.ResourceType
+}
+
+// Decode decodes the resource of the entry into r, which must be of the
+// same type.
+func (e BundleEntry) Decode(r Resource) error {
+	if got := e.ResourceType(); got != r.ResourceType() {
+		return fmt.Errorf("fhir: entry has a %q resource, not %q", got, r.ResourceType())
+	}
+	return decode(e.Resource, r)
+}
+
+// Next returns the URL of the next page of results, or "" if this is the
+// last page.
+func (b *Bundle) Next() string {
+	for _, l := range b.Link {
+		if l.Relation == "next" {
+			return l.URL
+		}
+	}
+	return ""
+}
+
+// NewTransaction returns an empty transaction Bundle. The entries of a
+// transaction are applied together, or not at all.
+func NewTransaction() *Bundle {
+	return &Bundle{Type: "transaction"}
+}
+
+// NewBatch returns an empty batch Bundle. The entries of a batch are applied
+// independently.
+func NewBatch() *Bundle {
+	return &Bundle{Type: "batch"}
+}
+
+// AddCreate adds an entry creating r. If ifNoneExist is not empty, r is only
+// created if no resource matches it. Other resources of a transaction can
+// refer to r with the returned Reference before it has an ID.
+func (b *Bundle) AddCreate(r Resource, ifNoneExist url.Values) (Reference, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return Reference{}, fmt.Errorf("json.Marshal: %w", err)
+	}
+	id, err := newUUID()
+	if err != nil {
+		return Reference{}, err
+	}
+	fullURL := "urn:uuid:" + id
+	b.Entry = append(b.Entry, BundleEntry{
+		FullURL:  fullURL,
+		Resource: raw,
+		Request: &BundleRequest{
+			Method:      http.MethodPost,
+			URL:         r.ResourceType(),
+			IfNoneExist: ifNoneExist.Encode(),
+		},
+	})
+	return Reference{Reference: fullURL}, nil
+}
+
+// AddUpdate adds an entry replacing the resource with the ID of r. If
+// ifMatch is true, the resource is only replaced if its version is still the
+// version of r.
+func (b *Bundle) AddUpdate(r Resource, ifMatch bool) error {
+	if r.ResourceID() == "" {
+		return fmt.Errorf("fhir: cannot update %s without an ID", r.ResourceType())
+	}
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	req := &BundleRequest{Method: http.MethodPut, URL: r.ResourceType() + "/" + r.ResourceID()}
+	if ifMatch {
+		if req.IfMatch = ETag(r); req.IfMatch == "" {
+			return fmt.Errorf("fhir: %s has no version to match", r.ResourceType())
+		}
+	}
+	b.Entry = append(b.Entry, BundleEntry{Resource: raw, Request: req})
+	return nil
+}
+
+// AddDelete adds an entry deleting the resource with the given type and ID.
+func (b *Bundle) AddDelete(resourceType, id string) {
+	b.Entry = append(b.Entry, BundleEntry{
+		Request: &BundleRequest{Method: http.MethodDelete, URL: resourceType + "/" + id},
+	})
+}
+
+// ExecuteBundle executes a transaction or batch Bundle, and returns the
+// response Bundle, which has an entry with the result of each entry of b.
+func (c *Client) ExecuteBundle(ctx context.Context, b *Bundle) (*Bundle, error) {
+	resp := &Bundle{}
+	if _, err := c.do(ctx, http.MethodPost, "", nil, nil, b, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
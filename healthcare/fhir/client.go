@@ -0,0 +1,244 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+const contentType = "application/fhir+json;charset=utf-8"
+
+var (
+	// ErrNotFound is matched by errors for resources that do not exist.
+	ErrNotFound = errors.New("fhir: not found")
+	// ErrPreconditionFailed is matched by errors for requests whose If-Match
+	// or conditional search did not hold.
+	ErrPreconditionFailed = errors.New("fhir: precondition failed")
+)
+
+// Error is returned for responses with an error status. Its Body is usually
+// an OperationOutcome resource.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fhir: %s %s: status %d %s: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), bytes.TrimSpace(e.Body))
+}
+
+// Is reports whether the status code of e matches ErrNotFound or
+// ErrPreconditionFailed.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// Client calls the FHIR REST API of a single FHIR store.
+type Client struct {
+	base string
+	hc   *http.Client
+}
+
+// StoreURL returns the base URL of the FHIR REST API of a FHIR store.
+func StoreURL(projectID, location, datasetID, fhirStoreID string) string {
+	return fmt.Sprintf("https://healthcare.googleapis.com/v1/projects/%s/locations/%s/datasets/%s/fhirStores/%s/fhir", projectID, location, datasetID, fhirStoreID)
+}
+
+// NewClient returns a Client for the store at storeURL, authenticated with
+// Application Default Credentials.
+func NewClient(ctx context.Context, storeURL string) (*Client, error) {
+	hc, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("google.DefaultClient: %w", err)
+	}
+	return NewClientWithHTTPClient(storeURL, hc), nil
+}
+
+// NewClientWithHTTPClient returns a Client for the store at storeURL that
+// sends requests with hc.
+func NewClientWithHTTPClient(storeURL string, hc *http.Client) *Client {
+	return &Client{base: strings.TrimSuffix(storeURL, "/"), hc: hc}
+}
+
+// do sends a request to path, which is relative to the store URL unless it
+// is absolute, and decodes the response into out if it is not nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out interface{}) (*http.Response, error) {
+	u := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		u = c.base
+		if path != "" {
+			u += "/" + path
+		}
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("json.Marshal: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, fmt.Errorf("NewRequest: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/fhir+json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	if resp.StatusCode > 299 {
+		return nil, &Error{Method: method, URL: u, StatusCode: resp.StatusCode, Body: respBytes}
+	}
+	if out != nil && len(respBytes) > 0 {
+		if err := decode(respBytes, out); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// decode replaces the value out points to with the JSON data, rather than
+// merging into it, so that copies of the old value do not share pointers
+// with the new one.
+func decode(data []byte, out interface{}) error {
+	v := reflect.ValueOf(out).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return nil
+}
+
+// ETag returns the entity tag of the stored version of r, for use in an
+// If-Match header.
+func ETag(r Resource) string {
+	if r.VersionID() == "" {
+		return ""
+	}
+	return `W/"` + r.VersionID() + `"`
+}
+
+// Create stores r as a new resource. r is updated with the stored resource,
+// including its ID and version.
+func (c *Client) Create(ctx context.Context, r Resource) error {
+	_, err := c.do(ctx, http.MethodPost, r.ResourceType(), nil, nil, r, r)
+	return err
+}
+
+// CreateIfNoneExist stores r as a new resource unless a resource of the same
+// type matches query. If one does, r is updated with it and created is
+// false. If several do, the error matches ErrPreconditionFailed.
+func (c *Client) CreateIfNoneExist(ctx context.Context, r Resource, query url.Values) (created bool, err error) {
+	header := http.Header{"If-None-Exist": {query.Encode()}}
+	resp, err := c.do(ctx, http.MethodPost, r.ResourceType(), nil, header, r, r)
+	if err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+// Read reads the resource with the given ID into r. The resource type is
+// given by r.
+func (c *Client) Read(ctx context.Context, id string, r Resource) error {
+	_, err := c.do(ctx, http.MethodGet, r.ResourceType()+"/"+id, nil, nil, nil, r)
+	return err
+}
+
+// Update stores r, replacing the resource with the same ID, or creating it
+// if there is none. r is updated with the stored resource.
+func (c *Client) Update(ctx context.Context, r Resource) error {
+	return c.update(ctx, r, nil)
+}
+
+// UpdateIfMatch is like Update, but only replaces the resource if its
+// version is still the version of r. Otherwise, the error matches
+// ErrPreconditionFailed.
+func (c *Client) UpdateIfMatch(ctx context.Context, r Resource) error {
+	etag := ETag(r)
+	if etag == "" {
+		return fmt.Errorf("fhir: %s has no version to match", r.ResourceType())
+	}
+	return c.update(ctx, r, http.Header{"If-Match": {etag}})
+}
+
+func (c *Client) update(ctx context.Context, r Resource, header http.Header) error {
+	if r.ResourceID() == "" {
+		return fmt.Errorf("fhir: cannot update %s without an ID", r.ResourceType())
+	}
+	_, err := c.do(ctx, http.MethodPut, r.ResourceType()+"/"+r.ResourceID(), nil, header, r, r)
+	return err
+}
+
+// ConditionalUpdate stores r in place of the resource of the same type that
+// matches query, or as a new resource if none does. r is updated with the
+// stored resource. If several resources match, the error matches
+// ErrPreconditionFailed.
+func (c *Client) ConditionalUpdate(ctx context.Context, r Resource, query url.Values) (created bool, err error) {
+	resp, err := c.do(ctx, http.MethodPut, r.ResourceType(), query, nil, r, r)
+	if err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+// Delete deletes the resource with the given type and ID. Deleting a
+// resource that does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, resourceType, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, resourceType+"/"+id, nil, nil, nil, nil)
+	return err
+}
+
+// ConditionalDelete deletes the resources of the given type that match
+// query.
+func (c *Client) ConditionalDelete(ctx context.Context, resourceType string, query url.Values) error {
+	_, err := c.do(ctx, http.MethodDelete, resourceType, query, nil, nil, nil)
+	return err
+}
@@ -0,0 +1,463 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newPatient(family string, given ...string) *Patient {
+	active := true
+	return &Patient{
+		Active: &active,
+		Name:   []HumanName{{Family: family, Given: given}},
+		Gender: "unknown",
+	}
+}
+
+func TestMarshalResourceType(t *testing.T) {
+	for _, r := range []Resource{newPatient("Smith"), &Encounter{Status: "finished"}, &Observation{Status: "final"}, NewBatch()} {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("json.Marshal(%T): %v", r, err)
+		}
+		want := fmt.Sprintf(`{"resourceType":%q,`, r.ResourceType())
+		if !strings.HasPrefix(string(b), want) {
+			t.Errorf("json.Marshal(%T) = %s, want prefix %s", r, b, want)
+		}
+	}
+}
+
+func TestCreateReadUpdate(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeStore(t)
+
+	p := newPatient("Smith", "Darcy")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if p.ID == "" || p.VersionID() != "1" {
+		t.Fatalf("Create set ID %q and version %q, want an ID and version 1", p.ID, p.VersionID())
+	}
+
+	got := &Patient{}
+	if err := c.Read(ctx, p.ID, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got.Name[0].Family != "Smith" || got.Active == nil || !*got.Active {
+		t.Errorf("Read got %+v, want an active patient named Smith", got)
+	}
+
+	stale := *got
+	got.Gender = "female"
+	if err := c.UpdateIfMatch(ctx, got); err != nil {
+		t.Fatalf("UpdateIfMatch: %v", err)
+	}
+	if got.VersionID() != "2" {
+		t.Errorf("UpdateIfMatch set version %q, want 2", got.VersionID())
+	}
+
+	// The stale copy still has version 1.
+	stale.Gender = "male"
+	if err := c.UpdateIfMatch(ctx, &stale); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("UpdateIfMatch with a stale version got %v, want ErrPreconditionFailed", err)
+	}
+	if err := c.Update(ctx, &stale); err != nil {
+		t.Errorf("Update: %v", err)
+	}
+	if stale.VersionID() != "3" {
+		t.Errorf("Update set version %q, want 3", stale.VersionID())
+	}
+
+	if err := c.UpdateIfMatch(ctx, newPatient("Jones")); err == nil {
+		t.Errorf("UpdateIfMatch of an unstored patient succeeded, want an error")
+	}
+	if err := c.Read(ctx, "missing", &Patient{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of a missing patient got %v, want ErrNotFound", err)
+	}
+}
+
+func TestUnknownElementsKept(t *testing.T) {
+	ctx := context.Background()
+	s, c := newFakeStore(t)
+
+	p := newPatient("Smith")
+	p.Meta = &Meta{Extra: map[string]json.RawMessage{
+		"tag": json.RawMessage(`[{"system":"urn:tags","code":"vip"}]`),
+	}}
+	p.Extra = map[string]json.RawMessage{
+		"telecom":   json.RawMessage(`[{"system":"phone","value":"555-0100"}]`),
+		"address":   json.RawMessage(`[{"city":"Springfield"}]`),
+		"extension": json.RawMessage(`[{"url":"urn:ext","valueString":"x"}]`),
+	}
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Read, modify and update the patient with the typed client.
+	got := &Patient{}
+	if err := c.Read(ctx, p.ID, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	got.Gender = "female"
+	if err := c.UpdateIfMatch(ctx, got); err != nil {
+		t.Fatalf("UpdateIfMatch: %v", err)
+	}
+
+	stored, err := json.Marshal(s.current("Patient/" + p.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res struct {
+		Gender    string            `json:"gender"`
+		Telecom   []json.RawMessage `json:"telecom"`
+		Address   []json.RawMessage `json:"address"`
+		Extension []json.RawMessage `json:"extension"`
+		Meta      struct {
+			VersionID string            `json:"versionId"`
+			Tag       []json.RawMessage `json:"tag"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(stored, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Gender != "female" || res.Meta.VersionID != "2" {
+		t.Errorf("stored patient %s, want gender female and version 2", stored)
+	}
+	if len(res.Telecom) != 1 || len(res.Address) != 1 || len(res.Extension) != 1 || len(res.Meta.Tag) != 1 {
+		t.Errorf("stored patient %s, want the telecom, address, extension and meta.tag kept", stored)
+	}
+	if got.Meta.VersionID != "2" || string(got.Meta.Extra["tag"]) != string(p.Meta.Extra["tag"]) {
+		t.Errorf("UpdateIfMatch set meta %+v, want version 2 and the tag", got.Meta)
+	}
+	if _, ok := got.Extra["resourceType"]; ok || len(got.Extra) != 3 {
+		t.Errorf("UpdateIfMatch set Extra %v, want telecom, address and extension", got.Extra)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeStore(t)
+
+	mrn := url.Values{"identifier": {"urn:mrn|1234"}}
+	p := newPatient("Smith")
+	p.Identifier = []Identifier{{System: "urn:mrn", Value: "1234"}}
+	created, err := c.CreateIfNoneExist(ctx, p, mrn)
+	if err != nil || !created {
+		t.Fatalf("CreateIfNoneExist = %v, %v, want true, nil", created, err)
+	}
+	again := newPatient("Smith")
+	again.Identifier = p.Identifier
+	created, err = c.CreateIfNoneExist(ctx, again, mrn)
+	if err != nil || created {
+		t.Fatalf("second CreateIfNoneExist = %v, %v, want false, nil", created, err)
+	}
+	if again.ID != p.ID {
+		t.Errorf("second CreateIfNoneExist got ID %q, want the existing %q", again.ID, p.ID)
+	}
+
+	update := newPatient("Smith-Jones")
+	update.Identifier = p.Identifier
+	created, err = c.ConditionalUpdate(ctx, update, mrn)
+	if err != nil || created {
+		t.Fatalf("ConditionalUpdate = %v, %v, want false, nil", created, err)
+	}
+	if update.ID != p.ID || update.VersionID() != "2" {
+		t.Errorf("ConditionalUpdate updated %s version %s, want %s version 2", update.ID, update.VersionID(), p.ID)
+	}
+	created, err = c.ConditionalUpdate(ctx, newPatient("Brown"), url.Values{"identifier": {"urn:mrn|5678"}})
+	if err != nil || !created {
+		t.Fatalf("ConditionalUpdate without a match = %v, %v, want true, nil", created, err)
+	}
+
+	// Both patients are active, so a conditional write on active=true
+	// matches too many.
+	active := url.Values{"active": {"true"}}
+	if _, err := c.CreateIfNoneExist(ctx, newPatient("Green"), active); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("CreateIfNoneExist matching two patients got %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := c.ConditionalUpdate(ctx, newPatient("Green"), active); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("ConditionalUpdate matching two patients got %v, want ErrPreconditionFailed", err)
+	}
+
+	if err := c.ConditionalDelete(ctx, "Patient", mrn); err != nil {
+		t.Fatalf("ConditionalDelete: %v", err)
+	}
+	if err := c.Read(ctx, p.ID, &Patient{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read after ConditionalDelete got %v, want ErrNotFound", err)
+	}
+	if err := c.Delete(ctx, "Patient", p.ID); err != nil {
+		t.Errorf("Delete of a deleted patient: %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeStore(t)
+
+	want := make(map[string]bool)
+	for i := 0; i < 25; i++ {
+		p := newPatient("Smith", fmt.Sprint("Given", i))
+		if err := c.Create(ctx, p); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		want[p.ID] = true
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Create(ctx, newPatient("Smithers")); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	first, err := c.Search(ctx, NewSearch("Patient").Where("family:exact", "Smith").Count(10))
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if first.Total == nil || *first.Total != 25 {
+		t.Errorf("Search got total %v, want 25", first.Total)
+	}
+	pages := 0
+	err = c.EachPage(ctx, first, func(b *Bundle) error {
+		pages++
+		for _, e := range b.Entry {
+			p := &Patient{}
+			if err := e.Decode(p); err != nil {
+				return err
+			}
+			if !want[p.ID] {
+				t.Errorf("Search returned unexpected or repeated patient %s", p.ID)
+			}
+			delete(want, p.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachPage: %v", err)
+	}
+	if pages != 3 || len(want) != 0 {
+		t.Errorf("EachPage got %d pages and missed %d patients, want 3 pages and none missed", pages, len(want))
+	}
+
+	// Without :exact, family matches prefixes.
+	all, err := c.Search(ctx, NewSearch("Patient").Where("family", "smith"))
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(all.Entry) != 28 || all.Next() != "" {
+		t.Errorf("Search got %d entries and next %q, want 28 entries on one page", len(all.Entry), all.Next())
+	}
+	if err := all.Entry[0].Decode(&Observation{}); err == nil {
+		t.Errorf("Decode of a Patient entry into an Observation succeeded, want an error")
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	s := NewSearch("Observation").
+		Where("code", "http://loinc.org|8867-4").
+		Where("date", "ge2023-01-01").
+		Where("status", "final", "amended").
+		Include("Observation:subject").
+		Sort("-date").
+		Count(20)
+	want := "_count=20&_include=Observation%3Asubject&_sort=-date&code=http%3A%2F%2Floinc.org%7C8867-4&date=ge2023-01-01&status=final%2Camended"
+	if got := s.Query().Encode(); got != want {
+		t.Errorf("Query() = %s, want %s", got, want)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeStore(t)
+
+	p := newPatient("Smith")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, g := range []string{"female", "male", "other", "unknown"} {
+		p.Gender = g
+		if err := c.UpdateIfMatch(ctx, p); err != nil {
+			t.Fatalf("UpdateIfMatch: %v", err)
+		}
+	}
+
+	first, err := c.History(ctx, "Patient", p.ID, 2)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	var versions []string
+	err = c.EachPage(ctx, first, func(b *Bundle) error {
+		for _, e := range b.Entry {
+			v := &Patient{}
+			if err := e.Decode(v); err != nil {
+				return err
+			}
+			versions = append(versions, v.VersionID()+":"+v.Gender)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachPage: %v", err)
+	}
+	want := "5:unknown 4:other 3:male 2:female 1:unknown"
+	if got := strings.Join(versions, " "); got != want {
+		t.Errorf("History got versions %q, want %q", got, want)
+	}
+
+	if _, err := c.History(ctx, "Patient", "missing", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("History of a missing patient got %v, want ErrNotFound", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeStore(t)
+
+	tx := NewTransaction()
+	patient, err := tx.AddCreate(newPatient("Smith"), nil)
+	if err != nil {
+		t.Fatalf("AddCreate: %v", err)
+	}
+	encounter, err := tx.AddCreate(&Encounter{
+		Status:  "finished",
+		Class:   Coding{System: "http://terminology.hl7.org/CodeSystem/v3-ActCode", Code: "AMB"},
+		Subject: &patient,
+	}, nil)
+	if err != nil {
+		t.Fatalf("AddCreate: %v", err)
+	}
+	if _, err := tx.AddCreate(&Observation{
+		Status:        "final",
+		Code:          CodeableConcept{Coding: []Coding{{System: "http://loinc.org", Code: "8867-4", Display: "Heart rate"}}},
+		Subject:       &patient,
+		Encounter:     &encounter,
+		ValueQuantity: &Quantity{Value: 80, Unit: "beats/minute"},
+	}, nil); err != nil {
+		t.Fatalf("AddCreate: %v", err)
+	}
+
+	resp, err := c.ExecuteBundle(ctx, tx)
+	if err != nil {
+		t.Fatalf("ExecuteBundle: %v", err)
+	}
+	if resp.Type != "transaction-response" || len(resp.Entry) != 3 {
+		t.Fatalf("ExecuteBundle got a %q bundle with %d entries, want a transaction-response with 3", resp.Type, len(resp.Entry))
+	}
+	for _, e := range resp.Entry {
+		if e.Response == nil || e.Response.Status != "201 Created" {
+			t.Errorf("ExecuteBundle got entry response %+v, want 201 Created", e.Response)
+		}
+	}
+	p, o := &Patient{}, &Observation{}
+	if err := resp.Entry[0].Decode(p); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if err := resp.Entry[2].Decode(o); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if want := ReferenceTo(p); o.Subject == nil || *o.Subject != want {
+		t.Errorf("Observation subject is %+v, want %+v", o.Subject, want)
+	}
+	if o.Encounter == nil || !strings.HasPrefix(o.Encounter.Reference, "Encounter/") {
+		t.Errorf("Observation encounter is %+v, want a reference to the created Encounter", o.Encounter)
+	}
+
+	// A failed entry rolls back the whole transaction.
+	stale := *p
+	p.Gender = "female"
+	if err := c.UpdateIfMatch(ctx, p); err != nil {
+		t.Fatalf("UpdateIfMatch: %v", err)
+	}
+	tx = NewTransaction()
+	if _, err := tx.AddCreate(newPatient("Jones"), nil); err != nil {
+		t.Fatalf("AddCreate: %v", err)
+	}
+	if err := tx.AddUpdate(&stale, true); err != nil {
+		t.Fatalf("AddUpdate: %v", err)
+	}
+	if _, err := c.ExecuteBundle(ctx, tx); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("ExecuteBundle with a stale update got %v, want ErrPreconditionFailed", err)
+	}
+	b, err := c.Search(ctx, NewSearch("Patient").Where("family", "Jones"))
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(b.Entry) != 0 {
+		t.Errorf("Search found %d patients created by a failed transaction, want 0", len(b.Entry))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeStore(t)
+
+	p1, p2 := newPatient("Smith"), newPatient("Jones")
+	for _, p := range []*Patient{p1, p2} {
+		if err := c.Create(ctx, p); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	stale := *p2
+	if err := c.Update(ctx, p2); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	batch := NewBatch()
+	batch.AddDelete("Patient", p1.ID)
+	if err := batch.AddUpdate(&stale, true); err != nil {
+		t.Fatalf("AddUpdate: %v", err)
+	}
+	if _, err := batch.AddCreate(newPatient("Brown"), url.Values{"family": {"Brown"}}); err != nil {
+		t.Fatalf("AddCreate: %v", err)
+	}
+	if err := batch.AddUpdate(newPatient("Green"), false); err == nil {
+		t.Errorf("AddUpdate of a patient without an ID succeeded, want an error")
+	}
+
+	resp, err := c.ExecuteBundle(ctx, batch)
+	if err != nil {
+		t.Fatalf("ExecuteBundle: %v", err)
+	}
+	var got []string
+	for _, e := range resp.Entry {
+		got = append(got, e.Response.Status)
+	}
+	want := "200 OK, 412 Precondition Failed, 201 Created"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("ExecuteBundle got statuses %q, want %q", strings.Join(got, ", "), want)
+	}
+	if len(resp.Entry[1].Response.Outcome) == 0 {
+		t.Errorf("failed batch entry has no outcome")
+	}
+	if err := c.Read(ctx, p1.ID, &Patient{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of the deleted patient got %v, want ErrNotFound", err)
+	}
+}
+
+func TestError(t *testing.T) {
+	err := error(&Error{Method: "GET", URL: "https://example.com/fhir/Patient/1", StatusCode: 404, Body: []byte(`{"resourceType":"OperationOutcome"}` + "\n")})
+	want := `fhir: GET https://example.com/fhir/Patient/1: status 404 Not Found: {"resourceType":"OperationOutcome"}`
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("errors.Is(%v) matched the wrong sentinels", err)
+	}
+}
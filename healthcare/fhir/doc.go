@@ -0,0 +1,34 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fhir is a typed client for the FHIR REST API of a Cloud Healthcare
+// API FHIR store.
+//
+// Resources are stored with Create and Update, which set the ID and version
+// of the resource from the store's response. UpdateIfMatch only updates a
+// resource if it was not changed since it was read:
+//
+//	p := &fhir.Patient{}
+//	if err := client.Read(ctx, id, p); err != nil {
+//		return err
+//	}
+//	p.Gender = "female"
+//	err := client.UpdateIfMatch(ctx, p)
+//	if errors.Is(err, fhir.ErrPreconditionFailed) {
+//		// Someone else updated the patient; read it again and retry.
+//	}
+//
+// Elements that the resource types do not model, such as a Patient's
+// telecom, are kept in their Extra field, so they survive such an update.
+package fhir
@@ -0,0 +1,313 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Resource is a FHIR resource that can be stored with a Client. It is
+// implemented by pointers to Patient, Observation and Encounter, and can be
+// implemented by other types that embed Base.
+type Resource interface {
+	// ResourceType returns the FHIR resource type, such as "Patient".
+	ResourceType() string
+	// ResourceID returns the logical ID of the resource, or "" if it has not
+	// been stored.
+	ResourceID() string
+	// VersionID returns the version of the resource, or "" if it has not
+	// been stored.
+	VersionID() string
+}
+
+// Base holds the elements common to all resources.
+type Base struct {
+	ID   string `json:"id,omitempty"`
+	Meta *Meta  `json:"meta,omitempty"`
+	// Extra holds the elements that the resource's type does not model,
+	// such as a Patient's telecom and address, by name. They are written
+	// back as they were read, so updating a resource that was read keeps
+	// them.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ResourceID returns the logical ID of the resource.
+func (b *Base) ResourceID() string { return b.ID }
+
+// VersionID returns the version of the resource.
+func (b *Base) VersionID() string {
+	if b.Meta == nil {
+		return ""
+	}
+	return b.Meta.VersionID
+}
+
+// Meta is the metadata the store maintains about a resource.
+type Meta struct {
+	VersionID   string `json:"versionId,omitempty"`
+	LastUpdated string `json:"lastUpdated,omitempty"`
+	// Extra holds the metadata that Meta does not model, such as tags and
+	// security labels, by name.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// MarshalJSON adds the elements in Extra to the JSON representation.
+func (m Meta) MarshalJSON() ([]byte, error) {
+	type plain Meta
+	return marshalObject("", plain(m), m.Extra)
+}
+
+// UnmarshalJSON keeps the elements that Meta does not model in Extra.
+func (m *Meta) UnmarshalJSON(data []byte) error {
+	type plain Meta
+	extra, err := unmarshalObject(data, (*plain)(m))
+	m.Extra = extra
+	return err
+}
+
+// Identifier is an identifier for a resource, such as a medical record
+// number.
+type Identifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// HumanName is the name of a person.
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+// Coding is a code defined by a terminology system.
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// CodeableConcept is a concept given by codings and/or text.
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+// Reference refers to another resource, such as "Patient/123", or to an
+// entry of the same transaction Bundle, such as "urn:uuid:...".
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+// ReferenceTo returns a Reference to a stored resource.
+func ReferenceTo(r Resource) Reference {
+	return Reference{Reference: r.ResourceType() + "/" + r.ResourceID()}
+}
+
+// Quantity is a measured amount.
+type Quantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit,omitempty"`
+	System string  `json:"system,omitempty"`
+	Code   string  `json:"code,omitempty"`
+}
+
+// Period is a time range, given as FHIR dateTimes.
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// Patient is a FHIR Patient resource.
+type Patient struct {
+	Base
+	Identifier []Identifier `json:"identifier,omitempty"`
+	Active     *bool        `json:"active,omitempty"`
+	Name       []HumanName  `json:"name,omitempty"`
+	Gender     string       `json:"gender,omitempty"`
+	BirthDate  string       `json:"birthDate,omitempty"`
+}
+
+// ResourceType returns "Patient".
+func (*Patient) ResourceType() string { return "Patient" }
+
+// MarshalJSON adds the resourceType and the elements in Extra to the JSON
+// representation.
+func (p Patient) MarshalJSON() ([]byte, error) {
+	type plain Patient
+	return marshalObject("Patient", plain(p), p.Extra)
+}
+
+// UnmarshalJSON keeps the elements that Patient does not model in Extra.
+func (p *Patient) UnmarshalJSON(data []byte) error {
+	type plain Patient
+	extra, err := unmarshalObject(data, (*plain)(p))
+	p.Extra = extra
+	return err
+}
+
+// Encounter is a FHIR Encounter resource: an interaction between a patient
+// and healthcare providers.
+type Encounter struct {
+	Base
+	Identifier []Identifier `json:"identifier,omitempty"`
+	Status     string       `json:"status"`
+	Class      Coding       `json:"class"`
+	Subject    *Reference   `json:"subject,omitempty"`
+	Period     *Period      `json:"period,omitempty"`
+}
+
+// ResourceType returns "Encounter".
+func (*Encounter) ResourceType() string { return "Encounter" }
+
+// MarshalJSON adds the resourceType and the elements in Extra to the JSON
+// representation.
+func (e Encounter) MarshalJSON() ([]byte, error) {
+	type plain Encounter
+	return marshalObject("Encounter", plain(e), e.Extra)
+}
+
+// UnmarshalJSON keeps the elements that Encounter does not model in Extra.
+func (e *Encounter) UnmarshalJSON(data []byte) error {
+	type plain Encounter
+	extra, err := unmarshalObject(data, (*plain)(e))
+	e.Extra = extra
+	return err
+}
+
+// Observation is a FHIR Observation resource, such as a vital sign or a
+// laboratory result.
+type Observation struct {
+	Base
+	Identifier        []Identifier    `json:"identifier,omitempty"`
+	Status            string          `json:"status"`
+	Code              CodeableConcept `json:"code"`
+	Subject           *Reference      `json:"subject,omitempty"`
+	Encounter         *Reference      `json:"encounter,omitempty"`
+	EffectiveDateTime string          `json:"effectiveDateTime,omitempty"`
+	ValueQuantity     *Quantity       `json:"valueQuantity,omitempty"`
+	ValueString       string          `json:"valueString,omitempty"`
+}
+
+// ResourceType returns "Observation".
+func (*Observation) ResourceType() string { return "Observation" }
+
+// MarshalJSON adds the resourceType and the elements in Extra to the JSON
+// representation.
+func (o Observation) MarshalJSON() ([]byte, error) {
+	type plain Observation
+	return marshalObject("Observation", plain(o), o.Extra)
+}
+
+// UnmarshalJSON keeps the elements that Observation does not model in Extra.
+func (o *Observation) UnmarshalJSON(data []byte) error {
+	type plain Observation
+	extra, err := unmarshalObject(data, (*plain)(o))
+	o.Extra = extra
+	return err
+}
+
+// marshalObject marshals v, a struct, as a JSON object that starts with the
+// given resourceType, if any, and ends with the elements of extra that v
+// does not have.
+func marshalObject(resourceType string, v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	known := jsonFields(reflect.TypeOf(v))
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if resourceType != "" {
+		buf.WriteString(`"resourceType":`)
+		t, _ := json.Marshal(resourceType)
+		buf.Write(t)
+	}
+	if fields := b[1 : len(b)-1]; len(fields) > 0 {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(fields)
+	}
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		if !known[name] && name != "resourceType" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		n, _ := json.Marshal(name)
+		buf.Write(n)
+		buf.WriteByte(':')
+		buf.Write(extra[name])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// unmarshalObject unmarshals data into v, a pointer to a struct, and returns
+// the elements of data that v does not have, or nil if there are none.
+func unmarshalObject(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	known := jsonFields(reflect.TypeOf(v).Elem())
+	for name := range all {
+		if known[name] || name == "resourceType" {
+			delete(all, name)
+		}
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// jsonFields returns the names of the JSON fields of the struct type t,
+// including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for n := range jsonFields(f.Type) {
+				fields[n] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
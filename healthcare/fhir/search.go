@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Search is a search for resources of one type. Build it with NewSearch and
+// its methods, which return the Search so that calls can be chained:
+//
+//	s := fhir.NewSearch("Patient").Where("family:exact", "Smith").Sort("-birthdate").Count(50)
+type Search struct {
+	resourceType string
+	params       url.Values
+}
+
+// NewSearch returns a search for all resources of resourceType.
+func NewSearch(resourceType string) *Search {
+	return &Search{resourceType: resourceType, params: url.Values{}}
+}
+
+// Where adds a search parameter, such as Where("family:exact", "Smith") or
+// Where("birthdate", "ge1990-01-01"). Resources must match every parameter;
+// giving several values in one call matches any of them.
+func (s *Search) Where(param string, values ...string) *Search {
+	s.params.Add(param, strings.Join(values, ","))
+	return s
+}
+
+// Sort orders the results by the given parameters. A parameter starting
+// with "-" sorts in descending order.
+func (s *Search) Sort(params ...string) *Search {
+	s.params.Set("_sort", strings.Join(params, ","))
+	return s
+}
+
+// Count sets the number of results per page.
+func (s *Search) Count(n int) *Search {
+	s.params.Set("_count", strconv.Itoa(n))
+	return s
+}
+
+// Include includes the resources referred to by the given search parameters
+// of the results, such as "Observation:subject", in the results.
+func (s *Search) Include(params ...string) *Search {
+	for _, p := range params {
+		s.params.Add("_include", p)
+	}
+	return s
+}
+
+// Query returns the query parameters of the search.
+func (s *Search) Query() url.Values {
+	q := url.Values{}
+	for k, v := range s.params {
+		q[k] = append([]string(nil), v...)
+	}
+	return q
+}
+
+// Search returns the first page of results of s. Use NextPage or EachPage
+// for the other pages.
+func (c *Client) Search(ctx context.Context, s *Search) (*Bundle, error) {
+	b := &Bundle{}
+	if _, err := c.do(ctx, http.MethodGet, s.resourceType, s.params, nil, nil, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// History returns the first page of versions of a resource, newest first.
+// count is the number of versions per page, or 0 for the store's default.
+func (c *Client) History(ctx context.Context, resourceType, id string, count int) (*Bundle, error) {
+	var q url.Values
+	if count > 0 {
+		q = url.Values{"_count": {strconv.Itoa(count)}}
+	}
+	b := &Bundle{}
+	if _, err := c.do(ctx, http.MethodGet, resourceType+"/"+id+"/_history", q, nil, nil, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NextPage returns the page of results after b, or nil if b is the last
+// page.
+func (c *Client) NextPage(ctx context.Context, b *Bundle) (*Bundle, error) {
+	next := b.Next()
+	if next == "" {
+		return nil, nil
+	}
+	page := &Bundle{}
+	if _, err := c.do(ctx, http.MethodGet, next, nil, nil, nil, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// EachPage calls fn with b and each following page of results, until the
+// last page or until fn returns an error.
+func (c *Client) EachPage(ctx context.Context, b *Bundle, fn func(*Bundle) error) error {
+	for b != nil {
+		if err := fn(b); err != nil {
+			return err
+		}
+		var err error
+		if b, err = c.NextPage(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
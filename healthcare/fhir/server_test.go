@@ -0,0 +1,493 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStore is an in-memory stand-in for the FHIR REST API of a FHIR store.
+// It supports enough of the API for the tests: reads, versioned and
+// conditional writes, simple search parameters, history, paging and
+// transaction and batch bundles.
+type fakeStore struct {
+	base string
+
+	mu     sync.Mutex
+	nextID int
+	// versions holds the versions of each resource by "Type/id", oldest
+	// first. Deleted versions are nil.
+	versions map[string][]map[string]interface{}
+	keys     []string // In creation order.
+}
+
+// newFakeStore starts a fakeStore and returns a Client for it.
+func newFakeStore(t *testing.T) (*fakeStore, *Client) {
+	t.Helper()
+	s := &fakeStore{versions: make(map[string][]map[string]interface{})}
+	srv := httptest.NewServer(http.StripPrefix("/fhir", s))
+	t.Cleanup(srv.Close)
+	s.base = srv.URL + "/fhir"
+	return s, NewClientWithHTTPClient(s.base, srv.Client())
+}
+
+// result is the outcome of an operation on the store.
+type result struct {
+	status   int
+	resource map[string]interface{}
+	key      string // "Type/id" of the resource written.
+	msg      string
+}
+
+func failed(status int, format string, a ...interface{}) result {
+	return result{status: status, msg: fmt.Sprintf(format, a...)}
+}
+
+func outcome(msg string) map[string]interface{} {
+	return map[string]interface{}{
+		"resourceType": "OperationOutcome",
+		"issue": []interface{}{
+			map[string]interface{}{"severity": "error", "code": "processing", "diagnostics": msg},
+		},
+	}
+}
+
+func (s *fakeStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var res map[string]interface{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/fhir+json") {
+			s.write(w, failed(http.StatusUnsupportedMediaType, "Content-Type %q", ct))
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+			s.write(w, failed(http.StatusBadRequest, "invalid JSON: %v", err))
+			return
+		}
+	}
+	var parts []string
+	if p := strings.Trim(r.URL.Path, "/"); p != "" {
+		parts = strings.Split(p, "/")
+	}
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		s.write(w, s.executeBundle(res))
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.write(w, s.searchBundle(parts[0], query))
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		ifNoneExist, err := url.ParseQuery(r.Header.Get("If-None-Exist"))
+		if err != nil {
+			s.write(w, failed(http.StatusBadRequest, "If-None-Exist: %v", err))
+			return
+		}
+		s.write(w, s.create(parts[0], res, ifNoneExist))
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		s.write(w, s.conditionalUpdate(parts[0], query, res))
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.write(w, s.conditionalDelete(parts[0], query))
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.write(w, s.read(parts[0], parts[1]))
+	case len(parts) == 2 && r.Method == http.MethodPut:
+		s.write(w, s.update(parts[0], parts[1], res, r.Header.Get("If-Match")))
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		s.write(w, s.delete(parts[0], parts[1]))
+	case len(parts) == 3 && parts[2] == "_history" && r.Method == http.MethodGet:
+		s.write(w, s.historyBundle(parts[0], parts[1], query))
+	default:
+		s.write(w, failed(http.StatusNotFound, "%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+func (s *fakeStore) write(w http.ResponseWriter, r result) {
+	w.Header().Set("Content-Type", "application/fhir+json;charset=utf-8")
+	body := r.resource
+	if r.status > 299 {
+		body = outcome(r.msg)
+	} else if r.key != "" && body != nil {
+		version := versionOf(body)
+		w.Header().Set("ETag", `W/"`+version+`"`)
+		w.Header().Set("Location", s.base+"/"+r.key+"/_history/"+version)
+	}
+	w.WriteHeader(r.status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func versionOf(res map[string]interface{}) string {
+	meta, _ := res["meta"].(map[string]interface{})
+	v, _ := meta["versionId"].(string)
+	return v
+}
+
+// current returns the current version of a resource, or nil if it does not
+// exist or was deleted.
+func (s *fakeStore) current(key string) map[string]interface{} {
+	vs := s.versions[key]
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs[len(vs)-1]
+}
+
+// put stores a new version of a resource.
+func (s *fakeStore) put(typ, id string, res map[string]interface{}) result {
+	key := typ + "/" + id
+	stored := make(map[string]interface{}, len(res)+2)
+	for k, v := range res {
+		stored[k] = v
+	}
+	stored["resourceType"] = typ
+	stored["id"] = id
+	// Like a real store, keep the metadata the client sets, such as tags.
+	meta := make(map[string]interface{})
+	if m, ok := res["meta"].(map[string]interface{}); ok {
+		for k, v := range m {
+			meta[k] = v
+		}
+	}
+	meta["versionId"] = strconv.Itoa(len(s.versions[key]) + 1)
+	meta["lastUpdated"] = "2023-01-01T00:00:00Z"
+	stored["meta"] = meta
+	status := http.StatusOK
+	if s.current(key) == nil {
+		status = http.StatusCreated
+	}
+	if _, ok := s.versions[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.versions[key] = append(s.versions[key], stored)
+	return result{status: status, resource: stored, key: key}
+}
+
+func (s *fakeStore) create(typ string, res map[string]interface{}, ifNoneExist url.Values) result {
+	if res["resourceType"] != typ {
+		return failed(http.StatusBadRequest, "resourceType %v does not match %s", res["resourceType"], typ)
+	}
+	if len(ifNoneExist) > 0 {
+		switch matches := s.search(typ, ifNoneExist); len(matches) {
+		case 0:
+		case 1:
+			return result{status: http.StatusOK, resource: matches[0], key: typ + "/" + matches[0]["id"].(string)}
+		default:
+			return failed(http.StatusPreconditionFailed, "%d resources match If-None-Exist", len(matches))
+		}
+	}
+	s.nextID++
+	return s.put(typ, fmt.Sprintf("id%d", s.nextID), res)
+}
+
+func (s *fakeStore) read(typ, id string) result {
+	key := typ + "/" + id
+	if _, ok := s.versions[key]; !ok {
+		return failed(http.StatusNotFound, "%s not found", key)
+	}
+	res := s.current(key)
+	if res == nil {
+		return failed(http.StatusGone, "%s was deleted", key)
+	}
+	return result{status: http.StatusOK, resource: res, key: key}
+}
+
+func (s *fakeStore) update(typ, id string, res map[string]interface{}, ifMatch string) result {
+	if res["resourceType"] != typ || res["id"] != id {
+		return failed(http.StatusBadRequest, "resource %v/%v does not match %s/%s", res["resourceType"], res["id"], typ, id)
+	}
+	if ifMatch != "" {
+		cur := s.current(typ + "/" + id)
+		if cur == nil || ifMatch != `W/"`+versionOf(cur)+`"` {
+			return failed(http.StatusPreconditionFailed, "If-Match %s does not match", ifMatch)
+		}
+	}
+	return s.put(typ, id, res)
+}
+
+func (s *fakeStore) conditionalUpdate(typ string, query url.Values, res map[string]interface{}) result {
+	switch matches := s.search(typ, query); len(matches) {
+	case 0:
+		return s.create(typ, res, nil)
+	case 1:
+		id := matches[0]["id"].(string)
+		if res["id"] == nil {
+			res["id"] = id
+		}
+		return s.update(typ, id, res, "")
+	default:
+		return failed(http.StatusPreconditionFailed, "%d resources match", len(matches))
+	}
+}
+
+func (s *fakeStore) delete(typ, id string) result {
+	key := typ + "/" + id
+	if s.current(key) != nil {
+		s.versions[key] = append(s.versions[key], nil)
+	}
+	return result{status: http.StatusOK}
+}
+
+func (s *fakeStore) conditionalDelete(typ string, query url.Values) result {
+	for _, res := range s.search(typ, query) {
+		s.delete(typ, res["id"].(string))
+	}
+	return result{status: http.StatusOK}
+}
+
+// search returns the current resources of typ that match every parameter
+// of query. Parameters starting with "_", other than _id, are ignored.
+func (s *fakeStore) search(typ string, query url.Values) []map[string]interface{} {
+	var matches []map[string]interface{}
+	for _, key := range s.keys {
+		res := s.current(key)
+		if res == nil || res["resourceType"] != typ {
+			continue
+		}
+		ok := true
+		for param, values := range query {
+			if strings.HasPrefix(param, "_") && param != "_id" {
+				continue
+			}
+			for _, v := range values {
+				ok = ok && matchParam(res, param, v)
+			}
+		}
+		if ok {
+			matches = append(matches, res)
+		}
+	}
+	return matches
+}
+
+// matchParam reports whether res has a value matching one of the
+// comma-separated values for param. String parameters such as family
+// match case-insensitive prefixes, unless the :exact modifier is given;
+// other parameters match whole values, or the value part of a token.
+func matchParam(res map[string]interface{}, param, values string) bool {
+	name, modifier, _ := strings.Cut(param, ":")
+	if name == "_id" {
+		name = "id"
+	}
+	var got []string
+	collectStrings(res, name, false, &got)
+	for _, want := range strings.Split(values, ",") {
+		if i := strings.LastIndex(want, "|"); i >= 0 {
+			want = want[i+1:]
+		}
+		for _, g := range got {
+			switch {
+			case modifier == "exact" || (name != "family" && name != "given" && name != "name"):
+				if g == want {
+					return true
+				}
+			case strings.HasPrefix(strings.ToLower(g), strings.ToLower(want)):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectStrings appends the strings found under keys named name in v.
+func collectStrings(v interface{}, name string, under bool, out *[]string) {
+	switch v := v.(type) {
+	case string, bool, float64:
+		if under {
+			*out = append(*out, fmt.Sprint(v))
+		}
+	case []interface{}:
+		for _, e := range v {
+			collectStrings(e, name, under, out)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			collectStrings(e, name, under || k == name, out)
+		}
+	}
+}
+
+// page returns the bundle with the entries selected by the _count and
+// _offset parameters of query, linking to the next page if there is one.
+func (s *fakeStore) page(typ, path string, query url.Values, entries []interface{}) map[string]interface{} {
+	count, err := strconv.Atoi(query.Get("_count"))
+	if err != nil || count <= 0 {
+		count = 100
+	}
+	offset, _ := strconv.Atoi(query.Get("_offset"))
+	end := offset + count
+	if end > len(entries) {
+		end = len(entries)
+	}
+	b := map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         typ,
+		"total":        len(entries),
+		"entry":        entries[offset:end],
+	}
+	if end < len(entries) {
+		next := url.Values{}
+		for k, v := range query {
+			next[k] = v
+		}
+		next.Set("_offset", strconv.Itoa(end))
+		b["link"] = []interface{}{
+			map[string]interface{}{"relation": "next", "url": s.base + "/" + path + "?" + next.Encode()},
+		}
+	}
+	return b
+}
+
+func (s *fakeStore) searchBundle(typ string, query url.Values) result {
+	var entries []interface{}
+	for _, res := range s.search(typ, query) {
+		entries = append(entries, map[string]interface{}{
+			"fullUrl":  s.base + "/" + typ + "/" + res["id"].(string),
+			"resource": res,
+		})
+	}
+	return result{status: http.StatusOK, resource: s.page("searchset", typ, query, entries)}
+}
+
+func (s *fakeStore) historyBundle(typ, id string, query url.Values) result {
+	key := typ + "/" + id
+	vs := s.versions[key]
+	if len(vs) == 0 {
+		return failed(http.StatusNotFound, "%s not found", key)
+	}
+	var entries []interface{}
+	for i := len(vs) - 1; i >= 0; i-- {
+		e := map[string]interface{}{"fullUrl": s.base + "/" + key}
+		if vs[i] == nil {
+			e["request"] = map[string]interface{}{"method": "DELETE", "url": key}
+		} else {
+			e["resource"] = vs[i]
+		}
+		entries = append(entries, e)
+	}
+	return result{status: http.StatusOK, resource: s.page("history", key+"/_history", query, entries)}
+}
+
+// executeBundle executes the entries of a transaction or batch in order.
+// References to the fullUrl of an earlier entry are replaced with the
+// reference to the resource it created. A failed entry undoes a
+// transaction.
+func (s *fakeStore) executeBundle(b map[string]interface{}) result {
+	typ, _ := b["type"].(string)
+	if typ != "transaction" && typ != "batch" {
+		return failed(http.StatusBadRequest, "bundle type %q", typ)
+	}
+	saved := make(map[string][]map[string]interface{}, len(s.versions))
+	for k, v := range s.versions {
+		saved[k] = v
+	}
+	savedKeys, savedID := s.keys, s.nextID
+
+	refs := make(map[string]string)
+	entries, _ := b["entry"].([]interface{})
+	var responses []interface{}
+	for _, e := range entries {
+		e, _ := e.(map[string]interface{})
+		r := s.executeEntry(e, refs)
+		if r.status > 299 && typ == "transaction" {
+			s.versions, s.keys, s.nextID = saved, savedKeys, savedID
+			return r
+		}
+		if fullURL, _ := e["fullUrl"].(string); fullURL != "" && r.key != "" {
+			refs[fullURL] = r.key
+		}
+		resp := map[string]interface{}{"status": fmt.Sprintf("%d %s", r.status, http.StatusText(r.status))}
+		if r.status > 299 {
+			resp["outcome"] = outcome(r.msg)
+		} else if r.key != "" && r.resource != nil {
+			resp["location"] = s.base + "/" + r.key + "/_history/" + versionOf(r.resource)
+			resp["etag"] = `W/"` + versionOf(r.resource) + `"`
+		}
+		entry := map[string]interface{}{"response": resp}
+		if r.status <= 299 && r.resource != nil {
+			entry["resource"] = r.resource
+		}
+		responses = append(responses, entry)
+	}
+	return result{status: http.StatusOK, resource: map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         typ + "-response",
+		"entry":        responses,
+	}}
+}
+
+func (s *fakeStore) executeEntry(e map[string]interface{}, refs map[string]string) result {
+	req, _ := e["request"].(map[string]interface{})
+	method, _ := req["method"].(string)
+	u, _ := req["url"].(string)
+	path, rawQuery, _ := strings.Cut(u, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return failed(http.StatusBadRequest, "url %q: %v", u, err)
+	}
+	parts := strings.Split(path, "/")
+
+	var res map[string]interface{}
+	if raw, ok := e["resource"]; ok {
+		b, _ := json.Marshal(raw)
+		for fullURL, key := range refs {
+			b = bytes.ReplaceAll(b, []byte(`"`+fullURL+`"`), []byte(`"`+key+`"`))
+		}
+		json.Unmarshal(b, &res)
+	}
+
+	switch {
+	case method == http.MethodPost && len(parts) == 1:
+		ifNoneExist, _ := req["ifNoneExist"].(string)
+		q, err := url.ParseQuery(ifNoneExist)
+		if err != nil {
+			return failed(http.StatusBadRequest, "ifNoneExist: %v", err)
+		}
+		return s.create(parts[0], res, q)
+	case method == http.MethodPut && len(parts) == 1:
+		return s.conditionalUpdate(parts[0], query, res)
+	case method == http.MethodPut && len(parts) == 2:
+		ifMatch, _ := req["ifMatch"].(string)
+		return s.update(parts[0], parts[1], res, ifMatch)
+	case method == http.MethodDelete && len(parts) == 2:
+		return s.delete(parts[0], parts[1])
+	case method == http.MethodGet && len(parts) == 2:
+		return s.read(parts[0], parts[1])
+	}
+	return failed(http.StatusBadRequest, "unsupported request %s %s", method, u)
+}
+
+func TestFakeStoreRejectsUnknownContentType(t *testing.T) {
+	s, _ := newFakeStore(t)
+	resp, err := http.Post(s.base+"/Patient", "application/json", strings.NewReader(`{"resourceType":"Patient"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("POST with application/json got status %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+}
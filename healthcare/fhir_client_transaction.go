@@ -0,0 +1,104 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snippets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/GoogleCloudPlatform/golang-samples/healthcare/fhir"
+)
+
+// fhirClientTransaction uses the typed FHIR client to create a patient, an
+// encounter and an observation in one transaction, and then searches for
+// the patient's observations.
+func fhirClientTransaction(w io.Writer, projectID, location, datasetID, fhirStoreID string) error {
+	ctx := context.Background()
+
+	client, err := fhir.NewClient(ctx, fhir.StoreURL(projectID, location, datasetID, fhirStoreID))
+	if err != nil {
+		return fmt.Errorf("fhir.NewClient: %w", err)
+	}
+
+	active := true
+	tx := fhir.NewTransaction()
+	// The patient is only created if there is none with this medical record
+	// number.
+	mrn := fhir.Identifier{System: "urn:example:mrn", Value: "12345"}
+	patient, err := tx.AddCreate(&fhir.Patient{
+		Identifier: []fhir.Identifier{mrn},
+		Active:     &active,
+		Name:       []fhir.HumanName{{Family: "Smith", Given: []string{"Darcy"}}},
+		Gender:     "female",
+		BirthDate:  "1970-01-01",
+	}, url.Values{"identifier": {mrn.System + "|" + mrn.Value}})
+	if err != nil {
+		return fmt.Errorf("AddCreate: %w", err)
+	}
+	encounter, err := tx.AddCreate(&fhir.Encounter{
+		Status:  "finished",
+		Class:   fhir.Coding{System: "http://terminology.hl7.org/CodeSystem/v3-ActCode", Code: "AMB", Display: "ambulatory"},
+		Subject: &patient,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("AddCreate: %w", err)
+	}
+	if _, err := tx.AddCreate(&fhir.Observation{
+		Status:        "final",
+		Code:          fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://loinc.org", Code: "8867-4", Display: "Heart rate"}}},
+		Subject:       &patient,
+		Encounter:     &encounter,
+		ValueQuantity: &fhir.Quantity{Value: 80, Unit: "beats/minute", System: "http://unitsofmeasure.org", Code: "/min"},
+	}, nil); err != nil {
+		return fmt.Errorf("AddCreate: %w", err)
+	}
+
+	resp, err := client.ExecuteBundle(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("ExecuteBundle: %w", err)
+	}
+	for _, e := range resp.Entry {
+		fmt.Fprintf(w, "%s %s\n", e.Response.Status, e.Response.Location)
+	}
+
+	p := &fhir.Patient{}
+	if err := resp.Entry[0].Decode(p); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	search := fhir.NewSearch("Observation").
+		Where("subject", fhir.ReferenceTo(p).Reference).
+		Sort("-_lastUpdated").
+		Count(10)
+	first, err := client.Search(ctx, search)
+	if err != nil {
+		return fmt.Errorf("Search: %w", err)
+	}
+	return client.EachPage(ctx, first, func(b *fhir.Bundle) error {
+		for _, e := range b.Entry {
+			o := &fhir.Observation{}
+			if err := e.Decode(o); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Observation/%s: %s", o.ID, o.Code.Coding[0].Display)
+			if o.ValueQuantity != nil {
+				fmt.Fprintf(w, " %v %s", o.ValueQuantity.Value, o.ValueQuantity.Unit)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	})
+}
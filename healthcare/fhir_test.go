@@ -311,6 +311,16 @@ func TestFHIRStore(t *testing.T) {
 		}
 	})
 
+	testutil.Retry(t, 10, 2*time.Second, func(r *testutil.R) {
+		buf.Reset()
+		if err := fhirClientTransaction(buf, tc.ProjectID, location, datasetID, fhirStoreID); err != nil {
+			r.Errorf("fhirClientTransaction got err: %v", err)
+		}
+		if got, want := buf.String(), "Heart rate 80 beats/minute"; !strings.Contains(got, want) {
+			r.Errorf("fhirClientTransaction got\n----\n%s\n----\nWant to contain:\n----\n%s\n----\n", got, want)
+		}
+	})
+
 	testutil.Retry(t, 10, 2*time.Second, func(r *testutil.R) {
 		if err := deleteFHIRStore(ioutil.Discard, tc.ProjectID, location, datasetID, fhirStoreID); err != nil {
 			r.Errorf("deleteFHIRStore got err: %v", err)
@@ -0,0 +1,302 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hl7v2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Acknowledgment codes, for MSA-1.
+const (
+	AcceptAck = "AA" // The message was accepted.
+	ErrorAck  = "AE" // The message had an error.
+	RejectAck = "AR" // The message was rejected.
+)
+
+// timeLayout is the layout of HL7v2 timestamps (the DTM data type).
+const timeLayout = "20060102150405-0700"
+
+// FormatTime formats t as an HL7v2 timestamp, such as 20230102150405+0000.
+func FormatTime(t time.Time) string {
+	return t.Format(timeLayout)
+}
+
+// Header holds the fields of the MSH segment of a new message.
+type Header struct {
+	SendingApplication   string
+	SendingFacility      string
+	ReceivingApplication string
+	ReceivingFacility    string
+	// Time is when the message was created. It defaults to now.
+	Time time.Time
+	// MessageType and TriggerEvent give the type of message, such as ADT
+	// and A01.
+	MessageType  string
+	TriggerEvent string
+	// MessageStructure, such as ADT_A01, is optional.
+	MessageStructure string
+	// ControlID identifies the message, and is returned in its ACK.
+	ControlID string
+	// ProcessingID is P (production, the default), D (debugging) or T
+	// (training).
+	ProcessingID string
+	// Version defaults to 2.5.1.
+	Version string
+}
+
+// NewMessage returns a message with the default delimiters and an MSH
+// segment with the fields of h.
+func NewMessage(h Header) *Message {
+	if h.Time.IsZero() {
+		h.Time = time.Now()
+	}
+	if h.ProcessingID == "" {
+		h.ProcessingID = "P"
+	}
+	if h.Version == "" {
+		h.Version = "2.5.1"
+	}
+	d := DefaultDelimiters
+	msh := &Segment{Name: "MSH", Fields: []Field{
+		{{{string(d.Field)}}},
+		{{{d.encodingCharacters()}}},
+	}}
+	msh.Set(3, h.SendingApplication)
+	msh.Set(4, h.SendingFacility)
+	msh.Set(5, h.ReceivingApplication)
+	msh.Set(6, h.ReceivingFacility)
+	msh.Set(7, FormatTime(h.Time))
+	msh.Set(9, h.MessageType, h.TriggerEvent, h.MessageStructure)
+	msh.Set(10, h.ControlID)
+	msh.Set(11, h.ProcessingID)
+	msh.Set(12, h.Version)
+	return &Message{Delimiters: d, Segments: []*Segment{msh}}
+}
+
+// AddSegment adds a segment with the given name and returns it.
+func (m *Message) AddSegment(name string) *Segment {
+	s := &Segment{Name: name}
+	m.Segments = append(m.Segments, s)
+	return s
+}
+
+// Patient identifies a patient, for the PID segment.
+type Patient struct {
+	// ID is the medical record number, assigned by AssigningAuthority.
+	ID                 string
+	AssigningAuthority string
+	Family             string
+	Given              string
+	BirthDate          time.Time
+	// Sex is F, M, O (other), U (unknown), A (ambiguous) or N (not
+	// applicable).
+	Sex string
+}
+
+func (m *Message) addPID(p Patient) {
+	pid := m.AddSegment("PID")
+	pid.Set(1, "1")
+	pid.Set(3, p.ID, "", "", p.AssigningAuthority, "MR")
+	pid.Set(5, p.Family, p.Given)
+	if !p.BirthDate.IsZero() {
+		pid.Set(7, p.BirthDate.Format("20060102"))
+	}
+	pid.Set(8, p.Sex)
+}
+
+// Visit describes a patient visit, for the PV1 segment.
+type Visit struct {
+	// Class is E (emergency), I (inpatient), O (outpatient) and so on.
+	Class string
+	// PointOfCare, Room and Bed give the location of the patient.
+	PointOfCare, Room, Bed string
+	// AttendingDoctorID and AttendingDoctor identify the attending doctor.
+	AttendingDoctorID, AttendingDoctor string
+}
+
+// NewADT returns an ADT (admission, discharge and transfer) message for the
+// event h.TriggerEvent, such as A01 (admit) or A03 (discharge), with EVN,
+// PID and PV1 segments.
+func NewADT(h Header, p Patient, v Visit) *Message {
+	h.MessageType = "ADT"
+	m := NewMessage(h)
+	evn := m.AddSegment("EVN")
+	evn.Set(1, h.TriggerEvent)
+	evn.Set(2, m.Get("MSH-7"))
+	m.addPID(p)
+	pv1 := m.AddSegment("PV1")
+	pv1.Set(1, "1")
+	pv1.Set(2, v.Class)
+	pv1.Set(3, v.PointOfCare, v.Room, v.Bed)
+	pv1.Set(7, v.AttendingDoctorID, v.AttendingDoctor)
+	return m
+}
+
+// Code is a coded value, such as a LOINC code.
+type Code struct {
+	Code   string
+	Text   string
+	System string // Such as LN for LOINC.
+}
+
+func (c Code) components() []string {
+	return []string{c.Code, c.Text, c.System}
+}
+
+// Order is the order an ORU message reports results for, for the OBR
+// segment.
+type Order struct {
+	// FillerOrderNumber identifies the order for the system reporting the
+	// results.
+	FillerOrderNumber string
+	Service           Code
+	// Time is when the observations were made.
+	Time time.Time
+}
+
+// Observation is a result, for an OBX segment.
+type Observation struct {
+	Code Code
+	// ValueType is the HL7v2 data type of Value, such as NM (numeric), ST
+	// (string) or TX (text). It defaults to ST.
+	ValueType      string
+	Value          string
+	Units          string
+	ReferenceRange string
+	// Status is F (final, the default), P (preliminary) or C (corrected).
+	Status string
+}
+
+// NewORU returns an ORU^R01 message reporting the results of an order, with
+// PID, OBR and OBX segments.
+func NewORU(h Header, p Patient, o Order, observations []Observation) *Message {
+	h.MessageType, h.TriggerEvent = "ORU", "R01"
+	if h.MessageStructure == "" {
+		h.MessageStructure = "ORU_R01"
+	}
+	m := NewMessage(h)
+	m.addPID(p)
+	obr := m.AddSegment("OBR")
+	obr.Set(1, "1")
+	obr.Set(3, o.FillerOrderNumber)
+	obr.Set(4, o.Service.components()...)
+	if !o.Time.IsZero() {
+		obr.Set(7, FormatTime(o.Time))
+	}
+	for i, obs := range observations {
+		if obs.ValueType == "" {
+			obs.ValueType = "ST"
+		}
+		if obs.Status == "" {
+			obs.Status = "F"
+		}
+		obx := m.AddSegment("OBX")
+		obx.Set(1, strconv.Itoa(i+1))
+		obx.Set(2, obs.ValueType)
+		obx.Set(3, obs.Code.components()...)
+		obx.Set(5, obs.Value)
+		obx.Set(6, obs.Units)
+		obx.Set(7, obs.ReferenceRange)
+		obx.Set(11, obs.Status)
+		if !o.Time.IsZero() {
+			obx.Set(14, FormatTime(o.Time))
+		}
+	}
+	return m
+}
+
+// Ack returns an ACK message acknowledging m with the given code, such as
+// AcceptAck, and an optional text explaining it. The ACK is sent from the
+// receiver of m to its sender, with the given control ID and time.
+func (m *Message) Ack(code, text, controlID string, t time.Time) (*Message, error) {
+	if m.Segment("MSH") == nil {
+		return nil, errors.New("hl7v2: message has no MSH segment")
+	}
+	switch code {
+	case AcceptAck, ErrorAck, RejectAck:
+	default:
+		return nil, fmt.Errorf("hl7v2: invalid acknowledgment code %q", code)
+	}
+	ack := NewMessage(Header{
+		SendingApplication:   m.Get("MSH-5"),
+		SendingFacility:      m.Get("MSH-6"),
+		ReceivingApplication: m.Get("MSH-3"),
+		ReceivingFacility:    m.Get("MSH-4"),
+		Time:                 t,
+		MessageType:          "ACK",
+		TriggerEvent:         m.Get("MSH-9-2"),
+		MessageStructure:     "ACK",
+		ControlID:            controlID,
+		ProcessingID:         m.Get("MSH-11"),
+		Version:              m.Get("MSH-12"),
+	})
+	msa := ack.AddSegment("MSA")
+	msa.Set(1, code)
+	msa.Set(2, m.Get("MSH-10"))
+	msa.Set(3, text)
+	return ack, nil
+}
+
+// ValidationError lists the problems Validate found in a message.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "hl7v2: invalid message: " + strings.Join(e, "; ")
+}
+
+// requiredSegments are the segments messages of each type must have.
+var requiredSegments = map[string][]string{
+	"ADT": {"EVN", "PID"},
+	"ORU": {"OBR", "OBX"},
+	"ACK": {"MSA"},
+}
+
+// Validate checks that m has the MSH fields every message needs (the
+// message type, control ID, processing ID and version), and the segments
+// its type needs, for the message types that Validate knows. It returns a
+// ValidationError listing the problems.
+func (m *Message) Validate() error {
+	var problems ValidationError
+	if len(m.Segments) == 0 || m.Segments[0].Name != "MSH" {
+		return ValidationError{"the first segment is not MSH"}
+	}
+	for _, f := range []struct{ loc, name string }{
+		{"MSH-9-1", "message type"},
+		{"MSH-10", "message control ID"},
+		{"MSH-11", "processing ID"},
+		{"MSH-12", "version ID"},
+	} {
+		if m.Get(f.loc) == "" {
+			problems = append(problems, fmt.Sprintf("%s (%s) is empty", f.loc, f.name))
+		}
+	}
+	typ := m.Get("MSH-9-1")
+	if typ != "ACK" && m.Get("MSH-9-2") == "" {
+		problems = append(problems, "MSH-9-2 (trigger event) is empty")
+	}
+	for _, name := range requiredSegments[typ] {
+		if m.Segment(name) == nil {
+			problems = append(problems, fmt.Sprintf("%s message has no %s segment", typ, name))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
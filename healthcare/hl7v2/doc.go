@@ -0,0 +1,31 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hl7v2 parses and builds HL7v2 messages, such as those stored in
+// Cloud Healthcare API HL7v2 stores.
+//
+// A message is a list of segments, made of fields. Fields may repeat, and
+// each repetition is made of components and subcomponents. Parse reads the
+// delimiters from the MSH segment and unescapes the values, and Get reads
+// values by location:
+//
+//	m, err := hl7v2.Parse(data)
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Println(m.Get("MSH-9-1"), m.Get("PID-5-1"), m.Get("OBX(2)-5"))
+//
+// NewADT and NewORU build messages, and Ack builds the acknowledgment of a
+// message.
+package hl7v2
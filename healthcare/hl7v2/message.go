@@ -0,0 +1,420 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hl7v2
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Delimiters are the characters that separate the parts of a message. They
+// are given by the first fields of the MSH segment.
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+	// Truncation is only used since HL7v2.7, and is 0 if not given.
+	Truncation byte
+}
+
+// DefaultDelimiters are the delimiters almost every message uses:
+// MSH|^~\&|...
+var DefaultDelimiters = Delimiters{Field: '|', Component: '^', Repetition: '~', Escape: '\\', Subcomponent: '&'}
+
+// encodingCharacters returns the value of MSH-2.
+func (d Delimiters) encodingCharacters() string {
+	s := string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent})
+	if d.Truncation != 0 {
+		s += string(d.Truncation)
+	}
+	return s
+}
+
+func (d Delimiters) validate() error {
+	all := []byte{d.Field, d.Component, d.Repetition, d.Escape, d.Subcomponent}
+	if d.Truncation != 0 {
+		all = append(all, d.Truncation)
+	}
+	for i, c := range all {
+		if c == '\r' || c == '\n' || c == ' ' || ('0' <= c && c <= '9') || ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') {
+			return fmt.Errorf("invalid delimiter %q", c)
+		}
+		if bytes.IndexByte(all[:i], c) >= 0 {
+			return fmt.Errorf("delimiter %q is used twice", c)
+		}
+	}
+	return nil
+}
+
+// Message is an HL7v2 message: a list of segments, the first of which is
+// the MSH (message header) segment.
+type Message struct {
+	Delimiters Delimiters
+	Segments   []*Segment
+}
+
+// Segment is a segment of a message, such as PID.
+type Segment struct {
+	Name string
+	// Fields[i] is field i+1 of the segment. For MSH segments, Fields[0] is
+	// the field separator and Fields[1] the other delimiters; they are
+	// always written from the Delimiters of the message.
+	Fields []Field
+}
+
+// Field is the value of a field, made of one or more repetitions.
+type Field []Repetition
+
+// Repetition is one repetition of a field, made of components.
+type Repetition []Component
+
+// Component is a component of a field, made of subcomponents. The
+// subcomponents are unescaped.
+type Component []string
+
+var segmentName = regexp.MustCompile(`^[A-Z][A-Z0-9]{2}$`)
+
+// Parse parses a message. Segments may be separated by carriage returns
+// (as the standard requires), newlines or both. Escape sequences in values
+// are unescaped; see UnescapeValue.
+func Parse(data []byte) (*Message, error) {
+	lines := strings.FieldsFunc(string(data), func(r rune) bool { return r == '\r' || r == '\n' })
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("hl7v2: empty message")
+	}
+	msh := lines[0]
+	if !strings.HasPrefix(msh, "MSH") || len(msh) < 8 {
+		return nil, fmt.Errorf("hl7v2: message does not start with an MSH segment")
+	}
+	d := Delimiters{Field: msh[3]}
+	enc := msh[4:]
+	if i := strings.IndexByte(enc, d.Field); i >= 0 {
+		enc = enc[:i]
+	}
+	if len(enc) < 4 || len(enc) > 5 {
+		return nil, fmt.Errorf("hl7v2: MSH-2 is %q, want 4 or 5 encoding characters", enc)
+	}
+	d.Component, d.Repetition, d.Escape, d.Subcomponent = enc[0], enc[1], enc[2], enc[3]
+	if len(enc) == 5 {
+		d.Truncation = enc[4]
+	}
+	if err := d.validate(); err != nil {
+		return nil, fmt.Errorf("hl7v2: MSH: %w", err)
+	}
+
+	m := &Message{Delimiters: d}
+	for n, line := range lines {
+		parts := strings.Split(line, string(d.Field))
+		s := &Segment{Name: parts[0]}
+		if !segmentName.MatchString(s.Name) {
+			return nil, fmt.Errorf("hl7v2: segment %d: invalid segment name %q", n+1, s.Name)
+		}
+		if s.Name == "MSH" {
+			if n > 0 {
+				return nil, fmt.Errorf("hl7v2: segment %d: unexpected MSH segment", n+1)
+			}
+			s.Fields = append(s.Fields, Field{{{string(d.Field)}}}, Field{{{enc}}})
+			parts = parts[2:]
+		} else {
+			parts = parts[1:]
+		}
+		for _, p := range parts {
+			s.Fields = append(s.Fields, d.parseField(p))
+		}
+		m.Segments = append(m.Segments, s)
+	}
+	return m, nil
+}
+
+func (d Delimiters) parseField(s string) Field {
+	if s == "" {
+		return nil
+	}
+	var f Field
+	for _, rep := range strings.Split(s, string(d.Repetition)) {
+		var r Repetition
+		for _, comp := range strings.Split(rep, string(d.Component)) {
+			var c Component
+			for _, sub := range strings.Split(comp, string(d.Subcomponent)) {
+				c = append(c, d.UnescapeValue(sub))
+			}
+			r = append(r, c)
+		}
+		f = append(f, r)
+	}
+	return f
+}
+
+// UnescapeValue replaces the escape sequences in s with the characters they
+// stand for: \F\, \S\, \T\, \R\ and \E\ for the delimiters, and \Xhh...\
+// for the characters with the given hexadecimal codes. Formatting
+// sequences, such as \.br\, are left as they are.
+func (d Delimiters) UnescapeValue(s string) string {
+	if strings.IndexByte(s, d.Escape) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, d.Escape)
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i+1:], d.Escape)
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		seq := s[i+1 : i+1+j]
+		s = s[i+j+2:]
+		switch {
+		case seq == "F":
+			b.WriteByte(d.Field)
+		case seq == "S":
+			b.WriteByte(d.Component)
+		case seq == "T":
+			b.WriteByte(d.Subcomponent)
+		case seq == "R":
+			b.WriteByte(d.Repetition)
+		case seq == "E":
+			b.WriteByte(d.Escape)
+		case seq == "P" && d.Truncation != 0:
+			b.WriteByte(d.Truncation)
+		case isHexSequence(seq):
+			for k := 1; k < len(seq); k += 2 {
+				v, _ := strconv.ParseUint(seq[k:k+2], 16, 8)
+				b.WriteByte(byte(v))
+			}
+		default:
+			b.WriteByte(d.Escape)
+			b.WriteString(seq)
+			b.WriteByte(d.Escape)
+		}
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func isHexSequence(seq string) bool {
+	if len(seq) < 3 || seq[0] != 'X' || len(seq)%2 != 1 {
+		return false
+	}
+	for _, c := range seq[1:] {
+		if !strings.ContainsRune("0123456789ABCDEFabcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isFormatting reports whether seq is a formatting escape sequence, such as
+// H, N or .br.
+func isFormatting(seq string) bool {
+	return seq == "H" || seq == "N" || (len(seq) > 1 && (seq[0] == '.' || seq[0] == 'Z'))
+}
+
+// EscapeValue replaces the delimiters, carriage returns and newlines in s with
+// escape sequences. Formatting sequences, such as \.br\, are kept.
+func (d Delimiters) EscapeValue(s string) string {
+	var b strings.Builder
+	escape := func(seq string) {
+		b.WriteByte(d.Escape)
+		b.WriteString(seq)
+		b.WriteByte(d.Escape)
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == d.Escape:
+			if j := strings.IndexByte(s[i+1:], d.Escape); j >= 0 && isFormatting(s[i+1:i+1+j]) {
+				b.WriteString(s[i : i+j+2])
+				i += j + 1
+				continue
+			}
+			escape("E")
+		case c == d.Field:
+			escape("F")
+		case c == d.Component:
+			escape("S")
+		case c == d.Subcomponent:
+			escape("T")
+		case c == d.Repetition:
+			escape("R")
+		case c == d.Truncation && c != 0:
+			escape("P")
+		case c == '\r' || c == '\n':
+			escape(fmt.Sprintf("X%02X", c))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Bytes returns the encoded message, with each segment followed by a
+// carriage return.
+func (m *Message) Bytes() []byte {
+	d := m.Delimiters
+	var b bytes.Buffer
+	for _, s := range m.Segments {
+		b.WriteString(s.Name)
+		fields := s.Fields
+		if s.Name == "MSH" {
+			b.WriteByte(d.Field)
+			b.WriteString(d.encodingCharacters())
+			if len(fields) > 2 {
+				fields = fields[2:]
+			} else {
+				fields = nil
+			}
+		}
+		for _, f := range fields {
+			b.WriteByte(d.Field)
+			b.WriteString(d.EncodeField(f))
+		}
+		b.WriteByte('\r')
+	}
+	return b.Bytes()
+}
+
+// String returns the encoded message, with segments separated by newlines
+// for display.
+func (m *Message) String() string {
+	return strings.ReplaceAll(strings.TrimSuffix(string(m.Bytes()), "\r"), "\r", "\n")
+}
+
+// EncodeField returns the encoded value of a field, escaping its values.
+func (d Delimiters) EncodeField(f Field) string {
+	reps := make([]string, len(f))
+	for i, r := range f {
+		comps := make([]string, len(r))
+		for j, c := range r {
+			subs := make([]string, len(c))
+			for k, s := range c {
+				subs[k] = d.EscapeValue(s)
+			}
+			comps[j] = strings.Join(subs, string(d.Subcomponent))
+		}
+		reps[i] = strings.Join(comps, string(d.Component))
+	}
+	return strings.Join(reps, string(d.Repetition))
+}
+
+// Segment returns the first segment with the given name, or nil if there is
+// none.
+func (m *Message) Segment(name string) *Segment {
+	for _, s := range m.Segments {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// All returns the segments with the given name.
+func (m *Message) All(name string) []*Segment {
+	var segs []*Segment
+	for _, s := range m.Segments {
+		if s.Name == name {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+// location matches locations such as PID-5, PID-3(2)-1, OBX(2)-5-1-2.
+var location = regexp.MustCompile(`^([A-Z][A-Z0-9]{2})(?:\((\d+)\))?-(\d+)(?:\((\d+)\))?(?:-(\d+)(?:-(\d+))?)?$`)
+
+// Get returns the value at a location such as "PID-5-1": the segment name,
+// then the field, component and subcomponent numbers, counting from 1. The
+// occurrence of a repeated segment or the repetition of a field can be
+// given in parentheses, as in "OBX(2)-5" or "PID-3(2)-1". The component and
+// subcomponent default to 1, so "PID-5" is the family name of the patient.
+// Get returns "" if the value is missing or the location is invalid.
+func (m *Message) Get(loc string) string {
+	match := location.FindStringSubmatch(loc)
+	if match == nil {
+		return ""
+	}
+	n := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		v, _ := strconv.Atoi(s)
+		return v
+	}
+	segs := m.All(match[1])
+	occurrence := n(match[2])
+	if occurrence < 1 || occurrence > len(segs) {
+		return ""
+	}
+	return segs[occurrence-1].Get(n(match[3]), n(match[4]), n(match[5]), n(match[6]))
+}
+
+// Get returns the value of the given subcomponent of the given repetition
+// of a field, counting from 1, or "" if there is none.
+func (s *Segment) Get(field, repetition, component, subcomponent int) string {
+	if field < 1 || field > len(s.Fields) {
+		return ""
+	}
+	f := s.Fields[field-1]
+	if repetition < 1 || repetition > len(f) {
+		return ""
+	}
+	r := f[repetition-1]
+	if component < 1 || component > len(r) {
+		return ""
+	}
+	c := r[component-1]
+	if subcomponent < 1 || subcomponent > len(c) {
+		return ""
+	}
+	return c[subcomponent-1]
+}
+
+// Set sets a field to a single repetition with the given components,
+// adding empty fields before it if needed. Set(5, "Smith", "Darcy") sets
+// the field to Smith^Darcy.
+func (s *Segment) Set(field int, components ...string) {
+	s.grow(field)
+	s.Fields[field-1] = Field{newRepetition(components)}
+}
+
+// AddRepetition adds a repetition with the given components to a field.
+func (s *Segment) AddRepetition(field int, components ...string) {
+	s.grow(field)
+	s.Fields[field-1] = append(s.Fields[field-1], newRepetition(components))
+}
+
+func (s *Segment) grow(field int) {
+	for len(s.Fields) < field {
+		s.Fields = append(s.Fields, nil)
+	}
+}
+
+func newRepetition(components []string) Repetition {
+	// Drop trailing empty components, which need not be written.
+	for len(components) > 0 && components[len(components)-1] == "" {
+		components = components[:len(components)-1]
+	}
+	r := make(Repetition, len(components))
+	for i, c := range components {
+		r[i] = Component{c}
+	}
+	return r
+}
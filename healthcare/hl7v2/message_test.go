@@ -0,0 +1,280 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hl7v2
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTestdata(t *testing.T) {
+	data, err := os.ReadFile("../testdata/hl7v2message.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if m.Delimiters != DefaultDelimiters {
+		t.Errorf("Parse got delimiters %+v, want the defaults", m.Delimiters)
+	}
+	var names []string
+	for _, s := range m.Segments {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, " "); got != "MSH EVN PID" {
+		t.Errorf("Parse got segments %q, want MSH EVN PID", got)
+	}
+	for loc, want := range map[string]string{
+		"MSH-1":       "|",
+		"MSH-2":       `^~\&`,
+		"MSH-4":       "SEND_FACILITY",
+		"MSH-9":       "TYPE",
+		"MSH-9-2":     "A",
+		"MSH-12":      "0.0",
+		"MSH-18-2":    "",
+		"EVN-2":       "20180101040000",
+		"PID-2-4":     "",
+		"PID-2-5":     "MRN",
+		"PID-3(2)":    "1111111111",
+		"PID-3(2)-5":  "ORGNMBR",
+		"PID-3(3)":    "",
+		"PID(2)-1":    "",
+		"OBX-1":       "",
+		"pid-3":       "",
+		"PID-3-1-2-3": "",
+	} {
+		if got := m.Get(loc); got != want {
+			t.Errorf("Get(%q) = %q, want %q", loc, got, want)
+		}
+	}
+	if got := string(m.Bytes()); got != string(data)+"\r" {
+		t.Errorf("Bytes() = %q, want the original message %q", got, data)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestParseCustomDelimiters(t *testing.T) {
+	data := "MSH#:*!%#APP#FAC#####ADT:A01#42#P#2.5\n" +
+		"PID###12345:::HOSP:MR*67890#!F!!S!!R!!E!!T!#O'Brien:Mary%Ann\r\n"
+	m, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := Delimiters{Field: '#', Component: ':', Repetition: '*', Escape: '!', Subcomponent: '%'}
+	if m.Delimiters != want {
+		t.Errorf("Parse got delimiters %+v, want %+v", m.Delimiters, want)
+	}
+	for loc, want := range map[string]string{
+		"MSH-9-2":      "A01",
+		"PID-3-4":      "HOSP",
+		"PID-3(2)":     "67890",
+		"PID-4":        "#:*!%",
+		"PID-5":        "O'Brien",
+		"PID-5-2-2":    "Ann",
+		"PID(1)-5-2-1": "Mary",
+	} {
+		if got := m.Get(loc); got != want {
+			t.Errorf("Get(%q) = %q, want %q", loc, got, want)
+		}
+	}
+	if got, want := m.String(), strings.TrimSpace(strings.ReplaceAll(data, "\r", "")); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"\r\n",
+		"PID|1\r",
+		"MSH|^~\r",
+		"MSH|^^\\&|A\r",
+		"MSH|^~\\&|A\rpid|1\r",
+		"MSH|^~\\&|A\rPID|1\rMSH|^~\\&|B\r",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	d := DefaultDelimiters
+	d.Truncation = '#'
+	for _, tc := range []struct{ value, escaped string }{
+		{"plain", "plain"},
+		{`a|b^c&d~e\f#g`, `a\F\b\S\c\T\d\R\e\E\f\P\g`},
+		{"line 1\r\nline 2", `line 1\X0D\\X0A\line 2`},
+		{`first\.br\second`, `first\.br\second`},
+		{`\H\bold\N\`, `\H\bold\N\`},
+	} {
+		if got := d.EscapeValue(tc.value); got != tc.escaped {
+			t.Errorf("EscapeValue(%q) = %q, want %q", tc.value, got, tc.escaped)
+		}
+		if got := d.UnescapeValue(tc.escaped); got != tc.value {
+			t.Errorf("UnescapeValue(%q) = %q, want %q", tc.escaped, got, tc.value)
+		}
+	}
+	for escaped, want := range map[string]string{
+		`\X414243\`:  "ABC",
+		`\Xzz\`:      `\Xzz\`,
+		`unclosed\F`: `unclosed\F`,
+	} {
+		if got := d.UnescapeValue(escaped); got != want {
+			t.Errorf("UnescapeValue(%q) = %q, want %q", escaped, got, want)
+		}
+	}
+}
+
+var (
+	testTime    = time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	testPatient = Patient{
+		ID:                 "12345",
+		AssigningAuthority: "HOSP",
+		Family:             "Smith",
+		Given:              "Darcy",
+		BirthDate:          time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC),
+		Sex:                "F",
+	}
+)
+
+func TestNewADT(t *testing.T) {
+	m := NewADT(Header{
+		SendingApplication:   "EHR",
+		SendingFacility:      "HOSP",
+		ReceivingApplication: "LAB",
+		ReceivingFacility:    "HOSP",
+		Time:                 testTime,
+		TriggerEvent:         "A01",
+		MessageStructure:     "ADT_A01",
+		ControlID:            "MSG0001",
+	}, testPatient, Visit{Class: "I", PointOfCare: "WARD1", Room: "101", Bed: "A", AttendingDoctorID: "D42", AttendingDoctor: "Who"})
+	want := strings.Join([]string{
+		`MSH|^~\&|EHR|HOSP|LAB|HOSP|20230304050607+0000||ADT^A01^ADT_A01|MSG0001|P|2.5.1`,
+		`EVN|A01|20230304050607+0000`,
+		`PID|1||12345^^^HOSP^MR||Smith^Darcy||19700102|F`,
+		`PV1|1|I|WARD1^101^A||||D42^Who`,
+	}, "\n")
+	if got := m.String(); got != want {
+		t.Errorf("NewADT got\n%s\nwant\n%s", got, want)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	// The built message parses back to the same message.
+	parsed, err := Parse(m.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := parsed.String(); got != want {
+		t.Errorf("Parse(NewADT) got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNewORU(t *testing.T) {
+	m := NewORU(Header{Time: testTime, ControlID: "MSG0002"}, testPatient, Order{
+		FillerOrderNumber: "ORD1",
+		Service:           Code{Code: "24331-1", Text: "Lipid panel", System: "LN"},
+		Time:              testTime,
+	}, []Observation{
+		{Code: Code{"2093-3", "Cholesterol", "LN"}, ValueType: "NM", Value: "180", Units: "mg/dL", ReferenceRange: "<200"},
+		{Code: Code{"8251-1", "Comment", "LN"}, Value: "Fasting | 12h", Status: "P"},
+	})
+	want := strings.Join([]string{
+		`MSH|^~\&|||||20230304050607+0000||ORU^R01^ORU_R01|MSG0002|P|2.5.1`,
+		`PID|1||12345^^^HOSP^MR||Smith^Darcy||19700102|F`,
+		`OBR|1||ORD1|24331-1^Lipid panel^LN|||20230304050607+0000`,
+		`OBX|1|NM|2093-3^Cholesterol^LN||180|mg/dL|<200||||F|||20230304050607+0000`,
+		`OBX|2|ST|8251-1^Comment^LN||Fasting \F\ 12h||||||P|||20230304050607+0000`,
+	}, "\n")
+	if got := m.String(); got != want {
+		t.Errorf("NewORU got\n%s\nwant\n%s", got, want)
+	}
+	if got := m.Get("OBX(2)-5"); got != "Fasting | 12h" {
+		t.Errorf(`Get("OBX(2)-5") = %q, want "Fasting | 12h"`, got)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestAck(t *testing.T) {
+	m := NewADT(Header{
+		SendingApplication:   "EHR",
+		SendingFacility:      "HOSP",
+		ReceivingApplication: "LAB",
+		ReceivingFacility:    "CLINIC",
+		Time:                 testTime,
+		TriggerEvent:         "A04",
+		ControlID:            "MSG0003",
+		ProcessingID:         "T",
+		Version:              "2.3",
+	}, testPatient, Visit{Class: "O"})
+
+	ack, err := m.Ack(ErrorAck, "Unknown ward", "ACK0003", testTime.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	want := strings.Join([]string{
+		`MSH|^~\&|LAB|CLINIC|EHR|HOSP|20230304050608+0000||ACK^A04^ACK|ACK0003|T|2.3`,
+		`MSA|AE|MSG0003|Unknown ward`,
+	}, "\n")
+	if got := ack.String(); got != want {
+		t.Errorf("Ack got\n%s\nwant\n%s", got, want)
+	}
+	if err := ack.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	if _, err := m.Ack("OK", "", "ACK0004", testTime); err == nil {
+		t.Errorf("Ack with an invalid code succeeded, want an error")
+	}
+	if _, err := (&Message{}).Ack(AcceptAck, "", "ACK0005", testTime); err == nil {
+		t.Errorf("Ack of a message without MSH succeeded, want an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for data, want := range map[string]string{
+		"MSH|^~\\&|A|B|C|D|20230101||ADT|1|P|2.5.1\rEVN|A01\r": "MSH-9-2 (trigger event) is empty; ADT message has no PID segment",
+		"MSH|^~\\&|A|B|C|D|20230101||ORU^R01\rPID|1\rOBR|1\r":  "MSH-10 (message control ID) is empty; MSH-11 (processing ID) is empty; MSH-12 (version ID) is empty; ORU message has no OBX segment",
+		"MSH|^~\\&|A|B|C|D|20230101||ACK|1|P|2.5.1\r":          "ACK message has no MSA segment",
+	} {
+		m, err := Parse([]byte(data))
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", data, err)
+		}
+		err = m.Validate()
+		var verr ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("Validate(%q) = %v, want a ValidationError", data, err)
+			continue
+		}
+		if got := strings.Join(verr, "; "); got != want {
+			t.Errorf("Validate(%q) found %q, want %q", data, got, want)
+		}
+	}
+	if err := (&Message{}).Validate(); err == nil {
+		t.Errorf("Validate of an empty message succeeded, want an error")
+	}
+}
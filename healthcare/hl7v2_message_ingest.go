@@ -22,6 +22,7 @@ import (
 	"io"
 	"io/ioutil"
 
+	"github.com/GoogleCloudPlatform/golang-samples/healthcare/hl7v2"
 	healthcare "google.golang.org/api/healthcare/v1"
 )
 
@@ -34,6 +35,24 @@ func ingestHL7V2Message(w io.Writer, projectID, location, datasetID, hl7V2StoreI
 		return fmt.Errorf("ReadFile: %w", err)
 	}
 
+	// Check the message before sending it, and show what it holds.
+	msg, err := hl7v2.Parse(hl7v2message)
+	if err != nil {
+		return fmt.Errorf("hl7v2.Parse: %w", err)
+	}
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Parsed %s^%s message %q:\n", msg.Get("MSH-9-1"), msg.Get("MSH-9-2"), msg.Get("MSH-10"))
+	for _, s := range msg.Segments {
+		for i, f := range s.Fields {
+			if s.Name == "MSH" && i < 2 || len(f) == 0 {
+				continue // Skip the delimiters and empty fields.
+			}
+			fmt.Fprintf(w, "  %s-%d: %s\n", s.Name, i+1, msg.Delimiters.EncodeField(f))
+		}
+	}
+
 	healthcareService, err := healthcare.NewService(ctx)
 	if err != nil {
 		return fmt.Errorf("healthcare.NewService: %w", err)
@@ -54,6 +73,20 @@ func ingestHL7V2Message(w io.Writer, projectID, location, datasetID, hl7V2StoreI
 	}
 
 	fmt.Fprintf(w, "Ingested HL7V2 message: %q\n", resp.Message.Name)
+
+	// The store acknowledges the message with an HL7v2 ACK.
+	if resp.Hl7Ack == "" {
+		return nil
+	}
+	ackData, err := base64.StdEncoding.DecodeString(resp.Hl7Ack)
+	if err != nil {
+		return fmt.Errorf("base64.DecodeString: %w", err)
+	}
+	ack, err := hl7v2.Parse(ackData)
+	if err != nil {
+		return fmt.Errorf("hl7v2.Parse: %w", err)
+	}
+	fmt.Fprintf(w, "Acknowledgment: %s %s\n", ack.Get("MSA-1"), ack.Get("MSA-3"))
 	return nil
 }
 
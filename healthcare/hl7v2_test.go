@@ -83,6 +83,9 @@ func TestHL7V2Store(t *testing.T) {
 		if err := ingestHL7V2Message(buf, tc.ProjectID, location, datasetID, hl7V2StoreID, dataFile); err != nil {
 			r.Errorf("ingestHL7V2Message got err: %v", err)
 		}
+		if got, wantContain := buf.String(), "Parsed TYPE^A message"; !strings.Contains(got, wantContain) {
+			r.Errorf("ingestHL7V2Message got\n----\n%v\n----\nWant to contain:\n----\n%v\n----\n", got, wantContain)
+		}
 		if got, wantContain := buf.String(), messageID; !strings.Contains(got, wantContain) {
 			r.Errorf("ingestHL7V2Message got\n----\n%v\n----\nWant to contain:\n----\n%v\n----\n", got, wantContain)
 		}
@@ -132,3 +135,25 @@ func TestHL7V2Store(t *testing.T) {
 		}
 	})
 }
+
+// TestIngestHL7V2MessageInvalid checks that invalid messages are rejected
+// before they are sent, so it needs no HL7V2 store.
+func TestIngestHL7V2MessageInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"no MSH":           "PID|1||12345\r",
+		"no control ID":    "MSH|^~\\&|A|B|C|D|20230101||ADT^A01||P|2.5.1\rEVN|A01\rPID|1\r",
+		"no PID in ADT":    "MSH|^~\\&|A|B|C|D|20230101||ADT^A01|1|P|2.5.1\rEVN|A01\r",
+		"bad delimiters":   "MSH|^^\\&|A\r",
+		"bad segment name": "MSH|^~\\&|A|B|C|D|20230101||ADT^A01|1|P|2.5.1\rpid|1\r",
+	} {
+		f, err := ioutil.TempFile(t.TempDir(), "message")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(data)
+		f.Close()
+		if err := ingestHL7V2Message(ioutil.Discard, "project", "location", "dataset", "store", f.Name()); err == nil || !strings.HasPrefix(err.Error(), "hl7v2") {
+			t.Errorf("ingestHL7V2Message(%s) got err %v, want an hl7v2 error", name, err)
+		}
+	}
+}
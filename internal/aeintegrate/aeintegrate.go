@@ -41,6 +41,9 @@
 //		resp, err := app.Get("/")
 //		...
 //	}
+//
+// Use AppSet to deploy several services together with dispatch.yaml,
+// cron.yaml or queue.yaml, and to route a share of traffic to them.
 package aeintegrate
 
 import (
@@ -81,6 +84,9 @@ type App struct {
 	// The service/module to deploy to. Read only.
 	Service string
 
+	// Whether the app runs in the flexible environment (env: flex). Read only.
+	Flexible bool
+
 	// Additional runtime environment variable overrides for the app.
 	Env map[string]string
 
@@ -128,6 +134,38 @@ func (p *App) version() string {
 	return p.Name + "-" + runID
 }
 
+// WaitHealthy polls path on the deployed version until it responds with
+// 200 OK or timeout passes. Flexible apps take several minutes to become
+// ready after deploying. For flexible apps, an empty path checks
+// "/_ah/health". Standard apps do not serve it, so they need a path.
+func (p *App) WaitHealthy(path string, timeout time.Duration) error {
+	if !p.deployed {
+		return errors.New("WaitHealthy called before Deploy")
+	}
+	if path == "" {
+		if !p.Flexible {
+			return errors.New("WaitHealthy needs a path for standard apps")
+		}
+		path = "/_ah/health"
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := p.Get(path)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				log.Printf("(%s) Healthy.", p.Name)
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not healthy after %v: %w", path, timeout, err)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
 // Deploy deploys the application to App Engine. If the deployment fails, it tries to clean up the failed deployment.
 func (p *App) Deploy() error {
 	// Don't deploy unless we're certain everything is ready for deployment
@@ -239,12 +277,15 @@ func (p *App) envAppYaml() (string, error) {
 	return p.tempAppYaml, nil
 }
 
-func (p *App) deployCmd() (*exec.Cmd, error) {
-	gcloudBin := os.Getenv("GCLOUD_BIN")
-	if gcloudBin == "" {
-		gcloudBin = "gcloud"
+// gcloudBin returns the path to the gcloud executable.
+func gcloudBin() string {
+	if bin := os.Getenv("GCLOUD_BIN"); bin != "" {
+		return bin
 	}
+	return "gcloud"
+}
 
+func (p *App) deployCmd() (*exec.Cmd, error) {
 	appYaml, err := p.envAppYaml()
 	if err != nil {
 		return nil, err
@@ -253,7 +294,7 @@ func (p *App) deployCmd() (*exec.Cmd, error) {
 	// NOTE: if the "app" component is not available, and this is run in parallel,
 	// gcloud will attempt to install those components multiple
 	// times and will eventually fail on IO.
-	cmd := exec.Command(gcloudBin,
+	cmd := exec.Command(gcloudBin(),
 		"--quiet",
 		"app", "deploy", appYaml,
 		"--project", p.ProjectID,
@@ -263,12 +304,8 @@ func (p *App) deployCmd() (*exec.Cmd, error) {
 	return cmd, nil
 }
 
-// readService reads the service out of the app.yaml file.
+// readService reads the service and environment out of the app.yaml file.
 func (p *App) readService() error {
-	if p.Service != "" {
-		return nil
-	}
-
 	b, err := os.ReadFile(filepath.Join(p.Dir, p.appYaml()))
 	if err != nil {
 		return err
@@ -276,6 +313,7 @@ func (p *App) readService() error {
 
 	var s struct {
 		Service string `yaml:"service"`
+		Env     string `yaml:"env"`
 	}
 	if err := yaml.Unmarshal(b, &s); err != nil {
 		return err
@@ -285,7 +323,10 @@ func (p *App) readService() error {
 		s.Service = "default"
 	}
 
-	p.Service = s.Service
+	if p.Service == "" {
+		p.Service = s.Service
+	}
+	p.Flexible = s.Env == "flex" || s.Env == "flexible"
 	return nil
 }
 
@@ -0,0 +1,398 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeintegrate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+// AppSet describes several App Engine services that are deployed and torn
+// down together, along with the app-level configuration they depend on.
+//
+//	set := &aeintegrate.AppSet{
+//		ProjectID:       projectID,
+//		Dir:             "multi-service",
+//		Apps:            []*aeintegrate.App{{Name: "fe", Dir: "multi-service/frontend"}, {Name: "be", Dir: "multi-service/backend"}},
+//		ConfigFiles:     []string{"dispatch.yaml", "cron.yaml"},
+//		HealthCheckPath: "/",
+//	}
+//	if err := set.Deploy(); err != nil {
+//		t.Fatalf("could not deploy: %v", err)
+//	}
+//	defer set.Cleanup()
+type AppSet struct {
+	// The project to deploy to. Overrides the ProjectID of each app.
+	ProjectID string
+
+	// The apps to deploy, one per service.
+	Apps []*App
+
+	// The directory containing ConfigFiles.
+	Dir string
+
+	// App-level configuration files, relative to Dir: "dispatch.yaml",
+	// "cron.yaml", "queue.yaml" or "dos.yaml". They are deployed after every
+	// app. Cleanup restores the dispatch rules from before Deploy, and
+	// replaces the others with empty files, as App Engine cannot return
+	// them, so the project must not depend on cron jobs, queues or DoS rules
+	// of its own.
+	ConfigFiles []string
+
+	// TrafficPercent is the percentage of each service's traffic to route to
+	// the test version, between 0 and 100. The remainder stays on the versions
+	// serving before Deploy. Zero leaves traffic unchanged.
+	TrafficPercent int
+
+	// HealthCheckPath is polled on every app after deploying. It is
+	// required unless every app is flexible, as standard apps do not serve
+	// the default, "/_ah/health".
+	HealthCheckPath string
+
+	// HealthCheckTimeout is how long to wait for each app to become healthy.
+	// Defaults to 10 minutes for flexible apps and 2 minutes otherwise.
+	// A negative value skips the health check.
+	HealthCheckTimeout time.Duration
+
+	// Traffic splits before Deploy changed them, by service.
+	oldSplits map[string]*appengine.TrafficSplit
+
+	// Whether Deploy deployed the configuration files, and the dispatch
+	// rules before it did.
+	configDeployed bool
+	oldDispatch    []*appengine.UrlDispatchRule
+}
+
+// emptyConfigs are the contents of the configuration files Cleanup deploys
+// in place of the ones Deploy deployed, by file name.
+var emptyConfigs = map[string]string{
+	"cron.yaml":  "cron: []\n",
+	"queue.yaml": "queue: []\n",
+	"dos.yaml":   "blacklist: []\n",
+}
+
+// Deploy deploys every app in parallel, then the configuration files, waits
+// for every app to become healthy and routes TrafficPercent to them.
+// If any step fails, everything deployed so far is cleaned up.
+func (s *AppSet) Deploy() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	for _, app := range s.Apps {
+		app.ProjectID = s.ProjectID
+		wg.Add(1)
+		go func(app *App) {
+			defer wg.Done()
+			if err := app.Deploy(); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", app.Name, err))
+				mu.Unlock()
+			}
+		}(app)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return s.abort(fmt.Errorf("deploy: %s", strings.Join(errs, "; ")))
+	}
+
+	if len(s.ConfigFiles) > 0 {
+		app, err := s.Apps[0].adminService.Apps.Get(s.ProjectID).Do()
+		if err != nil {
+			return s.abort(fmt.Errorf("get dispatch rules: %w", err))
+		}
+		s.oldDispatch = app.DispatchRules
+		// The files may be partly deployed even if the command fails.
+		s.configDeployed = true
+		log.Printf("Deploying %s...", strings.Join(s.ConfigFiles, ", "))
+		out, err := s.configCmd().CombinedOutput()
+		if err != nil {
+			os.Stderr.Write(out)
+			return s.abort(fmt.Errorf("deploy %s: %w", strings.Join(s.ConfigFiles, ", "), err))
+		}
+	}
+
+	for _, app := range s.Apps {
+		if s.HealthCheckTimeout < 0 {
+			break
+		}
+		if err := app.WaitHealthy(s.HealthCheckPath, s.healthCheckTimeout(app)); err != nil {
+			return s.abort(fmt.Errorf("%s: %w", app.Name, err))
+		}
+	}
+
+	if s.TrafficPercent > 0 {
+		if err := s.splitTraffic(); err != nil {
+			return s.abort(err)
+		}
+	}
+	return nil
+}
+
+// abort cleans up after a failed Deploy and returns err.
+func (s *AppSet) abort(err error) error {
+	if cerr := s.Cleanup(); cerr != nil {
+		log.Printf("Cleanup after failed deploy: %v", cerr)
+	}
+	return err
+}
+
+// Cleanup restores the traffic splits and configuration changed by Deploy
+// and deletes the version of every app. It continues past errors and
+// returns all of them.
+func (s *AppSet) Cleanup() error {
+	var errs []string
+	if err := s.restoreTraffic(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := s.restoreConfig(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, app := range s.Apps {
+		// Apps that failed to deploy have already cleaned up after themselves.
+		if !app.deployed {
+			continue
+		}
+		if err := app.Cleanup(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", app.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cleanup: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *AppSet) validate() error {
+	if s.ProjectID == "" {
+		return errors.New("Project ID missing")
+	}
+	if len(s.Apps) == 0 {
+		return errors.New("no apps to deploy")
+	}
+	if s.TrafficPercent < 0 || s.TrafficPercent > 100 {
+		return fmt.Errorf("TrafficPercent %d out of range [0, 100]", s.TrafficPercent)
+	}
+	for _, f := range s.ConfigFiles {
+		if _, ok := emptyConfigs[filepath.Base(f)]; !ok && filepath.Base(f) != "dispatch.yaml" {
+			return fmt.Errorf("config file %s cannot be cleaned up", f)
+		}
+	}
+	services := make(map[string]string)
+	for _, app := range s.Apps {
+		app.ProjectID = s.ProjectID
+		if err := app.readService(); err != nil {
+			return fmt.Errorf("%s: could not read service: %w", app.Name, err)
+		}
+		if other, ok := services[app.Service]; ok {
+			return fmt.Errorf("apps %s and %s both deploy service %q", other, app.Name, app.Service)
+		}
+		services[app.Service] = app.Name
+		if s.HealthCheckPath == "" && s.HealthCheckTimeout >= 0 && !app.Flexible {
+			return fmt.Errorf("%s: HealthCheckPath is required for standard apps", app.Name)
+		}
+	}
+	return nil
+}
+
+func (s *AppSet) healthCheckTimeout(app *App) time.Duration {
+	if s.HealthCheckTimeout != 0 {
+		return s.HealthCheckTimeout
+	}
+	if app.Flexible {
+		return 10 * time.Minute
+	}
+	return 2 * time.Minute
+}
+
+func (s *AppSet) configCmd() *exec.Cmd {
+	args := append([]string{"--quiet", "app", "deploy"}, s.ConfigFiles...)
+	args = append(args, "--project", s.ProjectID)
+	cmd := exec.Command(gcloudBin(), args...)
+	cmd.Dir = s.Dir
+	return cmd
+}
+
+// splitTraffic routes TrafficPercent of each service's traffic to its test
+// version, scaling down the versions already serving.
+func (s *AppSet) splitTraffic() error {
+	if s.oldSplits == nil {
+		s.oldSplits = make(map[string]*appengine.TrafficSplit)
+	}
+	for _, app := range s.Apps {
+		svc, err := app.adminService.Apps.Services.Get(s.ProjectID, app.Service).Do()
+		if err != nil {
+			return fmt.Errorf("get service %s: %w", app.Service, err)
+		}
+		s.oldSplits[app.Service] = svc.Split
+
+		split := newSplit(svc.Split, app.version(), s.TrafficPercent)
+		log.Printf("(%s) Routing %d%% of traffic to %s.", app.Name, s.TrafficPercent, app.version())
+		if err := setSplit(app.adminService, s.ProjectID, app.Service, split); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreConfig restores the dispatch rules saved by Deploy, and replaces
+// the other configuration files it deployed with empty ones.
+func (s *AppSet) restoreConfig() error {
+	if !s.configDeployed {
+		return nil
+	}
+	var errs []string
+	dir, err := os.MkdirTemp("", "aeintegrate")
+	if err != nil {
+		return fmt.Errorf("restore config: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	for _, f := range s.ConfigFiles {
+		name := filepath.Base(f)
+		if name == "dispatch.yaml" {
+			if err := setDispatch(s.Apps[0].adminService, s.ProjectID, s.oldDispatch); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(emptyConfigs[name]), 0644); err != nil {
+			return fmt.Errorf("restore config: %w", err)
+		}
+		files = append(files, name)
+	}
+	if len(files) > 0 {
+		log.Printf("Deploying empty %s...", strings.Join(files, ", "))
+		empty := &AppSet{ProjectID: s.ProjectID, Dir: dir, ConfigFiles: files}
+		if out, err := empty.configCmd().CombinedOutput(); err != nil {
+			os.Stderr.Write(out)
+			errs = append(errs, fmt.Sprintf("deploy empty %s: %v", strings.Join(files, ", "), err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	s.configDeployed = false
+	return nil
+}
+
+func setDispatch(admin *appengine.APIService, projectID string, rules []*appengine.UrlDispatchRule) error {
+	app := &appengine.Application{DispatchRules: rules, ForceSendFields: []string{"DispatchRules"}}
+	op, err := admin.Apps.Patch(projectID, app).UpdateMask("dispatchRules").Do()
+	if err != nil {
+		return fmt.Errorf("restore dispatch rules: %w", err)
+	}
+	if err := wait(admin, projectID, op); err != nil {
+		return fmt.Errorf("restore dispatch rules: %w", err)
+	}
+	return nil
+}
+
+// restoreTraffic restores the splits saved by splitTraffic. A version
+// cannot be deleted while it serves traffic.
+func (s *AppSet) restoreTraffic() error {
+	var errs []string
+	for _, app := range s.Apps {
+		old, ok := s.oldSplits[app.Service]
+		if !ok || old == nil {
+			continue
+		}
+		if err := setSplit(app.adminService, s.ProjectID, app.Service, old); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		delete(s.oldSplits, app.Service)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// newSplit returns a split sending percent of traffic to version and the
+// rest to the versions of old, in their existing proportions.
+func newSplit(old *appengine.TrafficSplit, version string, percent int) *appengine.TrafficSplit {
+	share := float64(percent) / 100
+	split := &appengine.TrafficSplit{
+		ShardBy:     "RANDOM",
+		Allocations: map[string]float64{version: share},
+	}
+	if old == nil {
+		split.Allocations[version] = 1
+		return split
+	}
+	var total float64
+	for v, a := range old.Allocations {
+		if v != version {
+			total += a
+		}
+	}
+	if total == 0 {
+		split.Allocations[version] = 1
+		return split
+	}
+	for v, a := range old.Allocations {
+		if v != version {
+			split.Allocations[v] = (1 - share) * a / total
+		}
+	}
+	return split
+}
+
+func setSplit(admin *appengine.APIService, projectID, service string, split *appengine.TrafficSplit) error {
+	op, err := admin.Apps.Services.Patch(projectID, service, &appengine.Service{Split: split}).UpdateMask("split").Do()
+	if err != nil {
+		return fmt.Errorf("set traffic split for %s: %w", service, err)
+	}
+	if err := wait(admin, projectID, op); err != nil {
+		return fmt.Errorf("set traffic split for %s: %w", service, err)
+	}
+	return nil
+}
+
+// wait polls op for up to two minutes, until it is done.
+func wait(admin *appengine.APIService, projectID string, op *appengine.Operation) error {
+	var err error
+	for try := 0; !op.Done && try < 60; try++ {
+		time.Sleep(2 * time.Second)
+		if op, err = admin.Apps.Operations.Get(projectID, operationID(op.Name)).Do(); err != nil {
+			return err
+		}
+	}
+	if op.Error != nil {
+		return errors.New(op.Error.Message)
+	}
+	return nil
+}
+
+// operationID returns the last element of an operation name such as
+// "apps/my-project/operations/1234".
+func operationID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
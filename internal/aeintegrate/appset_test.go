@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeintegrate
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appengine "google.golang.org/api/appengine/v1"
+)
+
+func TestNewSplit(t *testing.T) {
+	old := &appengine.TrafficSplit{Allocations: map[string]float64{"v1": 0.75, "v2": 0.25}}
+	split := newSplit(old, "test", 20)
+
+	want := map[string]float64{"test": 0.2, "v1": 0.6, "v2": 0.2}
+	for v, a := range want {
+		if got := split.Allocations[v]; math.Abs(got-a) > 1e-9 {
+			t.Errorf("newSplit: %s got %v, want %v", v, got, a)
+		}
+	}
+	if len(split.Allocations) != len(want) {
+		t.Errorf("newSplit: got %v, want %v", split.Allocations, want)
+	}
+
+	if got := newSplit(nil, "test", 20).Allocations["test"]; got != 1 {
+		t.Errorf("newSplit(nil): got %v for test version, want 1", got)
+	}
+}
+
+func writeAppYaml(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestAppSetValidate(t *testing.T) {
+	flex := &App{Name: "fe", Dir: writeAppYaml(t, "runtime: go\nenv: flex\n")}
+	backend := &App{Name: "be", Dir: writeAppYaml(t, "runtime: go121\nservice: backend\n")}
+	set := &AppSet{ProjectID: "my-project", Apps: []*App{flex, backend}}
+	if err := set.validate(); err == nil || !strings.Contains(err.Error(), "HealthCheckPath") {
+		t.Errorf("validate: got %v, want missing HealthCheckPath error", err)
+	}
+	set.HealthCheckPath = "/"
+	if err := set.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if flex.Service != "default" || !flex.Flexible {
+		t.Errorf("flex app: got service %q, flexible %v; want default, true", flex.Service, flex.Flexible)
+	}
+	if backend.Service != "backend" || backend.Flexible {
+		t.Errorf("backend app: got service %q, flexible %v; want backend, false", backend.Service, backend.Flexible)
+	}
+	if backend.ProjectID != "my-project" {
+		t.Errorf("backend app: got project %q, want my-project", backend.ProjectID)
+	}
+
+	dup := &App{Name: "dup", Dir: writeAppYaml(t, "runtime: go121\nservice: backend\n")}
+	set.Apps = append(set.Apps, dup)
+	if err := set.validate(); err == nil || !strings.Contains(err.Error(), "both deploy") {
+		t.Errorf("validate: got %v, want duplicate service error", err)
+	}
+
+	set = &AppSet{ProjectID: "my-project", Apps: []*App{flex}, TrafficPercent: 101}
+	if err := set.validate(); err == nil {
+		t.Errorf("validate: expected error for TrafficPercent, got success")
+	}
+
+	set = &AppSet{ProjectID: "my-project", Apps: []*App{flex}}
+	if err := set.validate(); err != nil {
+		t.Errorf("validate of a flexible app without HealthCheckPath: %v", err)
+	}
+	set.ConfigFiles = []string{"sub/dispatch.yaml", "cron.yaml", "index.yaml"}
+	if err := set.validate(); err == nil || !strings.Contains(err.Error(), "index.yaml") {
+		t.Errorf("validate: got %v, want error for index.yaml", err)
+	}
+}
+
+func TestAppSetConfigCmd(t *testing.T) {
+	set := &AppSet{ProjectID: "my-project", Dir: "dir", ConfigFiles: []string{"dispatch.yaml", "cron.yaml"}}
+	cmd := set.configCmd()
+	got := strings.Join(cmd.Args[1:], " ")
+	if want := "--quiet app deploy dispatch.yaml cron.yaml --project my-project"; got != want {
+		t.Errorf("configCmd: got %q, want %q", got, want)
+	}
+	if cmd.Dir != "dir" {
+		t.Errorf("configCmd: got dir %q, want dir", cmd.Dir)
+	}
+}
+
+func TestOperationID(t *testing.T) {
+	if got := operationID("apps/my-project/operations/1234"); got != "1234" {
+		t.Errorf("operationID: got %q, want 1234", got)
+	}
+}
@@ -56,10 +56,11 @@ type Service struct {
 	Dir string
 
 	// The container image name to deploy. If left blank the container will be built
-	// and pushed to gcr.io/[ProjectID]/[Name]:[Revision]
+	// and pushed to gcr.io/[ProjectID]/[Name]:[Revision], or built locally
+	// when using the LocalDockerPlatform.
 	Image string
 
-	// The project to deploy to.
+	// The project to deploy to. Not needed for the LocalDockerPlatform.
 	ProjectID string
 
 	// Allow unauthenticated request.
@@ -77,9 +78,10 @@ type Service struct {
 	// Strictly HTTP/2 serving
 	HTTP2 bool
 
-	deployed bool     // Whether the service has been deployed.
-	built    bool     // Whether the container image has been built.
-	url      *url.URL // The url of the deployed service.
+	deployed  bool     // Whether the service has been deployed.
+	built     bool     // Whether the container image has been built.
+	url       *url.URL // The url of the deployed service.
+	container string   // The local container running the service, if any.
 }
 
 // runID is an identifier that changes between runs.
@@ -210,6 +212,9 @@ func (s *Service) Host() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("service.ParsedURL: %w", err)
 	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
 	return u.Host + ":443", nil
 }
 
@@ -238,12 +243,12 @@ func (s *Service) ParsedURL() (*url.URL, error) {
 
 // validate confirms all required service properties are present.
 func (s *Service) validate() error {
-	if s.ProjectID == "" {
-		return errors.New("Project ID missing")
-	}
 	if s.Platform == nil {
 		return errors.New("Platform configuration missing")
 	}
+	if _, local := localPlatform(s.Platform); !local && s.ProjectID == "" {
+		return errors.New("Project ID missing")
+	}
 	if err := s.Platform.Validate(); err != nil {
 		return err
 	}
@@ -276,6 +281,14 @@ func (s *Service) Deploy() error {
 		}
 	}
 
+	if p, local := localPlatform(s.Platform); local {
+		if err := s.localDeploy(p); err != nil {
+			return err
+		}
+		s.deployed = true
+		return nil
+	}
+
 	if _, err := gcloud(s.operationLabel(labelOperationDeploy), s.deployCmd()); err != nil {
 		return fmt.Errorf("gcloud: %s: %q", s.version(), err)
 	}
@@ -296,6 +309,9 @@ func (s *Service) Build() error {
 	if s.built {
 		return fmt.Errorf("container image already built")
 	}
+	if _, local := localPlatform(s.Platform); local {
+		return s.localBuild()
+	}
 	if s.Image == "" {
 		s.Image = fmt.Sprintf("gcr.io/%s/%s:%s", s.ProjectID, s.Name, runID)
 	}
@@ -317,6 +333,9 @@ func (s *Service) Clean() error {
 	if err := s.validate(); err != nil {
 		return err
 	}
+	if _, local := localPlatform(s.Platform); local {
+		return s.localClean()
+	}
 
 	if _, err := gcloud(s.operationLabel(labelOperationDeleteService), s.deleteServiceCmd()); err != nil {
 		return fmt.Errorf("gcloud: %v: %q", s.version(), err)
@@ -453,7 +472,22 @@ func (s *Service) urlCmd() *exec.Cmd {
 	return cmd
 }
 
+// LogEntries reports whether find appears in a log entry of the service
+// matching filter. On the LocalDockerPlatform filter is ignored and the
+// container's output is searched instead. Use QueryLogs to inspect the
+// fields of structured entries.
 func (s *Service) LogEntries(filter string, find string, maxAttempts int) (bool, error) {
+	if _, local := localPlatform(s.Platform); local {
+		if s.container == "" {
+			return false, errors.New("LogEntries called before Deploy")
+		}
+		out, err := dockerLogs(s.container)
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(string(out), find), nil
+	}
+
 	ctx := context.Background()
 	client, err := logadmin.NewClient(ctx, s.ProjectID)
 	if err != nil {
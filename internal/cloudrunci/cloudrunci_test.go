@@ -17,6 +17,7 @@ package cloudrunci
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 )
@@ -111,6 +112,93 @@ func TestDeployArgs(t *testing.T) {
 	}
 }
 
+func TestLocalServiceValidate(t *testing.T) {
+	// Any executable stands in for docker.
+	oldBin := dockerBin
+	dockerBin = os.Args[0]
+	defer func() { dockerBin = oldBin }()
+
+	service := &Service{Name: "my-service", Platform: LocalDockerPlatform{}}
+	if err := service.validate(); err != nil {
+		t.Errorf("service.validate: got %v, want no error without a project", err)
+	}
+
+	dockerBin = "/does/not/exist/docker"
+	if err := service.validate(); err == nil {
+		t.Errorf("service.validate: expected error for missing docker, got success")
+	}
+}
+
+func TestLocalRunArgs(t *testing.T) {
+	service := &Service{
+		Name:     "my-service",
+		Image:    "my-image",
+		Platform: LocalDockerPlatform{ContainerPort: 9000},
+		Env:      EnvVars{"NAME1": "value1"},
+	}
+
+	cmd := service.localRunCmd(LocalDockerPlatform{ContainerPort: 9000})
+	for _, want := range []string{"PORT=9000", "NAME1=value1", "127.0.0.1::9000", service.version()} {
+		if !contains(cmd.Args, want) {
+			t.Errorf("localRunCmd: %q missing from %q", want, cmd.Args)
+		}
+	}
+	if got := cmd.Args[len(cmd.Args)-1]; got != "my-image" {
+		t.Errorf("localRunCmd: last argument got %q, want image", got)
+	}
+}
+
+func TestLocalServiceHost(t *testing.T) {
+	service := &Service{Name: "my-service", Platform: LocalDockerPlatform{}}
+	service.url = &url.URL{Scheme: "http", Host: "127.0.0.1:49153"}
+	service.deployed = true
+
+	got, err := service.Host()
+	if err != nil {
+		t.Fatalf("service.Host: %v", err)
+	}
+	if want := "127.0.0.1:49153"; got != want {
+		t.Errorf("service.Host: got %s, want %s", got, want)
+	}
+	got, err = service.URL("/handler")
+	if err != nil {
+		t.Fatalf("service.URL: %v", err)
+	}
+	if want := "http://127.0.0.1:49153/handler"; got != want {
+		t.Errorf("service.URL: got %s, want %s", got, want)
+	}
+}
+
+func TestLocalJobTaskArgs(t *testing.T) {
+	job := &Job{
+		Name:     "my-job",
+		Image:    "my-image",
+		Platform: LocalDockerPlatform{},
+		Tasks:    3,
+		Env:      EnvVars{"NAME1": "value1"},
+	}
+
+	cmd := job.localTaskCmd(2, 1)
+	for _, want := range []string{
+		"CLOUD_RUN_TASK_INDEX=2",
+		"CLOUD_RUN_TASK_ATTEMPT=1",
+		"CLOUD_RUN_TASK_COUNT=3",
+		"NAME1=value1",
+		job.taskContainer(2, 1),
+	} {
+		if !contains(cmd.Args, want) {
+			t.Errorf("localTaskCmd: %q missing from %q", want, cmd.Args)
+		}
+	}
+}
+
+func TestJobValidatePlatform(t *testing.T) {
+	job := &Job{Name: "my-job", Platform: ManagedPlatform{Region: "us-central1"}}
+	if err := job.validate(); err == nil {
+		t.Errorf("job.validate: expected error for unsupported platform, got success")
+	}
+}
+
 // contains searches for a string value in a string slice.
 func contains(haystack []string, needle string) bool {
 	for _, i := range haystack {
@@ -70,9 +70,22 @@ type Job struct {
 	// Build this Image as a BuildPack, without using a Dockerfile
 	AsBuildpack bool
 
-	built   bool // True if container image has been built.
-	created bool // True if job has been created.
-	started bool // true if the Job has been started.
+	// Platform, if set to a LocalDockerPlatform, runs the job's tasks as local
+	// containers instead of in Cloud Run Jobs. Other platforms are not supported.
+	Platform Platform
+
+	// Tasks is the number of tasks to run on the LocalDockerPlatform.
+	// Defaults to 1.
+	Tasks int
+
+	// MaxRetries is the number of times a failed task is retried on the
+	// LocalDockerPlatform.
+	MaxRetries int
+
+	built      bool     // True if container image has been built.
+	created    bool     // True if job has been created.
+	started    bool     // true if the Job has been started.
+	containers []string // Local task containers that have been run.
 }
 
 // NewJob creates a new Job to be run with Cloud Run Jobs.
@@ -96,6 +109,15 @@ func (j *Job) CommonGCloudFlags() []string {
 
 // validate confirms all required job properties are present.
 func (j *Job) validate() error {
+	if j.Platform != nil {
+		if _, local := localPlatform(j.Platform); !local {
+			return fmt.Errorf("unsupported platform for jobs: %s", j.Platform.Name())
+		}
+		if err := j.Platform.Validate(); err != nil {
+			return err
+		}
+		return j.Env.Validate()
+	}
 	if j.ProjectID == "" {
 		return errors.New("Project ID missing")
 	}
@@ -130,6 +152,12 @@ func (j *Job) Create() error {
 		}
 	}
 
+	// Local containers are created when the job is run.
+	if _, local := localPlatform(j.Platform); local {
+		j.created = true
+		return nil
+	}
+
 	if _, err := gcloud(fmt.Sprintf("%s: Creating Cloud Run Job", j.version()), j.createCmd()); err != nil {
 		return fmt.Errorf("gcloud: %s: %q", j.version(), err)
 	}
@@ -148,6 +176,9 @@ func (j *Job) Build() error {
 	if j.built {
 		return fmt.Errorf("container image already built")
 	}
+	if _, local := localPlatform(j.Platform); local {
+		return j.localBuild()
+	}
 	if j.Image == "" {
 		j.Image = fmt.Sprintf("gcr.io/%s/%s:%s", j.ProjectID, j.Name, runID)
 	}
@@ -160,8 +191,10 @@ func (j *Job) Build() error {
 	return nil
 }
 
-// Run starts the Job in Cloud Run Jobs.
+// Run starts the Job in Cloud Run Jobs and waits for it to complete.
 // This method will call Build and Create if necessary.
+// On the LocalDockerPlatform, Tasks containers are run in parallel with
+// CLOUD_RUN_TASK_INDEX, CLOUD_RUN_TASK_ATTEMPT and CLOUD_RUN_TASK_COUNT set.
 func (j *Job) Run() error {
 	if err := j.validate(); err != nil {
 		return err
@@ -172,6 +205,9 @@ func (j *Job) Run() error {
 			return err
 		}
 	}
+	if _, local := localPlatform(j.Platform); local {
+		return j.localRun()
+	}
 	if _, err := gcloud(fmt.Sprintf("%s: Running cloud run job", j.version()), j.runCmd()); err != nil {
 		return fmt.Errorf("gcloud: %v: %q", j.version(), err)
 	}
@@ -186,6 +222,9 @@ func (j *Job) Clean() error {
 	if err := j.validate(); err != nil {
 		return err
 	}
+	if _, local := localPlatform(j.Platform); local {
+		return j.localClean()
+	}
 
 	if _, err := gcloud(fmt.Sprintf("%s: Deleting cloud run job", j.version()), j.deleteJobCmd()); err != nil {
 		return fmt.Errorf("gcloud: %v: %q", j.version(), err)
@@ -295,7 +334,19 @@ func (j *Job) deleteJobCmd() *exec.Cmd {
 	return cmd
 }
 
+// LogEntries reports whether find appears in a log entry of the job
+// matching filter. On the LocalDockerPlatform filter is ignored and the
+// output of every task container is searched instead. Use QueryLogs to
+// inspect the fields of structured entries.
 func (j *Job) LogEntries(filter string, find string, maxAttempts int) (bool, error) {
+	if _, local := localPlatform(j.Platform); local {
+		out, err := j.localLogs()
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(string(out), find), nil
+	}
+
 	ctx := context.Background()
 	client, err := logadmin.NewClient(ctx, j.ProjectID)
 	if err != nil {
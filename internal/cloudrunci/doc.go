@@ -87,5 +87,32 @@ Configure the service for deploying to a Cloud Run for Anthos on VMWare cluster:
 		ProjectID: os.Getenv("GOOGLE_CLOUD_PROJECT"),
 		Platform:  cloudrunci.KubernetesPlatform{Kubeconfig: "~/.kubeconfig", Context: "my-cluster"},
 	}
+
+Run the service as a local Docker container, without a project or gcloud:
+
+	myService := &cloudrunci.Service{
+		Name:     "my-service",
+		Dir:      "../my-service",
+		Platform: cloudrunci.LocalDockerPlatform{},
+	}
+
+Run a job's tasks as local Docker containers:
+
+	myJob := &cloudrunci.Job{
+		Name:     "my-job",
+		Dir:      "../my-job",
+		Platform: cloudrunci.LocalDockerPlatform{},
+		Tasks:    3,
+	}
+
+Wait for structured log entries written by the service:
+
+	entries, err := myService.QueryLogs(ctx, cloudrunci.LogQuery{
+		Matchers: []cloudrunci.LogMatcher{
+			cloudrunci.HasSeverity(logging.Error),
+			cloudrunci.JSONPayloadField("component", "arbitrary-property"),
+		},
+		Timeout: 5 * time.Minute,
+	})
 */
 package cloudrunci
@@ -0,0 +1,280 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dockerBin is the path to the docker executable.
+var dockerBin string
+
+func init() {
+	dockerBin = os.Getenv("DOCKER_BIN")
+	if dockerBin == "" {
+		dockerBin = "docker"
+	}
+}
+
+// localPlatform returns the platform as a LocalDockerPlatform, if it is one.
+func localPlatform(p Platform) (LocalDockerPlatform, bool) {
+	switch lp := p.(type) {
+	case LocalDockerPlatform:
+		return lp, true
+	case *LocalDockerPlatform:
+		return *lp, true
+	}
+	return LocalDockerPlatform{}, false
+}
+
+// docker runs a docker command.
+func docker(label string, cmd *exec.Cmd) ([]byte, error) {
+	return gcloudExec("", label, cmd)
+}
+
+// localImage returns the image name used for locally built containers.
+func localImage(name string) string {
+	return "cloudrunci-local/" + strings.ToLower(name) + ":" + runID
+}
+
+func dockerBuildCmd(dir, image string, asBuildpack bool) *exec.Cmd {
+	cmd := exec.Command(dockerBin, "build", "--tag", image, ".")
+	if asBuildpack {
+		cmd = exec.Command("pack", "build", image, "--builder", "gcr.io/buildpacks/builder:v1")
+	}
+	cmd.Dir = dir
+	return cmd
+}
+
+func dockerRemoveImageCmd(image string) *exec.Cmd {
+	return exec.Command(dockerBin, "rmi", "--force", image)
+}
+
+func dockerRemoveContainersCmd(names ...string) *exec.Cmd {
+	return exec.Command(dockerBin, append([]string{"rm", "--force"}, names...)...)
+}
+
+// dockerLogs returns the combined stdout and stderr of a container.
+func dockerLogs(container string) ([]byte, error) {
+	cmd := exec.Command(dockerBin, "logs", container)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("docker logs %s: %w", container, err)
+	}
+	return out, nil
+}
+
+// localBuild builds the service's container image with Docker.
+func (s *Service) localBuild() error {
+	if s.Image == "" {
+		s.Image = localImage(s.Name)
+	}
+	if _, err := docker(s.operationLabel(labelOperationBuild), dockerBuildCmd(s.Dir, s.Image, s.AsBuildpack)); err != nil {
+		return fmt.Errorf("docker: %s: %q", s.Image, err)
+	}
+	s.built = true
+	return nil
+}
+
+func (s *Service) localRunCmd(p LocalDockerPlatform) *exec.Cmd {
+	port := strconv.Itoa(p.port())
+	args := []string{
+		"run",
+		"--detach",
+		"--name", s.version(),
+		"--publish", "127.0.0.1::" + port,
+		"--env", "PORT=" + port,
+		"--env", "K_SERVICE=" + s.Name,
+		"--env", "K_REVISION=" + s.version(),
+	}
+	for k := range s.Env {
+		args = append(args, "--env", s.Env.Variable(k))
+	}
+	args = append(args, s.Image)
+	cmd := exec.Command(dockerBin, args...)
+	cmd.Dir = s.Dir
+	return cmd
+}
+
+// localDeploy runs the service's container and waits for it to accept connections.
+func (s *Service) localDeploy(p LocalDockerPlatform) error {
+	if _, err := docker(s.operationLabel(labelOperationDeploy), s.localRunCmd(p)); err != nil {
+		return fmt.Errorf("docker: %s: %q", s.version(), err)
+	}
+	s.container = s.version()
+
+	out, err := docker(s.operationLabel(labelOperationGetURL), exec.Command(dockerBin, "port", s.container, strconv.Itoa(p.port())+"/tcp"))
+	if err != nil {
+		return fmt.Errorf("docker: %s: %q", s.version(), err)
+	}
+	// docker port may list several bindings, one per line.
+	hostPort := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if hostPort == "" {
+		return fmt.Errorf("docker: %s: no published port", s.version())
+	}
+	s.url = &url.URL{Scheme: "http", Host: hostPort}
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		conn, err := net.DialTimeout("tcp", hostPort, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: container not listening on %s: %w", s.version(), hostPort, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// localClean removes the service's container and any image built for it.
+func (s *Service) localClean() error {
+	if s.container != "" {
+		if _, err := docker(s.operationLabel(labelOperationDeleteService), dockerRemoveContainersCmd(s.container)); err != nil {
+			return fmt.Errorf("docker: %s: %q", s.version(), err)
+		}
+		s.container = ""
+	}
+	s.deployed = false
+	s.url = nil
+
+	if s.built {
+		if _, err := docker(s.operationLabel(labelOperationDeleteImage), dockerRemoveImageCmd(s.Image)); err != nil {
+			return fmt.Errorf("docker: %s: %q", s.version(), err)
+		}
+		s.built = false
+	}
+	return nil
+}
+
+// localBuild builds the job's container image with Docker.
+func (j *Job) localBuild() error {
+	if j.Image == "" {
+		j.Image = localImage(j.Name)
+	}
+	if _, err := docker(fmt.Sprintf("%s: Building image %s", j.version(), j.Image), dockerBuildCmd(j.Dir, j.Image, j.AsBuildpack)); err != nil {
+		return fmt.Errorf("docker: %s: %q", j.Image, err)
+	}
+	j.built = true
+	return nil
+}
+
+// taskContainer returns the name of the container for a task attempt.
+func (j *Job) taskContainer(index, attempt int) string {
+	return fmt.Sprintf("%s-task%d-attempt%d", j.version(), index, attempt)
+}
+
+func (j *Job) localTaskCmd(index, attempt int) *exec.Cmd {
+	tasks := j.taskCount()
+	args := []string{
+		"run",
+		"--name", j.taskContainer(index, attempt),
+		"--env", "CLOUD_RUN_JOB=" + j.version(),
+		"--env", "CLOUD_RUN_EXECUTION=" + j.version() + "-local",
+		"--env", "CLOUD_RUN_TASK_INDEX=" + strconv.Itoa(index),
+		"--env", "CLOUD_RUN_TASK_ATTEMPT=" + strconv.Itoa(attempt),
+		"--env", "CLOUD_RUN_TASK_COUNT=" + strconv.Itoa(tasks),
+	}
+	for k := range j.Env {
+		args = append(args, "--env", j.Env.Variable(k))
+	}
+	args = append(args, j.Image)
+	cmd := exec.Command(dockerBin, args...)
+	cmd.Dir = j.Dir
+	return cmd
+}
+
+func (j *Job) taskCount() int {
+	if j.Tasks < 1 {
+		return 1
+	}
+	return j.Tasks
+}
+
+// localRun runs every task of the job in parallel, retrying each failed task
+// up to MaxRetries times, and waits for all of them to finish.
+func (j *Job) localRun() error {
+	tasks := j.taskCount()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	for i := 0; i < tasks; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			var err error
+			for attempt := 0; attempt <= j.MaxRetries; attempt++ {
+				mu.Lock()
+				j.containers = append(j.containers, j.taskContainer(index, attempt))
+				mu.Unlock()
+				label := fmt.Sprintf("%s: Running task %d, attempt %d", j.version(), index, attempt)
+				if _, err = docker(label, j.localTaskCmd(index, attempt)); err == nil {
+					return
+				}
+			}
+			mu.Lock()
+			failed = append(failed, fmt.Sprintf("task %d: %v", index, err))
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %d of %d tasks failed: %s", j.version(), len(failed), tasks, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// localLogs returns the combined output of every task container that has run.
+func (j *Job) localLogs() ([]byte, error) {
+	var all []byte
+	for _, c := range j.containers {
+		out, err := dockerLogs(c)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, out...)
+	}
+	return all, nil
+}
+
+// localClean removes the job's task containers and any image built for it.
+func (j *Job) localClean() error {
+	if len(j.containers) > 0 {
+		if _, err := docker(fmt.Sprintf("%s: Deleting task containers", j.version()), dockerRemoveContainersCmd(j.containers...)); err != nil {
+			return fmt.Errorf("docker: %v: %q", j.version(), err)
+		}
+		j.containers = nil
+	}
+	j.created = false
+
+	if j.built {
+		if _, err := docker(fmt.Sprintf("%s: Deleting Image %s", j.version(), j.Image), dockerRemoveImageCmd(j.Image)); err != nil {
+			return fmt.Errorf("docker: %v: %q", j.version(), err)
+		}
+		j.built = false
+	}
+	return nil
+}
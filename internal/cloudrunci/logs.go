@@ -0,0 +1,352 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Special fields of structured logs written to stdout, which Cloud Run moves
+// out of the JSON payload and into the log entry.
+// See https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+const (
+	fieldSeverity = "severity"
+	fieldTrace    = "logging.googleapis.com/trace"
+	fieldSpanID   = "logging.googleapis.com/spanId"
+	fieldLabels   = "logging.googleapis.com/labels"
+	fieldTime     = "time"
+)
+
+// LogEntry is a parsed log entry written by a service or job.
+type LogEntry struct {
+	Timestamp time.Time
+	Severity  logging.Severity
+	// Trace is the trace resource name, e.g. "projects/my-project/traces/abc123".
+	Trace  string
+	SpanID string
+	Labels map[string]string
+	// TextPayload is set for unstructured entries.
+	TextPayload string
+	// JSONPayload is set for structured entries.
+	JSONPayload map[string]interface{}
+}
+
+// Field returns the value at the dot-separated path within the JSON payload,
+// e.g. "component" or "httpRequest.status".
+func (e *LogEntry) Field(path string) (interface{}, bool) {
+	var v interface{} = e.JSONPayload
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// LogMatcher reports whether a log entry matches a condition.
+type LogMatcher func(*LogEntry) bool
+
+// HasSeverity matches entries with exactly the given severity.
+func HasSeverity(s logging.Severity) LogMatcher {
+	return func(e *LogEntry) bool {
+		return e.Severity == s
+	}
+}
+
+// HasTrace matches entries correlated with the given trace. The trace may be
+// either a full resource name or a bare trace ID. An empty trace matches any
+// entry with a trace.
+func HasTrace(trace string) LogMatcher {
+	return func(e *LogEntry) bool {
+		if trace == "" {
+			return e.Trace != ""
+		}
+		return e.Trace == trace || strings.HasSuffix(e.Trace, "/traces/"+trace)
+	}
+}
+
+// HasLabel matches entries with the given label.
+func HasLabel(key, value string) LogMatcher {
+	return func(e *LogEntry) bool {
+		v, ok := e.Labels[key]
+		return ok && v == value
+	}
+}
+
+// JSONPayloadField matches entries whose JSON payload has value at the
+// dot-separated path. Values are compared in their formatted form, so
+// JSONPayloadField("httpRequest.status", 200) matches a decoded 200.0.
+func JSONPayloadField(path string, value interface{}) LogMatcher {
+	want := fmt.Sprint(value)
+	return func(e *LogEntry) bool {
+		v, ok := e.Field(path)
+		return ok && fmt.Sprint(v) == want
+	}
+}
+
+// PayloadContains matches entries whose text payload, or JSON payload
+// "message" field, contains s.
+func PayloadContains(s string) LogMatcher {
+	return func(e *LogEntry) bool {
+		if strings.Contains(e.TextPayload, s) {
+			return true
+		}
+		msg, ok := e.Field("message")
+		return ok && strings.Contains(fmt.Sprint(msg), s)
+	}
+}
+
+// LogQuery describes the log entries to wait for.
+type LogQuery struct {
+	// Filter is an additional Cloud Logging filter, ignored by local platforms.
+	Filter string
+	// Matchers must all match for an entry to be returned.
+	Matchers []LogMatcher
+	// MinEntries is the number of matching entries to wait for. Defaults to 1.
+	MinEntries int
+	// Timeout is how long to wait for logs to be ingested. Defaults to 5 minutes.
+	Timeout time.Duration
+	// PollInterval is how long to wait between queries. Defaults to 15 seconds.
+	PollInterval time.Duration
+}
+
+func (q LogQuery) match(e *LogEntry) bool {
+	for _, m := range q.Matchers {
+		if !m(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// logSource lists the log entries of a service or job.
+type logSource interface {
+	Entries(ctx context.Context, filter string) ([]*LogEntry, error)
+}
+
+// ErrLogsNotFound is returned by QueryLogs when too few matching entries
+// were found before the timeout.
+var ErrLogsNotFound = errors.New("matching log entries not found")
+
+// waitForLogs polls src until q.MinEntries entries match or q.Timeout passes,
+// as entries can take minutes to become visible in Cloud Logging.
+// The entries found so far are returned with any error.
+func waitForLogs(ctx context.Context, src logSource, q LogQuery) ([]*LogEntry, error) {
+	if q.MinEntries < 1 {
+		q.MinEntries = 1
+	}
+	if q.Timeout == 0 {
+		q.Timeout = 5 * time.Minute
+	}
+	if q.PollInterval == 0 {
+		q.PollInterval = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, q.Timeout)
+	defer cancel()
+
+	var matched []*LogEntry
+	for attempt := 1; ; attempt++ {
+		entries, err := src.Entries(ctx, q.Filter)
+		if err != nil && ctx.Err() == nil {
+			return matched, err
+		}
+		matched = matched[:0]
+		for _, e := range entries {
+			if q.match(e) {
+				matched = append(matched, e)
+			}
+		}
+		if len(matched) >= q.MinEntries {
+			return matched, nil
+		}
+		log.Printf("Attempt #%d: found %d of %d matching log entries", attempt, len(matched), q.MinEntries)
+
+		t := time.NewTimer(q.PollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return matched, fmt.Errorf("%w: found %d of %d after %v", ErrLogsNotFound, len(matched), q.MinEntries, q.Timeout)
+		case <-t.C:
+		}
+	}
+}
+
+// cloudLogSource reads entries from Cloud Logging.
+type cloudLogSource struct {
+	projectID string
+	// resource is a filter selecting the service or job's entries.
+	resource string
+}
+
+func (c cloudLogSource) Entries(ctx context.Context, filter string) ([]*LogEntry, error) {
+	client, err := logadmin.NewClient(ctx, c.projectID)
+	if err != nil {
+		return nil, fmt.Errorf("logadmin.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	var entries []*LogEntry
+	it := client.Entries(ctx, logadmin.Filter(c.resource+" "+filter))
+	for {
+		entry, err := it.Next()
+		if err == iterator.Done {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, fmt.Errorf("it.Next: %w", err)
+		}
+		entries = append(entries, fromLoggingEntry(entry))
+	}
+}
+
+// fromLoggingEntry converts a Cloud Logging entry.
+func fromLoggingEntry(entry *logging.Entry) *LogEntry {
+	e := &LogEntry{
+		Timestamp: entry.Timestamp,
+		Severity:  entry.Severity,
+		Trace:     entry.Trace,
+		SpanID:    entry.SpanID,
+		Labels:    entry.Labels,
+	}
+	switch p := entry.Payload.(type) {
+	case string:
+		e.TextPayload = p
+	case *structpb.Struct:
+		e.JSONPayload = p.AsMap()
+	default:
+		e.TextPayload = fmt.Sprint(p)
+	}
+	return e
+}
+
+// localLogSource parses the stdout of local containers the way Cloud Run
+// does: JSON lines become structured entries and other lines become text
+// entries.
+type localLogSource struct {
+	output func() ([]byte, error)
+}
+
+func (l localLogSource) Entries(ctx context.Context, filter string) ([]*LogEntry, error) {
+	out, err := l.output()
+	if err != nil {
+		return nil, err
+	}
+	return parseLocalLogs(out), nil
+}
+
+// parseLocalLogs parses container output into one entry per line.
+func parseLocalLogs(out []byte) []*LogEntry {
+	var entries []*LogEntry
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		entries = append(entries, parseLocalLine(line))
+	}
+	return entries
+}
+
+func parseLocalLine(line string) *LogEntry {
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &payload); err != nil {
+		return &LogEntry{TextPayload: line}
+	}
+	e := &LogEntry{JSONPayload: payload}
+	if s, ok := payload[fieldSeverity].(string); ok {
+		e.Severity = logging.ParseSeverity(s)
+		delete(payload, fieldSeverity)
+	}
+	if s, ok := payload[fieldTrace].(string); ok {
+		e.Trace = s
+		delete(payload, fieldTrace)
+	}
+	if s, ok := payload[fieldSpanID].(string); ok {
+		e.SpanID = s
+		delete(payload, fieldSpanID)
+	}
+	if m, ok := payload[fieldLabels].(map[string]interface{}); ok {
+		e.Labels = make(map[string]string, len(m))
+		for k, v := range m {
+			e.Labels[k] = fmt.Sprint(v)
+		}
+		delete(payload, fieldLabels)
+	}
+	if s, ok := payload[fieldTime].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			e.Timestamp = t
+			delete(payload, fieldTime)
+		}
+	}
+	return e
+}
+
+// QueryLogs waits for log entries of the deployed service that match q.
+// On the LocalDockerPlatform, the container's output is parsed instead of
+// querying Cloud Logging.
+func (s *Service) QueryLogs(ctx context.Context, q LogQuery) ([]*LogEntry, error) {
+	return waitForLogs(ctx, s.logSource(), q)
+}
+
+func (s *Service) logSource() logSource {
+	if _, local := localPlatform(s.Platform); local {
+		return localLogSource{output: func() ([]byte, error) {
+			if s.container == "" {
+				return nil, errors.New("QueryLogs called before Deploy")
+			}
+			return dockerLogs(s.container)
+		}}
+	}
+	return cloudLogSource{
+		projectID: s.ProjectID,
+		resource:  fmt.Sprintf(`resource.type="cloud_run_revision" resource.labels.service_name="%s"`, s.version()),
+	}
+}
+
+// QueryLogs waits for log entries of the job that match q.
+// On the LocalDockerPlatform, the output of the task containers is parsed
+// instead of querying Cloud Logging.
+func (j *Job) QueryLogs(ctx context.Context, q LogQuery) ([]*LogEntry, error) {
+	return waitForLogs(ctx, j.logSource(), q)
+}
+
+func (j *Job) logSource() logSource {
+	if _, local := localPlatform(j.Platform); local {
+		return localLogSource{output: j.localLogs}
+	}
+	return cloudLogSource{
+		projectID: j.ProjectID,
+		resource:  fmt.Sprintf(`resource.type="cloud_run_job" resource.labels.job_name="%s"`, j.version()),
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudrunci
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+const testContainerOutput = `Listening on port 8080
+{"severity":"ERROR","message":"lookup failed","component":"arbitrary-property","logging.googleapis.com/trace":"projects/my-project/traces/abc123","logging.googleapis.com/labels":{"requestId":"r1"},"httpRequest":{"status":500}}
+{"severity":"info","message":"request served","component":"arbitrary-property"}
+
+not {json
+`
+
+func TestParseLocalLogs(t *testing.T) {
+	entries := parseLocalLogs([]byte(testContainerOutput))
+	if len(entries) != 4 {
+		t.Fatalf("parseLocalLogs: got %d entries, want 4", len(entries))
+	}
+	if got := entries[0].TextPayload; got != "Listening on port 8080" {
+		t.Errorf("entries[0].TextPayload: got %q", got)
+	}
+
+	e := entries[1]
+	if e.Severity != logging.Error {
+		t.Errorf("entries[1].Severity: got %v, want %v", e.Severity, logging.Error)
+	}
+	if e.Trace != "projects/my-project/traces/abc123" {
+		t.Errorf("entries[1].Trace: got %q", e.Trace)
+	}
+	if e.Labels["requestId"] != "r1" {
+		t.Errorf("entries[1].Labels: got %v", e.Labels)
+	}
+	if _, ok := e.JSONPayload[fieldTrace]; ok {
+		t.Errorf("entries[1].JSONPayload: special field %q not removed", fieldTrace)
+	}
+	if entries[2].Severity != logging.Info {
+		t.Errorf("entries[2].Severity: got %v, want %v", entries[2].Severity, logging.Info)
+	}
+	if got := entries[3].TextPayload; got != "not {json" {
+		t.Errorf("entries[3].TextPayload: got %q", got)
+	}
+}
+
+func TestLogMatchers(t *testing.T) {
+	e := parseLocalLogs([]byte(testContainerOutput))[1]
+
+	tests := []struct {
+		name    string
+		matcher LogMatcher
+		want    bool
+	}{
+		{"severity", HasSeverity(logging.Error), true},
+		{"wrong severity", HasSeverity(logging.Info), false},
+		{"trace id", HasTrace("abc123"), true},
+		{"trace name", HasTrace("projects/my-project/traces/abc123"), true},
+		{"any trace", HasTrace(""), true},
+		{"wrong trace", HasTrace("xyz"), false},
+		{"label", HasLabel("requestId", "r1"), true},
+		{"wrong label", HasLabel("requestId", "r2"), false},
+		{"field", JSONPayloadField("component", "arbitrary-property"), true},
+		{"nested number", JSONPayloadField("httpRequest.status", 500), true},
+		{"missing field", JSONPayloadField("httpRequest.method", "GET"), false},
+		{"message", PayloadContains("lookup"), true},
+	}
+	for _, tc := range tests {
+		if got := tc.matcher(e); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if HasTrace("")(&LogEntry{}) {
+		t.Errorf("HasTrace(\"\"): matched an entry without a trace")
+	}
+}
+
+// fakeLogSource returns more entries on each call, like eventually
+// consistent log ingestion.
+type fakeLogSource struct {
+	entries []*LogEntry
+	calls   int
+}
+
+func (f *fakeLogSource) Entries(ctx context.Context, filter string) ([]*LogEntry, error) {
+	f.calls++
+	if f.calls > len(f.entries) {
+		return f.entries, nil
+	}
+	return f.entries[:f.calls], nil
+}
+
+func TestWaitForLogs(t *testing.T) {
+	src := &fakeLogSource{entries: parseLocalLogs([]byte(testContainerOutput))}
+	q := LogQuery{
+		Matchers:     []LogMatcher{JSONPayloadField("component", "arbitrary-property")},
+		MinEntries:   2,
+		PollInterval: time.Millisecond,
+		Timeout:      time.Second,
+	}
+	got, err := waitForLogs(context.Background(), src, q)
+	if err != nil {
+		t.Fatalf("waitForLogs: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("waitForLogs: got %d entries, want 2", len(got))
+	}
+	if src.calls != 3 {
+		t.Errorf("waitForLogs: got %d queries, want 3", src.calls)
+	}
+
+	q.Matchers = []LogMatcher{HasSeverity(logging.Critical)}
+	q.Timeout = 20 * time.Millisecond
+	if _, err := waitForLogs(context.Background(), src, q); !errors.Is(err, ErrLogsNotFound) {
+		t.Errorf("waitForLogs: got error %v, want ErrLogsNotFound", err)
+	}
+}
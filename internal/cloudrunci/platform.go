@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os/exec"
 )
 
 // Platform describes how platforms are defined.
@@ -126,3 +127,38 @@ func (p KubernetesPlatform) Validate() error {
 func (p KubernetesPlatform) CommandFlags() []string {
 	return []string{"--platform", "gke", "--kubeconfig", p.Kubeconfig, "--context", p.Context}
 }
+
+// LocalDockerPlatform runs services and jobs as local Docker containers
+// instead of deploying them to Cloud Run. No Google Cloud project or gcloud
+// installation is needed.
+type LocalDockerPlatform struct {
+	platformBase
+	// ContainerPort is the port the container listens on, passed as $PORT.
+	// Defaults to 8080.
+	ContainerPort int
+}
+
+// Name retrieves the ID for the local Docker platform.
+func (p LocalDockerPlatform) Name() string {
+	return "local-docker"
+}
+
+// Validate confirms the docker executable can be found.
+func (p LocalDockerPlatform) Validate() error {
+	if _, err := exec.LookPath(dockerBin); err != nil {
+		return fmt.Errorf("docker not found: %w", err)
+	}
+	return nil
+}
+
+// CommandFlags returns no flags, as the local Docker platform does not use gcloud.
+func (p LocalDockerPlatform) CommandFlags() []string {
+	return nil
+}
+
+func (p LocalDockerPlatform) port() int {
+	if p.ContainerPort == 0 {
+		return 8080
+	}
+	return p.ContainerPort
+}
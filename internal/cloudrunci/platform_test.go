@@ -63,3 +63,19 @@ func TestKubernetesPlatformRequest(t *testing.T) {
 		t.Errorf("KubernetesPlatform.Request: unexpected authentication header: %q", err)
 	}
 }
+
+func TestLocalDockerPlatformRequest(t *testing.T) {
+	p := cloudrunci.LocalDockerPlatform{}
+
+	req, err := p.NewRequest("GET", "http://127.0.0.1:8080")
+	if err != nil {
+		t.Errorf("LocalDockerPlatform.Request: %q", err)
+	}
+	authzHeader := req.Header.Get("Authorization")
+	if authzHeader != "" {
+		t.Errorf("LocalDockerPlatform.Request: unexpected authentication header: %q", authzHeader)
+	}
+	if flags := p.CommandFlags(); len(flags) != 0 {
+		t.Errorf("LocalDockerPlatform.CommandFlags: got %q, want none", flags)
+	}
+}
@@ -0,0 +1,1093 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// FakeStorage is an in-process fake of the Cloud Storage JSON and XML APIs.
+// It supports bucket CRUD, object upload and download with generations and
+// preconditions, listing with prefix and delimiter, object holds and bucket
+// retention policies. Point a client at it with ClientOptions or NewClient:
+//
+//	fake := testutil.NewFakeStorage()
+//	defer fake.Close()
+//	client, err := fake.NewClient(ctx)
+type FakeStorage struct {
+	// URL is the base URL of the fake server, such as http://127.0.0.1:1234.
+	URL string
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+	uploads map[string]*fakeUpload
+	lastGen int64
+}
+
+type fakeBucket struct {
+	project string
+	attrs   raw.Bucket
+	// objects holds every generation of each object in ascending order.
+	// The last generation is live unless it has a TimeDeleted.
+	objects map[string][]*fakeObject
+}
+
+type fakeObject struct {
+	attrs raw.Object
+	data  []byte
+	// retentionStart is when the bucket retention period starts counting,
+	// which is the creation time or when the event-based hold was released.
+	retentionStart time.Time
+}
+
+type fakeUpload struct {
+	bucket string
+	attrs  raw.Object
+	conds  fakeConditions
+	data   []byte
+}
+
+// fakeError is an error with an HTTP status code.
+type fakeError struct {
+	code   int
+	reason string
+	msg    string
+}
+
+func (e *fakeError) Error() string { return e.msg }
+
+func errNotFound(format string, v ...interface{}) error {
+	return &fakeError{http.StatusNotFound, "notFound", fmt.Sprintf(format, v...)}
+}
+
+func errConflict(format string, v ...interface{}) error {
+	return &fakeError{http.StatusConflict, "conflict", fmt.Sprintf(format, v...)}
+}
+
+func errForbidden(format string, v ...interface{}) error {
+	return &fakeError{http.StatusForbidden, "forbidden", fmt.Sprintf(format, v...)}
+}
+
+func errBadRequest(format string, v ...interface{}) error {
+	return &fakeError{http.StatusBadRequest, "invalid", fmt.Sprintf(format, v...)}
+}
+
+var errPrecondition = &fakeError{http.StatusPreconditionFailed, "conditionNotMet", "At least one of the pre-conditions you specified did not hold."}
+
+// NewFakeStorage starts a FakeStorage server. Call Close when done.
+func NewFakeStorage() *FakeStorage {
+	f := &FakeStorage{
+		buckets: map[string]*fakeBucket{},
+		uploads: map[string]*fakeUpload{},
+		lastGen: time.Now().UnixMicro(),
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	f.URL = f.srv.URL
+	return f
+}
+
+// Close shuts down the server.
+func (f *FakeStorage) Close() {
+	f.srv.Close()
+}
+
+// ClientOptions returns the options that point a storage.Client at the fake.
+func (f *FakeStorage) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(f.URL + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+// NewClient returns a storage.Client that talks to the fake.
+func (f *FakeStorage) NewClient(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx, f.ClientOptions()...)
+}
+
+func (f *FakeStorage) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	var err error
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/"):
+		err = f.serveUpload(w, r, splitPath(strings.TrimPrefix(path, "/upload/storage/v1/")))
+	case strings.HasPrefix(path, "/storage/v1/"):
+		err = f.serveJSON(w, r, splitPath(strings.TrimPrefix(path, "/storage/v1/")))
+	case strings.HasPrefix(path, "/download/storage/v1/"):
+		err = f.serveJSON(w, r, splitPath(strings.TrimPrefix(path, "/download/storage/v1/")))
+	default:
+		err = f.serveXML(w, r)
+	}
+	if err == nil {
+		return
+	}
+	fe, ok := err.(*fakeError)
+	if !ok {
+		fe = &fakeError{http.StatusInternalServerError, "internalError", err.Error()}
+	}
+	if strings.HasPrefix(path, "/storage/v1/") || strings.HasPrefix(path, "/upload/") || strings.HasPrefix(path, "/download/") {
+		writeFakeJSON(w, fe.code, map[string]interface{}{
+			"error": map[string]interface{}{
+				"code":    fe.code,
+				"message": fe.msg,
+				"errors": []map[string]string{{
+					"domain":  "global",
+					"reason":  fe.reason,
+					"message": fe.msg,
+				}},
+			},
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(fe.code)
+	fmt.Fprintf(w, "<?xml version='1.0' encoding='UTF-8'?><Error><Code>%s</Code><Message>%s</Message></Error>", fe.reason, fe.msg)
+}
+
+// splitPath splits an escaped URL path into unescaped segments.
+func splitPath(p string) []string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i, part := range parts {
+		if s, err := url.PathUnescape(part); err == nil {
+			parts[i] = s
+		}
+	}
+	return parts
+}
+
+func writeFakeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveJSON handles requests to the JSON API, with the /storage/v1/ prefix removed from parts.
+func (f *FakeStorage) serveJSON(w http.ResponseWriter, r *http.Request, parts []string) error {
+	if len(parts) == 0 || parts[0] != "b" {
+		return errNotFound("Not Found")
+	}
+	q := r.URL.Query()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		return f.listBuckets(w, q)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		return f.createBucket(w, r, q)
+	}
+
+	b, ok := f.buckets[parts[1]]
+	if !ok {
+		return errNotFound("The specified bucket does not exist.")
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		writeFakeJSON(w, http.StatusOK, b.attrs)
+		return nil
+	case len(parts) == 2 && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		return f.updateBucket(w, r, b)
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		return f.deleteBucket(w, b)
+	case len(parts) == 3 && parts[2] == "lockRetentionPolicy" && r.Method == http.MethodPost:
+		return f.lockRetentionPolicy(w, q, b)
+	case len(parts) == 3 && parts[2] == "o" && r.Method == http.MethodGet:
+		return f.listObjects(w, q, b)
+	case len(parts) < 4 || parts[2] != "o":
+		return errNotFound("Not Found")
+	}
+
+	name := parts[3]
+	switch {
+	case len(parts) == 4 && r.Method == http.MethodGet:
+		o, err := b.find(name, q.Get("generation"))
+		if err != nil {
+			return err
+		}
+		if err := parseQueryConditions(q).check(o); err != nil {
+			return err
+		}
+		if q.Get("alt") == "media" {
+			return f.writeMedia(w, r, b, o)
+		}
+		writeFakeJSON(w, http.StatusOK, b.objectAttrs(o))
+		return nil
+	case len(parts) == 4 && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		return f.updateObject(w, r, b, name)
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		return f.deleteObject(w, q, b, name)
+	case len(parts) == 5 && parts[4] == "compose" && r.Method == http.MethodPost:
+		return f.composeObject(w, r, b, name)
+	case len(parts) == 9 && (parts[4] == "rewriteTo" || parts[4] == "copyTo") && r.Method == http.MethodPost:
+		return f.rewriteObject(w, r, b, name, parts[6], parts[8])
+	}
+	return errNotFound("Not Found")
+}
+
+func (f *FakeStorage) listBuckets(w http.ResponseWriter, q url.Values) error {
+	project, prefix := q.Get("project"), q.Get("prefix")
+	var names []string
+	for name, b := range f.buckets {
+		if (project == "" || b.project == project) && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	resp := raw.Buckets{Kind: "storage#buckets"}
+	for _, name := range names {
+		attrs := f.buckets[name].attrs
+		resp.Items = append(resp.Items, &attrs)
+	}
+	writeFakeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+func (f *FakeStorage) createBucket(w http.ResponseWriter, r *http.Request, q url.Values) error {
+	var attrs raw.Bucket
+	if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+		return errBadRequest("invalid bucket: %v", err)
+	}
+	if attrs.Name == "" {
+		return errBadRequest("Required: bucket name")
+	}
+	if _, ok := f.buckets[attrs.Name]; ok {
+		return errConflict("Your previous request to create the named bucket succeeded and you already own it.")
+	}
+	now := formatFakeTime(time.Now())
+	attrs.Kind = "storage#bucket"
+	attrs.Id = attrs.Name
+	attrs.SelfLink = f.URL + "/storage/v1/b/" + attrs.Name
+	attrs.Metageneration = 1
+	attrs.TimeCreated = now
+	attrs.Updated = now
+	attrs.Etag = "CAE="
+	if attrs.Location == "" {
+		attrs.Location = "US"
+	}
+	if attrs.StorageClass == "" {
+		attrs.StorageClass = "STANDARD"
+	}
+	if rp := attrs.RetentionPolicy; rp != nil {
+		rp.EffectiveTime = now
+		rp.IsLocked = false
+	}
+	f.buckets[attrs.Name] = &fakeBucket{
+		project: q.Get("project"),
+		attrs:   attrs,
+		objects: map[string][]*fakeObject{},
+	}
+	writeFakeJSON(w, http.StatusOK, attrs)
+	return nil
+}
+
+func (f *FakeStorage) updateBucket(w http.ResponseWriter, r *http.Request, b *fakeBucket) error {
+	if err := parseQueryConditions(r.URL.Query()).checkMetageneration(b.attrs.Metageneration); err != nil {
+		return err
+	}
+	old := b.attrs
+	var updated raw.Bucket
+	if err := mergePatch(&old, r.Body, &updated); err != nil {
+		return err
+	}
+	if oldRP := old.RetentionPolicy; oldRP != nil && oldRP.IsLocked {
+		if updated.RetentionPolicy == nil || updated.RetentionPolicy.RetentionPeriod < oldRP.RetentionPeriod {
+			return errForbidden("Cannot reduce retention duration of a locked Retention Policy for bucket '%s'.", old.Name)
+		}
+	}
+	if rp := updated.RetentionPolicy; rp != nil {
+		if old.RetentionPolicy == nil {
+			rp.EffectiveTime = formatFakeTime(time.Now())
+			rp.IsLocked = false
+		} else {
+			rp.EffectiveTime = old.RetentionPolicy.EffectiveTime
+			rp.IsLocked = old.RetentionPolicy.IsLocked
+		}
+	}
+	updated.Name, updated.Id, updated.Kind = old.Name, old.Id, old.Kind
+	updated.TimeCreated, updated.SelfLink = old.TimeCreated, old.SelfLink
+	updated.Metageneration = old.Metageneration + 1
+	updated.Updated = formatFakeTime(time.Now())
+	b.attrs = updated
+	writeFakeJSON(w, http.StatusOK, b.attrs)
+	return nil
+}
+
+func (f *FakeStorage) deleteBucket(w http.ResponseWriter, b *fakeBucket) error {
+	if len(b.objects) > 0 {
+		return errConflict("The bucket you tried to delete is not empty.")
+	}
+	delete(f.buckets, b.attrs.Name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (f *FakeStorage) lockRetentionPolicy(w http.ResponseWriter, q url.Values, b *fakeBucket) error {
+	if q.Get("ifMetagenerationMatch") == "" {
+		return errBadRequest("Required parameter: ifMetagenerationMatch")
+	}
+	if err := parseQueryConditions(q).checkMetageneration(b.attrs.Metageneration); err != nil {
+		return err
+	}
+	if b.attrs.RetentionPolicy == nil {
+		return errBadRequest("Bucket '%s' does not have a retention policy.", b.attrs.Name)
+	}
+	b.attrs.RetentionPolicy.IsLocked = true
+	b.attrs.Metageneration++
+	b.attrs.Updated = formatFakeTime(time.Now())
+	writeFakeJSON(w, http.StatusOK, b.attrs)
+	return nil
+}
+
+func (f *FakeStorage) listObjects(w http.ResponseWriter, q url.Values, b *fakeBucket) error {
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	startOffset, endOffset := q.Get("startOffset"), q.Get("endOffset")
+	versions := q.Get("versions") == "true"
+
+	names := make([]string, 0, len(b.objects))
+	for name := range b.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var items []*raw.Object
+	prefixes := map[string]bool{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) ||
+			(startOffset != "" && name < startOffset) ||
+			(endOffset != "" && name >= endOffset) {
+			continue
+		}
+		if delim != "" {
+			if i := strings.Index(name[len(prefix):], delim); i >= 0 {
+				prefixes[name[:len(prefix)+i+len(delim)]] = true
+				continue
+			}
+		}
+		for _, o := range b.objects[name] {
+			if versions || o.attrs.TimeDeleted == "" {
+				items = append(items, b.objectAttrs(o))
+			}
+		}
+	}
+
+	offset, _ := strconv.Atoi(q.Get("pageToken"))
+	pageSize, _ := strconv.Atoi(q.Get("maxResults"))
+	if pageSize <= 0 || pageSize > 1000 {
+		pageSize = 1000
+	}
+	resp := raw.Objects{Kind: "storage#objects"}
+	if offset < len(items) {
+		end := offset + pageSize
+		if end < len(items) {
+			resp.NextPageToken = strconv.Itoa(end)
+		} else {
+			end = len(items)
+		}
+		resp.Items = items[offset:end]
+	}
+	if offset == 0 {
+		for p := range prefixes {
+			resp.Prefixes = append(resp.Prefixes, p)
+		}
+		sort.Strings(resp.Prefixes)
+	}
+	writeFakeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+func (f *FakeStorage) updateObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, name string) error {
+	q := r.URL.Query()
+	o, err := b.find(name, q.Get("generation"))
+	if err != nil {
+		return err
+	}
+	if err := parseQueryConditions(q).check(o); err != nil {
+		return err
+	}
+	old := o.attrs
+	var updated raw.Object
+	if err := mergePatch(&old, r.Body, &updated); err != nil {
+		return err
+	}
+	// Only metadata may change; the object's identity and content are fixed.
+	updated.Bucket, updated.Name, updated.Id, updated.Kind = old.Bucket, old.Name, old.Id, old.Kind
+	updated.Generation, updated.Size, updated.Md5Hash, updated.Crc32c = old.Generation, old.Size, old.Md5Hash, old.Crc32c
+	updated.TimeCreated, updated.TimeDeleted, updated.SelfLink, updated.MediaLink = old.TimeCreated, old.TimeDeleted, old.SelfLink, old.MediaLink
+	updated.Metageneration = old.Metageneration + 1
+	updated.Updated = formatFakeTime(time.Now())
+	if old.EventBasedHold && !updated.EventBasedHold {
+		o.retentionStart = time.Now()
+	}
+	o.attrs = updated
+	writeFakeJSON(w, http.StatusOK, b.objectAttrs(o))
+	return nil
+}
+
+func (f *FakeStorage) deleteObject(w http.ResponseWriter, q url.Values, b *fakeBucket, name string) error {
+	o, err := b.find(name, q.Get("generation"))
+	if err != nil {
+		return err
+	}
+	if err := parseQueryConditions(q).check(o); err != nil {
+		return err
+	}
+	if err := b.remove(o, q.Get("generation") != ""); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (f *FakeStorage) composeObject(w http.ResponseWriter, r *http.Request, b *fakeBucket, name string) error {
+	var req raw.ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errBadRequest("invalid compose request: %v", err)
+	}
+	var data []byte
+	for _, src := range req.SourceObjects {
+		gen := ""
+		if src.Generation != 0 {
+			gen = strconv.FormatInt(src.Generation, 10)
+		}
+		o, err := b.find(src.Name, gen)
+		if err != nil {
+			return err
+		}
+		if p := src.ObjectPreconditions; p != nil && p.IfGenerationMatch != 0 && p.IfGenerationMatch != o.attrs.Generation {
+			return errPrecondition
+		}
+		data = append(data, o.data...)
+	}
+	attrs := raw.Object{Name: name}
+	if req.Destination != nil {
+		attrs = *req.Destination
+		attrs.Name = name
+	}
+	o, err := f.insert(b, attrs, data, parseQueryConditions(r.URL.Query()))
+	if err != nil {
+		return err
+	}
+	writeFakeJSON(w, http.StatusOK, b.objectAttrs(o))
+	return nil
+}
+
+func (f *FakeStorage) rewriteObject(w http.ResponseWriter, r *http.Request, src *fakeBucket, srcName, dstBucket, dstName string) error {
+	q := r.URL.Query()
+	o, err := src.find(srcName, q.Get("sourceGeneration"))
+	if err != nil {
+		return err
+	}
+	dst, ok := f.buckets[dstBucket]
+	if !ok {
+		return errNotFound("The destination bucket does not exist.")
+	}
+	attrs := o.attrs
+	var override raw.Object
+	if body, _ := io.ReadAll(r.Body); len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &override); err != nil {
+			return errBadRequest("invalid object: %v", err)
+		}
+	}
+	if override.ContentType != "" {
+		attrs.ContentType = override.ContentType
+	}
+	if override.Metadata != nil {
+		attrs.Metadata = override.Metadata
+	}
+	if override.StorageClass != "" {
+		attrs.StorageClass = override.StorageClass
+	}
+	attrs.Name = dstName
+	attrs.TemporaryHold, attrs.EventBasedHold = false, false
+	newObj, err := f.insert(dst, attrs, o.data, parseQueryConditions(q))
+	if err != nil {
+		return err
+	}
+	size := int64(len(o.data))
+	writeFakeJSON(w, http.StatusOK, raw.RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		Done:                true,
+		ObjectSize:          size,
+		TotalBytesRewritten: size,
+		Resource:            dst.objectAttrs(newObj),
+	})
+	return nil
+}
+
+// serveUpload handles media uploads, with the /upload/storage/v1/ prefix removed from parts.
+func (f *FakeStorage) serveUpload(w http.ResponseWriter, r *http.Request, parts []string) error {
+	if len(parts) != 3 || parts[0] != "b" || parts[2] != "o" {
+		return errNotFound("Not Found")
+	}
+	q := r.URL.Query()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, ok := f.buckets[parts[1]]
+	if !ok {
+		return errNotFound("The specified bucket does not exist.")
+	}
+
+	if id := q.Get("upload_id"); id != "" {
+		return f.continueResumable(w, r, b, id)
+	}
+
+	var (
+		attrs raw.Object
+		data  []byte
+		err   error
+	)
+	switch q.Get("uploadType") {
+	case "media":
+		attrs.Name = q.Get("name")
+		attrs.ContentType = r.Header.Get("Content-Type")
+		data, err = io.ReadAll(r.Body)
+	case "multipart":
+		attrs, data, err = readMultipart(r)
+	case "resumable":
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil && err != io.EOF {
+			return errBadRequest("invalid object: %v", err)
+		}
+		if attrs.Name == "" {
+			attrs.Name = q.Get("name")
+		}
+		id := uuid.New().String()
+		f.uploads[id] = &fakeUpload{bucket: b.attrs.Name, attrs: attrs, conds: parseQueryConditions(q)}
+		loc := *r.URL
+		loc.Scheme, loc.Host = "http", r.Host
+		lq := loc.Query()
+		lq.Set("upload_id", id)
+		loc.RawQuery = lq.Encode()
+		w.Header().Set("Location", loc.String())
+		w.WriteHeader(http.StatusOK)
+		return nil
+	default:
+		return errBadRequest("unsupported uploadType %q", q.Get("uploadType"))
+	}
+	if err != nil {
+		return err
+	}
+	if attrs.Name == "" {
+		attrs.Name = q.Get("name")
+	}
+	o, err := f.insert(b, attrs, data, parseQueryConditions(q))
+	if err != nil {
+		return err
+	}
+	writeFakeJSON(w, http.StatusOK, b.objectAttrs(o))
+	return nil
+}
+
+// readMultipart reads the metadata and media parts of a multipart upload.
+func readMultipart(r *http.Request) (raw.Object, []byte, error) {
+	var attrs raw.Object
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return attrs, nil, errBadRequest("invalid Content-Type: %v", err)
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	meta, err := mr.NextPart()
+	if err != nil {
+		return attrs, nil, errBadRequest("missing metadata part: %v", err)
+	}
+	if err := json.NewDecoder(meta).Decode(&attrs); err != nil {
+		return attrs, nil, errBadRequest("invalid object metadata: %v", err)
+	}
+	media, err := mr.NextPart()
+	if err != nil {
+		return attrs, nil, errBadRequest("missing media part: %v", err)
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = media.Header.Get("Content-Type")
+	}
+	data, err := io.ReadAll(media)
+	return attrs, data, err
+}
+
+// continueResumable appends a chunk to a resumable upload, finishing it
+// once the total size is known and reached.
+func (f *FakeStorage) continueResumable(w http.ResponseWriter, r *http.Request, b *fakeBucket, id string) error {
+	u, ok := f.uploads[id]
+	if !ok || u.bucket != b.attrs.Name {
+		return errNotFound("No such upload")
+	}
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	u.data = append(u.data, chunk...)
+
+	// Content-Range is "bytes first-last/total", "bytes first-last/*" or "bytes */total".
+	total := "*"
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		total = cr[strings.LastIndex(cr, "/")+1:]
+	}
+	if total == "*" || strconv.Itoa(len(u.data)) != total {
+		if len(u.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		}
+		// Clients that send X-GUploader-No-308 expect a 200 with an override header instead.
+		if r.Header.Get("X-GUploader-No-308") == "yes" {
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return nil
+	}
+	delete(f.uploads, id)
+	o, err := f.insert(b, u.attrs, u.data, u.conds)
+	if err != nil {
+		return err
+	}
+	writeFakeJSON(w, http.StatusOK, b.objectAttrs(o))
+	return nil
+}
+
+// serveXML handles XML API requests of the form /bucket/object.
+func (f *FakeStorage) serveXML(w http.ResponseWriter, r *http.Request) error {
+	bucket, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" || name == "" {
+		return &fakeError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	}
+	q := r.URL.Query()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, ok := f.buckets[bucket]
+	if !ok {
+		return &fakeError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist."}
+	}
+	conds := parseHeaderConditions(r.Header)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		o, err := b.find(name, q.Get("generation"))
+		if err != nil {
+			return &fakeError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+		}
+		if err := conds.check(o); err != nil {
+			return err
+		}
+		return f.writeMedia(w, r, b, o)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		attrs := raw.Object{Name: name, ContentType: r.Header.Get("Content-Type")}
+		for k, v := range r.Header {
+			if meta := strings.TrimPrefix(strings.ToLower(k), "x-goog-meta-"); meta != strings.ToLower(k) {
+				if attrs.Metadata == nil {
+					attrs.Metadata = map[string]string{}
+				}
+				attrs.Metadata[meta] = v[0]
+			}
+		}
+		o, err := f.insert(b, attrs, data, conds)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", o.attrs.Md5Hash))
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(o.attrs.Generation, 10))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodDelete:
+		o, err := b.find(name, q.Get("generation"))
+		if err != nil {
+			return &fakeError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+		}
+		if err := conds.check(o); err != nil {
+			return err
+		}
+		if err := b.remove(o, q.Get("generation") != ""); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return &fakeError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed."}
+}
+
+// writeMedia writes the object's content, honoring a Range header.
+func (f *FakeStorage) writeMedia(w http.ResponseWriter, r *http.Request, b *fakeBucket, o *fakeObject) error {
+	h := w.Header()
+	h.Set("Content-Type", o.attrs.ContentType)
+	if o.attrs.ContentEncoding != "" {
+		h.Set("Content-Encoding", o.attrs.ContentEncoding)
+	}
+	if o.attrs.CacheControl != "" {
+		h.Set("Cache-Control", o.attrs.CacheControl)
+	}
+	h.Set("X-Goog-Generation", strconv.FormatInt(o.attrs.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(o.attrs.Metageneration, 10))
+	h.Set("X-Goog-Stored-Content-Encoding", "identity")
+	h.Set("X-Goog-Stored-Content-Length", strconv.Itoa(len(o.data)))
+	h.Set("X-Goog-Hash", "crc32c="+o.attrs.Crc32c+",md5="+o.attrs.Md5Hash)
+	h.Set("ETag", fmt.Sprintf("%q", o.attrs.Md5Hash))
+	if t, err := time.Parse(time.RFC3339Nano, o.attrs.Updated); err == nil {
+		h.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+	for k, v := range o.attrs.Metadata {
+		h.Set("X-Goog-Meta-"+k, v)
+	}
+
+	data, code := o.data, http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseRange(rng, int64(len(o.data)))
+		if !ok {
+			return &fakeError{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range cannot be satisfied."}
+		}
+		data, code = o.data[start:end], http.StatusPartialContent
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(o.data)))
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+	return nil
+}
+
+// parseRange parses a single "bytes=" range into a half-open interval.
+func parseRange(rng string, size int64) (start, end int64, ok bool) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	if spec == rng {
+		return 0, 0, false
+	}
+	first, last, hasDash := strings.Cut(spec, "-")
+	switch {
+	case !hasDash:
+		// "bytes=-N" is sent as a negative offset without a dash.
+		n, err := strconv.ParseInt(spec, 10, 64)
+		if err != nil || n >= 0 {
+			return 0, 0, false
+		}
+		start, end = size+n, size
+	case first == "":
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		start, end = size-n, size
+	default:
+		s, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		start, end = s, size
+		if last != "" {
+			e, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return 0, 0, false
+			}
+			if e+1 < end {
+				end = e + 1
+			}
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || (start == end && size > 0) {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// insert writes a new generation of attrs.Name to b.
+func (f *FakeStorage) insert(b *fakeBucket, attrs raw.Object, data []byte, conds fakeConditions) (*fakeObject, error) {
+	if attrs.Name == "" {
+		return nil, errBadRequest("Required: object name")
+	}
+	live := b.live(attrs.Name)
+	if err := conds.check(live); err != nil {
+		return nil, err
+	}
+	if live != nil && (b.attrs.Versioning == nil || !b.attrs.Versioning.Enabled) {
+		if err := b.deletable(live); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	f.lastGen++
+	if g := now.UnixMicro(); g > f.lastGen {
+		f.lastGen = g
+	}
+	md5sum := md5.Sum(data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+
+	attrs.Kind = "storage#object"
+	attrs.Bucket = b.attrs.Name
+	attrs.Generation = f.lastGen
+	attrs.Metageneration = 1
+	attrs.Id = fmt.Sprintf("%s/%s/%d", b.attrs.Name, attrs.Name, attrs.Generation)
+	attrs.SelfLink = f.URL + "/storage/v1/b/" + b.attrs.Name + "/o/" + url.PathEscape(attrs.Name)
+	attrs.MediaLink = f.URL + "/download/storage/v1/b/" + b.attrs.Name + "/o/" + url.PathEscape(attrs.Name) + "?alt=media"
+	attrs.Size = uint64(len(data))
+	attrs.Md5Hash = base64.StdEncoding.EncodeToString(md5sum[:])
+	attrs.Crc32c = base64.StdEncoding.EncodeToString(crc)
+	attrs.Etag = base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(attrs.Generation, 10)))
+	attrs.TimeCreated = formatFakeTime(now)
+	attrs.Updated = attrs.TimeCreated
+	attrs.TimeDeleted = ""
+	attrs.RetentionExpirationTime = ""
+	if attrs.ContentType == "" {
+		attrs.ContentType = "application/octet-stream"
+	}
+	if attrs.StorageClass == "" {
+		attrs.StorageClass = b.attrs.StorageClass
+	}
+	if b.attrs.DefaultEventBasedHold {
+		attrs.EventBasedHold = true
+	}
+
+	o := &fakeObject{attrs: attrs, data: append([]byte(nil), data...), retentionStart: now}
+	gens := b.objects[attrs.Name]
+	if live != nil {
+		if b.attrs.Versioning != nil && b.attrs.Versioning.Enabled {
+			live.attrs.TimeDeleted = formatFakeTime(now)
+		} else {
+			gens = gens[:len(gens)-1]
+		}
+	}
+	b.objects[attrs.Name] = append(gens, o)
+	return o, nil
+}
+
+// live returns the live generation of name, or nil.
+func (b *fakeBucket) live(name string) *fakeObject {
+	gens := b.objects[name]
+	if len(gens) == 0 {
+		return nil
+	}
+	if o := gens[len(gens)-1]; o.attrs.TimeDeleted == "" {
+		return o
+	}
+	return nil
+}
+
+// find returns the given generation of name, or the live generation if gen is empty.
+func (b *fakeBucket) find(name, gen string) (*fakeObject, error) {
+	if gen == "" {
+		if o := b.live(name); o != nil {
+			return o, nil
+		}
+		return nil, errNotFound("No such object: %s/%s", b.attrs.Name, name)
+	}
+	g, err := strconv.ParseInt(gen, 10, 64)
+	if err != nil {
+		return nil, errBadRequest("invalid generation %q", gen)
+	}
+	for _, o := range b.objects[name] {
+		if o.attrs.Generation == g {
+			return o, nil
+		}
+	}
+	return nil, errNotFound("No such object: %s/%s#%s", b.attrs.Name, name, gen)
+}
+
+// remove deletes o. If o is live, the bucket has versioning enabled and
+// permanent is false, o is kept as a noncurrent version instead.
+func (b *fakeBucket) remove(o *fakeObject, permanent bool) error {
+	if err := b.deletable(o); err != nil {
+		return err
+	}
+	name := o.attrs.Name
+	versioned := b.attrs.Versioning != nil && b.attrs.Versioning.Enabled
+	if !permanent && versioned {
+		o.attrs.TimeDeleted = formatFakeTime(time.Now())
+		return nil
+	}
+	gens := b.objects[name]
+	for i, g := range gens {
+		if g == o {
+			gens = append(gens[:i:i], gens[i+1:]...)
+			break
+		}
+	}
+	if len(gens) == 0 {
+		delete(b.objects, name)
+	} else {
+		b.objects[name] = gens
+	}
+	return nil
+}
+
+// deletable returns an error if o is under a hold or its retention period.
+func (b *fakeBucket) deletable(o *fakeObject) error {
+	if o.attrs.TemporaryHold {
+		return errForbidden("Object '%s/%s' is under active Temporary hold and cannot be deleted, overwritten or archived until hold is removed.", b.attrs.Name, o.attrs.Name)
+	}
+	if o.attrs.EventBasedHold {
+		return errForbidden("Object '%s/%s' is under active Event-Based hold and cannot be deleted, overwritten or archived until hold is removed.", b.attrs.Name, o.attrs.Name)
+	}
+	if exp, ok := b.retentionExpiration(o); ok && time.Now().Before(exp) {
+		return errForbidden("Object '%s/%s' is subject to bucket's retention policy and cannot be deleted, overwritten or archived until %s.", b.attrs.Name, o.attrs.Name, formatFakeTime(exp))
+	}
+	return nil
+}
+
+// retentionExpiration returns when the bucket's retention policy stops protecting o.
+func (b *fakeBucket) retentionExpiration(o *fakeObject) (time.Time, bool) {
+	rp := b.attrs.RetentionPolicy
+	if rp == nil || rp.RetentionPeriod == 0 || o.attrs.EventBasedHold {
+		return time.Time{}, false
+	}
+	return o.retentionStart.Add(time.Duration(rp.RetentionPeriod) * time.Second), true
+}
+
+// objectAttrs returns the attributes of o as served by the API.
+func (b *fakeBucket) objectAttrs(o *fakeObject) *raw.Object {
+	attrs := o.attrs
+	if exp, ok := b.retentionExpiration(o); ok {
+		attrs.RetentionExpirationTime = formatFakeTime(exp)
+	}
+	return &attrs
+}
+
+// fakeConditions are the generation and metageneration preconditions of a request.
+type fakeConditions struct {
+	genMatch, genNotMatch         *int64
+	metagenMatch, metagenNotMatch *int64
+}
+
+func parseQueryConditions(q url.Values) fakeConditions {
+	return fakeConditions{
+		genMatch:        parseFakeInt(q.Get("ifGenerationMatch")),
+		genNotMatch:     parseFakeInt(q.Get("ifGenerationNotMatch")),
+		metagenMatch:    parseFakeInt(q.Get("ifMetagenerationMatch")),
+		metagenNotMatch: parseFakeInt(q.Get("ifMetagenerationNotMatch")),
+	}
+}
+
+func parseHeaderConditions(h http.Header) fakeConditions {
+	return fakeConditions{
+		genMatch:        parseFakeInt(h.Get("X-Goog-If-Generation-Match")),
+		genNotMatch:     parseFakeInt(h.Get("X-Goog-If-Generation-Not-Match")),
+		metagenMatch:    parseFakeInt(h.Get("X-Goog-If-Metageneration-Match")),
+		metagenNotMatch: parseFakeInt(h.Get("X-Goog-If-Metageneration-Not-Match")),
+	}
+}
+
+func parseFakeInt(s string) *int64 {
+	if s == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// check returns errPrecondition if o, which may be nil, does not satisfy c.
+// A generation match of 0 requires that the object does not exist.
+func (c fakeConditions) check(o *fakeObject) error {
+	var gen, metagen int64
+	if o != nil {
+		gen, metagen = o.attrs.Generation, o.attrs.Metageneration
+	}
+	if c.genMatch != nil && *c.genMatch != gen {
+		return errPrecondition
+	}
+	if c.genNotMatch != nil && *c.genNotMatch == gen {
+		return errPrecondition
+	}
+	return c.checkMetageneration(metagen)
+}
+
+func (c fakeConditions) checkMetageneration(metagen int64) error {
+	if c.metagenMatch != nil && *c.metagenMatch != metagen {
+		return errPrecondition
+	}
+	if c.metagenNotMatch != nil && *c.metagenNotMatch == metagen {
+		return errPrecondition
+	}
+	return nil
+}
+
+// mergePatch applies the JSON merge patch in body to current, storing the result in updated.
+func mergePatch(current interface{}, body io.Reader, updated interface{}) error {
+	var patch map[string]interface{}
+	if err := json.NewDecoder(body).Decode(&patch); err != nil && err != io.EOF {
+		return errBadRequest("invalid patch: %v", err)
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	mergeJSON(doc, patch)
+	if b, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, updated); err != nil {
+		return errBadRequest("invalid patch: %v", err)
+	}
+	return nil
+}
+
+func mergeJSON(doc, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		if !ok {
+			doc[k] = v
+			continue
+		}
+		dm, ok := doc[k].(map[string]interface{})
+		if !ok {
+			dm = map[string]interface{}{}
+			doc[k] = dm
+		}
+		mergeJSON(dm, pm)
+	}
+}
+
+func formatFakeTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
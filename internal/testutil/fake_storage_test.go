@@ -0,0 +1,351 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+func newFakeStorageClient(t *testing.T) (*storage.Client, *FakeStorage) {
+	t.Helper()
+	fake := NewFakeStorage()
+	t.Cleanup(fake.Close)
+	client, err := fake.NewClient(context.Background())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, fake
+}
+
+func writeFakeObject(ctx context.Context, t *testing.T, o *storage.ObjectHandle, data string) (*storage.ObjectAttrs, error) {
+	t.Helper()
+	w := o.NewWriter(ctx)
+	w.ContentType = "text/plain"
+	if _, err := io.WriteString(w, data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Attrs(), nil
+}
+
+func readFakeObject(ctx context.Context, o *storage.ObjectHandle) (string, error) {
+	r, err := o.NewReader(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func wantCode(t *testing.T, op string, err error, code int) {
+	t.Helper()
+	var e *googleapi.Error
+	if !errors.As(err, &e) || e.Code != code {
+		t.Errorf("%s got error %v, want HTTP %d", op, err, code)
+	}
+}
+
+func TestFakeStorageBuckets(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newFakeStorageClient(t)
+
+	bucket, err := CreateTestBucket(ctx, t, client, "fake-project", "golang-samples-fake")
+	if err != nil {
+		t.Fatalf("CreateTestBucket: %v", err)
+	}
+	b := client.Bucket(bucket)
+	if err := b.Create(ctx, "fake-project", nil); err == nil {
+		t.Errorf("Create of existing bucket succeeded, want error")
+	}
+
+	attrs, err := b.Update(ctx, storage.BucketAttrsToUpdate{
+		VersioningEnabled: true,
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !attrs.VersioningEnabled || attrs.MetaGeneration != 2 {
+		t.Errorf("Update got versioning %v, metageneration %d; want true, 2", attrs.VersioningEnabled, attrs.MetaGeneration)
+	}
+
+	it := client.Buckets(ctx, "fake-project")
+	it.Prefix = "golang-samples-fake"
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Buckets.Next: %v", err)
+		}
+		names = append(names, attrs.Name)
+	}
+	if !reflect.DeepEqual(names, []string{bucket}) {
+		t.Errorf("Buckets got %q, want %q", names, []string{bucket})
+	}
+
+	if _, err := writeFakeObject(ctx, t, b.Object("a"), "hello"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	wantCode(t, "Delete of non-empty bucket", b.Delete(ctx), http.StatusConflict)
+
+	if err := DeleteBucketIfExists(ctx, client, bucket); err != nil {
+		t.Fatalf("DeleteBucketIfExists: %v", err)
+	}
+	if _, err := b.Attrs(ctx); err != storage.ErrBucketNotExist {
+		t.Errorf("Attrs after delete got %v, want ErrBucketNotExist", err)
+	}
+}
+
+func TestFakeStorageObjects(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newFakeStorageClient(t)
+	b := client.Bucket("objects")
+	if err := b.Create(ctx, "fake-project", nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	o := b.Object("dir/hello.txt")
+	attrs, err := writeFakeObject(ctx, t, o.If(storage.Conditions{DoesNotExist: true}), "hello world")
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if attrs.Size != 11 || attrs.ContentType != "text/plain" || attrs.Generation == 0 {
+		t.Errorf("write got attrs %+v", attrs)
+	}
+	_, err = writeFakeObject(ctx, t, o.If(storage.Conditions{DoesNotExist: true}), "again")
+	wantCode(t, "write with DoesNotExist", err, http.StatusPreconditionFailed)
+
+	got, err := readFakeObject(ctx, o)
+	if err != nil || got != "hello world" {
+		t.Errorf("read got %q, %v; want %q", got, err, "hello world")
+	}
+	r, err := o.NewRangeReader(ctx, 6, 5)
+	if err != nil {
+		t.Fatalf("NewRangeReader: %v", err)
+	}
+	rb, _ := io.ReadAll(r)
+	r.Close()
+	if string(rb) != "world" {
+		t.Errorf("range read got %q, want %q", rb, "world")
+	}
+
+	second, err := writeFakeObject(ctx, t, o.If(storage.Conditions{GenerationMatch: attrs.Generation}), "goodbye")
+	if err != nil {
+		t.Fatalf("write with GenerationMatch: %v", err)
+	}
+	if second.Generation <= attrs.Generation {
+		t.Errorf("second generation %d not after first %d", second.Generation, attrs.Generation)
+	}
+	if _, err := readFakeObject(ctx, o.Generation(attrs.Generation)); err != storage.ErrObjectNotExist {
+		t.Errorf("read of overwritten generation got %v, want ErrObjectNotExist", err)
+	}
+
+	updated, err := o.If(storage.Conditions{MetagenerationMatch: 1}).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{"color": "blue"},
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.Metadata["color"] != "blue" || updated.Metageneration != 2 {
+		t.Errorf("Update got metadata %v, metageneration %d", updated.Metadata, updated.Metageneration)
+	}
+	_, err = o.If(storage.Conditions{MetagenerationMatch: 1}).Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/html"})
+	wantCode(t, "Update with stale MetagenerationMatch", err, http.StatusPreconditionFailed)
+
+	copied, err := b.Object("copy.txt").CopierFrom(o).Run(ctx)
+	if err != nil {
+		t.Fatalf("Copier.Run: %v", err)
+	}
+	if copied.Size != 7 {
+		t.Errorf("copy got size %d, want 7", copied.Size)
+	}
+
+	if err := o.Delete(ctx); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := o.Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("Attrs after delete got %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestFakeStorageList(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newFakeStorageClient(t)
+	b := client.Bucket("list")
+	if err := b.Create(ctx, "fake-project", &storage.BucketAttrs{VersioningEnabled: true}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, name := range []string{"a/1", "a/2", "a/b/3", "c", "a/1"} {
+		if _, err := writeFakeObject(ctx, t, b.Object(name), name); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	list := func(q *storage.Query) []string {
+		t.Helper()
+		var got []string
+		it := b.Objects(ctx, q)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				return got
+			}
+			if err != nil {
+				t.Fatalf("Objects.Next: %v", err)
+			}
+			if attrs.Prefix != "" {
+				got = append(got, attrs.Prefix)
+			} else {
+				got = append(got, attrs.Name)
+			}
+		}
+	}
+
+	if got, want := list(&storage.Query{Prefix: "a/", Delimiter: "/"}), []string{"a/1", "a/2", "a/b/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("list with delimiter got %q, want %q", got, want)
+	}
+	if got, want := list(nil), []string{"a/1", "a/2", "a/b/3", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("list got %q, want %q", got, want)
+	}
+	if got, want := list(&storage.Query{Prefix: "a/1", Versions: true}), []string{"a/1", "a/1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("list versions got %q, want %q", got, want)
+	}
+}
+
+func TestFakeStorageHoldsAndRetention(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newFakeStorageClient(t)
+	b := client.Bucket("holds")
+	if err := b.Create(ctx, "fake-project", nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	o := b.Object("held")
+	if _, err := writeFakeObject(ctx, t, o, "data"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := o.Update(ctx, storage.ObjectAttrsToUpdate{TemporaryHold: true}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	wantCode(t, "Delete with temporary hold", o.Delete(ctx), http.StatusForbidden)
+	if _, err := o.Update(ctx, storage.ObjectAttrsToUpdate{TemporaryHold: false}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := o.Delete(ctx); err != nil {
+		t.Errorf("Delete after releasing hold: %v", err)
+	}
+
+	attrs, err := b.Update(ctx, storage.BucketAttrsToUpdate{
+		RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	retained := b.Object("retained")
+	oattrs, err := writeFakeObject(ctx, t, retained, "data")
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if oattrs.RetentionExpirationTime.IsZero() {
+		t.Errorf("object has no RetentionExpirationTime")
+	}
+	wantCode(t, "Delete under retention", retained.Delete(ctx), http.StatusForbidden)
+
+	if err := b.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).LockRetentionPolicy(ctx); err != nil {
+		t.Fatalf("LockRetentionPolicy: %v", err)
+	}
+	_, err = b.Update(ctx, storage.BucketAttrsToUpdate{RetentionPolicy: &storage.RetentionPolicy{}})
+	wantCode(t, "removing locked retention policy", err, http.StatusForbidden)
+	if attrs, err := b.Attrs(ctx); err != nil || !attrs.RetentionPolicy.IsLocked {
+		t.Errorf("Attrs got %+v, %v; want locked retention policy", attrs, err)
+	}
+}
+
+func TestFakeStorageXML(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newFakeStorageClient(t)
+	if err := client.Bucket("xml").Create(ctx, "fake-project", nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, fake.URL+"/xml/path/to/obj", strings.NewReader("via xml"))
+	req.Header.Set("x-goog-meta-source", "xml")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT got status %d, want 200", resp.StatusCode)
+	}
+
+	attrs, err := client.Bucket("xml").Object("path/to/obj").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Attrs: %v", err)
+	}
+	if attrs.Metadata["source"] != "xml" || attrs.Size != 7 {
+		t.Errorf("Attrs got metadata %v, size %d", attrs.Metadata, attrs.Size)
+	}
+}
+
+func TestFakeStorageResumableAndCompose(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newFakeStorageClient(t)
+	b := client.Bucket("resumable")
+	if err := b.Create(ctx, "fake-project", nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	data := strings.Repeat("0123456789", 60*1024)
+	w := b.Object("big").NewWriter(ctx)
+	w.ChunkSize = 256 * 1024
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got, err := readFakeObject(ctx, b.Object("big")); err != nil || got != data {
+		t.Errorf("read of resumable upload got %d bytes, %v; want %d bytes", len(got), err, len(data))
+	}
+
+	if _, err := writeFakeObject(ctx, t, b.Object("small"), "!"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	attrs, err := b.Object("both").ComposerFrom(b.Object("big"), b.Object("small")).Run(ctx)
+	if err != nil {
+		t.Fatalf("Composer.Run: %v", err)
+	}
+	if attrs.Size != int64(len(data)+1) {
+		t.Errorf("compose got size %d, want %d", attrs.Size, len(data)+1)
+	}
+}
@@ -16,63 +16,223 @@ package testutil
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"math"
+	"math/rand"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
+	"text/tabwriter"
 	"time"
 )
 
+// deadlineGrace is how long before a test's deadline RetryWithPolicy stops
+// retrying, leaving time to report the failure.
+const deadlineGrace = 5 * time.Second
+
 // Retry runs function f for up to maxAttempts times until f returns successfully, and reports whether f was run successfully.
 // It will sleep for the given period between invocations of f.
 // Use the provided *testutil.R instead of a *testing.T from the function.
 func Retry(t *testing.T, maxAttempts int, sleep time.Duration, f func(r *R)) bool {
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		r := &R{Attempt: attempt, log: &bytes.Buffer{}}
+	t.Helper()
+	return RetryWithPolicy(t, RetryPolicy{MaxAttempts: maxAttempts, Backoff: ConstantBackoff(sleep)}, f)
+}
 
-		f(r)
+// RetryWithoutTest is a variant of Retry that does not use a testing parameter.
+// It is meant for testing utilities that do not pass around the testing context, such as cloudrunci.
+func RetryWithoutTest(maxAttempts int, sleep time.Duration, f func(r *R)) bool {
+	policy := RetryPolicy{MaxAttempts: maxAttempts, Backoff: ConstantBackoff(sleep)}
+	return RetryWithReport(context.Background(), policy, f).Succeeded
+}
 
-		if !r.failed {
-			if r.log.Len() != 0 {
-				t.Logf("Success after %d attempts:%s", attempt, r.log.String())
-			}
-			return true
-		}
+// RetryWithPolicy runs function f according to policy until f returns successfully, and reports whether f was run successfully.
+// Retrying stops shortly before the test's deadline, if it has one.
+// If every attempt fails, the test is marked as failed and a report of each attempt is logged.
+func RetryWithPolicy(t *testing.T, policy RetryPolicy, f func(r *R)) bool {
+	t.Helper()
+	ctx := context.Background()
+	if d, ok := t.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, d.Add(-deadlineGrace))
+		defer cancel()
+	}
 
-		if attempt == maxAttempts {
-			t.Logf("FAILED after %d attempts:%s", attempt, r.log.String())
-			t.Fail()
+	report := RetryWithReport(ctx, policy, f)
+	last := report.Attempts[len(report.Attempts)-1]
+	if report.Succeeded {
+		if last.Log != "" {
+			t.Logf("Success after %d attempts:%s", last.Attempt, last.Log)
 		}
-
-		time.Sleep(sleep)
+		return true
 	}
+	t.Logf("FAILED after %d attempts (%s):%s\n%s", last.Attempt, report.StopReason, last.Log, report)
+	t.Fail()
 	return false
 }
 
-// RetryWithoutTest is a variant of Retry that does not use a testing parameter.
-// It is meant for testing utilities that do not pass around the testing context, such as cloudrunci.
-func RetryWithoutTest(maxAttempts int, sleep time.Duration, f func(r *R)) bool {
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		r := &R{Attempt: attempt, log: &bytes.Buffer{}}
+// RetryWithReport runs function f according to policy until f returns
+// successfully, ctx is done, or f fails permanently. It returns a report of
+// every attempt. R.Context returns a context derived from ctx.
+func RetryWithReport(ctx context.Context, policy RetryPolicy, f func(r *R)) *RetryReport {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Backoff == nil {
+		policy.Backoff = ConstantBackoff(0)
+	}
+	if !policy.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, policy.Deadline)
+		defer cancel()
+	}
 
+	report := &RetryReport{}
+	for attempt := 1; ; attempt++ {
+		r := &R{Attempt: attempt, log: &bytes.Buffer{}, ctx: ctx, retryable: policy.Retryable}
+		start := time.Now()
 		f(r)
+		report.Attempts = append(report.Attempts, AttemptReport{
+			Attempt:   attempt,
+			Duration:  time.Since(start),
+			Failed:    r.failed,
+			Permanent: r.permanent,
+			Line:      r.failLine,
+			Log:       r.log.String(),
+		})
 
-		if !r.failed {
-			if r.log.Len() != 0 {
-				r.Logf("Success after %d attempts:%s", attempt, r.log.String())
-			}
-			return true
+		switch {
+		case !r.failed:
+			report.Succeeded = true
+			report.StopReason = "succeeded"
+			return report
+		case r.permanent:
+			report.StopReason = "permanent failure"
+			return report
+		case attempt >= policy.MaxAttempts:
+			report.StopReason = "max attempts reached"
+			return report
 		}
 
-		if attempt == maxAttempts {
-			r.Logf("FAILED after %d attempts:%s", attempt, r.log.String())
-			return false
+		sleep := policy.Backoff.Delay(attempt)
+		if d, ok := ctx.Deadline(); ok && time.Now().Add(sleep).After(d) {
+			report.StopReason = "deadline reached"
+			return report
 		}
+		t := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			report.StopReason = "context done: " + ctx.Err().Error()
+			return report
+		case <-t.C:
+		}
+	}
+}
+
+// RetryPolicy configures RetryWithPolicy and RetryWithReport.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times f is run. Values below 1 mean 1.
+	MaxAttempts int
+	// Backoff decides how long to sleep between attempts. Nil means no sleep.
+	Backoff Backoff
+	// Deadline, if non-zero, is when to stop retrying. Retrying also stops
+	// if the next sleep would end after the deadline.
+	Deadline time.Time
+	// Retryable classifies errors passed as arguments to R.Errorf.
+	// If it returns false for any of them, the failure is permanent and f is
+	// not run again. Nil means every error is retryable.
+	Retryable func(err error) bool
+}
+
+// Backoff decides how long to sleep between attempts.
+type Backoff interface {
+	// Delay returns how long to sleep after the given failed attempt, starting at 1.
+	Delay(attempt int) time.Duration
+}
+
+// ConstantBackoff sleeps for the same duration after every attempt.
+type ConstantBackoff time.Duration
+
+// Delay implements Backoff.
+func (b ConstantBackoff) Delay(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff multiplies the sleep after every attempt, up to a maximum.
+type ExponentialBackoff struct {
+	// Initial is the sleep after the first attempt.
+	Initial time.Duration
+	// Max caps the sleep. Zero means no cap.
+	Max time.Duration
+	// Multiplier is applied after every attempt. Values of 1 or less mean 2.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, of each sleep that is randomized
+	// so that concurrent tests don't retry in lockstep.
+	Jitter float64
+}
 
-		time.Sleep(sleep)
+// Delay implements Backoff.
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	mult := b.Multiplier
+	if mult <= 1 {
+		mult = 2
 	}
-	return false
+	d := float64(b.Initial) * math.Pow(mult, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// RetryReport describes every attempt made by RetryWithReport.
+type RetryReport struct {
+	Attempts []AttemptReport
+	// Succeeded reports whether the last attempt succeeded.
+	Succeeded bool
+	// StopReason explains why no more attempts were made.
+	StopReason string
+}
+
+// AttemptReport describes a single attempt.
+type AttemptReport struct {
+	Attempt  int
+	Duration time.Duration
+	Failed   bool
+	// Permanent reports whether the failure stopped further attempts.
+	Permanent bool
+	// Line is the file:line of the first failure in the attempt.
+	Line string
+	// Log is everything logged during the attempt.
+	Log string
+}
+
+// String formats the report as a table with one row per attempt.
+func (rep *RetryReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ATTEMPT\tDURATION\tRESULT\tLINE\tERROR")
+	for _, a := range rep.Attempts {
+		result := "ok"
+		switch {
+		case a.Permanent:
+			result = "permanent"
+		case a.Failed:
+			result = "failed"
+		}
+		lastLine := strings.TrimSpace(a.Log)
+		if i := strings.LastIndex(lastLine, "\n"); i >= 0 {
+			lastLine = lastLine[i+1:]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", a.Attempt, a.Duration.Round(time.Millisecond), result, a.Line, lastLine)
+	}
+	w.Flush()
+	return buf.String()
 }
 
 // R is passed to each run of a flaky test run, manages state and accumulates log statements.
@@ -80,8 +240,21 @@ type R struct {
 	// The number of current attempt.
 	Attempt int
 
-	failed bool
-	log    *bytes.Buffer
+	failed    bool
+	permanent bool
+	failLine  string
+	log       *bytes.Buffer
+	ctx       context.Context
+	retryable func(error) bool
+}
+
+// Context returns a context that is done when retrying should stop, such as
+// when the test deadline is near.
+func (r *R) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
 // Fail marks the run as failed, and will retry once the function returns.
@@ -89,10 +262,33 @@ func (r *R) Fail() {
 	r.failed = true
 }
 
+// FailPermanent marks the run as failed, and stops further retries.
+func (r *R) FailPermanent() {
+	r.failed = true
+	r.permanent = true
+}
+
 // Errorf is equivalent to Logf followed by Fail.
+// If any argument is an error the retry policy does not consider retryable,
+// it is equivalent to Fatalf instead.
 func (r *R) Errorf(s string, v ...interface{}) {
-	r.logf(s, v...)
+	r.markFailLine(r.logf(s, v...))
 	r.Fail()
+	if r.retryable == nil {
+		return
+	}
+	for _, arg := range v {
+		if err, ok := arg.(error); ok && !r.retryable(err) {
+			r.FailPermanent()
+		}
+	}
+}
+
+// Fatalf is equivalent to Logf followed by FailPermanent.
+// Unlike testing.T.Fatalf, it does not stop the function; return after calling it.
+func (r *R) Fatalf(s string, v ...interface{}) {
+	r.markFailLine(r.logf(s, v...))
+	r.FailPermanent()
 }
 
 // Logf formats its arguments and records it in the error log.
@@ -101,10 +297,21 @@ func (r *R) Logf(s string, v ...interface{}) {
 	r.logf(s, v...)
 }
 
-func (r *R) logf(s string, v ...interface{}) {
+func (r *R) markFailLine(line string) {
+	if r.failLine == "" {
+		r.failLine = line
+	}
+}
+
+// logf records the message and returns the file:line it was logged from.
+func (r *R) logf(s string, v ...interface{}) string {
+	line := lineNumber()
 	fmt.Fprint(r.log, "\n")
-	fmt.Fprint(r.log, lineNumber())
+	if line != "" {
+		fmt.Fprint(r.log, line+": ")
+	}
 	fmt.Fprintf(r.log, s, v...)
+	return line
 }
 
 func lineNumber() string {
@@ -112,5 +319,5 @@ func lineNumber() string {
 	if !ok {
 		return ""
 	}
-	return filepath.Base(file) + ":" + strconv.Itoa(line) + ": "
+	return filepath.Base(file) + ":" + strconv.Itoa(line)
 }
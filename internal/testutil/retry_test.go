@@ -15,6 +15,9 @@
 package testutil
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -74,3 +77,82 @@ func TestRetryWithoutTestAttempts(t *testing.T) {
 		t.Errorf("attempts=%d; want %d", attempts, 5)
 	}
 }
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	for attempt, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+	} {
+		if got := b.Delay(attempt); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := b.Delay(2); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("Delay(2) with jitter = %v, want between 100ms and 200ms", got)
+		}
+	}
+}
+
+func TestRetryWithReportPermanent(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	policy := RetryPolicy{
+		MaxAttempts: 10,
+		Retryable:   func(err error) bool { return err != errPermanent },
+	}
+	report := RetryWithReport(context.Background(), policy, func(r *R) {
+		if r.Attempt == 3 {
+			r.Errorf("giving up: %v", errPermanent)
+			return
+		}
+		r.Errorf("retrying: %v", errors.New("transient"))
+	})
+
+	if report.Succeeded {
+		t.Fatalf("report.Succeeded = true, want false")
+	}
+	if got := len(report.Attempts); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+	last := report.Attempts[len(report.Attempts)-1]
+	if !last.Permanent || !strings.HasPrefix(last.Line, "retry_test.go:") {
+		t.Errorf("last attempt = %+v, want permanent failure with line number", last)
+	}
+	if !strings.Contains(report.String(), "giving up: permanent") {
+		t.Errorf("report.String() = %q, want it to contain the last error", report.String())
+	}
+}
+
+func TestRetryWithReportFatalf(t *testing.T) {
+	report := RetryWithReport(context.Background(), RetryPolicy{MaxAttempts: 5}, func(r *R) {
+		r.Fatalf("stop")
+	})
+	if len(report.Attempts) != 1 || report.StopReason != "permanent failure" {
+		t.Errorf("got %d attempts, stop reason %q; want 1 attempt, permanent failure", len(report.Attempts), report.StopReason)
+	}
+}
+
+func TestRetryWithReportDeadline(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 100,
+		Backoff:     ConstantBackoff(50 * time.Millisecond),
+		Deadline:    time.Now().Add(120 * time.Millisecond),
+	}
+	report := RetryWithReport(context.Background(), policy, func(r *R) {
+		if _, ok := r.Context().Deadline(); !ok {
+			r.Fatalf("R.Context has no deadline")
+		}
+		r.Fail()
+	})
+	if n := len(report.Attempts); n < 2 || n > 4 {
+		t.Errorf("got %d attempts, want 2 to 4 before the deadline", n)
+	}
+	if report.StopReason != "deadline reached" {
+		t.Errorf("StopReason = %q, want deadline reached", report.StopReason)
+	}
+}
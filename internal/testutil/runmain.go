@@ -18,9 +18,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -101,3 +106,258 @@ func (r *Runner) Run(env map[string]string, timeout time.Duration, args ...strin
 	}
 	return bufOut.Bytes(), bufErr.Bytes(), nil
 }
+
+// Start starts the built binary and returns without waiting for it to exit,
+// so long-running programs such as servers can be tested. The process is
+// killed when the test finishes if it is still running. You can supply extra
+// arguments for the binary via args.
+func (r *Runner) Start(env map[string]string, args ...string) (*Process, error) {
+	if !r.Built() {
+		return nil, fmt.Errorf("tried to start when binary not built")
+	}
+
+	environ := os.Environ()
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+
+	p := &Process{
+		stdout: newOutput(),
+		stderr: newOutput(),
+		done:   make(chan struct{}),
+	}
+	p.cmd = exec.Command(r.bin, args...)
+	p.cmd.Env = environ
+	p.cmd.Stdout = p.stdout
+	p.cmd.Stderr = p.stderr
+
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not execute binary: %w", err)
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		p.stdout.close()
+		p.stderr.close()
+		close(p.done)
+	}()
+	r.t.Cleanup(func() {
+		select {
+		case <-p.done:
+		default:
+			p.cmd.Process.Kill()
+			<-p.done
+		}
+	})
+	return p, nil
+}
+
+// Process is a running instance of a binary built by BuildMain.
+type Process struct {
+	cmd            *exec.Cmd
+	stdout, stderr *Output
+	done           chan struct{}
+	err            error
+}
+
+// Stdout returns the process's standard output.
+func (p *Process) Stdout() *Output { return p.stdout }
+
+// Stderr returns the process's standard error.
+func (p *Process) Stderr() *Output { return p.stderr }
+
+// Done returns a channel that is closed when the process exits.
+func (p *Process) Done() <-chan struct{} { return p.done }
+
+// Wait waits for the process to exit and returns its exit error, if any.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// ExitCode returns the exit code of the process, or -1 if it is still
+// running or was terminated by a signal.
+func (p *Process) ExitCode() int {
+	select {
+	case <-p.done:
+		return p.cmd.ProcessState.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// Signal sends sig to the process.
+func (p *Process) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+// Stop sends SIGTERM to the process and waits up to timeout for it to exit,
+// killing it if it does not. It returns the process's exit error, if any.
+func (p *Process) Stop(timeout time.Duration) error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		p.cmd.Process.Kill()
+		return p.Wait()
+	}
+	select {
+	case <-p.done:
+		return p.err
+	case <-time.After(timeout):
+		p.cmd.Process.Kill()
+		<-p.done
+		return fmt.Errorf("process did not exit within %s of SIGTERM and was killed: %w", timeout, p.err)
+	}
+}
+
+// WaitForPort waits up to timeout for addr, such as "localhost:8080", to
+// accept TCP connections.
+func (p *Process) WaitForPort(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-p.done:
+			return fmt.Errorf("process exited before %s was ready: %v", addr, p.err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not ready after %s: %w", addr, timeout, err)
+		}
+	}
+}
+
+// WaitForLog waits up to timeout for substr to appear in the process's
+// stdout or stderr.
+func (p *Process) WaitForLog(substr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	found := make(chan struct{}, 2)
+	for _, o := range []*Output{p.stdout, p.stderr} {
+		go func(o *Output) {
+			if o.waitFor(ctx, substr) {
+				found <- struct{}{}
+			}
+		}(o)
+	}
+	select {
+	case <-found:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%q not logged after %s", substr, timeout)
+	case <-p.done:
+		// The output may have been written just before exit.
+		if p.stdout.Contains(substr) || p.stderr.Contains(substr) {
+			return nil
+		}
+		return fmt.Errorf("process exited before logging %q: %v", substr, p.err)
+	}
+}
+
+// Output captures a stream of process output while it is written.
+// It is safe for concurrent use.
+type Output struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newOutput() *Output {
+	o := &Output{}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// Write implements io.Writer.
+func (o *Output) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, err := o.buf.Write(b)
+	o.cond.Broadcast()
+	return n, err
+}
+
+func (o *Output) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.cond.Broadcast()
+}
+
+// Bytes returns a copy of the output written so far.
+func (o *Output) Bytes() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]byte(nil), o.buf.Bytes()...)
+}
+
+// String returns the output written so far.
+func (o *Output) String() string {
+	return string(o.Bytes())
+}
+
+// Contains reports whether substr has been written so far.
+func (o *Output) Contains(substr string) bool {
+	return strings.Contains(o.String(), substr)
+}
+
+// NewReader returns a reader that streams the output from the beginning.
+// Reads block until more output is written, and return io.EOF once the
+// process has exited and all output has been read.
+func (o *Output) NewReader() io.Reader {
+	return &outputReader{o: o}
+}
+
+// waitFor blocks until substr is written, the output is closed, or ctx is done.
+func (o *Output) waitFor(ctx context.Context, substr string) bool {
+	// Wake up the wait below when ctx is done.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			o.mu.Lock()
+			o.cond.Broadcast()
+			o.mu.Unlock()
+		case <-finished:
+		}
+	}()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for {
+		if strings.Contains(o.buf.String(), substr) {
+			return true
+		}
+		if o.closed || ctx.Err() != nil {
+			return false
+		}
+		o.cond.Wait()
+	}
+}
+
+type outputReader struct {
+	o   *Output
+	off int
+}
+
+func (r *outputReader) Read(b []byte) (int, error) {
+	r.o.mu.Lock()
+	defer r.o.mu.Unlock()
+	for r.off >= r.o.buf.Len() {
+		if r.o.closed {
+			return 0, io.EOF
+		}
+		r.o.cond.Wait()
+	}
+	n := copy(b, r.o.buf.Bytes()[r.off:])
+	r.off += n
+	return n, nil
+}
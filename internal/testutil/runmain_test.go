@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunnerStart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM is not supported on Windows")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("testdata/server"); err != nil {
+		t.Fatal(err)
+	}
+	m := BuildMain(t)
+	os.Chdir(wd)
+	defer m.Cleanup()
+	if !m.Built() {
+		t.Fatal("failed to build testdata/server")
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	p, err := m.Start(map[string]string{"PORT": port})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := p.WaitForLog("listening on", 10*time.Second); err != nil {
+		t.Fatalf("WaitForLog: %v", err)
+	}
+	line, err := bufio.NewReader(p.Stdout().NewReader()).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "listening on") {
+		t.Errorf("first stdout line = %q, %v; want listening on ...", line, err)
+	}
+	if err := p.WaitForPort("localhost:"+port, 10*time.Second); err != nil {
+		t.Fatalf("WaitForPort: %v", err)
+	}
+	resp, err := http.Get("http://localhost:" + port)
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello\n" {
+		t.Errorf("response = %q, want %q", body, "hello\n")
+	}
+
+	if err := p.Stop(10 * time.Second); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if code := p.ExitCode(); code != 0 {
+		t.Errorf("ExitCode = %d, want 0", code)
+	}
+	if !p.Stderr().Contains("shutting down") {
+		t.Errorf("stderr = %q, want it to contain %q", p.Stderr(), "shutting down")
+	}
+}
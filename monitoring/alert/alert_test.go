@@ -67,6 +67,40 @@ func TestRestorePolicies(t *testing.T) {
 	}
 }
 
+func TestDiffPolicies(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	buf := new(bytes.Buffer)
+	if err := backupPolicies(buf, tc.ProjectID); err != nil {
+		t.Fatalf("backupPolicies got error: %v", err)
+	}
+	backup := strings.NewReader(buf.String())
+	buf.Reset()
+	if err := diffPolicies(buf, tc.ProjectID, backup); err != nil {
+		t.Fatalf("diffPolicies got error: %v", err)
+	}
+	want := "No differences."
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Fatalf("diffPolicies got %v, want substring %q", got, want)
+	}
+}
+
+func TestRestoreSelectedDryRun(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	buf := new(bytes.Buffer)
+	if err := backupPolicies(buf, tc.ProjectID); err != nil {
+		t.Fatalf("backupPolicies got error: %v", err)
+	}
+	backup := strings.NewReader(buf.String())
+	buf.Reset()
+	if err := restoreSelected(buf, tc.ProjectID, backup, restoreOptions{DryRun: true, Prune: true}); err != nil {
+		t.Fatalf("restoreSelected got error: %v", err)
+	}
+	want := "Dry run: 0 operations planned"
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Fatalf("restoreSelected got %v, want substring %q", got, want)
+	}
+}
+
 func TestReplaceChannels(t *testing.T) {
 	tc := testutil.SystemTest(t)
 	ctx := context.Background()
@@ -0,0 +1,305 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/api/iterator"
+)
+
+// diffPolicies compares the alert policies and notification channels in the
+// backup in r with those in the project. Policies and channels only in the
+// project are shown as added, those only in the backup as removed, and
+// changed fields as "path: backup value -> project value".
+func diffPolicies(w io.Writer, projectID string, r io.Reader) error {
+	b := backup{}
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	live, err := loadProject(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	d := diffBackups(&b, live)
+	if len(d) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return nil
+	}
+	for _, e := range d {
+		e.print(w)
+	}
+	return nil
+}
+
+// loadProject reads the alert policies and notification channels in the
+// project.
+func loadProject(ctx context.Context, projectID string) (*backup, error) {
+	b := &backup{ProjectID: projectID}
+
+	alertClient, err := monitoring.NewAlertPolicyClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer alertClient.Close()
+	alertIt := alertClient.ListAlertPolicies(ctx, &monitoringpb.ListAlertPoliciesRequest{
+		Name: "projects/" + projectID,
+	})
+	for {
+		resp, err := alertIt.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListAlertPolicies: %w", err)
+		}
+		b.AlertPolicies = append(b.AlertPolicies, &alertPolicy{resp})
+	}
+
+	channelClient, err := monitoring.NewNotificationChannelClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer channelClient.Close()
+	channelIt := channelClient.ListNotificationChannels(ctx, &monitoringpb.ListNotificationChannelsRequest{
+		Name: "projects/" + projectID,
+	})
+	for {
+		resp, err := channelIt.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListNotificationChannels: %w", err)
+		}
+		b.Channels = append(b.Channels, &channel{resp})
+	}
+	return b, nil
+}
+
+// resource is an alert policy or notification channel.
+type resource interface {
+	proto.Message
+	GetName() string
+	GetDisplayName() string
+}
+
+// matchResources pairs resources from a backup with resources in the
+// project, first by name and then, for resources recreated under a new name
+// or backed up from another project, by display name. It returns the index
+// of the matching project resource for each backup resource, or -1.
+func matchResources(backup, live []resource) []int {
+	matches := make([]int, len(backup))
+	claimed := make([]bool, len(live))
+	for i, r := range backup {
+		matches[i] = -1
+		for j, l := range live {
+			if !claimed[j] && r.GetName() != "" && r.GetName() == l.GetName() {
+				matches[i], claimed[j] = j, true
+				break
+			}
+		}
+	}
+	for i, r := range backup {
+		if matches[i] >= 0 {
+			continue
+		}
+		for j, l := range live {
+			if !claimed[j] && r.GetDisplayName() == l.GetDisplayName() {
+				matches[i], claimed[j] = j, true
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func policyResources(ps []*alertPolicy) []resource {
+	rs := make([]resource, len(ps))
+	for i, p := range ps {
+		rs[i] = p.AlertPolicy
+	}
+	return rs
+}
+
+func channelResources(cs []*channel) []resource {
+	rs := make([]resource, len(cs))
+	for i, c := range cs {
+		rs[i] = c.NotificationChannel
+	}
+	return rs
+}
+
+// fieldChange is a difference in one field. Values are JSON, and empty if
+// the field is not set.
+type fieldChange struct {
+	Path     string
+	Old, New string
+}
+
+// ignoredFields are the fields that are set by the API rather than being
+// part of a policy or channel's configuration.
+var ignoredFields = regexp.MustCompile(`^(name|creationRecord|mutationRecord|verificationStatus|conditions\[\d+\]\.name)(\.|\[|$)`)
+
+// diffFields returns the fields that differ between old and new, sorted by
+// path.
+func diffFields(old, new proto.Message) []fieldChange {
+	o, n := flattenProto(old), flattenProto(new)
+	var changes []fieldChange
+	for path, ov := range o {
+		if nv := n[path]; ov != nv {
+			changes = append(changes, fieldChange{path, ov, nv})
+		}
+	}
+	for path, nv := range n {
+		if _, ok := o[path]; !ok {
+			changes = append(changes, fieldChange{path, "", nv})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// flattenProto returns the configuration fields of m as a map from field
+// paths, such as "conditions[0].displayName", to JSON values.
+func flattenProto(m proto.Message) map[string]string {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, m); err != nil {
+		return map[string]string{"": err.Error()}
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		return map[string]string{"": err.Error()}
+	}
+	out := make(map[string]string)
+	flatten("", v, out)
+	for path := range out {
+		if ignoredFields.MatchString(path) {
+			delete(out, path)
+		}
+	}
+	return out
+}
+
+func flatten(prefix string, v interface{}, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flatten(k, e, out)
+		}
+	case []interface{}:
+		for i, e := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), e, out)
+		}
+	default:
+		b, _ := json.Marshal(v)
+		out[prefix] = string(b)
+	}
+}
+
+// diffEntry is a policy or channel that differs between a backup and the
+// project.
+type diffEntry struct {
+	Kind        string // "added", "removed" or "changed".
+	Resource    string // "policy" or "channel".
+	DisplayName string
+	Changes     []fieldChange
+}
+
+func (e diffEntry) print(w io.Writer) {
+	fmt.Fprintf(w, "%s %s %q\n", e.Kind, e.Resource, e.DisplayName)
+	for _, c := range e.Changes {
+		fmt.Fprintf(w, "    %s: %s -> %s\n", c.Path, orUnset(c.Old), orUnset(c.New))
+	}
+}
+
+func orUnset(v string) string {
+	if v == "" {
+		return "(unset)"
+	}
+	return v
+}
+
+// diffBackups compares the backup b with the project's live policies and
+// channels. Channels referenced by policies are compared by their display
+// names, so that policies backed up from another project can match.
+func diffBackups(b, live *backup) []diffEntry {
+	var d []diffEntry
+	channelMatches := matchResources(channelResources(b.Channels), channelResources(live.Channels))
+	d = append(d, diffResources("channel", channelResources(b.Channels), channelResources(live.Channels), channelMatches)...)
+
+	// Rewrite the backup's channel references to the matching live channels.
+	channelNames := make(map[string]string)
+	for i, j := range channelMatches {
+		if j >= 0 {
+			channelNames[b.Channels[i].GetName()] = live.Channels[j].GetName()
+		}
+	}
+	policies := make([]resource, len(b.AlertPolicies))
+	for i, p := range b.AlertPolicies {
+		policies[i] = withChannels(p.AlertPolicy, channelNames)
+	}
+	policyMatches := matchResources(policies, policyResources(live.AlertPolicies))
+	d = append(d, diffResources("policy", policies, policyResources(live.AlertPolicies), policyMatches)...)
+	return d
+}
+
+func diffResources(kind string, backup, live []resource, matches []int) []diffEntry {
+	var d []diffEntry
+	matched := make([]bool, len(live))
+	for i, r := range backup {
+		j := matches[i]
+		if j < 0 {
+			d = append(d, diffEntry{Kind: "removed", Resource: kind, DisplayName: r.GetDisplayName()})
+			continue
+		}
+		matched[j] = true
+		if changes := diffFields(r, live[j]); len(changes) > 0 {
+			d = append(d, diffEntry{Kind: "changed", Resource: kind, DisplayName: r.GetDisplayName(), Changes: changes})
+		}
+	}
+	for j, l := range live {
+		if !matched[j] {
+			d = append(d, diffEntry{Kind: "added", Resource: kind, DisplayName: l.GetDisplayName()})
+		}
+	}
+	return d
+}
+
+// withChannels returns a copy of the policy with its notification channels
+// renamed according to names.
+func withChannels(p *monitoringpb.AlertPolicy, names map[string]string) *monitoringpb.AlertPolicy {
+	p = proto.Clone(p).(*monitoringpb.AlertPolicy)
+	for i, c := range p.NotificationChannels {
+		if n, ok := names[c]; ok {
+			p.NotificationChannels[i] = n
+		}
+	}
+	return p
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+func testPolicy(project, id, displayName string, enabled bool, labels map[string]string, channels ...string) *alertPolicy {
+	p := &monitoringpb.AlertPolicy{
+		Name:        fmt.Sprintf("projects/%s/alertPolicies/%s", project, id),
+		DisplayName: displayName,
+		Enabled:     &wrappers.BoolValue{Value: enabled},
+		UserLabels:  labels,
+		Combiner:    monitoringpb.AlertPolicy_OR,
+		Conditions: []*monitoringpb.AlertPolicy_Condition{{
+			Name:        fmt.Sprintf("projects/%s/alertPolicies/%s/conditions/1", project, id),
+			DisplayName: displayName + " condition",
+		}},
+		CreationRecord: &monitoringpb.MutationRecord{MutatedBy: project + "-user"},
+	}
+	for _, c := range channels {
+		p.NotificationChannels = append(p.NotificationChannels, fmt.Sprintf("projects/%s/notificationChannels/%s", project, c))
+	}
+	return &alertPolicy{p}
+}
+
+func testChannel(project, id, displayName, email string) *channel {
+	return &channel{&monitoringpb.NotificationChannel{
+		Name:        fmt.Sprintf("projects/%s/notificationChannels/%s", project, id),
+		Type:        "email",
+		DisplayName: displayName,
+		Labels:      map[string]string{"email_address": email},
+	}}
+}
+
+// testBackups returns a backup of project "old", and project "new" which has
+// the same policies and channels with different names and some changes.
+func testBackups() (b, live *backup) {
+	b = &backup{
+		ProjectID: "old",
+		AlertPolicies: []*alertPolicy{
+			testPolicy("old", "1", "CPU high", true, map[string]string{"team": "infra"}, "10"),
+			testPolicy("old", "2", "Disk full", true, map[string]string{"team": "infra"}, "10"),
+			testPolicy("old", "3", "Errors", true, map[string]string{"team": "app"}, "11"),
+		},
+		Channels: []*channel{
+			testChannel("old", "10", "Ops", "ops@example.com"),
+			testChannel("old", "11", "App", "app@example.com"),
+		},
+	}
+	live = &backup{
+		ProjectID: "new",
+		AlertPolicies: []*alertPolicy{
+			testPolicy("new", "a", "CPU high", true, map[string]string{"team": "infra"}, "x"),
+			testPolicy("new", "b", "Disk full", false, map[string]string{"team": "infra"}, "x"),
+			testPolicy("new", "c", "Latency", true, map[string]string{"team": "infra"}, "x"),
+		},
+		Channels: []*channel{
+			testChannel("new", "x", "Ops", "oncall@example.com"),
+		},
+	}
+	return b, live
+}
+
+func TestDiffBackups(t *testing.T) {
+	b, live := testBackups()
+	var buf bytes.Buffer
+	for _, e := range diffBackups(b, live) {
+		e.print(&buf)
+	}
+	want := `changed channel "Ops"
+    labels.email_address: "ops@example.com" -> "oncall@example.com"
+removed channel "App"
+changed policy "Disk full"
+    enabled: true -> false
+removed policy "Errors"
+added policy "Latency"
+`
+	if got := buf.String(); got != want {
+		t.Errorf("diffBackups got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if d := diffBackups(b, b); len(d) != 0 {
+		t.Errorf("diffBackups(b, b) = %+v, want no differences", d)
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	tests := []struct {
+		label string
+		opts  restoreOptions
+		want  []string
+	}{
+		{
+			label: "all",
+			want:  []string{"update channel Ops", "create channel App", "update policy Disk full", "create policy Errors"},
+		},
+		{
+			label: "prune",
+			opts:  restoreOptions{Prune: true},
+			want:  []string{"update channel Ops", "create channel App", "update policy Disk full", "create policy Errors", "delete policy Latency"},
+		},
+		{
+			label: "display name",
+			opts:  restoreOptions{DisplayName: regexp.MustCompile(`^Err`)},
+			want:  []string{"create channel App", "create policy Errors"},
+		},
+		{
+			label: "labels and prune",
+			opts:  restoreOptions{Labels: map[string]string{"team": "infra"}, Prune: true},
+			want:  []string{"update channel Ops", "update policy Disk full", "delete policy Latency"},
+		},
+		{
+			label: "no match",
+			opts:  restoreOptions{Labels: map[string]string{"team": "none"}, Prune: true},
+		},
+	}
+	for _, test := range tests {
+		b, live := testBackups()
+		var got []string
+		for _, op := range planRestore(b, live, test.opts) {
+			r := op.Backup
+			if r == nil {
+				r = op.Live
+			}
+			got = append(got, op.Kind+" "+op.Resource+" "+r.GetDisplayName())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: planRestore got %q, want %q", test.label, got, test.want)
+		}
+	}
+}
+
+func TestPlanRestoreChannels(t *testing.T) {
+	b, live := testBackups()
+	for _, op := range planRestore(b, live, restoreOptions{}) {
+		if op.Resource != "policy" {
+			continue
+		}
+		p := op.Backup.(*monitoringpb.AlertPolicy)
+		want := "projects/new/notificationChannels/x"
+		if p.GetDisplayName() == "Errors" {
+			// The App channel is created when the plan is applied.
+			want = "projects/old/notificationChannels/11"
+		}
+		if got := p.GetNotificationChannels(); len(got) != 1 || got[0] != want {
+			t.Errorf("%s %q uses channels %q, want [%q]", op.Kind, p.GetDisplayName(), got, want)
+		}
+	}
+	// The backup itself is not modified.
+	if got := b.AlertPolicies[1].GetNotificationChannels()[0]; got != "projects/old/notificationChannels/10" {
+		t.Errorf("backup policy channel changed to %q", got)
+	}
+}
+
+func TestDiffBackupJSON(t *testing.T) {
+	// Backups read from a file compare equal to the policies they were
+	// written from.
+	b, _ := testBackups()
+	bs, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read backup
+	if err := json.Unmarshal(bs, &read); err != nil {
+		t.Fatal(err)
+	}
+	if d := diffBackups(&read, b); len(d) != 0 {
+		t.Errorf("diffBackups after JSON round trip = %+v, want no differences", d)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// restoreOptions select what restoreSelected restores.
+type restoreOptions struct {
+	// DryRun prints the planned operations without applying them.
+	DryRun bool
+	// DisplayName, if set, restores only the policies whose display names
+	// match it.
+	DisplayName *regexp.Regexp
+	// Labels, if set, restores only the policies with all of these user
+	// labels.
+	Labels map[string]string
+	// Prune deletes the selected policies in the project that are not in the
+	// backup. Channels are never deleted, since policies that were not
+	// selected may use them.
+	Prune bool
+}
+
+// selects reports whether the options select the policy.
+func (o restoreOptions) selects(p *monitoringpb.AlertPolicy) bool {
+	if o.DisplayName != nil && !o.DisplayName.MatchString(p.GetDisplayName()) {
+		return false
+	}
+	for k, v := range o.Labels {
+		if got, ok := p.GetUserLabels()[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// operation is a planned change to a policy or channel in the project.
+type operation struct {
+	Kind     string // "create", "update" or "delete".
+	Resource string // "policy" or "channel".
+	// Backup is the policy or channel to create or update to, and Live is
+	// the one to update or delete.
+	Backup, Live resource
+	Changes      []fieldChange
+}
+
+func (op operation) print(w io.Writer) {
+	r := op.Backup
+	if r == nil {
+		r = op.Live
+	}
+	fmt.Fprintf(w, "%s %s %q\n", op.Kind, op.Resource, r.GetDisplayName())
+	for _, c := range op.Changes {
+		fmt.Fprintf(w, "    %s: %s -> %s\n", c.Path, orUnset(c.Old), orUnset(c.New))
+	}
+}
+
+// planRestore returns the operations that make the selected policies in the
+// project, and the channels they use, match the backup. Policies and
+// channels that already match are left alone. The policies in the operations
+// use the names of the matching channels in the project.
+func planRestore(b, live *backup, opts restoreOptions) []operation {
+	var selected []*alertPolicy
+	used := make(map[string]bool)
+	for _, p := range b.AlertPolicies {
+		if opts.selects(p.AlertPolicy) {
+			selected = append(selected, p)
+			for _, c := range p.GetNotificationChannels() {
+				used[c] = true
+			}
+		}
+	}
+	filtered := opts.DisplayName != nil || len(opts.Labels) > 0
+
+	var ops []operation
+	channelMatches := matchResources(channelResources(b.Channels), channelResources(live.Channels))
+	for i, c := range b.Channels {
+		if filtered && !used[c.GetName()] {
+			continue
+		}
+		j := channelMatches[i]
+		if j < 0 {
+			ops = append(ops, operation{Kind: "create", Resource: "channel", Backup: c.NotificationChannel})
+			continue
+		}
+		l := live.Channels[j].NotificationChannel
+		if changes := diffFields(l, c.NotificationChannel); len(changes) > 0 {
+			ops = append(ops, operation{Kind: "update", Resource: "channel", Backup: c.NotificationChannel, Live: l, Changes: changes})
+		}
+	}
+
+	// Compare policies as if they used the matching live channels.
+	channelNames := make(map[string]string)
+	for i, j := range channelMatches {
+		if j >= 0 {
+			channelNames[b.Channels[i].GetName()] = live.Channels[j].GetName()
+		}
+	}
+	policies := make([]resource, len(selected))
+	for i, p := range selected {
+		policies[i] = withChannels(p.AlertPolicy, channelNames)
+	}
+	livePolicies := policyResources(live.AlertPolicies)
+	policyMatches := matchResources(policies, livePolicies)
+	matched := make([]bool, len(livePolicies))
+	for i := range selected {
+		j := policyMatches[i]
+		if j < 0 {
+			ops = append(ops, operation{Kind: "create", Resource: "policy", Backup: policies[i]})
+			continue
+		}
+		matched[j] = true
+		if changes := diffFields(livePolicies[j], policies[i]); len(changes) > 0 {
+			ops = append(ops, operation{Kind: "update", Resource: "policy", Backup: policies[i], Live: livePolicies[j], Changes: changes})
+		}
+	}
+	if opts.Prune {
+		for j, l := range live.AlertPolicies {
+			if !matched[j] && opts.selects(l.AlertPolicy) {
+				ops = append(ops, operation{Kind: "delete", Resource: "policy", Live: l.AlertPolicy})
+			}
+		}
+	}
+	return ops
+}
+
+// restoreSelected updates the project with the alert policies in r selected
+// by opts, and the notification channels they use. Unlike restorePolicies,
+// it only changes policies and channels that differ from the backup, and
+// can print the planned operations without applying them.
+func restoreSelected(w io.Writer, projectID string, r io.Reader, opts restoreOptions) error {
+	b := backup{}
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	live, err := loadProject(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	ops := planRestore(&b, live, opts)
+	for _, op := range ops {
+		op.print(w)
+	}
+	if opts.DryRun {
+		fmt.Fprintf(w, "Dry run: %d operations planned, none applied.\n", len(ops))
+		return nil
+	}
+	if err := applyPlan(ctx, projectID, ops); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Successfully applied %d operations.\n", len(ops))
+	return nil
+}
+
+// applyPlan applies the operations in order. Channels must come before the
+// policies using them.
+func applyPlan(ctx context.Context, projectID string, ops []operation) error {
+	alertClient, err := monitoring.NewAlertPolicyClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer alertClient.Close()
+	channelClient, err := monitoring.NewNotificationChannelClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer channelClient.Close()
+
+	// channelNames maps the names of channels in the backup to the names of
+	// the channels created for them.
+	channelNames := make(map[string]string)
+	for _, op := range ops {
+		switch {
+		case op.Resource == "channel" && op.Kind == "create":
+			c := proto.Clone(op.Backup).(*monitoringpb.NotificationChannel)
+			c.Name = ""
+			c.VerificationStatus = monitoringpb.NotificationChannel_VERIFICATION_STATUS_UNSPECIFIED
+			created, err := channelClient.CreateNotificationChannel(ctx, &monitoringpb.CreateNotificationChannelRequest{
+				Name:                "projects/" + projectID,
+				NotificationChannel: c,
+			})
+			if err != nil {
+				return fmt.Errorf("CreateNotificationChannel(%q): %w", c.GetDisplayName(), err)
+			}
+			channelNames[op.Backup.GetName()] = created.GetName()
+
+		case op.Resource == "channel" && op.Kind == "update":
+			c := proto.Clone(op.Backup).(*monitoringpb.NotificationChannel)
+			c.Name = op.Live.GetName()
+			c.VerificationStatus = monitoringpb.NotificationChannel_VERIFICATION_STATUS_UNSPECIFIED
+			if _, err := channelClient.UpdateNotificationChannel(ctx, &monitoringpb.UpdateNotificationChannelRequest{
+				NotificationChannel: c,
+			}); err != nil {
+				return fmt.Errorf("UpdateNotificationChannel(%q): %w", c.GetDisplayName(), err)
+			}
+
+		case op.Resource == "policy" && (op.Kind == "create" || op.Kind == "update"):
+			p := withChannels(op.Backup.(*monitoringpb.AlertPolicy), channelNames)
+			p.CreationRecord = nil
+			p.MutationRecord = nil
+			if op.Kind == "create" || p.GetName() != op.Live.GetName() {
+				// Condition names belong to the backed up policy.
+				for _, c := range p.Conditions {
+					c.Name = ""
+				}
+			}
+			if op.Kind == "create" {
+				p.Name = ""
+				if _, err := alertClient.CreateAlertPolicy(ctx, &monitoringpb.CreateAlertPolicyRequest{
+					Name:        "projects/" + projectID,
+					AlertPolicy: p,
+				}); err != nil {
+					return fmt.Errorf("CreateAlertPolicy(%q): %w", p.GetDisplayName(), err)
+				}
+				continue
+			}
+			p.Name = op.Live.GetName()
+			if _, err := alertClient.UpdateAlertPolicy(ctx, &monitoringpb.UpdateAlertPolicyRequest{
+				AlertPolicy: p,
+			}); err != nil {
+				return fmt.Errorf("UpdateAlertPolicy(%q): %w", p.GetDisplayName(), err)
+			}
+
+		case op.Resource == "policy" && op.Kind == "delete":
+			if err := alertClient.DeleteAlertPolicy(ctx, &monitoringpb.DeleteAlertPolicyRequest{
+				Name: op.Live.GetName(),
+			}); err != nil {
+				return fmt.Errorf("DeleteAlertPolicy(%q): %w", op.Live.GetDisplayName(), err)
+			}
+
+		default:
+			return fmt.Errorf("unknown operation %s %s", op.Kind, op.Resource)
+		}
+	}
+	return nil
+}
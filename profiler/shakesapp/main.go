@@ -42,6 +42,10 @@ var (
 	enableHeapAlloc  = flag.Bool("heap_alloc", false, "enable heap allocation profile collection")
 	enableThread     = flag.Bool("thread", false, "enable thread profile collection")
 	enableContention = flag.Bool("contention", false, "enable contention profile collection")
+	corpusDir        = flag.String("corpus_dir", "", "local directory to read the texts from instead of Cloud Storage")
+	cacheCorpus      = flag.Bool("cache_corpus", false, "keep the texts in memory instead of reading them for every request")
+	refreshInterval  = flag.Duration("refresh_interval", 0, "how often to read the cached texts again (0 for never)")
+	regexpCacheSize  = flag.Int("regexp_cache_size", 0, "number of compiled queries to cache (0 to compile for every line)")
 )
 
 func main() {
@@ -61,7 +65,12 @@ func main() {
 	}
 
 	server := grpc.NewServer()
-	shakesapp.RegisterShakespeareServiceServer(server, shakesapp.NewServer())
+	shakesapp.RegisterShakespeareServiceServer(server, shakesapp.NewServerWithConfig(shakesapp.Config{
+		CorpusDir:       *corpusDir,
+		CacheCorpus:     *cacheCorpus,
+		RefreshInterval: *refreshInterval,
+		RegexpCacheSize: *regexpCacheSize,
+	}))
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
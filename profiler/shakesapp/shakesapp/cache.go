@@ -0,0 +1,148 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shakesapp
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// corpusCache keeps the lines of the corpus in memory.
+type corpusCache struct {
+	load    func(ctx context.Context) ([]string, error)
+	refresh time.Duration // Zero to never refresh.
+	now     func() time.Time
+
+	mu      sync.Mutex
+	lines   []string
+	loaded  time.Time
+	err     error         // From the last load.
+	loading chan struct{} // Closed when the load in progress ends; nil if none.
+}
+
+func newCorpusCache(load func(ctx context.Context) ([]string, error), refresh time.Duration) *corpusCache {
+	return &corpusCache{load: load, refresh: refresh, now: time.Now}
+}
+
+// get returns the lower-cased lines of the corpus. The first call waits for
+// them to load. Once they are older than the refresh interval, they are
+// reloaded in the background, and the previous lines are returned until the
+// reload succeeds.
+func (c *corpusCache) get(ctx context.Context) ([]string, error) {
+	c.mu.Lock()
+	lines := c.lines
+	if lines != nil && (c.refresh == 0 || c.now().Sub(c.loaded) < c.refresh) {
+		c.mu.Unlock()
+		return lines, nil
+	}
+	done := c.startLoad()
+	c.mu.Unlock()
+	if lines != nil {
+		return lines, nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lines == nil {
+		return nil, c.err
+	}
+	return c.lines, nil
+}
+
+// startLoad starts loading the corpus, unless a load is already in progress,
+// and returns a channel that is closed when the load ends. c.mu must be
+// held.
+func (c *corpusCache) startLoad() <-chan struct{} {
+	if c.loading != nil {
+		return c.loading
+	}
+	done := make(chan struct{})
+	c.loading = done
+	go func() {
+		// The load is shared by every request, so it is not cancelled
+		// with any of them.
+		texts, err := c.load(context.Background())
+		var lines []string
+		if err == nil {
+			lines = splitLines(texts)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.err = err
+		switch {
+		case err == nil:
+			c.lines = lines
+			c.loaded = c.now()
+		case c.lines != nil:
+			log.Printf("Failed to refresh corpus, using the one loaded at %v: %v", c.loaded, err)
+		}
+		c.loading = nil
+		close(done)
+	}()
+	return done
+}
+
+// regexpCache keeps the most recently used compiled queries.
+type regexpCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // Of *regexpEntry, most recently used first.
+	entries map[string]*list.Element
+}
+
+type regexpEntry struct {
+	query string
+	re    *regexp.Regexp
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the compiled query, compiling it if it is not in the cache.
+func (c *regexpCache) get(query string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[query]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*regexpEntry).re, nil
+	}
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[query] = c.order.PushFront(&regexpEntry{query, re})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*regexpEntry).query)
+	}
+	return re, nil
+}
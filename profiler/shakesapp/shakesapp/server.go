@@ -20,23 +20,69 @@ package shakesapp
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// Config configures the server. The zero Config gives the original,
+// intentionally non-optimal server.
+type Config struct {
+	// CorpusDir is a local directory to read the texts from. If it is empty,
+	// the texts are read from gs://dataflow-samples/shakespeare/.
+	CorpusDir string
+	// CacheCorpus keeps the texts in memory instead of reading them for
+	// every request. They are read again once they are older than
+	// RefreshInterval, if it is set.
+	CacheCorpus     bool
+	RefreshInterval time.Duration
+	// RegexpCacheSize is the number of compiled queries to keep. If it is
+	// zero, the query is compiled again for every line.
+	RegexpCacheSize int
+}
+
 // server is an implementation of the server for ShakespeareService (defined
 // in shakesapp.proto).
-type server struct{}
+type server struct {
+	load    func(ctx context.Context) ([]string, error)
+	corpus  *corpusCache // nil if the corpus is not cached.
+	regexps *regexpCache // nil if queries are not cached.
+}
 
 // NewServer returns an implementation of the server for ShakespeareService
 // (defined in shakesapp.proto).
 func NewServer() ShakespeareServiceServer {
-	return &server{}
+	return NewServerWithConfig(Config{})
+}
+
+// NewServerWithConfig returns an implementation of the server for
+// ShakespeareService configured by cfg.
+func NewServerWithConfig(cfg Config) ShakespeareServiceServer {
+	s := &server{
+		load: func(ctx context.Context) ([]string, error) {
+			return readFiles(ctx, bucketName, bucketPrefix)
+		},
+	}
+	if cfg.CorpusDir != "" {
+		s.load = func(context.Context) ([]string, error) {
+			return readDir(cfg.CorpusDir)
+		}
+	}
+	if cfg.CacheCorpus {
+		s.corpus = newCorpusCache(s.load, cfg.RefreshInterval)
+	}
+	if cfg.RegexpCacheSize > 0 {
+		s.regexps = newRegexpCache(cfg.RegexpCacheSize)
+	}
+	return s
 }
 
 const bucketName = "dataflow-samples"
@@ -45,15 +91,13 @@ const bucketPrefix = "shakespeare/"
 // GetMatchCount implements a server for ShakespeareService.
 func (s *server) GetMatchCount(ctx context.Context, req *ShakespeareRequest) (*ShakespeareResponse, error) {
 	resp := &ShakespeareResponse{}
-	texts, err := readFiles(ctx, bucketName, bucketPrefix)
+	lines, err := s.lines(ctx)
 	if err != nil {
 		return resp, fmt.Errorf("fails to read files: %s", err)
 	}
-	for _, text := range texts {
-		for _, line := range strings.Split(text, "\n") {
-			line, query := strings.ToLower(line), strings.ToLower(req.Query)
-			// TODO: Compiling and matching a regular expression on every request
-			// might be too expensive? Consider optimizing.
+	query := strings.ToLower(req.Query)
+	if s.regexps == nil {
+		for _, line := range lines {
 			isMatch, err := regexp.MatchString(query, line)
 			if err != nil {
 				return resp, err
@@ -62,10 +106,64 @@ func (s *server) GetMatchCount(ctx context.Context, req *ShakespeareRequest) (*S
 				resp.MatchCount++
 			}
 		}
+		return resp, nil
+	}
+	re, err := s.regexps.get(query)
+	if err != nil {
+		return resp, err
+	}
+	for _, line := range lines {
+		if re.MatchString(line) {
+			resp.MatchCount++
+		}
 	}
 	return resp, nil
 }
 
+// lines returns the lower-cased lines of the corpus.
+func (s *server) lines(ctx context.Context) ([]string, error) {
+	if s.corpus != nil {
+		return s.corpus.get(ctx)
+	}
+	texts, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(texts), nil
+}
+
+// splitLines splits texts into lower-cased lines.
+func splitLines(texts []string) []string {
+	var lines []string
+	for _, text := range texts {
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, strings.ToLower(line))
+		}
+	}
+	return lines
+}
+
+// readDir reads the content of the regular files within dir and its
+// subdirectories.
+func readDir(dir string) ([]string, error) {
+	var texts []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		texts = append(texts, string(data))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read files in %s: %w", dir, err)
+	}
+	return texts, nil
+}
+
 // readFiles reads the content of files within the specified bucket with the
 // specified prefix path in parallel and returns their content. It fails if
 // operations to find or read any of the files fails.
@@ -84,7 +182,7 @@ func readFiles(ctx context.Context, bucketName, prefix string) ([]string, error)
 	bucket := client.Bucket(bucketName)
 
 	var paths []string
-	it := bucket.Objects(ctx, &storage.Query{Prefix: bucketPrefix})
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
 	for {
 		attrs, err := it.Next()
 		if err == iterator.Done {
@@ -98,13 +196,15 @@ func readFiles(ctx context.Context, bucketName, prefix string) ([]string, error)
 		}
 	}
 
-	resps := make(chan resp)
+	// The channel is buffered so that no goroutine is left blocked if
+	// reading stops early.
+	resps := make(chan resp, len(paths))
 	for _, path := range paths {
 		go func(path string) {
-			obj := bucket.Object(path)
-			r, err := obj.NewReader(ctx)
+			r, err := bucket.Object(path).NewReader(ctx)
 			if err != nil {
 				resps <- resp{"", err}
+				return
 			}
 			defer r.Close()
 			data, err := ioutil.ReadAll(r)
@@ -0,0 +1,214 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shakesapp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestGetMatchCount(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hamlet"), []byte("To be, or not to be\nHello\nhello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, cfg := range []Config{
+		{CorpusDir: dir},
+		{CorpusDir: dir, CacheCorpus: true, RegexpCacheSize: 1},
+	} {
+		s := NewServerWithConfig(cfg)
+		for _, tc := range []struct {
+			query string
+			want  int64
+		}{
+			{"hello", 2},
+			{"World", 1},
+			{"^to be", 1},
+			{"hello", 2},
+		} {
+			resp, err := s.GetMatchCount(ctx, &ShakespeareRequest{Query: tc.query})
+			if err != nil {
+				t.Fatalf("%+v: GetMatchCount(%q): %v", cfg, tc.query, err)
+			}
+			if resp.MatchCount != tc.want {
+				t.Errorf("%+v: GetMatchCount(%q) = %d, want %d", cfg, tc.query, resp.MatchCount, tc.want)
+			}
+		}
+		if _, err := s.GetMatchCount(ctx, &ShakespeareRequest{Query: "("}); err == nil {
+			t.Errorf("%+v: GetMatchCount(%q) succeeded, want error", cfg, "(")
+		}
+	}
+}
+
+// waitLoad waits for the load in progress in c, if any, to end.
+func waitLoad(c *corpusCache) {
+	c.mu.Lock()
+	done := c.loading
+	c.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestCorpusCacheRefresh(t *testing.T) {
+	loads := 0
+	var loadErr error
+	c := newCorpusCache(func(context.Context) ([]string, error) {
+		loads++
+		return []string{fmt.Sprint(loads)}, loadErr
+	}, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	check := func(want string) {
+		t.Helper()
+		lines, err := c.get(ctx)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if len(lines) != 1 || lines[0] != want {
+			t.Errorf("get = %q, want [%q]", lines, want)
+		}
+	}
+	check("1")
+	check("1")
+	// Stale lines are returned while they are refreshed.
+	now = now.Add(time.Minute)
+	check("1")
+	waitLoad(c)
+	check("2")
+	// A failed refresh keeps the previous lines.
+	now = now.Add(time.Minute)
+	loadErr = fmt.Errorf("unavailable")
+	check("2")
+	waitLoad(c)
+	check("2")
+}
+
+func TestCorpusCacheSlowLoad(t *testing.T) {
+	release := make(chan struct{})
+	c := newCorpusCache(func(context.Context) ([]string, error) {
+		<-release
+		return []string{"line"}, nil
+	}, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	// A cancelled request does not cancel the load.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.get(ctx); err != context.Canceled {
+		t.Errorf("get with a cancelled context: got %v, want %v", err, context.Canceled)
+	}
+	release <- struct{}{}
+	waitLoad(c)
+	if lines, err := c.get(context.Background()); err != nil || len(lines) != 1 {
+		t.Fatalf("get = %q, %v; want [line]", lines, err)
+	}
+
+	// Requests do not wait for a refresh.
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if lines, err := c.get(context.Background()); err != nil || len(lines) != 1 {
+			t.Errorf("get during refresh = %q, %v; want [line]", lines, err)
+		}
+	}
+	release <- struct{}{}
+	waitLoad(c)
+}
+
+func TestRegexpCache(t *testing.T) {
+	c := newRegexpCache(2)
+	a, _ := c.get("a")
+	c.get("b")
+	if a2, _ := c.get("a"); a2 != a {
+		t.Errorf("get(a) compiled again, want cached")
+	}
+	c.get("c") // Evicts b, the least recently used.
+	if _, ok := c.entries["b"]; ok {
+		t.Errorf("b is still cached after evicting")
+	}
+	if _, ok := c.entries["a"]; !ok {
+		t.Errorf("a was evicted, want b evicted")
+	}
+}
+
+// BenchmarkSimulateClient serves the corpus with the original and the
+// optimized configurations and sends b.N requests with SimulateClient. To
+// compare profiles, run for example:
+//
+//	go test -run=NONE -bench=SimulateClient/original -cpuprofile=original.prof
+//	go test -run=NONE -bench=SimulateClient/optimized -cpuprofile=optimized.prof
+//
+// The corpus is read from SHAKESAPP_CORPUS_DIR if it is set, or else
+// downloaded once from gs://dataflow-samples/shakespeare/.
+func BenchmarkSimulateClient(b *testing.B) {
+	dir := os.Getenv("SHAKESAPP_CORPUS_DIR")
+	if dir == "" {
+		dir = downloadCorpus(b)
+	}
+	for _, bc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"original", Config{CorpusDir: dir}},
+		{"optimized", Config{CorpusDir: dir, CacheCorpus: true, RegexpCacheSize: len(queries)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			addr := startServer(b, NewServerWithConfig(bc.cfg))
+			b.ResetTimer()
+			if err := SimulateClient(context.Background(), addr, b.N, 4); err != nil {
+				b.Fatalf("SimulateClient: %v", err)
+			}
+		})
+	}
+}
+
+// downloadCorpus copies the corpus from Cloud Storage to a temporary
+// directory, so the benchmark does not measure the downloads.
+func downloadCorpus(b *testing.B) string {
+	texts, err := readFiles(context.Background(), bucketName, bucketPrefix)
+	if err != nil {
+		b.Skipf("failed to download corpus, set SHAKESAPP_CORPUS_DIR: %v", err)
+	}
+	dir := b.TempDir()
+	for i, text := range texts {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprint(i)), []byte(text), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func startServer(b *testing.B, srv ShakespeareServiceServer) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := grpc.NewServer()
+	RegisterShakespeareServiceServer(s, srv)
+	go s.Serve(lis)
+	b.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Shardjob is a Cloud Run job that counts the lines and words of the files
+// listed in a manifest. The files are sharded across the job's tasks, and a
+// retried task skips the files it already counted.
+//
+// It is configured with environment variables:
+//
+//	MANIFEST        file listing one input file per line, relative to the manifest
+//	CHECKPOINT_DIR  directory recording the files counted by each execution
+//	OUTPUT_DIR      directory to write the counts to, as JSON
+//	FAIL_RATE       probability of failing each file, to simulate errors
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/jobs/taskrunner"
+)
+
+func main() {
+	task, err := taskrunner.TaskFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	runner, err := runnerFromEnv(task)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summary, err := runner.Run(context.Background(), task)
+	taskrunner.LogSummary(os.Stdout, summary, err)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func runnerFromEnv(task taskrunner.Task) (*taskrunner.Runner, error) {
+	manifest := os.Getenv("MANIFEST")
+	checkpoints := os.Getenv("CHECKPOINT_DIR")
+	output := os.Getenv("OUTPUT_DIR")
+	if manifest == "" || checkpoints == "" || output == "" {
+		return nil, errors.New("MANIFEST, CHECKPOINT_DIR and OUTPUT_DIR must be set")
+	}
+	failRate := 0.0
+	if s := os.Getenv("FAIL_RATE"); s != "" {
+		var err error
+		if failRate, err = strconv.ParseFloat(s, 64); err != nil || failRate < 0 || failRate > 1 {
+			return nil, fmt.Errorf("invalid FAIL_RATE %q: must be a float between 0 and 1 inclusive", s)
+		}
+	}
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &wordCounter{
+		inputDir:  filepath.Dir(manifest),
+		outputDir: output,
+		failRate:  failRate,
+	}
+	return &taskrunner.Runner{
+		Sharder:     &taskrunner.FileManifest{Path: manifest},
+		Checkpoints: &taskrunner.DirCheckpoints{Dir: checkpoints, Execution: task.Execution},
+		Process:     w.count,
+	}, nil
+}
+
+// wordCounter counts the lines and words of input files.
+type wordCounter struct {
+	inputDir  string
+	outputDir string
+	failRate  float64
+}
+
+type counts struct {
+	File  string `json:"file"`
+	Lines int    `json:"lines"`
+	Words int    `json:"words"`
+}
+
+// count writes the counts for the named file to the output directory.
+func (w *wordCounter) count(ctx context.Context, name string) error {
+	if rand.Float64() < w.failRate {
+		return errors.New("simulated failure")
+	}
+	f, err := os.Open(filepath.Join(w.inputDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c := counts{File: name}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		c.Lines++
+		c.Words += len(strings.Fields(s.Text()))
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that a failed attempt does not
+	// leave partial output.
+	path := filepath.Join(w.outputDir, strings.ReplaceAll(name, "/", "_")+".json")
+	if err := os.WriteFile(path+".tmp", out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
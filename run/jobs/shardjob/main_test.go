@@ -0,0 +1,75 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/jobs/taskrunner"
+)
+
+func TestWordCount(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"manifest.txt": "a.txt\nsub/b.txt\n",
+		"a.txt":        "one two\nthree\n",
+		"sub/b.txt":    "four",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("MANIFEST", filepath.Join(dir, "manifest.txt"))
+	t.Setenv("CHECKPOINT_DIR", filepath.Join(dir, "checkpoints"))
+	t.Setenv("OUTPUT_DIR", filepath.Join(dir, "out"))
+	t.Setenv("FAIL_RATE", "0")
+
+	task := taskrunner.Task{Execution: "shardjob-test", Count: 1}
+	r, err := runnerFromEnv(task)
+	if err != nil {
+		t.Fatalf("runnerFromEnv: %v", err)
+	}
+	s, err := r.Run(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if s.Processed != 2 {
+		t.Errorf("Run processed %d files, want 2", s.Processed)
+	}
+
+	for name, want := range map[string]string{
+		"a.txt.json":     `{"file":"a.txt","lines":2,"words":3}`,
+		"sub_b.txt.json": `{"file":"sub/b.txt","lines":1,"words":1}`,
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, "out", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+
+	t.Setenv("FAIL_RATE", "2")
+	if _, err := runnerFromEnv(task); err == nil {
+		t.Errorf("runnerFromEnv with FAIL_RATE 2: got nil error")
+	}
+}
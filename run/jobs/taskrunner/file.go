@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskrunner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileManifest is a Sharder that reads the items from a manifest file with
+// one item per line. Empty lines and lines starting with # are ignored.
+//
+// Items are assigned to tasks by a hash of the item, so an item stays with
+// the same task when the manifest is reordered or other items are added.
+type FileManifest struct {
+	Path string
+}
+
+// Shard implements Sharder.
+func (m *FileManifest) Shard(ctx context.Context, index, count int) ([]string, error) {
+	f, err := os.Open(m.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var items []string
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		item := strings.TrimSpace(s.Text())
+		if item == "" || strings.HasPrefix(item, "#") || seen[item] {
+			continue
+		}
+		seen[item] = true
+		if shardOf(item, count) == index {
+			items = append(items, item)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", m.Path, err)
+	}
+	return items, nil
+}
+
+// shardOf returns the index of the task item is assigned to, out of count
+// tasks.
+func shardOf(item string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(item))
+	return int(h.Sum32() % uint32(count))
+}
+
+// DirCheckpoints is a CheckpointStore that records each processed item as an
+// empty file in a subdirectory of Dir named after Execution. On Cloud Run,
+// Dir can be a Cloud Storage volume mount shared by all tasks and attempts.
+type DirCheckpoints struct {
+	Dir string
+	// Execution scopes the checkpoints to one job execution, usually
+	// Task.Execution, so that retried attempts skip the items already
+	// processed but a new execution processes every item again. If it is
+	// empty, checkpoints are kept in Dir itself and never expire.
+	Execution string
+}
+
+// dir returns the directory holding the checkpoints of the execution.
+func (c *DirCheckpoints) dir() string {
+	return filepath.Join(c.Dir, c.Execution)
+}
+
+// path returns the checkpoint file of item. Items are hashed, since they may
+// contain characters that are not valid in file names.
+func (c *DirCheckpoints) path(item string) string {
+	sum := sha256.Sum256([]byte(item))
+	return filepath.Join(c.dir(), hex.EncodeToString(sum[:]))
+}
+
+// Done implements CheckpointStore.
+func (c *DirCheckpoints) Done(ctx context.Context, item string) (bool, error) {
+	_, err := os.Stat(c.path(item))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MarkDone implements CheckpointStore.
+func (c *DirCheckpoints) MarkDone(ctx context.Context, item string) error {
+	if err := os.MkdirAll(c.dir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(c.path(item))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
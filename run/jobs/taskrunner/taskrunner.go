@@ -0,0 +1,165 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package taskrunner runs the share of a Cloud Run job's work assigned to one
+// task. Items are sharded across the job's tasks, and each processed item is
+// checkpointed so that a retried attempt resumes where the failed attempt
+// stopped instead of redoing its work.
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A Sharder assigns the items of a job to its tasks.
+type Sharder interface {
+	// Shard returns the items assigned to the task with the given index,
+	// out of count tasks. Every item must be assigned to exactly one task.
+	Shard(ctx context.Context, index, count int) ([]string, error)
+}
+
+// A CheckpointStore records which items have been processed.
+type CheckpointStore interface {
+	// Done reports whether item has been processed.
+	Done(ctx context.Context, item string) (bool, error)
+	// MarkDone records that item has been processed.
+	MarkDone(ctx context.Context, item string) error
+}
+
+// Task identifies a task of a Cloud Run job execution.
+type Task struct {
+	// Execution is the name of the job execution, or "" outside Cloud Run.
+	Execution string
+	Index     int
+	Count     int
+	Attempt   int
+}
+
+// TaskFromEnv reads the task from the CLOUD_RUN_EXECUTION,
+// CLOUD_RUN_TASK_INDEX, CLOUD_RUN_TASK_COUNT and CLOUD_RUN_TASK_ATTEMPT
+// environment variables set by Cloud Run. Unset variables describe the first
+// attempt of a single task.
+func TaskFromEnv() (Task, error) {
+	t := Task{Execution: os.Getenv("CLOUD_RUN_EXECUTION"), Count: 1}
+	for name, v := range map[string]*int{
+		"CLOUD_RUN_TASK_INDEX":   &t.Index,
+		"CLOUD_RUN_TASK_COUNT":   &t.Count,
+		"CLOUD_RUN_TASK_ATTEMPT": &t.Attempt,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return Task{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*v = n
+	}
+	if t.Count < 1 || t.Index < 0 || t.Index >= t.Count {
+		return Task{}, fmt.Errorf("invalid task %d of %d", t.Index, t.Count)
+	}
+	return t, nil
+}
+
+// maxSummaryErrors is the number of item errors kept in a Summary.
+const maxSummaryErrors = 10
+
+// Summary describes what a task did.
+type Summary struct {
+	Task      int      `json:"task"`
+	Attempt   int      `json:"attempt"`
+	Items     int      `json:"items"`
+	Processed int      `json:"processed"`
+	Skipped   int      `json:"skipped"`
+	Failed    int      `json:"failed"`
+	Duration  string   `json:"duration"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// A Runner processes the items assigned to a task.
+type Runner struct {
+	Sharder     Sharder
+	Checkpoints CheckpointStore
+	// Process does the work for one item. Items it fails on are not
+	// checkpointed, so they are processed again by the next attempt.
+	Process func(ctx context.Context, item string) error
+}
+
+// Run processes the items assigned to task that have not been processed
+// yet. It processes every item even if some fail, and then returns an error
+// if any failed, so that Cloud Run retries the task.
+func (r *Runner) Run(ctx context.Context, task Task) (s Summary, err error) {
+	start := time.Now()
+	s = Summary{Task: task.Index, Attempt: task.Attempt}
+	defer func() { s.Duration = time.Since(start).Round(time.Millisecond).String() }()
+
+	items, err := r.Sharder.Shard(ctx, task.Index, task.Count)
+	if err != nil {
+		return s, fmt.Errorf("Shard: %w", err)
+	}
+	s.Items = len(items)
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return s, err
+		}
+		done, err := r.Checkpoints.Done(ctx, item)
+		if err != nil {
+			return s, fmt.Errorf("checkpoint %q: %w", item, err)
+		}
+		if done {
+			s.Skipped++
+			continue
+		}
+		if err := r.Process(ctx, item); err != nil {
+			s.Failed++
+			if len(s.Errors) < maxSummaryErrors {
+				s.Errors = append(s.Errors, fmt.Sprintf("%s: %v", item, err))
+			}
+			continue
+		}
+		if err := r.Checkpoints.MarkDone(ctx, item); err != nil {
+			return s, fmt.Errorf("checkpoint %q: %w", item, err)
+		}
+		s.Processed++
+	}
+	if s.Failed > 0 {
+		return s, fmt.Errorf("%d of %d items failed", s.Failed, s.Items)
+	}
+	return s, nil
+}
+
+// LogSummary writes the summary to w as a structured log line, which Cloud
+// Logging parses into the jsonPayload of the log entry.
+func LogSummary(w io.Writer, s Summary, err error) error {
+	entry := struct {
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+		Error    string `json:"error,omitempty"`
+		Summary
+	}{Severity: "INFO", Summary: s}
+	entry.Message = fmt.Sprintf("Task #%d, Attempt #%d: processed %d, skipped %d, failed %d of %d items",
+		s.Task, s.Attempt, s.Processed, s.Skipped, s.Failed, s.Items)
+	if err != nil {
+		entry.Severity = "ERROR"
+		entry.Error = err.Error()
+	}
+	return json.NewEncoder(w).Encode(entry)
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskrunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeManifest(t *testing.T, items []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "manifest.txt")
+	content := "# items\n\n" + strings.Join(items, "\n") + "\n" + items[0] + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileManifest(t *testing.T) {
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf("gs://bucket/item-%d", i))
+	}
+	m := &FileManifest{Path: writeManifest(t, items)}
+
+	ctx := context.Background()
+	const count = 4
+	seen := make(map[string]int)
+	for index := 0; index < count; index++ {
+		shard, err := m.Shard(ctx, index, count)
+		if err != nil {
+			t.Fatalf("Shard(%d, %d): %v", index, count, err)
+		}
+		if len(shard) == 0 {
+			t.Errorf("Shard(%d, %d) is empty", index, count)
+		}
+		for _, item := range shard {
+			seen[item]++
+		}
+	}
+	if len(seen) != len(items) {
+		t.Errorf("got %d items assigned, want %d", len(seen), len(items))
+	}
+	for item, n := range seen {
+		if n != 1 {
+			t.Errorf("%s assigned to %d tasks, want 1", item, n)
+		}
+	}
+}
+
+func TestDirCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	c := &DirCheckpoints{Dir: filepath.Join(t.TempDir(), "checkpoints")}
+	item := "gs://bucket/a file?"
+	if done, err := c.Done(ctx, item); done || err != nil {
+		t.Fatalf("Done before MarkDone = %v, %v, want false, nil", done, err)
+	}
+	if err := c.MarkDone(ctx, item); err != nil {
+		t.Fatalf("MarkDone: %v", err)
+	}
+	if done, err := c.Done(ctx, item); !done || err != nil {
+		t.Fatalf("Done after MarkDone = %v, %v, want true, nil", done, err)
+	}
+}
+
+func TestDirCheckpointsNewExecution(t *testing.T) {
+	items := []string{"a", "b", "c"}
+	dir := t.TempDir()
+	ctx := context.Background()
+	for _, execution := range []string{"job-abc12", "job-def34"} {
+		var processed []string
+		r := &Runner{
+			Sharder:     &FileManifest{Path: writeManifest(t, items)},
+			Checkpoints: &DirCheckpoints{Dir: dir, Execution: execution},
+			Process: func(ctx context.Context, item string) error {
+				processed = append(processed, item)
+				return nil
+			},
+		}
+		s, err := r.Run(ctx, Task{Execution: execution, Count: 1})
+		if err != nil {
+			t.Fatalf("execution %s: %v", execution, err)
+		}
+		if s.Processed != 3 || s.Skipped != 0 || len(processed) != 3 {
+			t.Errorf("execution %s: got %+v, processed %q, want all 3 items processed", execution, s, processed)
+		}
+	}
+}
+
+func TestRunnerResumes(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	r := &Runner{
+		Sharder:     &FileManifest{Path: writeManifest(t, items)},
+		Checkpoints: &DirCheckpoints{Dir: t.TempDir()},
+	}
+	ctx := context.Background()
+	task := Task{Index: 0, Count: 1, Attempt: 0}
+
+	// The first attempt fails on c.
+	var processed []string
+	r.Process = func(ctx context.Context, item string) error {
+		if item == "c" {
+			return errors.New("unavailable")
+		}
+		processed = append(processed, item)
+		return nil
+	}
+	s, err := r.Run(ctx, task)
+	if err == nil {
+		t.Fatalf("first attempt: got nil error")
+	}
+	if s.Items != 5 || s.Processed != 4 || s.Failed != 1 || s.Skipped != 0 {
+		t.Errorf("first attempt: got %+v, want 4 processed and 1 failed of 5", s)
+	}
+	if len(s.Errors) != 1 || s.Errors[0] != "c: unavailable" {
+		t.Errorf("first attempt: got errors %q", s.Errors)
+	}
+
+	// The retry only processes c.
+	processed = nil
+	r.Process = func(ctx context.Context, item string) error {
+		processed = append(processed, item)
+		return nil
+	}
+	task.Attempt++
+	s, err = r.Run(ctx, task)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if s.Processed != 1 || s.Skipped != 4 || s.Attempt != 1 {
+		t.Errorf("retry: got %+v, want 1 processed and 4 skipped", s)
+	}
+	if len(processed) != 1 || processed[0] != "c" {
+		t.Errorf("retry: processed %q, want [c]", processed)
+	}
+	if s.Duration == "" {
+		t.Errorf("retry: no duration in summary")
+	}
+}
+
+func TestLogSummary(t *testing.T) {
+	var buf bytes.Buffer
+	s := Summary{Task: 2, Attempt: 1, Items: 3, Processed: 2, Failed: 1, Errors: []string{"x: boom"}}
+	if err := LogSummary(&buf, s, errors.New("1 of 3 items failed")); err != nil {
+		t.Fatalf("LogSummary: %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "}\n") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("LogSummary wrote %q, want one line", buf.String())
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if got["severity"] != "ERROR" || got["task"] != 2.0 || got["failed"] != 1.0 {
+		t.Errorf("LogSummary wrote %v", got)
+	}
+}
+
+func TestTaskFromEnv(t *testing.T) {
+	t.Setenv("CLOUD_RUN_EXECUTION", "job-abc12")
+	t.Setenv("CLOUD_RUN_TASK_INDEX", "2")
+	t.Setenv("CLOUD_RUN_TASK_COUNT", "3")
+	t.Setenv("CLOUD_RUN_TASK_ATTEMPT", "1")
+	got, err := TaskFromEnv()
+	if err != nil {
+		t.Fatalf("TaskFromEnv: %v", err)
+	}
+	if want := (Task{Execution: "job-abc12", Index: 2, Count: 3, Attempt: 1}); got != want {
+		t.Errorf("TaskFromEnv = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("CLOUD_RUN_TASK_INDEX", "3")
+	if _, err := TaskFromEnv(); err == nil {
+		t.Errorf("TaskFromEnv with index out of range: got nil error")
+	}
+}
@@ -3,6 +3,9 @@ module github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/editor
 go 1.19
 
 require (
+	github.com/alecthomas/chroma v0.10.0
+	github.com/microcosm-cc/bluemonday v1.0.24
+	github.com/russross/blackfriday/v2 v2.1.0
 	golang.org/x/oauth2 v0.9.0
 	google.golang.org/api v0.128.0
 )
@@ -10,10 +13,13 @@ require (
 require (
 	cloud.google.com/go/compute v1.19.3 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/dlclark/regexp2 v1.4.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/google/s2a-go v0.1.4 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.2.4 // indirect
+	github.com/gorilla/css v1.0.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
@@ -15,12 +15,16 @@
 package main
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/editor/markdown"
+	"golang.org/x/oauth2"
 )
 
 func init() {
@@ -95,3 +99,99 @@ func TestRenderHandlerErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderHandlerLocal(t *testing.T) {
+	policy, err := markdown.NewPolicy("")
+	if err != nil {
+		t.Fatalf("markdown.NewPolicy: %v", err)
+	}
+	s := &Service{Renderer: &LocalRenderer{Policy: policy}}
+
+	tests := []struct {
+		label string
+		body  string
+		want  string
+	}{
+		{
+			label: "default options",
+			body:  `{"data": "| a |\n|---|\n| 1 |"}`,
+			want:  "<table>",
+		},
+		{
+			label: "task lists",
+			body:  `{"data": "- [x] done", "options": {"taskLists": true}}`,
+			want:  `<input type="checkbox" disabled="" checked=""> done`,
+		},
+		{
+			label: "table of contents",
+			body:  `{"data": "# Title", "options": {"toc": true}}`,
+			want:  `<h1 id="title">`,
+		},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		s.renderHandler(rr, httptest.NewRequest("POST", "/render", strings.NewReader(test.body)))
+		if got := rr.Result().StatusCode; got != http.StatusOK {
+			t.Errorf("%s: response status: got %d, want %d", test.label, got, http.StatusOK)
+		}
+		if got := rr.Body.String(); !strings.Contains(got, test.want) {
+			t.Errorf("%s: body: got %q, want it to contain %q", test.label, got, test.want)
+		}
+	}
+}
+
+func TestRenderServiceOptions(t *testing.T) {
+	var gotQuery, gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Write([]byte("<p>ok</p>"))
+	}))
+	defer upstream.Close()
+
+	rs := &RenderService{
+		URL:         upstream.URL,
+		tokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+	}
+	out, err := rs.Render([]byte("**md**"), markdown.Options{Tables: true, Highlight: true})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(out) != "<p>ok</p>" {
+		t.Errorf("Render: got %q, want %q", out, "<p>ok</p>")
+	}
+	if gotBody != "**md**" {
+		t.Errorf("upstream body: got %q, want %q", gotBody, "**md**")
+	}
+	want := "footnotes=false&highlight=true&tables=true&tasklists=false&toc=false"
+	if gotQuery != want {
+		t.Errorf("upstream query: got %q, want %q", gotQuery, want)
+	}
+}
+
+func TestHighlightCSSHandler(t *testing.T) {
+	s := &Service{}
+	rr := httptest.NewRecorder()
+	s.highlightCSSHandler(rr, httptest.NewRequest("GET", "/highlight.css", nil))
+	if got := rr.Body.String(); !strings.Contains(got, ".chroma") {
+		t.Errorf("body: got %q, want chroma styles", got)
+	}
+}
+
+func TestMarkdownCopy(t *testing.T) {
+	want, err := ioutil.ReadFile("../renderer/markdown/markdown.go")
+	if os.IsNotExist(err) {
+		t.Skip("renderer not found")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile("markdown/markdown.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("markdown/markdown.go differs from the renderer's; run go generate")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// The markdown package is a copy of the renderer's, so that the editor
+// module builds on its own.
+//go:generate cp ../renderer/markdown/markdown.go markdown/markdown.go
+
+import (
+	"github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/editor/markdown"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// LocalRenderer converts Markdown to sanitized HTML in process, the same way
+// as the renderer service.
+type LocalRenderer struct {
+	// Policy sanitizes the HTML. It must allow the markup generated for
+	// the options; see markdown.AllowGenerated.
+	Policy *bluemonday.Policy
+}
+
+// Render converts the Markdown in to sanitized HTML.
+func (r *LocalRenderer) Render(in []byte, opts markdown.Options) ([]byte, error) {
+	return (&markdown.Renderer{Policy: r.Policy}).Render(in, opts), nil
+}
@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/editor/markdown"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/idtoken"
 )
@@ -74,11 +75,12 @@ func (s *RenderService) NewRequest(method string) (*http.Request, error) {
 var renderClient = &http.Client{Timeout: 30 * time.Second}
 
 // Render converts the Markdown plaintext to HTML.
-func (s *RenderService) Render(in []byte) ([]byte, error) {
+func (s *RenderService) Render(in []byte, opts markdown.Options) ([]byte, error) {
 	req, err := s.NewRequest(http.MethodPost)
 	if err != nil {
 		return nil, fmt.Errorf("RenderService.NewRequest: %w", err)
 	}
+	req.URL.RawQuery = opts.Query().Encode()
 	req.Body = ioutil.NopCloser(bytes.NewReader(in))
 	defer req.Body.Close()
 
@@ -16,7 +16,6 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -24,11 +23,13 @@ import (
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/editor/markdown"
 )
 
 // MarkdownRenderer defines an interface for rendering Markdown to HTML.
 type MarkdownRenderer interface {
-	Render([]byte) ([]byte, error)
+	Render([]byte, markdown.Options) ([]byte, error)
 }
 
 // Service manages centralized resources of the service
@@ -40,10 +41,19 @@ type Service struct {
 }
 
 // NewServiceFromEnv creates a new Service instance from environment variables.
+// Markdown is rendered by the service at EDITOR_UPSTREAM_RENDER_URL, or in
+// process if it is not set, sanitized with the EDITOR_RENDER_POLICY policy.
 func NewServiceFromEnv() (*Service, error) {
-	url := os.Getenv("EDITOR_UPSTREAM_RENDER_URL")
-	if url == "" {
-		return nil, errors.New("no configuration for upstream render service: add EDITOR_UPSTREAM_RENDER_URL environment variable")
+	var renderer MarkdownRenderer
+	if url := os.Getenv("EDITOR_UPSTREAM_RENDER_URL"); url != "" {
+		renderer = &RenderService{URL: url}
+	} else {
+		policy, err := markdown.NewPolicy(os.Getenv("EDITOR_RENDER_POLICY"))
+		if err != nil {
+			return nil, fmt.Errorf("markdown.NewPolicy: %w", err)
+		}
+		log.Printf("No upstream render service configured with EDITOR_UPSTREAM_RENDER_URL, rendering in process")
+		renderer = &LocalRenderer{Policy: policy}
 	}
 
 	// The use case of this service is the UI driven by these files.
@@ -61,9 +71,7 @@ func NewServiceFromEnv() (*Service, error) {
 	markdownDefault := string(out)
 
 	return &Service{
-		Renderer: &RenderService{
-			URL: url,
-		},
+		Renderer:        renderer,
 		parsedTemplate:  parsedTemplate,
 		markdownDefault: markdownDefault,
 	}, nil
@@ -75,6 +83,7 @@ func (s *Service) RegisterHandlers() *http.ServeMux {
 
 	mux.HandleFunc("/", s.editorHandler)
 	mux.HandleFunc("/render", s.renderHandler)
+	mux.HandleFunc("/highlight.css", s.highlightCSSHandler)
 
 	return mux
 }
@@ -95,7 +104,8 @@ func (s *Service) editorHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// renderHandler expects a JSON body payload with a 'data' property holding plain text for rendering.
+// renderHandler expects a JSON body payload with a 'data' property holding plain text for rendering,
+// and an optional 'options' property holding the markdown.Options.
 func (s *Service) renderHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -109,14 +119,21 @@ func (s *Service) renderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var d struct{ Data string }
+	var d struct {
+		Data    string
+		Options *markdown.Options
+	}
 	if err := json.Unmarshal(out, &d); err != nil {
 		log.Printf("json.Unmarshal: %v", err)
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
+	opts := markdown.DefaultOptions
+	if d.Options != nil {
+		opts = *d.Options
+	}
 
-	rendered, err := s.Renderer.Render([]byte(d.Data))
+	rendered, err := s.Renderer.Render([]byte(d.Data), opts)
 	if err != nil {
 		log.Printf("MarkdownRenderer.Render: %v", err)
 		msg := http.StatusText(http.StatusInternalServerError)
@@ -129,3 +146,12 @@ func (s *Service) renderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(rendered)
 }
+
+// highlightCSSHandler serves the stylesheet for code blocks highlighted by
+// either renderer.
+func (s *Service) highlightCSSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	if err := markdown.WriteHighlightCSS(w); err != nil {
+		log.Printf("markdown.WriteHighlightCSS: %v", err)
+	}
+}
@@ -3,12 +3,14 @@ module github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/render
 go 1.19
 
 require (
+	github.com/alecthomas/chroma v0.10.0
 	github.com/microcosm-cc/bluemonday v1.0.24
 	github.com/russross/blackfriday/v2 v2.1.0
 )
 
 require (
 	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/dlclark/regexp2 v1.4.0 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
 )
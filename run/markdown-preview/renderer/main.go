@@ -21,11 +21,19 @@ import (
 	"net/http"
 	"os"
 
-	"github.com/microcosm-cc/bluemonday"
-	"github.com/russross/blackfriday/v2"
+	"github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/render/markdown"
 )
 
+var renderer *markdown.Renderer
+
 func main() {
+	// RENDERER_POLICY selects how the rendered HTML is sanitized.
+	policy, err := markdown.NewPolicy(os.Getenv("RENDERER_POLICY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	renderer = &markdown.Renderer{Policy: policy}
+
 	http.HandleFunc("/", markdownHandler)
 
 	port := os.Getenv("PORT")
@@ -35,13 +43,22 @@ func main() {
 	}
 
 	log.Printf("Listening on port %s", port)
-	err := http.ListenAndServe(":"+port, nil)
+	err = http.ListenAndServe(":"+port, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// markdownHandler renders the Markdown in the request body. Query parameters
+// select the options; see markdown.ParseOptions.
 func markdownHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := markdown.ParseOptions(r.URL.Query())
+	if err != nil {
+		log.Printf("markdown.ParseOptions: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	out, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("ioutil.ReadAll: %v", err)
@@ -49,8 +66,5 @@ func markdownHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	unsafe := blackfriday.Run(out)
-	// This is a very basic content policy and tighter standards are recommended.
-	output := bluemonday.UGCPolicy().SanitizeBytes(unsafe)
-	w.Write(output)
+	w.Write(renderer.Render(out, opts))
 }
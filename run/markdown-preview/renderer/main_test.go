@@ -15,11 +15,22 @@
 package main
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/markdown-preview/render/markdown"
 )
 
+func init() {
+	policy, err := markdown.NewPolicy("")
+	if err != nil {
+		panic(err)
+	}
+	renderer = &markdown.Renderer{Policy: policy}
+}
+
 var tests = []struct {
 	label string
 	input string
@@ -49,3 +60,20 @@ func TestMarkdownHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestMarkdownHandlerOptions(t *testing.T) {
+	req := httptest.NewRequest("POST", "/?tasklists=true", strings.NewReader("- [x] done"))
+	rr := httptest.NewRecorder()
+	markdownHandler(rr, req)
+	want := `<ul>` + "\n" + `<li><input type="checkbox" disabled="" checked=""> done</li>` + "\n" + `</ul>` + "\n"
+	if got := rr.Body.String(); got != want {
+		t.Errorf("tasklists: got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest("POST", "/?toc=maybe", strings.NewReader("# Title"))
+	rr = httptest.NewRecorder()
+	markdownHandler(rr, req)
+	if got := rr.Result().StatusCode; got != http.StatusBadRequest {
+		t.Errorf("invalid option: got status %d, want %d", got, http.StatusBadRequest)
+	}
+}
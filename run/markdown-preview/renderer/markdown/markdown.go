@@ -0,0 +1,282 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package markdown converts Markdown to sanitized HTML, with optional
+// extensions such as task lists, a table of contents and highlighted code
+// blocks. It is used by both the renderer service and the editor.
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
+)
+
+// Options configures the Markdown extensions used to render a document.
+type Options struct {
+	// Tables renders GitHub-flavored pipe tables.
+	Tables bool `json:"tables"`
+	// TaskLists renders list items starting with "[ ]" or "[x]" as checkboxes.
+	TaskLists bool `json:"taskLists"`
+	// Footnotes renders [^note] references and their definitions.
+	Footnotes bool `json:"footnotes"`
+	// TOC adds anchors to headings and a table of contents linking to them.
+	TOC bool `json:"toc"`
+	// Highlight highlights fenced code blocks with a known language.
+	Highlight bool `json:"highlight"`
+}
+
+// DefaultOptions match how documents were rendered before options existed.
+var DefaultOptions = Options{Tables: true}
+
+// ParseOptions reads options from query parameters named after the lowercase
+// option names, such as "toc=true". Options that are not set keep their
+// default.
+func ParseOptions(q url.Values) (Options, error) {
+	opts := DefaultOptions
+	for name, opt := range map[string]*bool{
+		"tables":    &opts.Tables,
+		"tasklists": &opts.TaskLists,
+		"footnotes": &opts.Footnotes,
+		"toc":       &opts.TOC,
+		"highlight": &opts.Highlight,
+	} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for %s: %q", name, v)
+		}
+		*opt = b
+	}
+	return opts, nil
+}
+
+// Query encodes the options as the query parameters read by ParseOptions.
+func (o Options) Query() url.Values {
+	return url.Values{
+		"tables":    {strconv.FormatBool(o.Tables)},
+		"tasklists": {strconv.FormatBool(o.TaskLists)},
+		"footnotes": {strconv.FormatBool(o.Footnotes)},
+		"toc":       {strconv.FormatBool(o.TOC)},
+		"highlight": {strconv.FormatBool(o.Highlight)},
+	}
+}
+
+// Renderer converts Markdown to HTML and sanitizes the result.
+type Renderer struct {
+	// Policy sanitizes the HTML. It must allow the markup generated for
+	// the options; see AllowGenerated.
+	Policy *bluemonday.Policy
+}
+
+// Render converts the Markdown in to sanitized HTML.
+func (r *Renderer) Render(in []byte, opts Options) []byte {
+	ext := blackfriday.CommonExtensions &^ blackfriday.Tables
+	flags := blackfriday.CommonHTMLFlags
+	if opts.Tables {
+		ext |= blackfriday.Tables
+	}
+	if opts.Footnotes {
+		ext |= blackfriday.Footnotes
+		flags |= blackfriday.FootnoteReturnLinks
+	}
+	if opts.TOC {
+		ext |= blackfriday.AutoHeadingIDs
+	}
+	hr := &htmlRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{Flags: flags}),
+		opts:         opts,
+		tasks:        make(map[*blackfriday.Node]bool),
+	}
+	unsafe := blackfriday.Run(in, blackfriday.WithExtensions(ext), blackfriday.WithRenderer(hr))
+	return r.Policy.SanitizeBytes(unsafe)
+}
+
+// htmlRenderer extends the blackfriday HTML renderer with task lists, a table
+// of contents, heading anchors and highlighted code blocks.
+type htmlRenderer struct {
+	*blackfriday.HTMLRenderer
+	opts Options
+	// tasks holds the text nodes starting task list items, and whether the
+	// task is done.
+	tasks map[*blackfriday.Node]bool
+}
+
+var taskMarker = regexp.MustCompile(`^\[([ xX])\]\s+`)
+
+// RenderHeader finds task list items and writes the table of contents before
+// the document is rendered.
+func (r *htmlRenderer) RenderHeader(w io.Writer, ast *blackfriday.Node) {
+	if r.opts.TaskLists {
+		ast.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+			if !entering || n.Type != blackfriday.Item {
+				return blackfriday.GoToNext
+			}
+			if p := n.FirstChild; p != nil && p.Type == blackfriday.Paragraph {
+				if t := p.FirstChild; t != nil && t.Type == blackfriday.Text {
+					if m := taskMarker.FindSubmatch(t.Literal); m != nil {
+						t.Literal = t.Literal[len(m[0]):]
+						r.tasks[t] = m[1][0] != ' '
+					}
+				}
+			}
+			return blackfriday.GoToNext
+		})
+	}
+	r.HTMLRenderer.RenderHeader(w, ast)
+	if r.opts.TOC {
+		r.writeTOC(w, ast)
+	}
+}
+
+// writeTOC gives every heading a unique ID and writes a table of contents
+// linking to them. Unlike the blackfriday TOC, the IDs are derived from the
+// heading text, so links to them stay valid when headings are added.
+func (r *htmlRenderer) writeTOC(w io.Writer, ast *blackfriday.Node) {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	ast.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || n.Type != blackfriday.Heading || n.IsTitleblock {
+			return blackfriday.GoToNext
+		}
+		base := n.HeadingID
+		if base == "" {
+			base = "section"
+		}
+		id := base
+		for i := 1; seen[id]; i++ {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		seen[id] = true
+		n.HeadingID = id
+
+		fmt.Fprintf(&buf, "<li class=\"toc-h%d\"><a href=\"#%s\">", n.Level, id)
+		for c := n.FirstChild; c != nil; c = c.Next {
+			c.Walk(func(c *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+				return r.HTMLRenderer.RenderNode(&buf, c, entering)
+			})
+		}
+		buf.WriteString("</a></li>\n")
+		return blackfriday.SkipChildren
+	})
+	if buf.Len() > 0 {
+		io.WriteString(w, "<nav class=\"toc\">\n<ul>\n")
+		buf.WriteTo(w)
+		io.WriteString(w, "</ul>\n</nav>\n")
+	}
+}
+
+// RenderNode renders task list checkboxes, heading anchors and highlighted
+// code blocks, and everything else as blackfriday does.
+func (r *htmlRenderer) RenderNode(w io.Writer, n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	if done, ok := r.tasks[n]; ok && entering {
+		io.WriteString(w, `<input type="checkbox" disabled`)
+		if done {
+			io.WriteString(w, ` checked`)
+		}
+		io.WriteString(w, `> `)
+	}
+	if n.Type == blackfriday.Heading && !entering && r.opts.TOC && n.HeadingID != "" {
+		fmt.Fprintf(w, ` <a class="anchor" href="#%s">#</a>`, n.HeadingID)
+	}
+	if n.Type == blackfriday.CodeBlock && r.opts.Highlight {
+		if lang := strings.Fields(string(n.Info)); len(lang) > 0 {
+			if err := highlight(w, string(n.Literal), lang[0]); err == nil {
+				return blackfriday.GoToNext
+			}
+		}
+	}
+	return r.HTMLRenderer.RenderNode(w, n, entering)
+}
+
+var highlighter = chromahtml.New(chromahtml.WithClasses(true))
+
+// highlight writes code in the given language as HTML, with CSS classes for
+// the tokens. It fails if the language is unknown.
+func highlight(w io.Writer, code, lang string) error {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		return fmt.Errorf("unknown language %q", lang)
+	}
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := highlighter.Format(&buf, styles.Fallback, it); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// NewPolicy returns the named sanitization policy, which allows the markup
+// generated by the Renderer:
+//
+//   - "ugc" allows the HTML commonly accepted in user generated content.
+//   - "strict" only allows the HTML that Markdown and the Renderer produce.
+func NewPolicy(name string) (*bluemonday.Policy, error) {
+	var p *bluemonday.Policy
+	switch name {
+	case "", "ugc":
+		p = bluemonday.UGCPolicy()
+	case "strict":
+		p = bluemonday.NewPolicy()
+		p.AllowStandardURLs()
+		p.AllowRelativeURLs(true)
+		p.RequireNoFollowOnLinks(true)
+		p.AllowAttrs("href").OnElements("a")
+		p.AllowImages()
+		p.AllowLists()
+		p.AllowTables()
+		p.AllowElements("p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6",
+			"em", "strong", "del", "code", "pre", "blockquote", "dl", "dt", "dd")
+	default:
+		return nil, fmt.Errorf("unknown sanitization policy %q", name)
+	}
+	AllowGenerated(p)
+	return p, nil
+}
+
+// AllowGenerated extends p to allow the markup the Renderer generates for
+// heading anchors, the table of contents, footnotes, task lists and code
+// highlighting.
+func AllowGenerated(p *bluemonday.Policy) {
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[a-zA-Z0-9:_.-]+$`)).
+		OnElements("h1", "h2", "h3", "h4", "h5", "h6", "li", "sup")
+	p.AllowElements("nav", "sup")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9-]+( [a-z0-9-]+)*$`)).
+		OnElements("div", "span", "pre", "code", "sup", "a", "li", "nav")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("disabled", "checked").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+}
+
+// WriteHighlightCSS writes the stylesheet for highlighted code blocks.
+func WriteHighlightCSS(w io.Writer) error {
+	return highlighter.WriteCSS(w, styles.Fallback)
+}
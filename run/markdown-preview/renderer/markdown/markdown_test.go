@@ -0,0 +1,140 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package markdown
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	got, err := ParseOptions(url.Values{"tables": {"false"}, "toc": {"1"}})
+	if err != nil {
+		t.Fatalf("ParseOptions: %v", err)
+	}
+	if want := (Options{TOC: true}); got != want {
+		t.Errorf("ParseOptions: got %+v, want %+v", got, want)
+	}
+	if got, _ := ParseOptions(url.Values{}); got != DefaultOptions {
+		t.Errorf("ParseOptions(no options): got %+v, want %+v", got, DefaultOptions)
+	}
+	if _, err := ParseOptions(url.Values{"highlight": {"yes please"}}); err == nil {
+		t.Errorf("ParseOptions(invalid): got nil error")
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		label string
+		input string
+		opts  Options
+		want  []string
+		not   []string
+	}{
+		{
+			label: "tables",
+			input: "| a |\n|---|\n| 1 |",
+			opts:  Options{Tables: true},
+			want:  []string{"<table>", "<td>1</td>"},
+		},
+		{
+			label: "no tables",
+			input: "| a |\n|---|\n| 1 |",
+			not:   []string{"<table>"},
+		},
+		{
+			label: "task lists",
+			input: "- [ ] todo\n- [x] done\n- [y] other",
+			opts:  Options{TaskLists: true},
+			want: []string{
+				`<li><input type="checkbox" disabled=""> todo</li>`,
+				`<li><input type="checkbox" disabled="" checked=""> done</li>`,
+				`<li>[y] other</li>`,
+			},
+		},
+		{
+			label: "footnotes",
+			input: "text[^1]\n\n[^1]: note",
+			opts:  Options{Footnotes: true},
+			want:  []string{`<sup class="footnote-ref" id="fnref:1">`, `<li id="fn:1">note`},
+		},
+		{
+			label: "toc",
+			input: "# Title\n\n## Part\n\n## Part",
+			opts:  Options{TOC: true},
+			want: []string{
+				`<nav class="toc">`,
+				`<li class="toc-h2"><a href="#part-1" rel="nofollow">Part</a></li>`,
+				`<h1 id="title">Title <a class="anchor" href="#title" rel="nofollow">#</a></h1>`,
+				`<h2 id="part-1">`,
+			},
+		},
+		{
+			label: "highlight",
+			input: "```go\nfunc main() {}\n```",
+			opts:  Options{Highlight: true},
+			want:  []string{`<pre class="chroma">`, `<span class="kd">func</span>`},
+		},
+		{
+			label: "highlight unknown language",
+			input: "```notalanguage\nx\n```",
+			opts:  Options{Highlight: true},
+			want:  []string{`<pre><code class="language-notalanguage">x`},
+		},
+		{
+			label: "sanitize",
+			input: `<input type="text" onfocus="alert(1)"><span class="x" style="color:red">y</span>`,
+			opts:  Options{TaskLists: true, Highlight: true},
+			want:  []string{`<span class="x">y</span>`},
+			not:   []string{"onfocus", "style", `type="text"`},
+		},
+	}
+
+	for _, policy := range []string{"ugc", "strict"} {
+		p, err := NewPolicy(policy)
+		if err != nil {
+			t.Fatalf("NewPolicy(%q): %v", policy, err)
+		}
+		r := &Renderer{Policy: p}
+		for _, test := range tests {
+			got := string(r.Render([]byte(test.input), test.opts))
+			for _, want := range test.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("%s, %s: got %q, want it to contain %q", policy, test.label, got, want)
+				}
+			}
+			for _, not := range test.not {
+				if strings.Contains(got, not) {
+					t.Errorf("%s, %s: got %q, want it not to contain %q", policy, test.label, got, not)
+				}
+			}
+		}
+	}
+}
+
+func TestStrictPolicy(t *testing.T) {
+	p, err := NewPolicy("strict")
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	got := string((&Renderer{Policy: p}).Render([]byte("<details><summary>s</summary>d</details>"), DefaultOptions))
+	if strings.Contains(got, "<details>") {
+		t.Errorf("got %q, want details removed", got)
+	}
+	if _, err := NewPolicy("lenient"); err == nil {
+		t.Errorf("NewPolicy(unknown): got nil error")
+	}
+}
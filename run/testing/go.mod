@@ -3,6 +3,7 @@ module github.com/GoogleCloudPlatform/golang-samples/run/testing
 go 1.19
 
 require (
+	cloud.google.com/go/logging v1.7.0
 	github.com/GoogleCloudPlatform/golang-samples v0.0.0-20230522201558-cba0742a460f
 	github.com/GoogleCloudPlatform/golang-samples/run/grpc-ping v0.0.0-20230522201558-cba0742a460f
 	github.com/GoogleCloudPlatform/golang-samples/run/grpc-server-streaming v0.0.0-20230522201558-cba0742a460f
@@ -15,7 +16,6 @@ require (
 	cloud.google.com/go/compute v1.20.1 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
 	cloud.google.com/go/iam v1.1.0 // indirect
-	cloud.google.com/go/logging v1.7.0 // indirect
 	cloud.google.com/go/longrunning v0.5.0 // indirect
 	cloud.google.com/go/storage v1.30.1 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
@@ -15,9 +15,11 @@
 package cloudruntests
 
 import (
+	"context"
 	"net/http"
 	"testing"
 
+	"cloud.google.com/go/logging"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/cloudrunci"
 	"github.com/GoogleCloudPlatform/golang-samples/internal/testutil"
 )
@@ -37,6 +39,8 @@ func TestLoggingManualService(t *testing.T) {
 	if err != nil {
 		t.Fatalf("service.NewRequest: %v", err)
 	}
+	traceID := "105445aa7843bc8bf206b12000100000"
+	req.Header.Set("X-Cloud-Trace-Context", traceID+"/1;o=1")
 
 	resp, err := service.Do(req)
 	if err != nil {
@@ -48,4 +52,15 @@ func TestLoggingManualService(t *testing.T) {
 	if got := resp.StatusCode; got != http.StatusOK {
 		t.Errorf("response status: got %d, want %d", got, http.StatusOK)
 	}
+
+	entries, err := service.QueryLogs(context.Background(), cloudrunci.LogQuery{
+		Matchers: []cloudrunci.LogMatcher{
+			cloudrunci.HasSeverity(logging.Notice),
+			cloudrunci.HasTrace(traceID),
+			cloudrunci.JSONPayloadField("component", "arbitrary-property"),
+		},
+	})
+	if err != nil {
+		t.Errorf("service.QueryLogs: %v (found %d entries)", err, len(entries))
+	}
 }
@@ -0,0 +1,86 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// parseLabel splits a "key=value" label.
+func parseLabel(label string) (key, value string, err error) {
+	key, value, ok := strings.Cut(label, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid label %q: want key=value", label)
+	}
+	return key, value, nil
+}
+
+// Label returns the value of the label with the given key.
+func (p *Project) Label(key string) (string, bool) {
+	for _, l := range p.Labels {
+		if k, v, err := parseLabel(l); err == nil && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// HasLabels reports whether p has every one of the "key=value" labels.
+func (p *Project) HasLabels(labels []string) bool {
+	for _, l := range labels {
+		key, want, err := parseLabel(l)
+		if err != nil {
+			return false
+		}
+		if got, ok := p.Label(key); !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// labelsFlag is a repeatable flag of "key=value" labels.
+type labelsFlag []string
+
+func (f *labelsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *labelsFlag) Set(s string) error {
+	if _, _, err := parseLabel(s); err != nil {
+		return err
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// parseArgs parses args with fs, allowing flags to follow positional
+// arguments (e.g. "pool-add proj --label tier=large"). It returns the
+// positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaseRequire(t *testing.T) {
+	pool := &Pool{}
+	pool.Add("small", "tier=small", "region=us-east1")
+	pool.Add("large", "tier=large", "region=us-east1")
+
+	proj, err := pool.Lease("build-1", time.Hour, "tier=large")
+	if err != nil {
+		t.Fatalf("Lease: %v", err)
+	}
+	if proj.ID != "large" {
+		t.Errorf("Lease(tier=large) got %s, want large", proj.ID)
+	}
+	if _, err := pool.Lease("build-2", time.Hour, "tier=large"); !errors.Is(err, ErrNoFreeProject) {
+		t.Errorf("second Lease(tier=large) got %v, want ErrNoFreeProject", err)
+	}
+	if _, err := pool.Lease("build-2", time.Hour, "tier=gpu"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("Lease(tier=gpu) got %v, want ErrNoMatch", err)
+	}
+	proj, err = pool.Lease("build-2", time.Hour, "region=us-east1")
+	if err != nil {
+		t.Fatalf("Lease: %v", err)
+	}
+	if proj.ID != "small" {
+		t.Errorf("Lease(region=us-east1) got %s, want small", proj.ID)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("pool-add", flag.ContinueOnError)
+	var labels labelsFlag
+	fs.Var(&labels, "label", "")
+
+	args, err := parseArgs(fs, []string{"proj", "--label", "region=us-east1", "--label", "tier=large"})
+	if err != nil {
+		t.Fatalf("parseArgs: %v", err)
+	}
+	if want := []string{"proj"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("parseArgs got args %q, want %q", args, want)
+	}
+	if want := (labelsFlag{"region=us-east1", "tier=large"}); !reflect.DeepEqual(labels, want) {
+		t.Errorf("parseArgs got labels %q, want %q", labels, want)
+	}
+
+	if _, err := parseArgs(fs, []string{"proj", "--label", "tier"}); err == nil {
+		t.Errorf("parseArgs accepted label without value, want error")
+	}
+}
+
+func TestStatusGroupBy(t *testing.T) {
+	ctx := context.Background()
+	store = &memoryStore{}
+	for _, p := range []struct {
+		id     string
+		labels []string
+	}{
+		{"proj-large", []string{"tier=large", "region=us-east1"}},
+		{"proj-small", []string{"tier=small"}},
+		{"proj-plain", nil},
+		{"proj-large-2", []string{"tier=large"}},
+	} {
+		if err := addToPool(ctx, p.id, p.labels); err != nil {
+			t.Fatalf("addToPool: %v", err)
+		}
+	}
+	if _, err := leaseProject(ctx, "build-1", time.Hour, []string{"tier=small"}); err != nil {
+		t.Fatalf("leaseProject: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := status(ctx, &buf, "tier"); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	// Groups are sorted by key, and projects without the label are grouped
+	// under (none).
+	var groups []string
+	members := map[string][]string{}
+	group := ""
+	for _, line := range strings.Split(buf.String(), "\n")[1:] {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "["):
+			group = strings.Trim(line, "[]")
+			groups = append(groups, group)
+		default:
+			// Lines have the lease, project, owner and labels columns.
+			proj, owner := strings.TrimSpace(line[9:39]), strings.TrimSpace(line[40:60])
+			members[group] = append(members[group], proj)
+			if leased := proj == "proj-small"; leased != (owner == "build-1") {
+				t.Errorf("status: got line %q, want only proj-small leased by build-1", line)
+			}
+		}
+	}
+	if want := []string{"tier=(none)", "tier=large", "tier=small"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("status -group-by tier: got groups %q, want %q\n%s", groups, want, buf.String())
+	}
+	want := map[string][]string{
+		"tier=(none)": {"proj-plain"},
+		"tier=large":  {"proj-large", "proj-large-2"},
+		"tier=small":  {"proj-small"},
+	}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("status -group-by tier: got %q, want %q\n%s", members, want, buf.String())
+	}
+}
@@ -14,7 +14,8 @@
 
 // Command gimmeproj provides access to a pool of projects.
 //
-// The metadata about the project pool is stored in Cloud Datastore in a meta-project.
+// The metadata about the project pool is stored in Cloud Datastore in a meta-project,
+// or in a local JSON file when -pool-file is set.
 // Projects are leased for a certain duration, and automatically returned to the pool when the lease expires.
 // Projects should be returned before the lease expires.
 package main
@@ -24,21 +25,33 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
 	"time"
-
-	ds "cloud.google.com/go/datastore"
 )
 
 var (
 	metaProject = flag.String("project", "", "Meta-project that manages the pool.")
+	poolFile    = flag.String("pool-file", "", "Local JSON file that stores the pool, instead of a meta-project.")
 	format      = flag.String("output", "", "Output format for selected operations. Options include: list")
-	datastore   *ds.Client
+	owner       = flag.String("owner", defaultOwner(), "Owner token recorded on leases. Defaults to $GIMMEPROJ_OWNER, $BUILD_ID, $KOKORO_BUILD_ID or the hostname.")
+	store       PoolStore
 
 	version   = "dev"
 	buildDate = "unknown"
 )
 
+// Errors returned by Pool operations.
+var (
+	ErrNoFreeProject = errors.New("could not find a free project")
+	ErrNoMatch       = errors.New("no project in pool has the required labels")
+	ErrNotInPool     = errors.New("project not in pool")
+	ErrNotOwner      = errors.New("project is leased by another owner")
+	ErrLeaseExpired  = errors.New("lease has expired")
+)
+
 type Pool struct {
 	Projects []Project
 }
@@ -46,6 +59,12 @@ type Pool struct {
 type Project struct {
 	ID          string
 	LeaseExpiry time.Time
+	// Owner is the token of the caller that holds the current lease, such as a CI build ID.
+	Owner string
+	// LeaseCreated is when the current lease was first granted. Renewals do not change it.
+	LeaseCreated time.Time
+	// Labels are "key=value" pairs describing the project, such as "tier=large".
+	Labels []string
 }
 
 func (p *Pool) Get(projID string) (*Project, bool) {
@@ -58,37 +77,97 @@ func (p *Pool) Get(projID string) (*Project, bool) {
 	return nil, false
 }
 
-func (p *Pool) Add(proj string) (ok bool) {
+func (p *Pool) Add(proj string, labels ...string) (ok bool) {
 	if _, ok := p.Get(proj); ok {
 		return false
 	}
-	p.Projects = append(p.Projects, Project{ID: proj})
+	p.Projects = append(p.Projects, Project{ID: proj, Labels: labels})
 	return true
 }
 
-func (p *Pool) Lease(d time.Duration) (*Project, bool) {
-	if len(p.Projects) == 0 {
-		return nil, false
-	}
-
-	oldest := &p.Projects[0]
+// Lease leases the project whose lease expired the longest ago to owner for the duration d.
+// Only projects that have all of the required "key=value" labels are considered.
+func (p *Pool) Lease(owner string, d time.Duration, require ...string) (*Project, error) {
+	var oldest *Project
 	for i := range p.Projects {
 		proj := &p.Projects[i]
-		if proj.LeaseExpiry.Before(oldest.LeaseExpiry) {
+		if !proj.HasLabels(require) {
+			continue
+		}
+		if oldest == nil || proj.LeaseExpiry.Before(oldest.LeaseExpiry) {
 			oldest = proj
 		}
 	}
+	if oldest == nil {
+		if len(require) == 0 {
+			return nil, ErrNoFreeProject
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNoMatch, strings.Join(require, ","))
+	}
 	if !oldest.Expired() {
-		return nil, false
+		if len(require) == 0 {
+			return nil, ErrNoFreeProject
+		}
+		return nil, fmt.Errorf("%w with labels %s", ErrNoFreeProject, strings.Join(require, ","))
+	}
+	now := time.Now()
+	oldest.Owner = owner
+	oldest.LeaseCreated = now
+	oldest.LeaseExpiry = now.Add(d)
+	return oldest, nil
+}
+
+// Renew extends owner's lease on projID so that it expires d from now.
+func (p *Pool) Renew(projID, owner string, d time.Duration) (*Project, error) {
+	proj, ok := p.Get(projID)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", projID, ErrNotInPool)
 	}
-	oldest.LeaseExpiry = time.Now().Add(d)
-	return oldest, true
+	if proj.Owner != owner {
+		return nil, fmt.Errorf("%s: %w", projID, ErrNotOwner)
+	}
+	if proj.Expired() {
+		return nil, fmt.Errorf("%s: %w", projID, ErrLeaseExpired)
+	}
+	proj.LeaseExpiry = time.Now().Add(d)
+	return proj, nil
+}
+
+// Return ends owner's lease on projID, returning it to the pool.
+// Leases held by other owners are only ended if force is set.
+func (p *Pool) Return(projID, owner string, force bool) (*Project, error) {
+	proj, ok := p.Get(projID)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", projID, ErrNotInPool)
+	}
+	if !force && !proj.Expired() && proj.Owner != owner {
+		return nil, fmt.Errorf("%s (owner %q): %w", projID, proj.Owner, ErrNotOwner)
+	}
+	proj.LeaseExpiry = time.Now().Add(-10 * time.Second)
+	proj.Owner = ""
+	proj.LeaseCreated = time.Time{}
+	return proj, nil
 }
 
 func (p *Project) Expired() bool {
 	return time.Now().After(p.LeaseExpiry)
 }
 
+// defaultOwner returns an owner token identifying the current caller.
+// It prefers CI build identifiers and falls back to the hostname.
+func defaultOwner() string {
+	for _, env := range []string{"GIMMEPROJ_OWNER", "BUILD_ID", "KOKORO_BUILD_ID"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
 func main() {
 	flag.Parse()
 	if err := submain(); err != nil {
@@ -103,17 +182,24 @@ func submain() error {
 	usage := errors.New(`
 Usage:
 	gimmeproj -project=[meta project ID] command
+	gimmeproj -pool-file=[path] command
 	gimmeproj -project=[meta project ID] -output=list status
 
 Commands:
-	lease [duration]    Leases a project for a given duration. Prints the project ID to stdout.
-	done [project ID]   Returns a project to the pool.
-	version             Prints the version of gimmeproj.
+	lease [duration] [-require key=value]...
+	                                Leases a project for a given duration. Prints the project ID to stdout.
+	                                Only projects with all of the -require labels are leased.
+	renew [project ID] [duration]   Extends your lease on a project so it expires after the given duration.
+	done [-force] [project ID]      Returns a project to the pool. -force returns projects leased by another owner.
+	version                         Prints the version of gimmeproj.
 
 Administrative commands:
-	pool-add [project ID]       Adds a project to the pool.
+	pool-add [project ID] [-label key=value]...
+	                            Adds a project to the pool with the given labels.
 	pool-rm  [project ID]       Removes a project from the pool.
-	status                      Displays the current status of the meta project. Respects -output.
+	status [-group-by key]      Displays the current status of the meta project. Respects -output.
+	                            -group-by groups projects by the value of a label.
+	serve [address]             Serves lease, renew, done and status as a JSON HTTP API (default localhost:8080).
 `)
 
 	if flag.Arg(0) == "version" {
@@ -121,8 +207,8 @@ Administrative commands:
 		return nil
 	}
 
-	if *metaProject == "" {
-		fmt.Fprintln(os.Stderr, "-project flag is required.")
+	if (*metaProject == "") == (*poolFile == "") {
+		fmt.Fprintln(os.Stderr, "Exactly one of -project or -pool-file is required.")
 		return usage
 	}
 
@@ -131,79 +217,82 @@ Administrative commands:
 		return usage
 	}
 
-	var err error
-	datastore, err = ds.NewClient(ctx, *metaProject)
+	if *poolFile != "" {
+		store = newFileStore(*poolFile)
+	} else {
+		var err error
+		store, err = newDatastoreStore(ctx, *metaProject)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd := flag.Arg(0)
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	var labels, require labelsFlag
+	fs.Var(&labels, "label", "Label to add to the project, as key=value. May be repeated.")
+	fs.Var(&require, "require", "Label the leased project must have, as key=value. May be repeated.")
+	force := fs.Bool("force", false, "Return the project even if it is leased by another owner.")
+	groupBy := fs.String("group-by", "", "Label key to group projects by.")
+	args, err := parseArgs(fs, flag.Args()[1:])
 	if err != nil {
-		return fmt.Errorf("datastore.NewClient: %w", err)
+		return usage
+	}
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
 	}
 
-	switch flag.Arg(0) {
+	switch cmd {
 	case "help":
 		fmt.Fprintln(os.Stderr, usage.Error())
 		return nil
 	case "lease":
-		return lease(ctx, flag.Arg(1))
+		return lease(ctx, arg(0), require)
 	case "pool-add":
-		return addToPool(ctx, flag.Arg(1))
+		return addToPool(ctx, arg(0), labels)
 	case "pool-rm":
-		return removeFromPool(ctx, flag.Arg(1))
+		return removeFromPool(ctx, arg(0))
 	case "status":
-		return status(ctx)
+		return status(ctx, os.Stdout, *groupBy)
+	case "renew":
+		return renew(ctx, arg(0), arg(1))
 	case "done":
-		return done(ctx, flag.Arg(1))
+		return done(ctx, arg(0), *force)
+	case "serve":
+		return serve(ctx, arg(0))
 	}
 	fmt.Fprintln(os.Stderr, "Unknown command.")
 	return usage
 }
 
-// withPool runs the given function in a transaction, saving the state of the pool if the function returns with a non-nil error.
+// withPool runs the given function in a transaction, saving the state of the pool if the function returns with a nil error.
 func withPool(ctx context.Context, f func(pool *Pool) error) error {
-	_, err := datastore.RunInTransaction(ctx, func(tx *ds.Transaction) error {
-		key := ds.NameKey("Pool", "pool", nil)
-		var pool Pool
-		if err := tx.Get(key, &pool); err != nil {
-			if err == ds.ErrNoSuchEntity {
-				if _, err := tx.Put(key, &pool); err != nil {
-					return fmt.Errorf("Initial Pool.Put: %w", err)
-				}
-			} else {
-				return fmt.Errorf("Pool.Get: %w", err)
-			}
-		}
-		if err := f(&pool); err != nil {
+	return store.RunInTransaction(ctx, func(tx PoolTx) error {
+		pool, err := tx.Load()
+		if err != nil {
 			return err
 		}
-		_, err := tx.Put(key, &pool)
-		if err != nil {
-			return fmt.Errorf("Pool.Put: %w", err)
+		if err := f(pool); err != nil {
+			return err
 		}
-		return nil
+		return tx.Save(pool)
 	})
-	if err != nil {
-		return fmt.Errorf("datastore: %w", err)
-	}
-	return nil
 }
 
-func lease(ctx context.Context, duration string) error {
-	if duration == "" {
-		return errors.New("must provide a duration (e.g. 10m). See https://golang.org/pkg/time/#ParseDuration")
-	}
-	d, err := time.ParseDuration(duration)
+func lease(ctx context.Context, duration string, require []string) error {
+	d, err := parseDuration(duration)
 	if err != nil {
-		return fmt.Errorf("Could not parse duration: %w", err)
+		return err
 	}
 
-	var proj *Project
-	err = withPool(ctx, func(pool *Pool) error {
-		var ok bool
-		proj, ok = pool.Lease(d)
-		if !ok {
+	proj, err := leaseProject(ctx, *owner, d, require)
+	if err != nil {
+		if errors.Is(err, ErrNoFreeProject) && len(require) == 0 {
 			return errors.New("Could not find a free project. Try again soon.")
 		}
-		return nil
-	})
-	if err != nil {
 		return err
 	}
 	fmt.Fprintf(os.Stderr, "Leased! %s is yours for %s.\n", proj.ID, d)
@@ -211,55 +300,136 @@ func lease(ctx context.Context, duration string) error {
 	return nil
 }
 
-func done(ctx context.Context, projectID string) error {
+func renew(ctx context.Context, projectID, duration string) error {
 	if projectID == "" {
 		return errors.New("must provide project id")
 	}
-	err := withPool(ctx, func(pool *Pool) error {
-		proj, ok := pool.Get(projectID)
-		if !ok {
-			return fmt.Errorf("Could not find project %s in project pool.", projectID)
-		}
-		proj.LeaseExpiry = time.Now().Add(-10 * time.Second)
-		return nil
-	})
+	d, err := parseDuration(duration)
 	if err != nil {
 		return err
 	}
+	if _, err := renewProject(ctx, projectID, *owner, d); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Renewed! %s is yours for %s.\n", projectID, d)
+	return nil
+}
+
+func done(ctx context.Context, projectID string, force bool) error {
+	if projectID == "" {
+		return errors.New("must provide project id")
+	}
+	if err := returnProject(ctx, projectID, *owner, force); err != nil {
+		return err
+	}
 	fmt.Fprintf(os.Stderr, "Returned %s to the pool.\n", projectID)
 	return nil
 }
 
-func status(ctx context.Context) error {
+func parseDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, errors.New("must provide a duration (e.g. 10m). See https://golang.org/pkg/time/#ParseDuration")
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse duration: %w", err)
+	}
+	return d, nil
+}
+
+// leaseProject leases a free project with the required labels to owner for the duration d.
+func leaseProject(ctx context.Context, owner string, d time.Duration, require []string) (Project, error) {
+	var proj Project
+	err := withPool(ctx, func(pool *Pool) error {
+		p, err := pool.Lease(owner, d, require...)
+		if err != nil {
+			return err
+		}
+		proj = *p
+		return nil
+	})
+	return proj, err
+}
+
+// renewProject extends owner's lease on projectID for the duration d.
+func renewProject(ctx context.Context, projectID, owner string, d time.Duration) (Project, error) {
+	var proj Project
+	err := withPool(ctx, func(pool *Pool) error {
+		p, err := pool.Renew(projectID, owner, d)
+		if err != nil {
+			return err
+		}
+		proj = *p
+		return nil
+	})
+	return proj, err
+}
+
+// returnProject returns projectID to the pool on behalf of owner.
+func returnProject(ctx context.Context, projectID, owner string, force bool) error {
 	return withPool(ctx, func(pool *Pool) error {
+		_, err := pool.Return(projectID, owner, force)
+		return err
+	})
+}
+
+// status writes the status of the pool to w, grouping projects by the value
+// of the groupBy label if it is set.
+func status(ctx context.Context, w io.Writer, groupBy string) error {
+	if *format != "" && *format != "list" {
+		return errors.New("output may be '', 'list'")
+	}
+	return withPool(ctx, func(pool *Pool) error {
+		groups := map[string][]Project{}
+		for _, proj := range pool.Projects {
+			key := ""
+			if groupBy != "" {
+				v, ok := proj.Label(groupBy)
+				if !ok {
+					v = "(none)"
+				}
+				key = groupBy + "=" + v
+			}
+			groups[key] = append(groups[key], proj)
+		}
+		keys := make([]string, 0, len(groups))
+		for k := range groups {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
 		if *format == "" {
-			fmt.Printf("%-8s %s\n", "LEASE", "PROJECT")
+			fmt.Fprintf(w, "%-8s %-30s %-20s %s\n", "LEASE", "PROJECT", "OWNER", "LABELS")
 		}
-		for _, proj := range pool.Projects {
-			exp := ""
-			if !proj.Expired() {
-				secs := proj.LeaseExpiry.Sub(time.Now()) / time.Second * time.Second
-				exp = secs.String()
+		for _, k := range keys {
+			if *format == "" && k != "" {
+				fmt.Fprintf(w, "\n[%s]\n", k)
 			}
-			switch *format {
-			case "":
-				fmt.Printf("%-8s %s\n", exp, proj.ID)
-			case "list":
-				fmt.Printf("%s\n", proj.ID)
-			default:
-				return errors.New("output may be '', 'list'")
+			for _, proj := range groups[k] {
+				exp, leasedBy := "", ""
+				if !proj.Expired() {
+					leasedBy = proj.Owner
+					secs := proj.LeaseExpiry.Sub(time.Now()) / time.Second * time.Second
+					exp = secs.String()
+				}
+				switch *format {
+				case "":
+					fmt.Fprintf(w, "%-8s %-30s %-20s %s\n", exp, proj.ID, leasedBy, strings.Join(proj.Labels, ","))
+				case "list":
+					fmt.Fprintf(w, "%s\n", proj.ID)
+				}
 			}
 		}
 		return nil
 	})
 }
 
-func addToPool(ctx context.Context, proj string) error {
+func addToPool(ctx context.Context, proj string, labels []string) error {
 	if proj == "" {
 		return errors.New("must provide project id")
 	}
 	return withPool(ctx, func(pool *Pool) error {
-		if !pool.Add(proj) {
+		if !pool.Add(proj, labels...) {
 			return fmt.Errorf("%s already in pool", proj)
 		}
 		return nil
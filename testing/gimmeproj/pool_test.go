@@ -0,0 +1,77 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeaseOwnership(t *testing.T) {
+	pool := &Pool{}
+	pool.Add("proj-a")
+
+	proj, err := pool.Lease("build-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Lease: %v", err)
+	}
+	if proj.Owner != "build-1" || proj.LeaseCreated.IsZero() {
+		t.Errorf("Lease got owner %q, created %v; want build-1 and a creation time", proj.Owner, proj.LeaseCreated)
+	}
+	created := proj.LeaseCreated
+
+	if _, err := pool.Lease("build-2", time.Hour); !errors.Is(err, ErrNoFreeProject) {
+		t.Errorf("second Lease got %v, want ErrNoFreeProject", err)
+	}
+
+	if _, err := pool.Renew("proj-a", "build-2", time.Hour); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("Renew by other owner got %v, want ErrNotOwner", err)
+	}
+	proj, err = pool.Renew("proj-a", "build-1", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if !proj.LeaseCreated.Equal(created) {
+		t.Errorf("Renew changed LeaseCreated to %v, want %v", proj.LeaseCreated, created)
+	}
+	if time.Until(proj.LeaseExpiry) < time.Hour {
+		t.Errorf("Renew got expiry %v, want about 2h from now", proj.LeaseExpiry)
+	}
+
+	if _, err := pool.Return("proj-a", "build-2", false); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("Return by other owner got %v, want ErrNotOwner", err)
+	}
+	if _, err := pool.Return("proj-a", "build-2", true); err != nil {
+		t.Errorf("forced Return: %v", err)
+	}
+	if _, err := pool.Renew("proj-a", "build-1", time.Hour); err == nil {
+		t.Errorf("Renew after forced Return succeeded, want error")
+	}
+	if _, err := pool.Return("missing", "build-1", false); !errors.Is(err, ErrNotInPool) {
+		t.Errorf("Return of missing project got %v, want ErrNotInPool", err)
+	}
+}
+
+func TestRenewExpired(t *testing.T) {
+	pool := &Pool{Projects: []Project{{
+		ID:          "proj-a",
+		Owner:       "build-1",
+		LeaseExpiry: time.Now().Add(-time.Minute),
+	}}}
+	if _, err := pool.Renew("proj-a", "build-1", time.Hour); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("Renew got %v, want ErrLeaseExpired", err)
+	}
+}
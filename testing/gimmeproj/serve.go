@@ -0,0 +1,217 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// leaseRequest is the JSON body accepted by the lease, renew and done endpoints.
+type leaseRequest struct {
+	Owner    string `json:"owner"`
+	Project  string `json:"project,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Force    bool   `json:"force,omitempty"`
+	// Require lists "key=value" labels the leased project must have.
+	Require []string `json:"require,omitempty"`
+}
+
+// projectStatus is the JSON representation of a project in the pool.
+type projectStatus struct {
+	ID           string    `json:"id"`
+	Leased       bool      `json:"leased"`
+	Owner        string    `json:"owner,omitempty"`
+	LeaseCreated time.Time `json:"leaseCreated"`
+	LeaseExpiry  time.Time `json:"leaseExpiry"`
+	Labels       []string  `json:"labels,omitempty"`
+}
+
+func newProjectStatus(p Project) projectStatus {
+	if p.Expired() {
+		return projectStatus{ID: p.ID, Labels: p.Labels}
+	}
+	return projectStatus{
+		ID:           p.ID,
+		Leased:       true,
+		Owner:        p.Owner,
+		LeaseCreated: p.LeaseCreated,
+		LeaseExpiry:  p.LeaseExpiry,
+		Labels:       p.Labels,
+	}
+}
+
+// serve exposes the pool as a JSON HTTP API on addr until ctx is done.
+func serve(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = "localhost:8080"
+	}
+	srv := &http.Server{Addr: addr, Handler: newServeMux()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("gimmeproj: serving on http://%s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("ListenAndServe: %w", err)
+	}
+	return nil
+}
+
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lease", handleLease)
+	mux.HandleFunc("/renew", handleRenew)
+	mux.HandleFunc("/done", handleDone)
+	mux.HandleFunc("/status", handleStatus)
+	return mux
+}
+
+func handleLease(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeLeaseRequest(w, r)
+	if !ok {
+		return
+	}
+	d, err := parseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Labels can also be required in the query, as in
+	// /lease?require=tier=large, like the -require flag of lease.
+	require := append(req.Require, r.URL.Query()["require"]...)
+	for _, l := range require {
+		if _, _, err := parseLabel(l); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	proj, err := leaseProject(r.Context(), req.Owner, d, require)
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newProjectStatus(proj))
+}
+
+func handleRenew(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeLeaseRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Project == "" {
+		writeError(w, http.StatusBadRequest, errors.New("must provide project id"))
+		return
+	}
+	d, err := parseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	proj, err := renewProject(r.Context(), req.Project, req.Owner, d)
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newProjectStatus(proj))
+}
+
+func handleDone(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeLeaseRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Project == "" {
+		writeError(w, http.StatusBadRequest, errors.New("must provide project id"))
+		return
+	}
+	if err := returnProject(r.Context(), req.Project, req.Owner, req.Force); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, projectStatus{ID: req.Project})
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var projects []projectStatus
+	err := withPool(r.Context(), func(pool *Pool) error {
+		for _, proj := range pool.Projects {
+			projects = append(projects, newProjectStatus(proj))
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Projects []projectStatus `json:"projects"`
+	}{projects})
+}
+
+// decodeLeaseRequest decodes the body of a POST request, writing an error
+// response and returning false if it is not a valid leaseRequest.
+func decodeLeaseRequest(w http.ResponseWriter, r *http.Request) (leaseRequest, bool) {
+	var req leaseRequest
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("json.Decode: %w", err))
+		return req, false
+	}
+	if req.Owner == "" {
+		writeError(w, http.StatusBadRequest, errors.New("must provide owner"))
+		return req, false
+	}
+	return req, true
+}
+
+// statusCode maps Pool errors to HTTP status codes.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNotInPool), errors.Is(err, ErrNoMatch):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNoFreeProject), errors.Is(err, ErrLeaseExpired):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
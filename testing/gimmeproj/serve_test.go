@@ -0,0 +1,226 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// request sends a request with the given body to the serve handlers, and
+// decodes the JSON response into out if it is not nil.
+func request(t *testing.T, method, path, body string, out interface{}) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	newServeMux().ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: got Content-Type %q, want application/json", method, path, ct)
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: json.Unmarshal(%q): %v", method, path, w.Body, err)
+		}
+	}
+	return w.Code
+}
+
+func TestServeLeaseRenewDone(t *testing.T) {
+	ctx := context.Background()
+	store = &memoryStore{}
+	if err := addToPool(ctx, "proj-a", []string{"tier=large"}); err != nil {
+		t.Fatalf("addToPool: %v", err)
+	}
+
+	var proj projectStatus
+	if code := request(t, "POST", "/lease", `{"owner":"build-1","duration":"1h","require":["tier=large"]}`, &proj); code != http.StatusOK {
+		t.Fatalf("lease: got status %d, want 200", code)
+	}
+	if proj.ID != "proj-a" || !proj.Leased || proj.Owner != "build-1" || proj.LeaseCreated.IsZero() {
+		t.Errorf("lease: got %+v, want proj-a leased by build-1", proj)
+	}
+	if d := time.Until(proj.LeaseExpiry); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("lease: got expiry in %v, want 1h", d)
+	}
+
+	if code := request(t, "POST", "/renew", `{"owner":"build-2","project":"proj-a","duration":"1h"}`, nil); code != http.StatusForbidden {
+		t.Errorf("renew by another owner: got status %d, want 403", code)
+	}
+	if code := request(t, "POST", "/renew", `{"owner":"build-1","project":"proj-a","duration":"3h"}`, &proj); code != http.StatusOK {
+		t.Fatalf("renew: got status %d, want 200", code)
+	}
+	if d := time.Until(proj.LeaseExpiry); d < 2*time.Hour {
+		t.Errorf("renew: got expiry in %v, want 3h", d)
+	}
+
+	if code := request(t, "POST", "/done", `{"owner":"build-2","project":"proj-a"}`, nil); code != http.StatusForbidden {
+		t.Errorf("done by another owner: got status %d, want 403", code)
+	}
+	if code := request(t, "POST", "/done", `{"owner":"build-1","project":"proj-a"}`, &proj); code != http.StatusOK {
+		t.Fatalf("done: got status %d, want 200", code)
+	}
+	if proj.ID != "proj-a" || proj.Leased {
+		t.Errorf("done: got %+v, want proj-a not leased", proj)
+	}
+
+	// The project can be leased again, requiring its labels in the query,
+	// and forcibly returned by another owner.
+	if code := request(t, "POST", "/lease?require=tier=large", `{"owner":"build-3","duration":"1h"}`, nil); code != http.StatusOK {
+		t.Fatalf("lease after done: got status %d, want 200", code)
+	}
+	if code := request(t, "POST", "/done", `{"owner":"build-4","project":"proj-a","force":true}`, nil); code != http.StatusOK {
+		t.Errorf("forced done: got status %d, want 200", code)
+	}
+}
+
+func TestServeExpiredLease(t *testing.T) {
+	store = &memoryStore{}
+	err := withPool(context.Background(), func(pool *Pool) error {
+		pool.Projects = []Project{{
+			ID:           "proj-a",
+			Owner:        "build-1",
+			LeaseCreated: time.Now().Add(-2 * time.Hour),
+			LeaseExpiry:  time.Now().Add(-time.Hour),
+		}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withPool: %v", err)
+	}
+
+	if code := request(t, "POST", "/renew", `{"owner":"build-1","project":"proj-a","duration":"1h"}`, nil); code != http.StatusConflict {
+		t.Errorf("renew of an expired lease: got status %d, want 409", code)
+	}
+	var status struct {
+		Projects []projectStatus `json:"projects"`
+	}
+	if code := request(t, "GET", "/status", "", &status); code != http.StatusOK {
+		t.Fatalf("status: got status %d, want 200", code)
+	}
+	if len(status.Projects) != 1 || status.Projects[0].Leased || status.Projects[0].Owner != "" {
+		t.Errorf("status: got %+v, want proj-a not leased", status.Projects)
+	}
+	// Another owner can lease the project, and its old owner cannot return it.
+	if code := request(t, "POST", "/lease", `{"owner":"build-2","duration":"1h"}`, nil); code != http.StatusOK {
+		t.Fatalf("lease of an expired project: got status %d, want 200", code)
+	}
+	if code := request(t, "POST", "/done", `{"owner":"build-1","project":"proj-a"}`, nil); code != http.StatusForbidden {
+		t.Errorf("done by the expired owner: got status %d, want 403", code)
+	}
+}
+
+func TestServeStatus(t *testing.T) {
+	ctx := context.Background()
+	store = &memoryStore{}
+	for _, id := range []string{"proj-a", "proj-b"} {
+		if err := addToPool(ctx, id, []string{"tier=small"}); err != nil {
+			t.Fatalf("addToPool: %v", err)
+		}
+	}
+	if _, err := leaseProject(ctx, "build-1", time.Hour, nil); err != nil {
+		t.Fatalf("leaseProject: %v", err)
+	}
+
+	var status struct {
+		Projects []projectStatus `json:"projects"`
+	}
+	if code := request(t, "GET", "/status", "", &status); code != http.StatusOK {
+		t.Fatalf("status: got status %d, want 200", code)
+	}
+	leased := map[string]string{}
+	for _, p := range status.Projects {
+		if len(p.Labels) != 1 || p.Labels[0] != "tier=small" {
+			t.Errorf("status: %s has labels %q, want [tier=small]", p.ID, p.Labels)
+		}
+		if p.Leased {
+			leased[p.ID] = p.Owner
+		}
+	}
+	if len(status.Projects) != 2 || len(leased) != 1 || leased["proj-a"] != "build-1" {
+		t.Errorf("status: got %+v, want proj-a leased by build-1 and proj-b free", status.Projects)
+	}
+
+	if code := request(t, "POST", "/status", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status: got status %d, want 405", code)
+	}
+}
+
+func TestServeBadRequests(t *testing.T) {
+	ctx := context.Background()
+	store = &memoryStore{}
+	if err := addToPool(ctx, "proj-a", []string{"tier=small"}); err != nil {
+		t.Fatalf("addToPool: %v", err)
+	}
+	if _, err := leaseProject(ctx, "build-1", time.Hour, nil); err != nil {
+		t.Fatalf("leaseProject: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/lease", "", http.StatusMethodNotAllowed},
+		{"POST", "/lease", `{"owner":`, http.StatusBadRequest},
+		{"POST", "/lease", `{"duration":"1h"}`, http.StatusBadRequest},
+		{"POST", "/lease", `{"owner":"build-2"}`, http.StatusBadRequest},
+		{"POST", "/lease", `{"owner":"build-2","duration":"soon"}`, http.StatusBadRequest},
+		{"POST", "/lease", `{"owner":"build-2","duration":"1h","require":["tier"]}`, http.StatusBadRequest},
+		{"POST", "/lease", `{"owner":"build-2","duration":"1h"}`, http.StatusConflict},
+		{"POST", "/lease", `{"owner":"build-2","duration":"1h","require":["tier=large"]}`, http.StatusNotFound},
+		{"POST", "/lease?require=tier", `{"owner":"build-2","duration":"1h"}`, http.StatusBadRequest},
+		{"POST", "/lease?require=tier=large", `{"owner":"build-2","duration":"1h"}`, http.StatusNotFound},
+		{"POST", "/renew", `{"owner":"build-1","duration":"1h"}`, http.StatusBadRequest},
+		{"POST", "/renew", `{"owner":"build-1","project":"proj-a"}`, http.StatusBadRequest},
+		{"POST", "/renew", `{"owner":"build-1","project":"proj-z","duration":"1h"}`, http.StatusNotFound},
+		{"GET", "/done", "", http.StatusMethodNotAllowed},
+		{"POST", "/done", `{"owner":"build-1"}`, http.StatusBadRequest},
+		{"POST", "/done", `{"owner":"build-1","project":"proj-z"}`, http.StatusNotFound},
+	} {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if code := request(t, tc.method, tc.path, tc.body, &resp); code != tc.want {
+			t.Errorf("%s %s %s: got status %d, want %d", tc.method, tc.path, tc.body, code, tc.want)
+		}
+		if resp.Error == "" {
+			t.Errorf("%s %s %s: got no error message", tc.method, tc.path, tc.body)
+		}
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("proj-a: %w", ErrNotInPool), http.StatusNotFound},
+		{fmt.Errorf("%w: tier=gpu", ErrNoMatch), http.StatusNotFound},
+		{fmt.Errorf("proj-a: %w", ErrNotOwner), http.StatusForbidden},
+		{ErrNoFreeProject, http.StatusConflict},
+		{fmt.Errorf("proj-a: %w", ErrLeaseExpired), http.StatusConflict},
+		{errors.New("datastore unavailable"), http.StatusInternalServerError},
+	} {
+		if got := statusCode(tc.err); got != tc.want {
+			t.Errorf("statusCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ds "cloud.google.com/go/datastore"
+)
+
+// PoolStore persists the Pool.
+type PoolStore interface {
+	// RunInTransaction runs f in a transaction. Changes saved with PoolTx.Save
+	// are only committed if f returns nil.
+	RunInTransaction(ctx context.Context, f func(tx PoolTx) error) error
+}
+
+// PoolTx reads and writes the Pool within a transaction.
+type PoolTx interface {
+	// Load returns the current Pool. It returns an empty Pool if none has been saved.
+	Load() (*Pool, error)
+	// Save stages pool to be written when the transaction commits.
+	Save(pool *Pool) error
+}
+
+// datastoreStore stores the Pool as a single entity in Cloud Datastore.
+type datastoreStore struct {
+	client *ds.Client
+}
+
+func newDatastoreStore(ctx context.Context, projectID string) (*datastoreStore, error) {
+	client, err := ds.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("datastore.NewClient: %w", err)
+	}
+	return &datastoreStore{client: client}, nil
+}
+
+var poolKey = ds.NameKey("Pool", "pool", nil)
+
+func (s *datastoreStore) RunInTransaction(ctx context.Context, f func(tx PoolTx) error) error {
+	_, err := s.client.RunInTransaction(ctx, func(tx *ds.Transaction) error {
+		return f(datastoreTx{tx})
+	})
+	if err != nil {
+		return fmt.Errorf("datastore: %w", err)
+	}
+	return nil
+}
+
+type datastoreTx struct {
+	tx *ds.Transaction
+}
+
+func (t datastoreTx) Load() (*Pool, error) {
+	var pool Pool
+	if err := t.tx.Get(poolKey, &pool); err != nil && err != ds.ErrNoSuchEntity {
+		return nil, fmt.Errorf("Pool.Get: %w", err)
+	}
+	return &pool, nil
+}
+
+func (t datastoreTx) Save(pool *Pool) error {
+	if _, err := t.tx.Put(poolKey, pool); err != nil {
+		return fmt.Errorf("Pool.Put: %w", err)
+	}
+	return nil
+}
+
+// fileStore stores the Pool as JSON in a local file. Transactions are
+// serialized across processes with a lock file next to the pool file.
+type fileStore struct {
+	path string
+	// staleLock is how old a lock file can get before it is assumed to be
+	// left behind by a crashed process and removed.
+	staleLock time.Duration
+}
+
+func newFileStore(path string) *fileStore {
+	return &fileStore{path: path, staleLock: time.Minute}
+}
+
+func (s *fileStore) RunInTransaction(ctx context.Context, f func(tx PoolTx) error) error {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx := &fileTx{path: s.path}
+	if err := f(tx); err != nil {
+		return err
+	}
+	if tx.pending == nil {
+		return nil
+	}
+	return tx.commit()
+}
+
+// lock acquires the lock file, retrying until ctx is done.
+func (s *fileStore) lock(ctx context.Context) (unlock func(), err error) {
+	lockPath := s.path + ".lock"
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("lock %s: %w", lockPath, err)
+		}
+		if fi, err := os.Stat(lockPath); err == nil && time.Since(fi.ModTime()) > s.staleLock {
+			os.Remove(lockPath)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock %s: %w", lockPath, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+type fileTx struct {
+	path    string
+	pending *Pool
+}
+
+func (t *fileTx) Load() (*Pool, error) {
+	var pool Pool
+	b, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &pool, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	if err := json.Unmarshal(b, &pool); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(%s): %w", t.path, err)
+	}
+	return &pool, nil
+}
+
+func (t *fileTx) Save(pool *Pool) error {
+	t.pending = pool
+	return nil
+}
+
+// commit writes the pending Pool to a temporary file and renames it into
+// place, so readers never observe a partially written pool.
+func (t *fileTx) commit() error {
+	b, err := json.MarshalIndent(t.pending, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("CreateTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Write: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		return fmt.Errorf("Rename: %w", err)
+	}
+	return nil
+}
+
+// memoryStore keeps the Pool in memory. It is used in tests.
+type memoryStore struct {
+	mu   sync.Mutex
+	pool Pool
+}
+
+func (s *memoryStore) RunInTransaction(ctx context.Context, f func(tx PoolTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{current: s.pool}
+	if err := f(tx); err != nil {
+		return err
+	}
+	if tx.pending != nil {
+		s.pool = copyPool(tx.pending)
+	}
+	return nil
+}
+
+type memoryTx struct {
+	current Pool
+	pending *Pool
+}
+
+func (t *memoryTx) Load() (*Pool, error) {
+	pool := copyPool(&t.current)
+	return &pool, nil
+}
+
+func (t *memoryTx) Save(pool *Pool) error {
+	t.pending = pool
+	return nil
+}
+
+func copyPool(p *Pool) Pool {
+	return Pool{Projects: append([]Project(nil), p.Projects...)}
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T) map[string]PoolStore {
+	return map[string]PoolStore{
+		"memory": &memoryStore{},
+		"file":   newFileStore(filepath.Join(t.TempDir(), "pool.json")),
+	}
+}
+
+func TestCommands(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store = s
+
+			if err := addToPool(ctx, "proj-a", nil); err != nil {
+				t.Fatalf("addToPool: %v", err)
+			}
+			if err := addToPool(ctx, "proj-a", nil); err == nil {
+				t.Errorf("addToPool of duplicate project succeeded, want error")
+			}
+			if err := addToPool(ctx, "proj-b", nil); err != nil {
+				t.Fatalf("addToPool: %v", err)
+			}
+
+			if err := lease(ctx, "10m", nil); err != nil {
+				t.Fatalf("lease: %v", err)
+			}
+			if err := lease(ctx, "10m", nil); err != nil {
+				t.Fatalf("lease: %v", err)
+			}
+			if err := lease(ctx, "10m", nil); err == nil {
+				t.Errorf("lease with no free projects succeeded, want error")
+			}
+			if err := status(ctx, io.Discard, ""); err != nil {
+				t.Errorf("status: %v", err)
+			}
+
+			if err := done(ctx, "proj-a", false); err != nil {
+				t.Errorf("done: %v", err)
+			}
+			if err := done(ctx, "proj-c", false); err == nil {
+				t.Errorf("done of unknown project succeeded, want error")
+			}
+			if err := removeFromPool(ctx, "proj-b"); err != nil {
+				t.Errorf("removeFromPool: %v", err)
+			}
+			if err := removeFromPool(ctx, "proj-b"); err == nil {
+				t.Errorf("removeFromPool of removed project succeeded, want error")
+			}
+
+			err := withPool(ctx, func(pool *Pool) error {
+				if len(pool.Projects) != 1 || pool.Projects[0].ID != "proj-a" {
+					t.Errorf("pool got %+v, want only proj-a", pool.Projects)
+				}
+				if !pool.Projects[0].Expired() {
+					t.Errorf("proj-a is still leased after done")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("withPool: %v", err)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store = s
+			if err := addToPool(ctx, "proj-a", nil); err != nil {
+				t.Fatalf("addToPool: %v", err)
+			}
+
+			errAbort := errors.New("abort")
+			err := withPool(ctx, func(pool *Pool) error {
+				pool.Add("proj-b")
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("withPool got %v, want %v", err, errAbort)
+			}
+			withPool(ctx, func(pool *Pool) error {
+				if _, ok := pool.Get("proj-b"); ok {
+					t.Errorf("aborted transaction was saved")
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestFileStoreConcurrentLeases(t *testing.T) {
+	ctx := context.Background()
+	store = newFileStore(filepath.Join(t.TempDir(), "pool.json"))
+	for _, id := range []string{"proj-a", "proj-b", "proj-c"} {
+		if err := addToPool(ctx, id, nil); err != nil {
+			t.Fatalf("addToPool: %v", err)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		leased = map[string]bool{}
+	)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proj, err := leaseProject(ctx, "owner", time.Hour, nil)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if leased[proj.ID] {
+				t.Errorf("%s leased twice", proj.ID)
+			}
+			leased[proj.ID] = true
+		}()
+	}
+	wg.Wait()
+	if len(leased) != 3 {
+		t.Errorf("leased %d projects, want 3", len(leased))
+	}
+}